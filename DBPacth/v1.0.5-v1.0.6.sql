@@ -0,0 +1,468 @@
+ALTER TABLE `package`
+ADD COLUMN `replication_status` VARCHAR(20) NULL AFTER `create_time`,
+ADD INDEX `idx_replication_status` (`replication_status`);
+
+ALTER TABLE `package`
+ADD COLUMN `label` VARCHAR(64) NULL AFTER `replication_status`,
+ADD COLUMN `idempotency_key` VARCHAR(128) NULL AFTER `label`,
+ADD UNIQUE INDEX `uk_label` (`label`),
+ADD UNIQUE INDEX `uk_idempotency_key` (`deployment_id`, `idempotency_key`);
+UPDATE `package` SET `label` = `id` WHERE `label` IS NULL;
+
+CREATE TABLE `binary_version` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `app_id` int DEFAULT NULL,
+  `app_version` varchar(45) DEFAULT NULL,
+  `target_range` varchar(45) DEFAULT NULL,
+  `live` tinyint(1) DEFAULT '1',
+  `update_time` bigint DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_app_version` (`app_id`,`app_version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `deployment`
+ADD COLUMN `require_approval` TINYINT(1) NULL DEFAULT 0 AFTER `create_time`,
+ADD COLUMN `approvers` VARCHAR(1024) NULL AFTER `require_approval`;
+ALTER TABLE `package`
+ADD COLUMN `uid` INT NULL AFTER `idempotency_key`,
+ADD COLUMN `status` VARCHAR(20) NULL AFTER `uid`,
+ADD COLUMN `approved_by` INT NULL AFTER `status`;
+
+CREATE TABLE `audit_log` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `uid` int DEFAULT NULL,
+  `action` varchar(64) DEFAULT NULL,
+  `target` varchar(256) DEFAULT NULL,
+  `detail` TEXT DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_uid` (`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+CREATE TABLE `deployment_freeze` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_id` int DEFAULT NULL,
+  `start_day` int DEFAULT NULL,
+  `start_time` varchar(5) DEFAULT NULL,
+  `end_day` int DEFAULT NULL,
+  `end_time` varchar(5) DEFAULT NULL,
+  `timezone` varchar(64) DEFAULT NULL,
+  `overriders` varchar(1024) DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `deployment_version`
+ADD COLUMN `bundle_name` VARCHAR(128) NOT NULL DEFAULT '' AFTER `deployment_id`;
+
+ALTER TABLE `users`
+MODIFY COLUMN `id` INT NOT NULL AUTO_INCREMENT,
+MODIFY COLUMN `user_name` VARCHAR(200) NULL;
+
+ALTER TABLE `users`
+ADD COLUMN `role` VARCHAR(45) NULL AFTER `password`;
+
+-- 只有原来的admin账号成为管理员,其他已有账号为developer
+UPDATE `users` SET `role`='admin' WHERE `role` IS NULL AND `user_name`='admin';
+UPDATE `users` SET `role`='developer' WHERE `role` IS NULL;
+
+ALTER TABLE `users`
+ADD COLUMN `totp_secret` VARCHAR(64) NULL AFTER `role`,
+ADD COLUMN `totp_enabled` TINYINT(1) NULL DEFAULT 0 AFTER `totp_secret`,
+ADD COLUMN `totp_recovery_codes` TEXT NULL AFTER `totp_enabled`;
+
+ALTER TABLE `deployment`
+ADD COLUMN `secret_hash` VARCHAR(64) NULL AFTER `approvers`,
+ADD COLUMN `previous_secret_hash` VARCHAR(64) NULL AFTER `secret_hash`;
+
+CREATE TABLE `storage_pending` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `object_key` varchar(256) DEFAULT NULL,
+  `provider` varchar(20) DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_object_key` (`object_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+CREATE TABLE `package_diff` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `package_id` int DEFAULT NULL,
+  `base_package_id` int DEFAULT NULL,
+  `base_hash` varchar(100) DEFAULT NULL,
+  `download` varchar(256) DEFAULT NULL,
+  `size` bigint DEFAULT NULL,
+  `status` varchar(20) DEFAULT NULL,
+  `error` varchar(500) DEFAULT NULL,
+  `wait_ms` bigint DEFAULT NULL,
+  `duration_ms` bigint DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_package_base` (`package_id`,`base_package_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+CREATE TABLE `feature_flag` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `tenant` varchar(100) DEFAULT NULL,
+  `name` varchar(100) DEFAULT NULL,
+  `app_id` int DEFAULT NULL,
+  `enabled` tinyint(1) DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  `update_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_tenant_name_app` (`tenant`,`name`,`app_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `apps`
+ADD COLUMN `display_name` VARCHAR(256) NULL AFTER `create_time`,
+ADD COLUMN `platform` VARCHAR(45) NULL AFTER `display_name`,
+ADD COLUMN `icon` VARCHAR(256) NULL AFTER `platform`,
+ADD COLUMN `app_store_url` VARCHAR(500) NULL AFTER `icon`,
+ADD COLUMN `play_store_url` VARCHAR(500) NULL AFTER `app_store_url`;
+
+ALTER TABLE `package`
+ADD COLUMN `rollout` INT NULL AFTER `approved_by`,
+ADD COLUMN `rollout_paused` TINYINT(1) NULL AFTER `rollout`;
+
+CREATE TABLE `rollout_history` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `package_id` int DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `action` varchar(20) DEFAULT NULL,
+  `from_rollout` int DEFAULT NULL,
+  `to_rollout` int DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_package_id` (`package_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+CREATE TABLE `client_rule` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_id` int DEFAULT NULL,
+  `client_unique_id` varchar(256) DEFAULT NULL,
+  `action` varchar(20) DEFAULT NULL,
+  `package_id` int DEFAULT NULL,
+  `note` varchar(500) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `deployment`
+ADD COLUMN `force_binary_update` TINYINT(1) NULL DEFAULT 0 AFTER `previous_secret_hash`,
+ADD COLUMN `force_binary_message` VARCHAR(1024) NULL AFTER `force_binary_update`,
+ADD COLUMN `force_binary_url` VARCHAR(500) NULL AFTER `force_binary_message`;
+
+ALTER TABLE `package`
+ADD COLUMN `metadata` TEXT NULL AFTER `rollout_paused`;
+
+ALTER TABLE `package`
+ADD COLUMN `zstd_download` VARCHAR(256) NULL AFTER `metadata`,
+ADD COLUMN `zstd_size` BIGINT NULL AFTER `zstd_download`;
+
+CREATE TABLE `deployment_lookup` (
+  `deployment_key` varchar(256) NOT NULL,
+  `bundle_name` varchar(128) NOT NULL DEFAULT '',
+  `app_version` varchar(45) NOT NULL,
+  `deployment_id` int DEFAULT NULL,
+  `app_id` int DEFAULT NULL,
+  `deployment_version_id` int DEFAULT NULL,
+  `package_id` int DEFAULT NULL,
+  `new_version` varchar(45) DEFAULT NULL,
+  `update_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`deployment_key`,`bundle_name`,`app_version`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `deployment`
+ADD UNIQUE KEY `uk_key` (`key`);
+
+ALTER TABLE `deployment_version`
+ADD KEY `idx_deployment_bundle_version` (`deployment_id`,`bundle_name`,`app_version`);
+
+CREATE TABLE `metric_rollup` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `granularity` varchar(8) NOT NULL,
+  `bucket_time` bigint NOT NULL,
+  `deployment_id` int NOT NULL,
+  `package_id` int NOT NULL DEFAULT '0',
+  `metric` varchar(32) NOT NULL,
+  `value` bigint NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_bucket` (`granularity`,`bucket_time`,`deployment_id`,`package_id`,`metric`),
+  KEY `idx_deployment` (`deployment_id`,`granularity`,`bucket_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `client_rule`
+ADD COLUMN `expire_time` BIGINT NULL AFTER `create_time`;
+
+CREATE TABLE `invite_token` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_id` int DEFAULT NULL,
+  `package_id` int DEFAULT NULL,
+  `token_hash` varchar(64) DEFAULT NULL,
+  `note` varchar(500) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `expire_time` bigint DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_token_hash` (`token_hash`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `deployment`
+ADD COLUMN `ephemeral_days` INT NULL AFTER `force_binary_url`,
+ADD COLUMN `last_active_time` BIGINT NULL AFTER `ephemeral_days`;
+
+CREATE TABLE `package_tombstone` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `package_id` int DEFAULT NULL,
+  `deployment_id` int DEFAULT NULL,
+  `deployment_version_id` int DEFAULT NULL,
+  `label` varchar(64) DEFAULT NULL,
+  `hash` varchar(256) DEFAULT NULL,
+  `size` bigint DEFAULT NULL,
+  `download` varchar(256) DEFAULT NULL,
+  `zstd_download` varchar(256) DEFAULT NULL,
+  `description` TEXT DEFAULT NULL,
+  `reason` varchar(500) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `release_time` bigint DEFAULT NULL,
+  `delete_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `audit_log`
+ADD COLUMN `client_ip` VARCHAR(64) NULL AFTER `detail`;
+
+ALTER TABLE `package`
+ADD COLUMN `descriptions` TEXT NULL AFTER `metadata`;
+
+ALTER TABLE `deployment`
+ADD COLUMN `force_binary_messages` TEXT NULL AFTER `force_binary_url`;
+
+ALTER TABLE `deployment`
+ADD COLUMN `label_format` VARCHAR(64) NULL AFTER `last_active_time`,
+ADD COLUMN `label_seq` INT NULL AFTER `label_format`;
+
+ALTER TABLE `package`
+DROP INDEX `uk_label`,
+ADD UNIQUE KEY `uk_label` (`deployment_id`,`label`);
+
+ALTER TABLE `package`
+ADD COLUMN `rollout_ramp_start` BIGINT NULL AFTER `rollout_paused`,
+ADD COLUMN `rollout_ramp_minutes` INT NULL AFTER `rollout_ramp_start`,
+ADD COLUMN `rollout_ramp_steps` INT NULL AFTER `rollout_ramp_minutes`,
+ADD COLUMN `rollout_ramp_from` INT NULL AFTER `rollout_ramp_steps`;
+
+ALTER TABLE `deployment`
+ADD COLUMN `default_mandatory` TINYINT(1) NULL AFTER `label_seq`,
+ADD COLUMN `default_rollout` INT NULL AFTER `default_mandatory`,
+ADD COLUMN `max_package_size` BIGINT NULL AFTER `default_rollout`,
+ADD COLUMN `enforce_policy` TINYINT(1) NULL AFTER `max_package_size`;
+
+ALTER TABLE `package`
+ADD COLUMN `is_mandatory` TINYINT(1) NULL AFTER `rollout_ramp_from`;
+
+CREATE TABLE `outbox` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `event_type` varchar(50) DEFAULT NULL,
+  `payload` TEXT DEFAULT NULL,
+  `attempts` int DEFAULT '0',
+  `next_time` bigint DEFAULT NULL,
+  `publish_time` bigint DEFAULT NULL,
+  `sent_time` bigint DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_sent_next` (`sent_time`,`next_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `package`
+ADD COLUMN `provenance` TEXT NULL AFTER `zstd_size`,
+ADD COLUMN `blob_sha256` VARCHAR(64) NULL AFTER `provenance`;
+
+CREATE TABLE `custom_domain` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `tenant` varchar(100) DEFAULT NULL,
+  `domain` varchar(253) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_domain` (`domain`),
+  KEY `idx_tenant` (`tenant`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+CREATE TABLE `tenant_plan` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `tenant` varchar(100) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `plan` varchar(100) DEFAULT NULL,
+  `external_id` varchar(200) DEFAULT NULL,
+  `max_apps` int DEFAULT NULL,
+  `max_deployments` int DEFAULT NULL,
+  `max_storage_gb` int DEFAULT NULL,
+  `max_monthly_active` int DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  `update_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_uid` (`uid`),
+  KEY `idx_tenant` (`tenant`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `deployment`
+ADD COLUMN `key_hmac` VARCHAR(64) NULL AFTER `enforce_policy`,
+ADD COLUMN `key_enc` VARCHAR(512) NULL AFTER `key_hmac`,
+ADD UNIQUE KEY `uk_key_hmac` (`key_hmac`);
+
+ALTER TABLE `audit_log`
+ADD COLUMN `impersonator_uid` INT NULL AFTER `create_time`,
+ADD KEY `idx_impersonator_uid` (`impersonator_uid`);
+
+ALTER TABLE `package`
+ADD FULLTEXT KEY `ft_description` (`description`,`descriptions`) WITH PARSER ngram;
+
+CREATE TABLE `build_pin` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_id` bigint DEFAULT NULL,
+  `deployment_version_id` int DEFAULT NULL,
+  `app_version` varchar(100) DEFAULT NULL,
+  `build_number` varchar(64) DEFAULT NULL,
+  `package_id` bigint DEFAULT NULL,
+  `label` varchar(100) DEFAULT NULL,
+  `note` varchar(500) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_version_build` (`deployment_version_id`,`build_number`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+-- id_generator snowflake: app/deployment/package的id和引用它们的列改为bigint
+ALTER TABLE `apps`
+MODIFY COLUMN `id` bigint NOT NULL AUTO_INCREMENT;
+
+ALTER TABLE `deployment`
+MODIFY COLUMN `id` bigint NOT NULL AUTO_INCREMENT,
+MODIFY COLUMN `app_id` bigint DEFAULT NULL;
+
+ALTER TABLE `package`
+MODIFY COLUMN `id` bigint NOT NULL AUTO_INCREMENT,
+MODIFY COLUMN `deployment_id` bigint DEFAULT NULL;
+
+ALTER TABLE `binary_version`
+MODIFY COLUMN `app_id` bigint DEFAULT NULL;
+
+ALTER TABLE `client_rule`
+MODIFY COLUMN `deployment_id` bigint DEFAULT NULL,
+MODIFY COLUMN `package_id` bigint DEFAULT NULL;
+
+ALTER TABLE `deployment_freeze`
+MODIFY COLUMN `deployment_id` bigint DEFAULT NULL;
+
+ALTER TABLE `deployment_lookup`
+MODIFY COLUMN `deployment_id` bigint DEFAULT NULL,
+MODIFY COLUMN `app_id` bigint DEFAULT NULL,
+MODIFY COLUMN `package_id` bigint DEFAULT NULL;
+
+ALTER TABLE `deployment_version`
+MODIFY COLUMN `deployment_id` bigint DEFAULT NULL,
+MODIFY COLUMN `current_package` bigint DEFAULT NULL;
+
+ALTER TABLE `feature_flag`
+MODIFY COLUMN `app_id` bigint DEFAULT NULL;
+
+ALTER TABLE `invite_token`
+MODIFY COLUMN `deployment_id` bigint DEFAULT NULL,
+MODIFY COLUMN `package_id` bigint DEFAULT NULL;
+
+ALTER TABLE `metric_rollup`
+MODIFY COLUMN `deployment_id` bigint NOT NULL,
+MODIFY COLUMN `package_id` bigint NOT NULL DEFAULT '0';
+
+ALTER TABLE `package_diff`
+MODIFY COLUMN `package_id` bigint DEFAULT NULL,
+MODIFY COLUMN `base_package_id` bigint DEFAULT NULL;
+
+ALTER TABLE `package_tombstone`
+MODIFY COLUMN `package_id` bigint DEFAULT NULL,
+MODIFY COLUMN `deployment_id` bigint DEFAULT NULL;
+
+ALTER TABLE `rollout_history`
+MODIFY COLUMN `package_id` bigint DEFAULT NULL;
+
+ALTER TABLE `apps`
+ADD COLUMN `row_version` int NOT NULL DEFAULT 0;
+
+ALTER TABLE `deployment`
+ADD COLUMN `row_version` int NOT NULL DEFAULT 0;
+
+ALTER TABLE `package`
+ADD COLUMN `row_version` int NOT NULL DEFAULT 0;
+
+CREATE TABLE `metric_event` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `deployment_id` bigint NOT NULL,
+  `package_id` bigint NOT NULL,
+  `active` int NOT NULL DEFAULT '0',
+  `failed` int NOT NULL DEFAULT '0',
+  `installed` int NOT NULL DEFAULT '0',
+  `create_time` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_create_time` (`create_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+CREATE TABLE `release_history` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_version_id` int NOT NULL,
+  `package_id` bigint DEFAULT NULL,
+  `action` varchar(16) NOT NULL,
+  `uid` int DEFAULT NULL,
+  `create_time` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_version_time` (`deployment_version_id`,`create_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+CREATE TABLE `release_job` (
+  `processing_id` varchar(36) NOT NULL,
+  `uid` int NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `payload` mediumtext,
+  `result` text,
+  `error` text,
+  `create_time` bigint NOT NULL,
+  `update_time` bigint NOT NULL,
+  PRIMARY KEY (`processing_id`),
+  KEY `idx_status_update` (`status`,`update_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+
+ALTER TABLE `package`
+ADD COLUMN `replication_attempts` INT NOT NULL DEFAULT 0 AFTER `replication_status`,
+ADD COLUMN `replication_next_time` BIGINT NULL AFTER `replication_attempts`;
+
+ALTER TABLE `users`
+ADD COLUMN `totp_last_step` BIGINT NULL AFTER `totp_recovery_codes`;
+
+ALTER TABLE `tenant_plan`
+ADD COLUMN `totp_required` TINYINT(1) NULL AFTER `max_monthly_active`;
+
+ALTER TABLE `apps`
+ADD COLUMN `public_id` VARCHAR(36) NULL AFTER `id`,
+ADD UNIQUE KEY `uk_public_id` (`public_id`);
+
+ALTER TABLE `deployment`
+ADD COLUMN `public_id` VARCHAR(36) NULL AFTER `id`,
+ADD UNIQUE KEY `uk_public_id` (`public_id`);
+
+ALTER TABLE `package`
+ADD COLUMN `public_id` VARCHAR(36) NULL AFTER `id`,
+ADD UNIQUE KEY `uk_public_id` (`public_id`);
+
+ALTER TABLE `users`
+ADD COLUMN `auth_provider` VARCHAR(16) NULL AFTER `totp_last_step`,
+ADD COLUMN `auth_issuer` VARCHAR(255) NULL AFTER `auth_provider`,
+ADD COLUMN `auth_subject` VARCHAR(255) NULL AFTER `auth_issuer`,
+ADD UNIQUE KEY `uk_external_id` (`auth_provider`,`auth_issuer`,`auth_subject`);
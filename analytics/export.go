@@ -0,0 +1,121 @@
+package analytics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/storage"
+)
+
+const (
+	EVENT_UPDATE_CHECK = "update_check"
+	EVENT_DOWNLOAD     = "download"
+	EVENT_DEPLOY       = "deploy"
+)
+
+// 客户端请求记录,每行一个JSON,字段名与README中的Athena表结构一致
+type Record struct {
+	Time          int64  `json:"time"`
+	Event         string `json:"event"`
+	DeploymentKey string `json:"deployment_key"`
+	AppVersion    string `json:"app_version,omitempty"`
+	Label         string `json:"label,omitempty"`
+	PackageHash   string `json:"package_hash,omitempty"`
+	Status        string `json:"status,omitempty"`
+	Country       string `json:"country,omitempty"`
+}
+
+// 当前小时的gzip缓冲,跨小时或达到记录上限时上传
+type batch struct {
+	hour    time.Time
+	buf     *bytes.Buffer
+	gz      *gzip.Writer
+	records uint
+	part    int
+}
+
+var (
+	mu      sync.Mutex
+	current *batch
+	host, _ = os.Hostname()
+)
+
+func Enabled() bool {
+	return config.GetConfig().AccessExport.Prefix != ""
+}
+
+func Start() {
+	if !Enabled() {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			mu.Lock()
+			var full *batch
+			if current != nil && !current.hour.Equal(time.Now().UTC().Truncate(time.Hour)) {
+				full, current = current, nil
+			}
+			mu.Unlock()
+			if full != nil {
+				upload(full)
+			}
+		}
+	}()
+}
+
+func Add(rec Record) {
+	if !Enabled() {
+		return
+	}
+	now := time.Now().UTC()
+	rec.Time = now.UnixMilli()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	hour := now.Truncate(time.Hour)
+	var full *batch
+	mu.Lock()
+	if current != nil && !current.hour.Equal(hour) {
+		full, current = current, nil
+	}
+	if current == nil {
+		buf := new(bytes.Buffer)
+		current = &batch{hour: hour, buf: buf, gz: gzip.NewWriter(buf)}
+	}
+	current.gz.Write(append(line, '\n'))
+	current.records++
+	if current.records >= config.GetConfig().AccessExport.MaxRecords {
+		full = current
+		current = &batch{hour: hour, buf: new(bytes.Buffer), part: full.part + 1}
+		current.gz = gzip.NewWriter(current.buf)
+	}
+	mu.Unlock()
+	if full != nil {
+		go upload(full)
+	}
+}
+
+// {prefix}dt=2024-01-02/hour=15/{host}-{part}-{unix}.json.gz,按dt/hour分区方便Athena查询
+func upload(b *batch) {
+	if err := b.gz.Close(); err != nil {
+		log.Printf("analytics: gzip error:%s", err.Error())
+		return
+	}
+	key := fmt.Sprintf("%sdt=%s/hour=%s/%s-%d-%d.json.gz", config.GetConfig().AccessExport.Prefix,
+		b.hour.Format("2006-01-02"), b.hour.Format("15"), host, b.part, time.Now().Unix())
+	if _, err := storage.Upload(key, b.buf.Bytes()); err != nil {
+		log.Printf("analytics: upload %s error:%s", key, err.Error())
+		sentry.CaptureError("analytics", err, map[string]string{"key": key})
+		return
+	}
+	log.Printf("analytics: uploaded %s records=%d", key, b.records)
+}
@@ -0,0 +1,195 @@
+package anomaly
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/utils"
+	"com.lc.go.codepush/server/webhook"
+)
+
+const (
+	ALERT_TRAFFIC_DROP   = "traffic_drop"
+	ALERT_FAILED_SPIKE   = "failed_spike"
+	ALERT_ZERO_DOWNLOADS = "zero_downloads"
+)
+
+type Alert struct {
+	Type       string `json:"type"`
+	AppName    string `json:"appName"`
+	Deployment string `json:"deployment"`
+	Label      string `json:"label,omitempty"`
+	Message    string `json:"message"`
+	Time       int64  `json:"time"`
+}
+
+// 配置了告警地址时才统计和检查
+func Enabled() bool {
+	c := config.GetConfig().Anomaly
+	return c.Interval > 0 && (c.WebhookUrl != "" || c.SlackWebhookUrl != "")
+}
+
+func interval() time.Duration {
+	return time.Duration(config.GetConfig().Anomaly.Interval) * time.Second
+}
+
+func bucketOf(t time.Time) int64 {
+	return t.Unix() / int64(config.GetConfig().Anomaly.Interval)
+}
+
+func countKey(bucket int64) string {
+	return constants.REDIS_CHECK_COUNT + strconv.FormatInt(bucket, 10)
+}
+
+// 按周期统计每个部署的update_check次数
+func RecordCheck(deploymentKey string) {
+	if !Enabled() || deploymentKey == "" {
+		return
+	}
+	ttl := interval() * time.Duration(config.GetConfig().Anomaly.BaselineBuckets+2)
+	redis.IncrHash(countKey(bucketOf(time.Now())), deploymentKey, ttl)
+}
+
+// 每个周期只有一个实例执行检查
+func Start() {
+	if !Enabled() {
+		return
+	}
+	go func() {
+		for range time.Tick(interval()) {
+			bucket := bucketOf(time.Now())
+			if redis.SetNX(constants.REDIS_ANOMALY+"lock:"+strconv.FormatInt(bucket, 10), interval()) {
+				analyze(bucket)
+			}
+		}
+	}()
+}
+
+func analyze(bucket int64) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("anomaly: analyze error:%v", r)
+			sentry.CapturePanic("anomaly", r, nil)
+		}
+	}()
+	names := map[int]*Alert{}
+	checkTrafficDrop(bucket, names)
+	checkReleases(bucket, names)
+}
+
+// 当前桶还在计数,比较上一个完整的周期和之前几个周期的平均值
+func checkTrafficDrop(bucket int64, names map[int]*Alert) {
+	c := config.GetConfig().Anomaly
+	current := redis.GetHashCounts(countKey(bucket - 1))
+	totals := map[string]int64{}
+	for i := int64(2); i <= int64(c.BaselineBuckets)+1; i++ {
+		for key, count := range redis.GetHashCounts(countKey(bucket - i)) {
+			totals[key] += count
+		}
+	}
+	for key, total := range totals {
+		avg := float64(total) / float64(c.BaselineBuckets)
+		if avg < float64(c.MinVolume) || float64(current[key]) >= avg*(1-c.DropRatio) {
+			continue
+		}
+		deployment := model.Deployment{}.GetByKey(key)
+		if deployment == nil {
+			continue
+		}
+		alert := describe(*deployment.Id, names)
+		alert.Type = ALERT_TRAFFIC_DROP
+		alert.Message = fmt.Sprintf("update_check volume of %s/%s dropped to %d (baseline %.0f per %ds)", alert.AppName, alert.Deployment, current[key], avg, c.Interval)
+		fire(alert, key, interval()*time.Duration(c.BaselineBuckets))
+	}
+}
+
+type packageSnapshot struct {
+	Active int `json:"active"`
+	Failed int `json:"failed"`
+}
+
+// 最近7天的发布: 安装失败比例突增,或发布后一段时间仍没有下载
+func checkReleases(bucket int64, names map[int]*Alert) {
+	c := config.GetConfig().Anomaly
+	now := time.Now()
+	packs := model.Package{}.GetReleasedAfter(now.Add(-7 * 24 * time.Hour).UnixMilli())
+	if packs == nil {
+		return
+	}
+	zeroDownloadBefore := now.Add(-time.Duration(c.ZeroDownloadMinutes) * time.Minute).UnixMilli()
+	for _, pack := range *packs {
+		snapshotKey := constants.REDIS_ANOMALY + "package:" + strconv.Itoa(*pack.Id)
+		snapshot := redis.GetRedisObj[packageSnapshot](snapshotKey)
+		redis.SetRedisObj(snapshotKey, packageSnapshot{Active: utils.IntValue(pack.Active), Failed: utils.IntValue(pack.Failed)}, 3*interval())
+		if snapshot != nil {
+			failed := utils.IntValue(pack.Failed) - snapshot.Failed
+			active := utils.IntValue(pack.Active) - snapshot.Active
+			if failed >= int(c.MinFailed) && float64(failed) >= float64(failed+active)*c.FailedRatio {
+				alert := describe(*pack.DeploymentId, names)
+				alert.Type = ALERT_FAILED_SPIKE
+				alert.Label = utils.StringValue(pack.Label)
+				alert.Message = fmt.Sprintf("%s/%s %s: %d failed installs vs %d succeeded in the last %ds", alert.AppName, alert.Deployment, alert.Label, failed, active, c.Interval)
+				fire(alert, strconv.Itoa(*pack.Id), interval()*time.Duration(c.BaselineBuckets))
+			}
+		}
+		if *pack.CreateTime <= zeroDownloadBefore && utils.IntValue(pack.Installed) == 0 && utils.IntValue(pack.Active) == 0 && hasTraffic(*pack.DeploymentId, bucket) {
+			alert := describe(*pack.DeploymentId, names)
+			alert.Type = ALERT_ZERO_DOWNLOADS
+			alert.Label = utils.StringValue(pack.Label)
+			alert.Message = fmt.Sprintf("%s/%s %s: no downloads %d minutes after release", alert.AppName, alert.Deployment, alert.Label, c.ZeroDownloadMinutes)
+			fire(alert, strconv.Itoa(*pack.Id), 7*24*time.Hour)
+		}
+	}
+}
+
+// 客户端仍在检查更新,但没有下载新包
+func hasTraffic(deploymentId int, bucket int64) bool {
+	deployment := model.GetOne[model.Deployment]("id", deploymentId)
+	if deployment == nil {
+		return false
+	}
+	for i := int64(1); i <= int64(config.GetConfig().Anomaly.BaselineBuckets); i++ {
+		if redis.GetHashCounts(countKey(bucket - i))[*deployment.Key] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(deploymentId int, names map[int]*Alert) Alert {
+	if alert, ok := names[deploymentId]; ok {
+		return *alert
+	}
+	alert := &Alert{Deployment: strconv.Itoa(deploymentId)}
+	if deployment := model.GetOne[model.Deployment]("id", deploymentId); deployment != nil {
+		alert.Deployment = *deployment.Name
+		if app := model.GetOne[model.App]("id", deployment.AppId); app != nil {
+			alert.AppName = *app.AppName
+		}
+	}
+	names[deploymentId] = alert
+	return *alert
+}
+
+// 同一目标的同类告警在dedupe时间内只发送一次
+func fire(alert Alert, target string, dedupe time.Duration) {
+	if !redis.SetNX(constants.REDIS_ANOMALY+"alert:"+alert.Type+":"+target, dedupe) {
+		return
+	}
+	alert.Time = time.Now().UnixMilli()
+	log.Printf("anomaly: %s", alert.Message)
+	metrics.Count("anomaly.alerts", 1, map[string]string{"type": alert.Type})
+	c := config.GetConfig().Anomaly
+	webhook.Send(c.WebhookUrl, alert)
+	if c.SlackWebhookUrl != "" {
+		webhook.Send(c.SlackWebhookUrl, map[string]string{"text": ":warning: [" + config.GetConfig().TenantName + "] " + alert.Message})
+	}
+}
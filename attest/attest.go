@@ -0,0 +1,87 @@
+package attest
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"log"
+	"strconv"
+	"sync"
+
+	"com.lc.go.codepush/server/config"
+)
+
+const PAYLOAD_TYPE = "application/vnd.in-toto+json"
+
+// DSSE信封,cosign/in-toto的工具可以直接校验
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+type Signature struct {
+	KeyId string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// attestation_signing_key为PKCS8 PEM格式的ed25519私钥,例如 openssl genpkey -algorithm ed25519
+var signingKey = sync.OnceValue(func() ed25519.PrivateKey {
+	data := config.GetConfig().Attestation.SigningKey
+	if data == "" {
+		return nil
+	}
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		log.Panic("attestation_signing_key is not PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		log.Panic("Parse attestation_signing_key error:" + err.Error())
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		log.Panic("attestation_signing_key is not an ed25519 key")
+	}
+	return edKey
+})
+
+func Enabled() bool {
+	return config.GetConfig().Attestation.SigningKey != ""
+}
+
+// 公钥DER的sha256
+func KeyId() string {
+	der, _ := x509.MarshalPKIXPublicKey(signingKey().Public())
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+func PublicKeyPEM() string {
+	der, _ := x509.MarshalPKIXPublicKey(signingKey().Public())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// 没有配置签名密钥时signatures为空
+func Sign(payload []byte) Envelope {
+	envelope := Envelope{
+		PayloadType: PAYLOAD_TYPE,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{},
+	}
+	if !Enabled() {
+		return envelope
+	}
+	sig := ed25519.Sign(signingKey(), pae(PAYLOAD_TYPE, payload))
+	envelope.Signatures = append(envelope.Signatures, Signature{KeyId: KeyId(), Sig: base64.StdEncoding.EncodeToString(sig)})
+	return envelope
+}
+
+// DSSE的Pre-Authentication Encoding
+func pae(payloadType string, payload []byte) []byte {
+	out := []byte("DSSEv1 " + strconv.Itoa(len(payloadType)) + " " + payloadType + " " + strconv.Itoa(len(payload)) + " ")
+	return append(out, payload...)
+}
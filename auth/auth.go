@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"com.lc.go.codepush/server/config"
+)
+
+// 认证后的用户信息
+type Principal struct {
+	Uid        int      `json:"uid"`
+	UserName   string   `json:"userName"`
+	Role       string   `json:"role"`
+	Provider   string   `json:"provider"`
+	Scopes     []string `json:"scopes"`
+	ExpireTime int64    `json:"expireTime"`
+	// 管理员代入该用户时为管理员的uid和用户名
+	ImpersonatorUid  int    `json:"impersonatorUid,omitempty"`
+	ImpersonatorName string `json:"impersonatorName,omitempty"`
+}
+
+// 认证提供者,自定义SSO可以实现该接口并在init中Register
+type Provider interface {
+	ValidateToken(ctx context.Context, token string) (*Principal, error)
+}
+
+// 支持用户名密码登录的认证提供者
+type PasswordProvider interface {
+	Login(ctx context.Context, userName string, password string) (*Principal, error)
+}
+
+var ErrInvalidToken = errors.New("invalid token")
+
+var (
+	providers = map[string]Provider{}
+	lock      sync.RWMutex
+)
+
+func Register(name string, provider Provider) {
+	lock.Lock()
+	defer lock.Unlock()
+	providers[name] = provider
+}
+
+func GetProvider(name string) Provider {
+	lock.RLock()
+	defer lock.RUnlock()
+	return providers[name]
+}
+
+// 按配置顺序依次尝试认证提供者
+func ValidateToken(ctx context.Context, token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	for _, name := range config.GetConfig().Auth.Providers {
+		provider := GetProvider(name)
+		if provider == nil {
+			continue
+		}
+		principal, err := provider.ValidateToken(ctx, token)
+		if err == nil && principal != nil {
+			principal.Provider = name
+			return principal, nil
+		}
+		if err != nil && !errors.Is(err, ErrInvalidToken) {
+			return nil, err
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+// 按配置顺序使用支持密码登录的提供者登录
+func Login(ctx context.Context, userName string, password string) (*Principal, error) {
+	for _, name := range config.GetConfig().Auth.Providers {
+		provider, ok := GetProvider(name).(PasswordProvider)
+		if !ok {
+			continue
+		}
+		principal, err := provider.Login(ctx, userName, password)
+		if err == nil && principal != nil {
+			principal.Provider = name
+			return principal, nil
+		}
+		if err != nil && !errors.Is(err, ErrInvalidToken) {
+			return nil, err
+		}
+	}
+	return nil, ErrInvalidToken
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/google/uuid"
+)
+
+// 首次启动生成的管理员账号,明文只返回一次
+type BootstrapResult struct {
+	UserName   string `json:"userName"`
+	Password   string `json:"password"`
+	AccessKey  string `json:"accessKey"`
+	ExpireTime int64  `json:"expireTime"`
+}
+
+var ErrAlreadyBootstrapped = errors.New("users already exist")
+
+// 用户表为空时创建管理员和一个一年有效的access key,多实例并发调用时只有一个成功
+func Bootstrap(userName string) (*BootstrapResult, error) {
+	if (model.User{}).Count() > 0 {
+		return nil, ErrAlreadyBootstrapped
+	}
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	password := hex.EncodeToString(b)
+	// 客户端登录时提交md5(password)
+	sum := md5.Sum([]byte(password))
+	passwordHash := hex.EncodeToString(sum[:])
+	role := constants.ROLE_ADMIN
+	user := model.User{
+		UserName: &userName,
+		Password: &passwordHash,
+		Role:     &role,
+	}
+	accessKey := uuid.NewString()
+	expireTime := *utils.GetTimeNow() + 365*24*60*60*1000
+	del := false
+	token := model.Token{
+		Token:      &accessKey,
+		ExpireTime: &expireTime,
+		Del:        &del,
+	}
+	created, err := model.User{}.CreateFirst(&user, &token)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, ErrAlreadyBootstrapped
+	}
+	model.AddAuditLog(*user.Id, "bootstrap", userName, "")
+	return &BootstrapResult{
+		UserName:   userName,
+		Password:   password,
+		AccessKey:  accessKey,
+		ExpireTime: expireTime,
+	}, nil
+}
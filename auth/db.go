@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/utils"
+)
+
+func init() {
+	Register("db", dbProvider{})
+}
+
+// 登录接口生成的token
+type dbProvider struct{}
+
+func (dbProvider) ValidateToken(ctx context.Context, token string) (*Principal, error) {
+	tokenNow := model.GetOne[model.Token]("token=?", token)
+	if tokenNow == nil || (tokenNow.Del != nil && *tokenNow.Del) {
+		return nil, ErrInvalidToken
+	}
+	if tokenNow.ExpireTime == nil || *utils.GetTimeNow() > *tokenNow.ExpireTime {
+		return nil, ErrInvalidToken
+	}
+	user := model.GetOne[model.User]("id=?", *tokenNow.Uid)
+	if user == nil {
+		return nil, ErrInvalidToken
+	}
+	return &Principal{
+		Uid:        *user.Id,
+		UserName:   *user.UserName,
+		Role:       user.GetRole(),
+		Scopes:     []string{"all"},
+		ExpireTime: *tokenNow.ExpireTime,
+	}, nil
+}
+
+func (dbProvider) Login(ctx context.Context, userName string, password string) (*Principal, error) {
+	user := model.GetOne[model.User]("user_name", userName)
+	if user == nil || user.Password == nil || *user.Password != password {
+		return nil, ErrInvalidToken
+	}
+	return &Principal{
+		Uid:      *user.Id,
+		UserName: *user.UserName,
+		Role:     user.GetRole(),
+		Scopes:   []string{"all"},
+	}, nil
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"time"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/google/uuid"
+)
+
+// 管理员代入其他用户的会话,请求头X-Impersonation-Session带上会话id
+type ImpersonationSession struct {
+	Id         string `json:"id"`
+	AdminUid   int    `json:"adminUid"`
+	AdminName  string `json:"adminName"`
+	Uid        int    `json:"uid"`
+	UserName   string `json:"userName"`
+	Reason     string `json:"reason"`
+	CreateTime int64  `json:"createTime"`
+	ExpireTime int64  `json:"expireTime"`
+}
+
+func StartImpersonation(admin *Principal, user *model.User, reason string, duration time.Duration) *ImpersonationSession {
+	now := time.Now()
+	session := &ImpersonationSession{
+		Id:         uuid.NewString(),
+		AdminUid:   admin.Uid,
+		AdminName:  admin.UserName,
+		Uid:        *user.Id,
+		UserName:   *user.UserName,
+		Reason:     reason,
+		CreateTime: now.UnixMilli(),
+		ExpireTime: now.Add(duration).UnixMilli(),
+	}
+	redis.SetRedisObj(constants.REDIS_IMPERSONATION+session.Id, session, duration)
+	return session
+}
+
+func GetImpersonation(id string) *ImpersonationSession {
+	session := redis.GetRedisObj[ImpersonationSession](constants.REDIS_IMPERSONATION + id)
+	if session == nil || session.ExpireTime < time.Now().UnixMilli() {
+		return nil
+	}
+	return session
+}
+
+func EndImpersonation(id string) {
+	redis.DelRedisObj(constants.REDIS_IMPERSONATION + id)
+}
+
+// 只有发起会话的管理员可以使用,返回被代入用户的身份
+func Impersonate(admin *Principal, id string) (*Principal, *ImpersonationSession) {
+	if admin.Role != constants.ROLE_ADMIN {
+		return nil, nil
+	}
+	session := GetImpersonation(id)
+	if session == nil || session.AdminUid != admin.Uid {
+		return nil, nil
+	}
+	user := model.GetOne[model.User]("id", session.Uid)
+	if user == nil {
+		return nil, nil
+	}
+	return &Principal{
+		Uid:              session.Uid,
+		UserName:         session.UserName,
+		Role:             user.GetRole(),
+		Provider:         "impersonation",
+		ExpireTime:       session.ExpireTime,
+		ImpersonatorUid:  admin.Uid,
+		ImpersonatorName: admin.UserName,
+	}, session
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func init() {
+	Register("ldap", ldapProvider{})
+}
+
+// LDAP/AD 登录,登录成功后由db provider校验生成的token
+type ldapProvider struct{}
+
+func (ldapProvider) ValidateToken(ctx context.Context, token string) (*Principal, error) {
+	return nil, ErrInvalidToken
+}
+
+func (ldapProvider) Login(ctx context.Context, userName string, password string) (*Principal, error) {
+	ldapConfig := config.GetConfig().Auth.Ldap
+	if ldapConfig.Url == "" || password == "" {
+		return nil, ErrInvalidToken
+	}
+	conn, err := ldap.DialURL(ldapConfig.Url, ldap.DialWithTLSConfig(&tls.Config{
+		InsecureSkipVerify: ldapConfig.InsecureSkipVerify,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if ldapConfig.BindDN != "" {
+		if err := conn.Bind(ldapConfig.BindDN, ldapConfig.BindPassword); err != nil {
+			return nil, err
+		}
+	}
+	search := ldap.NewSearchRequest(
+		ldapConfig.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(ldapConfig.UserFilter, ldap.EscapeFilter(userName)),
+		[]string{"dn", ldapConfig.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidToken
+	}
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	role := mapGroupRole(entry.GetAttributeValues(ldapConfig.GroupAttribute), ldapConfig.GroupRoles, ldapConfig.DefaultRole)
+	if role == "" {
+		return nil, ErrInvalidToken
+	}
+	user, err := findOrCreateExternalUser("ldap", "", entry.DN, userName, true)
+	if err != nil {
+		return nil, err
+	}
+	// 按DN找到的账号都是LDAP创建的,只更新这些账号的角色
+	if user.Role == nil || *user.Role != role {
+		model.User{}.UpdateRole(*user.Id, role)
+	}
+	return &Principal{
+		Uid:      *user.Id,
+		UserName: *user.UserName,
+		Role:     role,
+		Scopes:   []string{"all"},
+	}, nil
+}
+
+// groupRoles格式: groupDN1:role1;groupDN2:role2 (DN中包含逗号,所以用分号分隔),第一个匹配的组生效
+func mapGroupRole(groups []string, groupRoles string, defaultRole string) string {
+	for _, item := range strings.Split(groupRoles, ";") {
+		index := strings.LastIndex(item, ":")
+		if index <= 0 {
+			continue
+		}
+		groupDN := strings.TrimSpace(item[:index])
+		for _, group := range groups {
+			if strings.EqualFold(group, groupDN) {
+				return strings.TrimSpace(item[index+1:])
+			}
+		}
+	}
+	return defaultRole
+}
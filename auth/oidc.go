@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/egress"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+)
+
+func init() {
+	Register("oidc", oidcProvider{})
+}
+
+// 使用IdP的userinfo接口校验access token
+type oidcProvider struct{}
+
+type oidcUserInfo struct {
+	Sub               string `json:"sub"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+	Scope             string `json:"scope"`
+}
+
+var oidcClient = egress.Client(10 * time.Second)
+
+func (oidcProvider) ValidateToken(ctx context.Context, token string) (*Principal, error) {
+	authConfig := config.GetConfig().Auth
+	if authConfig.OidcUserinfoUrl == "" {
+		return nil, ErrInvalidToken
+	}
+	sum := sha256.Sum256([]byte(token))
+	redisKey := constants.REDIS_OIDC_TOKEN + hex.EncodeToString(sum[:])
+	if principal := redis.GetRedisObj[Principal](redisKey); principal != nil {
+		return principal, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authConfig.OidcUserinfoUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	rep, err := oidcClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+	if rep.StatusCode == http.StatusUnauthorized || rep.StatusCode == http.StatusForbidden {
+		return nil, ErrInvalidToken
+	}
+	if rep.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: userinfo status %d", rep.StatusCode)
+	}
+	var info oidcUserInfo
+	if err := json.NewDecoder(rep.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	if info.Sub == "" {
+		return nil, ErrInvalidToken
+	}
+	userName := info.PreferredUsername
+	if userName == "" {
+		userName = info.Email
+	}
+	if userName == "" {
+		userName = info.Sub
+	}
+	// userinfo中没有iss,用userinfo地址区分IdP
+	user, err := findOrCreateExternalUser("oidc", authConfig.OidcUserinfoUrl, info.Sub, userName, authConfig.OidcAutoCreateUser)
+	if err != nil {
+		return nil, err
+	}
+	principal := &Principal{
+		Uid:      *user.Id,
+		UserName: *user.UserName,
+		Role:     user.GetRole(),
+		Scopes:   strings.Fields(info.Scope),
+	}
+	redis.SetRedisObj(redisKey, principal, 5*time.Minute)
+	return principal, nil
+}
+
+// 外部认证的用户在本地users表中对应一条记录,应用归属于该uid;
+// 按provider、issuer和subject查找,用户名已被其他账号使用时拒绝登录,不绑定到已有账号
+func findOrCreateExternalUser(provider string, issuer string, subject string, userName string, autoCreate bool) (*model.User, error) {
+	if user := (model.User{}).GetByExternalId(provider, issuer, subject); user != nil {
+		return user, nil
+	}
+	if !autoCreate {
+		return nil, ErrInvalidToken
+	}
+	if model.GetOne[model.User]("user_name=?", userName) != nil {
+		log.Printf("auth: %s login of %s refused, user name %s is used by another account", provider, subject, userName)
+		return nil, ErrInvalidToken
+	}
+	role := constants.ROLE_DEVELOPER
+	user := &model.User{UserName: &userName, Role: &role, AuthProvider: &provider, AuthIssuer: &issuer, AuthSubject: &subject}
+	if err := model.Create[model.User](user); err != nil {
+		// 同一个用户同时登录时只有一个创建成功
+		if user := (model.User{}).GetByExternalId(provider, issuer, subject); user != nil {
+			return user, nil
+		}
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"strconv"
+	"strings"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model"
+)
+
+func init() {
+	Register("static", staticProvider{})
+}
+
+// 配置中的静态key,格式: key1:uid1,key2:uid2
+type staticProvider struct{}
+
+func (staticProvider) ValidateToken(ctx context.Context, token string) (*Principal, error) {
+	for _, item := range strings.Split(config.GetConfig().Auth.StaticKeys, ",") {
+		key, uidStr, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || subtle.ConstantTimeCompare([]byte(key), []byte(token)) != 1 {
+			continue
+		}
+		uid, err := strconv.Atoi(uidStr)
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+		user := model.GetOne[model.User]("id=?", uid)
+		if user == nil {
+			return nil, ErrInvalidToken
+		}
+		return &Principal{
+			Uid:      uid,
+			UserName: *user.UserName,
+			Role:     user.GetRole(),
+			Scopes:   []string{"all"},
+		}, nil
+	}
+	return nil, ErrInvalidToken
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RFC 6238, 30秒步长, 6位数字
+func GenerateTotpSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return totpEncoding.EncodeToString(b)
+}
+
+func TotpUrl(issuer string, userName string, secret string) string {
+	label := url.PathEscape(issuer + ":" + userName)
+	return "otpauth://totp/" + label + "?secret=" + secret + "&issuer=" + url.QueryEscape(issuer)
+}
+
+// 允许前后各一个步长的时钟偏差,返回匹配的时间步,调用方记录下来防止重复使用
+func ValidateTotp(secret string, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != 6 {
+		return 0, false
+	}
+	counter := now.Unix() / 30
+	for _, i := range []int64{-1, 0, 1} {
+		if hmac.Equal([]byte(totpCode(key, counter+i)), []byte(code)) {
+			return counter + i, true
+		}
+	}
+	return 0, false
+}
+
+func totpCode(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// 生成恢复码,返回明文(只展示一次)和逗号分隔的hash
+func GenerateRecoveryCodes(count int) ([]string, string) {
+	codes := make([]string, count)
+	hashes := make([]string, count)
+	for i := range codes {
+		b := make([]byte, 5)
+		rand.Read(b)
+		codes[i] = hex.EncodeToString(b)
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, strings.Join(hashes, ",")
+}
+
+// 校验并消耗恢复码,返回剩余的hash
+func UseRecoveryCode(hashes string, code string) (string, bool) {
+	codeHash := hashRecoveryCode(strings.ToLower(strings.TrimSpace(code)))
+	var left []string
+	found := false
+	for _, v := range strings.Split(hashes, ",") {
+		if !found && v != "" && hmac.Equal([]byte(v), []byte(codeHash)) {
+			found = true
+			continue
+		}
+		left = append(left, v)
+	}
+	return strings.Join(left, ","), found
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
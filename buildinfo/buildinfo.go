@@ -0,0 +1,62 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// 构建时写入,例如
+// go build -ldflags "-X com.lc.go.codepush/server/buildinfo.GitSha=$(git rev-parse HEAD) -X com.lc.go.codepush/server/buildinfo.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+// 没有写入时使用go build记录的vcs信息
+var (
+	Version   = "1.0.5"
+	GitSha    = ""
+	BuildDate = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	GitSha    string `json:"gitSha"`
+	BuildDate string `json:"buildDate"`
+	// 构建时工作区有未提交的修改
+	Dirty     bool   `json:"dirty,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+var Get = sync.OnceValue(func() Info {
+	info := Info{Version: Version, GitSha: GitSha, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.GitSha == "" {
+					info.GitSha = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			case "vcs.modified":
+				info.Dirty = s.Value == "true"
+			}
+		}
+	}
+	if info.GitSha == "" {
+		info.GitSha = "unknown"
+	}
+	return info
+})
+
+// 日志和指标中使用的短sha
+func (i Info) ShortSha() string {
+	if len(i.GitSha) > 12 {
+		return i.GitSha[:12]
+	}
+	return i.GitSha
+}
+
+// Sentry的release,例如 code-push-server-go@1.0.5+3f2a9c1d0b7e
+func (i Info) Release() string {
+	return "code-push-server-go@" + i.Version + "+" + i.ShortSha()
+}
@@ -25,12 +25,20 @@ DROP TABLE IF EXISTS `apps`;
 /*!40101 SET @saved_cs_client     = @@character_set_client */;
 /*!50503 SET character_set_client = utf8mb4 */;
 CREATE TABLE `apps` (
-  `id` int NOT NULL AUTO_INCREMENT,
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `public_id` varchar(36) DEFAULT NULL,
   `uid` int DEFAULT NULL,
   `app_name` varchar(256) DEFAULT NULL,
   `os` int DEFAULT NULL,
   `create_time` bigint DEFAULT NULL,
-  PRIMARY KEY (`id`)
+  `display_name` varchar(256) DEFAULT NULL,
+  `platform` varchar(45) DEFAULT NULL,
+  `icon` varchar(256) DEFAULT NULL,
+  `app_store_url` varchar(500) DEFAULT NULL,
+  `play_store_url` varchar(500) DEFAULT NULL,
+  `row_version` int NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_public_id` (`public_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -43,6 +51,158 @@ LOCK TABLES `apps` WRITE;
 /*!40000 ALTER TABLE `apps` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `audit_log`
+--
+
+DROP TABLE IF EXISTS `audit_log`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `audit_log` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `uid` int DEFAULT NULL,
+  `action` varchar(64) DEFAULT NULL,
+  `target` varchar(256) DEFAULT NULL,
+  `detail` TEXT DEFAULT NULL,
+  `client_ip` varchar(64) DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  `impersonator_uid` int DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_uid` (`uid`),
+  KEY `idx_impersonator_uid` (`impersonator_uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `audit_log`
+--
+
+LOCK TABLES `audit_log` WRITE;
+/*!40000 ALTER TABLE `audit_log` DISABLE KEYS */;
+/*!40000 ALTER TABLE `audit_log` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `binary_version`
+--
+
+DROP TABLE IF EXISTS `binary_version`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `binary_version` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `app_id` bigint DEFAULT NULL,
+  `app_version` varchar(45) DEFAULT NULL,
+  `target_range` varchar(45) DEFAULT NULL,
+  `live` tinyint(1) DEFAULT '1',
+  `update_time` bigint DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_app_version` (`app_id`,`app_version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `binary_version`
+--
+
+LOCK TABLES `binary_version` WRITE;
+/*!40000 ALTER TABLE `binary_version` DISABLE KEYS */;
+/*!40000 ALTER TABLE `binary_version` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `build_pin`
+--
+
+DROP TABLE IF EXISTS `build_pin`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `build_pin` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_id` bigint DEFAULT NULL,
+  `deployment_version_id` int DEFAULT NULL,
+  `app_version` varchar(100) DEFAULT NULL,
+  `build_number` varchar(64) DEFAULT NULL,
+  `package_id` bigint DEFAULT NULL,
+  `label` varchar(100) DEFAULT NULL,
+  `note` varchar(500) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_version_build` (`deployment_version_id`,`build_number`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `build_pin`
+--
+
+LOCK TABLES `build_pin` WRITE;
+/*!40000 ALTER TABLE `build_pin` DISABLE KEYS */;
+/*!40000 ALTER TABLE `build_pin` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `client_rule`
+--
+
+DROP TABLE IF EXISTS `client_rule`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `client_rule` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_id` bigint DEFAULT NULL,
+  `client_unique_id` varchar(256) DEFAULT NULL,
+  `action` varchar(20) DEFAULT NULL,
+  `package_id` bigint DEFAULT NULL,
+  `note` varchar(500) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  `expire_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `client_rule`
+--
+
+LOCK TABLES `client_rule` WRITE;
+/*!40000 ALTER TABLE `client_rule` DISABLE KEYS */;
+/*!40000 ALTER TABLE `client_rule` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `custom_domain`
+--
+
+DROP TABLE IF EXISTS `custom_domain`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `custom_domain` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `tenant` varchar(100) DEFAULT NULL,
+  `domain` varchar(253) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_domain` (`domain`),
+  KEY `idx_tenant` (`tenant`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `custom_domain`
+--
+
+LOCK TABLES `custom_domain` WRITE;
+/*!40000 ALTER TABLE `custom_domain` DISABLE KEYS */;
+/*!40000 ALTER TABLE `custom_domain` ENABLE KEYS */;
+UNLOCK TABLES;
+
 --
 -- Table structure for table `deployment`
 --
@@ -51,14 +211,37 @@ DROP TABLE IF EXISTS `deployment`;
 /*!40101 SET @saved_cs_client     = @@character_set_client */;
 /*!50503 SET character_set_client = utf8mb4 */;
 CREATE TABLE `deployment` (
-  `id` int NOT NULL AUTO_INCREMENT,
-  `app_id` int DEFAULT NULL,
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `public_id` varchar(36) DEFAULT NULL,
+  `app_id` bigint DEFAULT NULL,
   `name` varchar(256) DEFAULT NULL,
   `key` varchar(256) DEFAULT NULL,
   `version_id` int DEFAULT NULL,
   `update_time` bigint DEFAULT NULL,
   `create_time` bigint DEFAULT NULL,
-  PRIMARY KEY (`id`)
+  `require_approval` tinyint(1) DEFAULT '0',
+  `approvers` varchar(1024) DEFAULT NULL,
+  `secret_hash` varchar(64) DEFAULT NULL,
+  `previous_secret_hash` varchar(64) DEFAULT NULL,
+  `force_binary_update` tinyint(1) DEFAULT '0',
+  `force_binary_message` varchar(1024) DEFAULT NULL,
+  `force_binary_url` varchar(500) DEFAULT NULL,
+  `force_binary_messages` text,
+  `ephemeral_days` int DEFAULT NULL,
+  `last_active_time` bigint DEFAULT NULL,
+  `label_format` varchar(64) DEFAULT NULL,
+  `label_seq` int DEFAULT NULL,
+  `default_mandatory` tinyint(1) DEFAULT NULL,
+  `default_rollout` int DEFAULT NULL,
+  `max_package_size` bigint DEFAULT NULL,
+  `enforce_policy` tinyint(1) DEFAULT NULL,
+  `key_hmac` varchar(64) DEFAULT NULL,
+  `key_enc` varchar(512) DEFAULT NULL,
+  `row_version` int NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_public_id` (`public_id`),
+  UNIQUE KEY `uk_key` (`key`),
+  UNIQUE KEY `uk_key_hmac` (`key_hmac`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -71,6 +254,68 @@ LOCK TABLES `deployment` WRITE;
 /*!40000 ALTER TABLE `deployment` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `deployment_freeze`
+--
+
+DROP TABLE IF EXISTS `deployment_freeze`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `deployment_freeze` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_id` bigint DEFAULT NULL,
+  `start_day` int DEFAULT NULL,
+  `start_time` varchar(5) DEFAULT NULL,
+  `end_day` int DEFAULT NULL,
+  `end_time` varchar(5) DEFAULT NULL,
+  `timezone` varchar(64) DEFAULT NULL,
+  `overriders` varchar(1024) DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `deployment_freeze`
+--
+
+LOCK TABLES `deployment_freeze` WRITE;
+/*!40000 ALTER TABLE `deployment_freeze` DISABLE KEYS */;
+/*!40000 ALTER TABLE `deployment_freeze` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `deployment_lookup`
+--
+
+DROP TABLE IF EXISTS `deployment_lookup`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `deployment_lookup` (
+  `deployment_key` varchar(256) NOT NULL,
+  `bundle_name` varchar(128) NOT NULL DEFAULT '',
+  `app_version` varchar(45) NOT NULL,
+  `deployment_id` bigint DEFAULT NULL,
+  `app_id` bigint DEFAULT NULL,
+  `deployment_version_id` int DEFAULT NULL,
+  `package_id` bigint DEFAULT NULL,
+  `new_version` varchar(45) DEFAULT NULL,
+  `update_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`deployment_key`,`bundle_name`,`app_version`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `deployment_lookup`
+--
+
+LOCK TABLES `deployment_lookup` WRITE;
+/*!40000 ALTER TABLE `deployment_lookup` DISABLE KEYS */;
+/*!40000 ALTER TABLE `deployment_lookup` ENABLE KEYS */;
+UNLOCK TABLES;
+
 --
 -- Table structure for table `deployment_version`
 --
@@ -80,13 +325,15 @@ DROP TABLE IF EXISTS `deployment_version`;
 /*!50503 SET character_set_client = utf8mb4 */;
 CREATE TABLE `deployment_version` (
   `id` int NOT NULL AUTO_INCREMENT,
-  `deployment_id` int DEFAULT NULL,
+  `deployment_id` bigint DEFAULT NULL,
+  `bundle_name` varchar(128) NOT NULL DEFAULT '',
   `app_version` varchar(45) DEFAULT NULL,
   `version_num` bigint DEFAULT NULL,
-  `current_package` int DEFAULT NULL,
+  `current_package` bigint DEFAULT NULL,
   `update_time` bigint DEFAULT NULL,
   `create_time` bigint DEFAULT NULL,
-  PRIMARY KEY (`id`)
+  PRIMARY KEY (`id`),
+  KEY `idx_deployment_bundle_version` (`deployment_id`,`bundle_name`,`app_version`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -99,6 +346,146 @@ LOCK TABLES `deployment_version` WRITE;
 /*!40000 ALTER TABLE `deployment_version` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `feature_flag`
+--
+
+DROP TABLE IF EXISTS `feature_flag`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `feature_flag` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `tenant` varchar(100) DEFAULT NULL,
+  `name` varchar(100) DEFAULT NULL,
+  `app_id` bigint DEFAULT NULL,
+  `enabled` tinyint(1) DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  `update_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_tenant_name_app` (`tenant`,`name`,`app_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `feature_flag`
+--
+
+LOCK TABLES `feature_flag` WRITE;
+/*!40000 ALTER TABLE `feature_flag` DISABLE KEYS */;
+/*!40000 ALTER TABLE `feature_flag` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `invite_token`
+--
+
+DROP TABLE IF EXISTS `invite_token`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `invite_token` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_id` bigint DEFAULT NULL,
+  `package_id` bigint DEFAULT NULL,
+  `token_hash` varchar(64) DEFAULT NULL,
+  `note` varchar(500) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `expire_time` bigint DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_token_hash` (`token_hash`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `invite_token`
+--
+
+LOCK TABLES `invite_token` WRITE;
+/*!40000 ALTER TABLE `invite_token` DISABLE KEYS */;
+/*!40000 ALTER TABLE `invite_token` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `metric_event`
+--
+
+DROP TABLE IF EXISTS `metric_event`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `metric_event` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `deployment_id` bigint NOT NULL,
+  `package_id` bigint NOT NULL,
+  `active` int NOT NULL DEFAULT '0',
+  `failed` int NOT NULL DEFAULT '0',
+  `installed` int NOT NULL DEFAULT '0',
+  `create_time` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_create_time` (`create_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `metric_rollup`
+--
+
+DROP TABLE IF EXISTS `metric_rollup`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `metric_rollup` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `granularity` varchar(8) NOT NULL,
+  `bucket_time` bigint NOT NULL,
+  `deployment_id` bigint NOT NULL,
+  `package_id` bigint NOT NULL DEFAULT '0',
+  `metric` varchar(32) NOT NULL,
+  `value` bigint NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_bucket` (`granularity`,`bucket_time`,`deployment_id`,`package_id`,`metric`),
+  KEY `idx_deployment` (`deployment_id`,`granularity`,`bucket_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `metric_rollup`
+--
+
+LOCK TABLES `metric_rollup` WRITE;
+/*!40000 ALTER TABLE `metric_rollup` DISABLE KEYS */;
+/*!40000 ALTER TABLE `metric_rollup` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `outbox`
+--
+
+DROP TABLE IF EXISTS `outbox`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `outbox` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `event_type` varchar(50) DEFAULT NULL,
+  `payload` TEXT DEFAULT NULL,
+  `attempts` int DEFAULT '0',
+  `next_time` bigint DEFAULT NULL,
+  `publish_time` bigint DEFAULT NULL,
+  `sent_time` bigint DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_sent_next` (`sent_time`,`next_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `outbox`
+--
+
+LOCK TABLES `outbox` WRITE;
+/*!40000 ALTER TABLE `outbox` DISABLE KEYS */;
+/*!40000 ALTER TABLE `outbox` ENABLE KEYS */;
+UNLOCK TABLES;
+
 --
 -- Table structure for table `package`
 --
@@ -107,8 +494,9 @@ DROP TABLE IF EXISTS `package`;
 /*!40101 SET @saved_cs_client     = @@character_set_client */;
 /*!50503 SET character_set_client = utf8mb4 */;
 CREATE TABLE `package` (
-  `id` int NOT NULL AUTO_INCREMENT,
-  `deployment_id` int DEFAULT NULL,
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `public_id` varchar(36) DEFAULT NULL,
+  `deployment_id` bigint DEFAULT NULL,
   `deployment_version_id` int DEFAULT NULL,
   `size` bigint DEFAULT NULL,
   `hash` varchar(256) DEFAULT NULL,
@@ -118,7 +506,34 @@ CREATE TABLE `package` (
   `failed` int DEFAULT '0',
   `installed` int DEFAULT '0',
   `create_time` bigint DEFAULT NULL,
-  PRIMARY KEY (`id`)
+  `replication_status` varchar(20) DEFAULT NULL,
+  `replication_attempts` int NOT NULL DEFAULT 0,
+  `replication_next_time` bigint DEFAULT NULL,
+  `label` varchar(64) DEFAULT NULL,
+  `idempotency_key` varchar(128) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `status` varchar(20) DEFAULT NULL,
+  `approved_by` int DEFAULT NULL,
+  `rollout` int DEFAULT NULL,
+  `rollout_paused` tinyint(1) DEFAULT NULL,
+  `rollout_ramp_start` bigint DEFAULT NULL,
+  `rollout_ramp_minutes` int DEFAULT NULL,
+  `rollout_ramp_steps` int DEFAULT NULL,
+  `rollout_ramp_from` int DEFAULT NULL,
+  `is_mandatory` tinyint(1) DEFAULT NULL,
+  `metadata` text,
+  `descriptions` text,
+  `zstd_download` varchar(256) DEFAULT NULL,
+  `zstd_size` bigint DEFAULT NULL,
+  `provenance` text,
+  `blob_sha256` varchar(64) DEFAULT NULL,
+  `row_version` int NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_public_id` (`public_id`),
+  KEY `idx_replication_status` (`replication_status`),
+  UNIQUE KEY `uk_label` (`deployment_id`,`label`),
+  UNIQUE KEY `uk_idempotency_key` (`deployment_id`,`idempotency_key`),
+  FULLTEXT KEY `ft_description` (`description`,`descriptions`) /*!50100 WITH PARSER `ngram` */
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -134,6 +549,206 @@ LOCK TABLES `package` WRITE;
 /*!40000 ALTER TABLE `package` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `package_diff`
+--
+
+DROP TABLE IF EXISTS `package_diff`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `package_diff` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `package_id` bigint DEFAULT NULL,
+  `base_package_id` bigint DEFAULT NULL,
+  `base_hash` varchar(100) DEFAULT NULL,
+  `download` varchar(256) DEFAULT NULL,
+  `size` bigint DEFAULT NULL,
+  `status` varchar(20) DEFAULT NULL,
+  `error` varchar(500) DEFAULT NULL,
+  `wait_ms` bigint DEFAULT NULL,
+  `duration_ms` bigint DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_package_base` (`package_id`,`base_package_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `package_diff`
+--
+
+LOCK TABLES `package_diff` WRITE;
+/*!40000 ALTER TABLE `package_diff` DISABLE KEYS */;
+/*!40000 ALTER TABLE `package_diff` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `package_tombstone`
+--
+
+DROP TABLE IF EXISTS `package_tombstone`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `package_tombstone` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `package_id` bigint DEFAULT NULL,
+  `deployment_id` bigint DEFAULT NULL,
+  `deployment_version_id` int DEFAULT NULL,
+  `label` varchar(64) DEFAULT NULL,
+  `hash` varchar(256) DEFAULT NULL,
+  `size` bigint DEFAULT NULL,
+  `download` varchar(256) DEFAULT NULL,
+  `zstd_download` varchar(256) DEFAULT NULL,
+  `description` TEXT DEFAULT NULL,
+  `reason` varchar(500) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `release_time` bigint DEFAULT NULL,
+  `delete_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_deployment_id` (`deployment_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `package_tombstone`
+--
+
+LOCK TABLES `package_tombstone` WRITE;
+/*!40000 ALTER TABLE `package_tombstone` DISABLE KEYS */;
+/*!40000 ALTER TABLE `package_tombstone` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `release_history`
+--
+
+DROP TABLE IF EXISTS `release_history`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `release_history` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `deployment_version_id` int NOT NULL,
+  `package_id` bigint DEFAULT NULL,
+  `action` varchar(16) NOT NULL,
+  `uid` int DEFAULT NULL,
+  `create_time` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_version_time` (`deployment_version_id`,`create_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `release_job`
+--
+
+DROP TABLE IF EXISTS `release_job`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `release_job` (
+  `processing_id` varchar(36) NOT NULL,
+  `uid` int NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `payload` mediumtext,
+  `result` text,
+  `error` text,
+  `create_time` bigint NOT NULL,
+  `update_time` bigint NOT NULL,
+  PRIMARY KEY (`processing_id`),
+  KEY `idx_status_update` (`status`,`update_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `rollout_history`
+--
+
+DROP TABLE IF EXISTS `rollout_history`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `rollout_history` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `package_id` bigint DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `action` varchar(20) DEFAULT NULL,
+  `from_rollout` int DEFAULT NULL,
+  `to_rollout` int DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_package_id` (`package_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `rollout_history`
+--
+
+LOCK TABLES `rollout_history` WRITE;
+/*!40000 ALTER TABLE `rollout_history` DISABLE KEYS */;
+/*!40000 ALTER TABLE `rollout_history` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `storage_pending`
+--
+
+DROP TABLE IF EXISTS `storage_pending`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `storage_pending` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `object_key` varchar(256) DEFAULT NULL,
+  `provider` varchar(20) DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_object_key` (`object_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `storage_pending`
+--
+
+LOCK TABLES `storage_pending` WRITE;
+/*!40000 ALTER TABLE `storage_pending` DISABLE KEYS */;
+/*!40000 ALTER TABLE `storage_pending` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `tenant_plan`
+--
+
+DROP TABLE IF EXISTS `tenant_plan`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `tenant_plan` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `tenant` varchar(100) DEFAULT NULL,
+  `uid` int DEFAULT NULL,
+  `plan` varchar(100) DEFAULT NULL,
+  `external_id` varchar(200) DEFAULT NULL,
+  `max_apps` int DEFAULT NULL,
+  `max_deployments` int DEFAULT NULL,
+  `max_storage_gb` int DEFAULT NULL,
+  `max_monthly_active` int DEFAULT NULL,
+  `totp_required` tinyint(1) DEFAULT NULL,
+  `create_time` bigint DEFAULT NULL,
+  `update_time` bigint DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_uid` (`uid`),
+  KEY `idx_tenant` (`tenant`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `tenant_plan`
+--
+
+LOCK TABLES `tenant_plan` WRITE;
+/*!40000 ALTER TABLE `tenant_plan` DISABLE KEYS */;
+/*!40000 ALTER TABLE `tenant_plan` ENABLE KEYS */;
+UNLOCK TABLES;
+
 --
 -- Table structure for table `token`
 --
@@ -168,22 +783,22 @@ DROP TABLE IF EXISTS `users`;
 /*!40101 SET @saved_cs_client     = @@character_set_client */;
 /*!50503 SET character_set_client = utf8mb4 */;
 CREATE TABLE `users` (
-  `id` int NOT NULL,
-  `user_name` varchar(45) DEFAULT NULL,
+  `id` int NOT NULL AUTO_INCREMENT,
+  `user_name` varchar(200) DEFAULT NULL,
   `password` varchar(45) DEFAULT NULL,
-  PRIMARY KEY (`id`)
+  `role` varchar(45) NOT NULL DEFAULT 'developer',
+  `totp_secret` varchar(64) DEFAULT NULL,
+  `totp_enabled` tinyint(1) DEFAULT '0',
+  `totp_recovery_codes` TEXT DEFAULT NULL,
+  `totp_last_step` bigint DEFAULT NULL,
+  `auth_provider` varchar(16) DEFAULT NULL,
+  `auth_issuer` varchar(255) DEFAULT NULL,
+  `auth_subject` varchar(255) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_external_id` (`auth_provider`,`auth_issuer`,`auth_subject`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
---
--- Dumping data for table `users`
---
-
-LOCK TABLES `users` WRITE;
-/*!40000 ALTER TABLE `users` DISABLE KEYS */;
-INSERT INTO `users` VALUES (1,'admin','21232f297a57a5a743894a0e4a801fc3');
-/*!40000 ALTER TABLE `users` ENABLE KEYS */;
-UNLOCK TABLES;
 /*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
 
 /*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
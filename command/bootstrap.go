@@ -0,0 +1,28 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"com.lc.go.codepush/server/auth"
+)
+
+// 空数据库首次启动时创建管理员,凭据只打印一次
+func Bootstrap(args []string) error {
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	userName := fs.String("user", "admin", "admin user name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	result, err := auth.Bootstrap(*userName)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Admin account created, save these credentials now, they are not shown again:")
+	fmt.Println("  user name:  " + result.UserName)
+	fmt.Println("  password:   " + result.Password)
+	fmt.Println("  access key: " + result.AccessKey)
+	fmt.Println("  expires:    " + time.UnixMilli(result.ExpireTime).Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,33 @@
+package command
+
+import (
+	"fmt"
+	"os"
+)
+
+var commands = map[string]func(args []string) error{
+	"storage-check":     StorageCheck,
+	"e2e":               E2e,
+	"seed":              Seed,
+	"bootstrap":         Bootstrap,
+	"export-static":     ExportStatic,
+	"import-appcenter":  ImportAppCenter,
+	"import-node":       ImportNode,
+	"self-test":         SelfTest,
+	"offline-bundle":    OfflineBundle,
+	"replay":            Replay,
+	"rekey-deployments": RekeyDeployments,
+}
+
+// 运行命令行子命令,如 ./code-push-server-go storage-check
+func Run(args []string) {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Println("Unknown command:" + args[0])
+		os.Exit(2)
+	}
+	if err := cmd(args[1:]); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,285 @@
+package command
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 对运行中的服务跑一遍完整的发布生命周期:
+// 发布 -> update_check -> report_status -> 回滚 -> update_check
+type e2eClient struct {
+	baseUrl string
+	prefix  string
+	token   string
+}
+
+func E2e(args []string) error {
+	fs := flag.NewFlagSet("e2e", flag.ContinueOnError)
+	baseUrl := fs.String("url", "http://127.0.0.1:8080", "server url")
+	prefix := fs.String("prefix", "", "url_prefix of the api")
+	userName := fs.String("user", "admin", "user name")
+	password := fs.String("password", "", "password")
+	bootstrapToken := fs.String("bootstrap-token", "", "create the first admin through /bootstrap; -user/-password are used when users already exist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c := &e2eClient{baseUrl: *baseUrl, prefix: *prefix}
+
+	if *bootstrapToken != "" {
+		c.token = c.bootstrap(*bootstrapToken, *userName)
+	}
+	if c.token == "" {
+		if *password == "" {
+			return errors.New("-password or -bootstrap-token is required")
+		}
+		var login struct {
+			Token string `json:"token"`
+		}
+		if err := c.post("/login", map[string]any{"userName": *userName, "password": *password}, &login); err != nil {
+			return err
+		}
+		c.token = login.Token
+	}
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	appName := "e2e-" + suffix
+	deploymentName := "Staging"
+	appVersion := "1.0.0"
+	if err := c.post("/createApp", map[string]any{"appName": appName, "os": 1}, nil); err != nil {
+		return err
+	}
+	if err := c.post("/createDeployment", map[string]any{"appName": appName, "deploymentName": deploymentName}, nil); err != nil {
+		return err
+	}
+
+	hashes := []string{"e2e-hash-1-" + suffix, "e2e-hash-2-" + suffix}
+	labels := make([]string, len(hashes))
+	for i, hash := range hashes {
+		key := appName + "-" + hash + ".zip"
+		bundle, err := e2eBundle(strconv.Itoa(i))
+		if err != nil {
+			return err
+		}
+		if err := c.upload(key, bundle); err != nil {
+			return err
+		}
+		var created struct {
+			Label string `json:"label"`
+		}
+		err = c.post("/createBundle", map[string]any{
+			"appName":     appName,
+			"deployment":  deploymentName,
+			"downloadUrl": key,
+			"version":     appVersion,
+			"size":        len(bundle),
+			"hash":        hash,
+		}, &created)
+		if err != nil {
+			return err
+		}
+		labels[i] = created.Label
+		fmt.Println("release label " + created.Label)
+	}
+
+	var ls struct {
+		Deployments []struct {
+			DeploymentName string `json:"deploymentName"`
+			DeploymentKey  string `json:"deploymentKey"`
+		} `json:"deployments"`
+	}
+	if err := c.post("/lsDeployment", map[string]any{"appName": appName, "k": true}, &ls); err != nil {
+		return err
+	}
+	deploymentKey := ""
+	for _, v := range ls.Deployments {
+		if v.DeploymentName == deploymentName {
+			deploymentKey = v.DeploymentKey
+		}
+	}
+	if deploymentKey == "" {
+		return errors.New("deployment key not found")
+	}
+
+	if err := c.expectUpdate(deploymentKey, appVersion, hashes[0], labels[1]); err != nil {
+		return err
+	}
+	if err := c.expectUpdate(deploymentKey, appVersion, hashes[1], ""); err != nil {
+		return err
+	}
+	report := map[string]any{
+		"app_version":      appVersion,
+		"deployment_key":   deploymentKey,
+		"client_unique_id": "e2e-" + suffix,
+		"label":            labels[1],
+		"status":           "DeploymentSucceeded",
+	}
+	if err := c.post("/v0.1/public/codepush/report_status/deploy", report, nil); err != nil {
+		return err
+	}
+	if err := c.post("/rollback", map[string]any{"appName": appName, "deployment": deploymentName, "version": appVersion}, nil); err != nil {
+		return err
+	}
+	// 回滚后当前包恢复为第一次发布
+	if err := c.expectUpdate(deploymentKey, appVersion, hashes[1], labels[0]); err != nil {
+		return err
+	}
+	if err := c.post("/delDeployment", map[string]any{"appName": appName, "deployment": deploymentName}, nil); err != nil {
+		return err
+	}
+	if err := c.post("/delApp", map[string]any{"appName": appName}, nil); err != nil {
+		return err
+	}
+	fmt.Println("e2e ok")
+	return nil
+}
+
+// 用户表为空时返回新管理员的access key,否则返回空
+func (c *e2eClient) bootstrap(bootstrapToken string, userName string) string {
+	data, err := json.Marshal(map[string]any{"userName": userName})
+	if err != nil {
+		return ""
+	}
+	req, err := http.NewRequest(http.MethodPost, c.apiUrl("/bootstrap"), bytes.NewReader(data))
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Bootstrap-Token", bootstrapToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Data struct {
+			AccessKey string `json:"accessKey"`
+		} `json:"data"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&result) != nil {
+		return ""
+	}
+	fmt.Println("bootstrapped " + userName)
+	return result.Data.AccessKey
+}
+
+func e2eBundle(content string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	f, err := w.Create("index.android.bundle")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write([]byte("console.log(" + strconv.Quote(content) + ");")); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type e2eUpdateInfo struct {
+	UpdateInfo struct {
+		IsAvailable bool   `json:"is_available"`
+		Label       string `json:"label"`
+	} `json:"update_info"`
+}
+
+func (c *e2eClient) checkUpdate(deploymentKey string, appVersion string, packageHash string) (*e2eUpdateInfo, error) {
+	query := url.Values{}
+	query.Set("deployment_key", deploymentKey)
+	query.Set("app_version", appVersion)
+	query.Set("package_hash", packageHash)
+	info := &e2eUpdateInfo{}
+	err := c.do(http.MethodGet, c.baseUrl+"/v0.1/public/codepush/update_check?"+query.Encode(), "", nil, info)
+	return info, err
+}
+
+// label为空时期望没有更新
+func (c *e2eClient) expectUpdate(deploymentKey string, appVersion string, packageHash string, label string) error {
+	info, err := c.checkUpdate(deploymentKey, appVersion, packageHash)
+	if err != nil {
+		return err
+	}
+	if label == "" {
+		if info.UpdateInfo.IsAvailable {
+			return errors.New("update_check: unexpected update " + info.UpdateInfo.Label)
+		}
+		return nil
+	}
+	if !info.UpdateInfo.IsAvailable || info.UpdateInfo.Label != label {
+		return errors.New("update_check: expected label " + label + ", got " + info.UpdateInfo.Label)
+	}
+	fmt.Println("update_check ok " + label)
+	return nil
+}
+
+func (c *e2eClient) apiUrl(path string) string {
+	if len(path) > 5 && path[:5] == "/v0.1" {
+		return c.baseUrl + path
+	}
+	return c.baseUrl + c.prefix + path
+}
+
+func (c *e2eClient) post(path string, body any, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return c.do(http.MethodPost, c.apiUrl(path), "application/json", bytes.NewReader(data), out)
+}
+
+func (c *e2eClient) upload(key string, data []byte) error {
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+	f, err := w.CreateFormFile("file", key)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.do(http.MethodPost, c.apiUrl("/uploadBundle"), w.FormDataContentType(), buf, nil)
+}
+
+func (c *e2eClient) do(method string, url string, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("token", c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(method + " " + url + ": " + resp.Status + " " + string(respBody))
+	}
+	if out != nil {
+		return json.Unmarshal(respBody, out)
+	}
+	return nil
+}
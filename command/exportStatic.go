@@ -0,0 +1,185 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+)
+
+// 与update_check返回的update_info字段一致
+type staticUpdateInfo struct {
+	DownloadUrl            string `json:"download_url"`
+	Description            string `json:"description"`
+	IsAvailable            bool   `json:"is_available"`
+	IsDisabled             bool   `json:"is_disabled"`
+	TargetBinaryRange      string `json:"target_binary_range"`
+	PackageHash            string `json:"package_hash"`
+	Label                  string `json:"label"`
+	PackageSize            int64  `json:"package_size"`
+	UpdateAppVersion       bool   `json:"update_app_version"`
+	ShouldRunBinaryVersion bool   `json:"should_run_binary_version"`
+	IsMandatory            bool   `json:"is_mandatory"`
+}
+
+type staticIndex struct {
+	DeploymentKey string   `json:"deployment_key"`
+	NewVersion    string   `json:"new_version"`
+	Versions      []string `json:"versions"`
+	GeneratedAt   string   `json:"generated_at"`
+}
+
+type staticExporter struct {
+	out     string
+	prefix  string
+	baseUrl string
+	upload  bool
+}
+
+// 把部署当前的update_check结果和包导出成静态文件,服务不可用时可由CDN或边缘函数直接返回:
+//
+//	<key>/index.json                 版本列表和最新版本
+//	<key>/<appVersion>.json          {"update_info":...}
+//	<key>/<bundleName>/<appVersion>.json
+//	packages/<hash>.zip
+//
+// 不包含灰度、设备规则和差量包,客户端按package_hash判断是否已是最新
+func ExportStatic(args []string) error {
+	fs := flag.NewFlagSet("export-static", flag.ContinueOnError)
+	deploymentKey := fs.String("deployment-key", "", "deployment key to export")
+	out := fs.String("out", "", "output directory")
+	baseUrl := fs.String("base-url", "", "public url the export is served from")
+	upload := fs.Bool("upload", false, "also upload the files through the storage chain")
+	prefix := fs.String("prefix", "static", "storage key prefix used with -upload")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *deploymentKey == "" || *baseUrl == "" {
+		return errors.New("-deployment-key and -base-url are required")
+	}
+	if *out == "" && !*upload {
+		return errors.New("-out or -upload is required")
+	}
+	// 上传的对象会被加密,静态托管无法直接提供
+	if *upload && storage.EncryptionEnabled() {
+		return errors.New("-upload is not supported with blob encryption, use -out")
+	}
+	deployment := model.Deployment{}.GetByKey(*deploymentKey)
+	if deployment == nil {
+		return errors.New("Deployment key not found")
+	}
+	e := &staticExporter{out: *out, prefix: *prefix, baseUrl: strings.TrimRight(*baseUrl, "/"), upload: *upload}
+
+	newVersion := ""
+	if v := (model.DeploymentVersion{}).GetNewVersionByKeyDeploymentId(*deployment.Id, ""); v != nil {
+		newVersion = *v.AppVersion
+	}
+	forceBinary := deployment.ForceBinaryUpdate != nil && *deployment.ForceBinaryUpdate
+	versions := model.GetList[model.DeploymentVersion]("deployment_id", *deployment.Id)
+	index := staticIndex{
+		DeploymentKey: *deploymentKey,
+		NewVersion:    newVersion,
+		Versions:      []string{},
+		GeneratedAt:   time.Now().UTC().Format(time.RFC3339),
+	}
+	if versions != nil {
+		for _, v := range *versions {
+			info := staticUpdateInfo{}
+			if forceBinary {
+				info.UpdateAppVersion = true
+				info.IsMandatory = true
+				info.TargetBinaryRange = newVersion
+				if deployment.ForceBinaryMessage != nil {
+					info.Description = *deployment.ForceBinaryMessage
+				}
+			} else if v.CurrentPackage != nil {
+				pack := model.GetOne[model.Package]("id", *v.CurrentPackage)
+				if pack == nil {
+					continue
+				}
+				url, err := e.exportPackage(pack)
+				if err != nil {
+					return err
+				}
+				info = staticUpdateInfo{
+					DownloadUrl:       url,
+					IsAvailable:       true,
+					TargetBinaryRange: *v.AppVersion,
+					PackageHash:       *pack.Hash,
+					PackageSize:       *pack.Size,
+					Label:             strconv.Itoa(*pack.Id),
+				}
+				if pack.Label != nil {
+					info.Label = *pack.Label
+				}
+				if pack.Description != nil {
+					info.Description = *pack.Description
+				}
+			} else if newVersion != "" && utils.FormatVersionStr(*v.AppVersion) < utils.FormatVersionStr(newVersion) {
+				info.TargetBinaryRange = newVersion
+				info.UpdateAppVersion = true
+			}
+			name := *v.AppVersion + ".json"
+			if v.BundleName != nil && *v.BundleName != "" {
+				name = *v.BundleName + "/" + name
+			}
+			if err := e.writeJSON(*deploymentKey+"/"+name, map[string]any{"update_info": info}); err != nil {
+				return err
+			}
+			index.Versions = append(index.Versions, name)
+		}
+	}
+	if err := e.writeJSON(*deploymentKey+"/index.json", index); err != nil {
+		return err
+	}
+	fmt.Println("exported " + strconv.Itoa(len(index.Versions)) + " versions of " + *deployment.Name)
+	return nil
+}
+
+func (e *staticExporter) exportPackage(pack *model.Package) (string, error) {
+	name := "packages/" + *pack.Hash + path.Ext(*pack.Download)
+	data, err := storage.Download(*pack.Download)
+	if err != nil {
+		return "", err
+	}
+	if err := e.write(name, data); err != nil {
+		return "", err
+	}
+	return e.baseUrl + "/" + name, nil
+}
+
+func (e *staticExporter) writeJSON(name string, obj any) error {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	return e.write(name, data)
+}
+
+func (e *staticExporter) write(name string, data []byte) error {
+	if e.out != "" {
+		file := filepath.Join(e.out, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			return err
+		}
+	}
+	if e.upload {
+		if _, err := storage.Upload(e.prefix+"/"+name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+	"github.com/google/uuid"
+)
+
+// 导入结果,最后打印为核对报告
+type importReport struct {
+	Apps        int
+	Deployments int
+	Packages    int
+	Skipped     int
+	Warnings    []string
+}
+
+func (r *importReport) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	r.Warnings = append(r.Warnings, msg)
+	fmt.Println("warning: " + msg)
+}
+
+func (r *importReport) print() {
+	fmt.Printf("imported %d apps, %d deployments, %d packages, skipped %d packages\n", r.Apps, r.Deployments, r.Packages, r.Skipped)
+	if len(r.Warnings) > 0 {
+		fmt.Printf("%d warnings:\n", len(r.Warnings))
+		for _, w := range r.Warnings {
+			fmt.Println("  " + w)
+		}
+	}
+}
+
+// 导入一个部署时按版本缓存deployment_version,并记录每个版本最后一个可用的包
+type importDeployment struct {
+	deployment *model.Deployment
+	versions   map[string]*model.DeploymentVersion
+	current    map[int]int
+	// v{n}标签中最大的n,导入后继续按v{n}编号
+	labelSeq  int
+	otherName bool
+}
+
+func newImportDeployment(deployment *model.Deployment) *importDeployment {
+	return &importDeployment{
+		deployment: deployment,
+		versions:   map[string]*model.DeploymentVersion{},
+		current:    map[int]int{},
+	}
+}
+
+// 只支持精确版本,版本范围返回错误
+func (d *importDeployment) version(appVersion string) (dv *model.DeploymentVersion, err error) {
+	if v, ok := d.versions[appVersion]; ok {
+		return v, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unsupported app version %q", appVersion)
+		}
+	}()
+	versionNum := utils.FormatVersionStr(appVersion)
+	bundleName := ""
+	dv = model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*d.deployment.Id, bundleName, appVersion)
+	if dv == nil {
+		dv = &model.DeploymentVersion{
+			DeploymentId: d.deployment.Id,
+			BundleName:   &bundleName,
+			AppVersion:   &appVersion,
+			VersionNum:   &versionNum,
+			CreateTime:   utils.GetTimeNow(),
+		}
+		if err := model.Create[model.DeploymentVersion](dv); err != nil {
+			return nil, err
+		}
+	}
+	d.versions[appVersion] = dv
+	return dv, nil
+}
+
+var vLabelRegexp = regexp.MustCompile(`^v(\d+)$`)
+
+// 重复导入时已存在的标签跳过
+func (d *importDeployment) exists(label string) bool {
+	return (model.Package{}).GetByDeploymentIdAndLabel(*d.deployment.Id, label) != nil
+}
+
+// 保留原来的标签,available为false时不作为版本的当前包
+func (d *importDeployment) addPackage(pack *model.Package, data []byte, available bool) error {
+	key := uuid.NewString() + ".zip"
+	if _, err := storage.Upload(key, data); err != nil {
+		return err
+	}
+	pack.DeploymentId = d.deployment.Id
+	pack.Download = &key
+	if err := model.Create[model.Package](pack); err != nil {
+		return err
+	}
+	if available {
+		d.current[*pack.DeploymentVersionId] = *pack.Id
+	}
+	if m := vLabelRegexp.FindStringSubmatch(*pack.Label); m != nil {
+		if n, _ := strconv.Atoi(m[1]); n > d.labelSeq {
+			d.labelSeq = n
+		}
+	} else {
+		d.otherName = true
+	}
+	return nil
+}
+
+// 设置每个版本的当前包,标签都是v{n}时之后的发布继续编号
+func (d *importDeployment) finish() error {
+	var newest *model.DeploymentVersion
+	for _, dv := range d.versions {
+		if pid, ok := d.current[*dv.Id]; ok {
+			if err := (model.DeploymentVersion{}).UpdateCurrentPackage(nil, *dv.Id, &pid, constants.RELEASE_ACTION_IMPORT, 0); err != nil {
+				return err
+			}
+		}
+		if newest == nil || *dv.VersionNum > *newest.VersionNum {
+			newest = dv
+		}
+	}
+	if newest != nil {
+		d.deployment.VersionId = newest.Id
+		d.deployment.UpdateTime = utils.GetTimeNow()
+		model.Update[model.Deployment](d.deployment)
+	}
+	if d.labelSeq > 0 && !d.otherName {
+		format := "v{n}"
+		model.Deployment{}.UpdateLabelFormat(nil, *d.deployment.Id, &format, d.labelSeq)
+	}
+	return model.DeploymentLookup{}.Rebuild(nil, *d.deployment.Id)
+}
+
+// 按名称查找或创建应用,os: 1=iOS 2=Android
+func importApp(uid int, appName string, os int, report *importReport) (*model.App, error) {
+	if app := (model.App{}).GetAppByUidAndAppName(uid, appName); app != nil {
+		return app, nil
+	}
+	app := model.App{
+		Uid:        &uid,
+		AppName:    &appName,
+		OS:         &os,
+		CreateTime: utils.GetTimeNow(),
+	}
+	if err := model.Create[model.App](&app); err != nil {
+		return nil, err
+	}
+	report.Apps++
+	return &app, nil
+}
+
+// 保留原来的deploymentKey,key已被其他部署使用时返回错误
+func importDeploymentByKey(app *model.App, name string, key string, report *importReport) (*importDeployment, error) {
+	if deployment := (model.Deployment{}).GetByAppidAndName(*app.Id, name); deployment != nil {
+		if *deployment.Key != key {
+			return nil, fmt.Errorf("deployment %s/%s exists with a different key", *app.AppName, name)
+		}
+		return newImportDeployment(deployment), nil
+	}
+	if other := (model.Deployment{}).GetByKey(key); other != nil {
+		return nil, fmt.Errorf("deployment key of %s/%s is used by deployment %d", *app.AppName, name, *other.Id)
+	}
+	deployment := model.Deployment{
+		AppId:      app.Id,
+		Name:       &name,
+		Key:        &key,
+		CreateTime: utils.GetTimeNow(),
+	}
+	if err := model.Create[model.Deployment](&deployment); err != nil {
+		return nil, err
+	}
+	// 迁移前探测过的key不再返回404
+	redis.DelKeys(constants.REDIS_UNKNOWN_KEY + key)
+	report.Deployments++
+	return newImportDeployment(&deployment), nil
+}
@@ -0,0 +1,228 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/egress"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+)
+
+// App Center API导出的字段
+type appCenterApp struct {
+	Name string `json:"name"`
+	OS   string `json:"os"`
+}
+
+type appCenterDeployment struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+type appCenterRelease struct {
+	Label       string `json:"label"`
+	AppVersion  string `json:"appVersion"`
+	Description string `json:"description"`
+	IsDisabled  bool   `json:"isDisabled"`
+	IsMandatory bool   `json:"isMandatory"`
+	Rollout     *int   `json:"rollout"`
+	PackageHash string `json:"packageHash"`
+	BlobUrl     string `json:"blobUrl"`
+	Size        int64  `json:"size"`
+	UploadTime  int64  `json:"uploadTime"`
+}
+
+type appCenterMetric struct {
+	Label      string `json:"label"`
+	Active     int    `json:"active"`
+	Downloaded int    `json:"downloaded"`
+	Installed  int    `json:"installed"`
+	Failed     int    `json:"failed"`
+}
+
+// 导入App Center CodePush导出目录:
+//
+//	apps.json                                  [{"name","os"}]
+//	{app}/deployments.json                     [{"name","key"}]
+//	{app}/{deployment}/history.json            releases接口的返回
+//	{app}/{deployment}/metrics.json            可选,metrics接口的返回
+//	{app}/{deployment}/blobs/{label}.zip       可选,没有时从blobUrl下载
+func ImportAppCenter(args []string) error {
+	fs := flag.NewFlagSet("import-appcenter", flag.ContinueOnError)
+	dir := fs.String("dir", "", "App Center export directory")
+	userName := fs.String("user", "admin", "owner of the imported apps")
+	download := fs.Bool("download", true, "download blobs from blobUrl when not in the export")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("-dir is required")
+	}
+	user := model.GetOne[model.User]("user_name", *userName)
+	if user == nil {
+		return errors.New("User " + *userName + " not found")
+	}
+	var apps []appCenterApp
+	if err := readJSONFile(filepath.Join(*dir, "apps.json"), &apps); err != nil {
+		return err
+	}
+	report := &importReport{}
+	for _, a := range apps {
+		appOS := 1
+		if strings.EqualFold(a.OS, "Android") {
+			appOS = 2
+		}
+		app, err := importApp(*user.Id, a.Name, appOS, report)
+		if err != nil {
+			return err
+		}
+		var deployments []appCenterDeployment
+		if err := readJSONFile(filepath.Join(*dir, a.Name, "deployments.json"), &deployments); err != nil {
+			return err
+		}
+		for _, d := range deployments {
+			if err := importAppCenterDeployment(filepath.Join(*dir, a.Name, d.Name), app, d, *download, report); err != nil {
+				report.warn("%s/%s: %s", a.Name, d.Name, err.Error())
+			}
+		}
+	}
+	report.print()
+	return nil
+}
+
+func importAppCenterDeployment(dir string, app *model.App, d appCenterDeployment, download bool, report *importReport) error {
+	target, err := importDeploymentByKey(app, d.Name, d.Key, report)
+	if err != nil {
+		return err
+	}
+	var releases []appCenterRelease
+	if err := readJSONFile(filepath.Join(dir, "history.json"), &releases); err != nil {
+		return err
+	}
+	metrics := map[string]appCenterMetric{}
+	var metricList []appCenterMetric
+	if err := readJSONFile(filepath.Join(dir, "metrics.json"), &metricList); err == nil {
+		for _, m := range metricList {
+			metrics[m.Label] = m
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].UploadTime < releases[j].UploadTime
+	})
+	for _, r := range releases {
+		name := *app.AppName + "/" + d.Name + "/" + r.Label
+		if target.exists(r.Label) {
+			report.Skipped++
+			continue
+		}
+		dv, err := target.version(r.AppVersion)
+		if err != nil {
+			report.Skipped++
+			report.warn("%s: %s", name, err.Error())
+			continue
+		}
+		data, err := readAppCenterBlob(dir, r, download)
+		if err != nil {
+			report.Skipped++
+			report.warn("%s: %s", name, err.Error())
+			continue
+		}
+		if r.Size > 0 && int64(len(data)) != r.Size {
+			report.warn("%s: blob size %d, history says %d", name, len(data), r.Size)
+		}
+		label := r.Label
+		hash := r.PackageHash
+		size := int64(len(data))
+		description := r.Description
+		createTime := r.UploadTime
+		if createTime == 0 {
+			createTime = *utils.GetTimeNow()
+		}
+		m := metrics[r.Label]
+		pack := model.Package{
+			DeploymentVersionId: dv.Id,
+			Size:                &size,
+			Hash:                &hash,
+			Description:         &description,
+			Label:               &label,
+			Active:              &m.Active,
+			Installed:           &m.Installed,
+			Failed:              &m.Failed,
+			CreateTime:          &createTime,
+			Uid:                 app.Uid,
+		}
+		if r.Rollout != nil && *r.Rollout < 100 {
+			pack.Rollout = r.Rollout
+		}
+		if r.IsDisabled {
+			status := constants.PACKAGE_STATUS_DISABLED
+			pack.Status = &status
+		}
+		if r.IsMandatory {
+			report.warn("%s: mandatory flag is not supported, imported as optional", name)
+		}
+		if err := target.addPackage(&pack, data, !r.IsDisabled); err != nil {
+			return err
+		}
+		report.Packages++
+	}
+	return target.finish()
+}
+
+// 优先使用导出目录中的文件({label}.zip或{packageHash}.zip)
+func readAppCenterBlob(dir string, r appCenterRelease, download bool) ([]byte, error) {
+	for _, name := range []string{r.Label, r.PackageHash} {
+		if name == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "blobs", name+".zip"))
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if !download || r.BlobUrl == "" {
+		return nil, errors.New("blob not found")
+	}
+	return downloadBlob(r.BlobUrl)
+}
+
+var blobClient = egress.Client(10 * time.Minute)
+
+func downloadBlob(url string) ([]byte, error) {
+	resp, err := blobClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return nil
+}
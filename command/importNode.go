@@ -0,0 +1,241 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/utils"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// lisong/code-push-server的表结构
+type nodeApp struct {
+	Id   int
+	Name string
+	Uid  int
+	Os   int
+}
+
+type nodeDeployment struct {
+	Id            int
+	Name          string
+	DeploymentKey string
+	LabelId       int
+}
+
+type nodeDeploymentVersion struct {
+	Id               int
+	AppVersion       string
+	CurrentPackageId *int
+}
+
+type nodePackage struct {
+	Id                  int
+	DeploymentVersionId int
+	Description         *string
+	PackageHash         string
+	BlobUrl             string
+	Size                int64
+	Label               string
+	CreatedAt           time.Time
+	Active              *int
+	Installed           *int
+	Failed              *int
+}
+
+// 核对报告的一行
+type nodeVerify struct {
+	name                               string
+	source, imported, existing         int
+	sourceInstalled, importedInstalled int
+	currentMissing                     []string
+}
+
+// 从Node版code-push-server的mysql导入应用、部署、包和安装统计
+func ImportNode(args []string) error {
+	fs := flag.NewFlagSet("import-node", flag.ContinueOnError)
+	dsn := fs.String("dsn", "", "source mysql dsn, e.g. user:pass@tcp(host:3306)/codepush")
+	blobDir := fs.String("blob-dir", "", "local storageDir of the node server")
+	blobUrl := fs.String("blob-url", "", "download url prefix of the node server storage")
+	userName := fs.String("user", "admin", "owner for apps whose node user does not exist here")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" || (*blobDir == "" && *blobUrl == "") {
+		return errors.New("-dsn and one of -blob-dir or -blob-url are required")
+	}
+	fallback := model.GetOne[model.User]("user_name", *userName)
+	if fallback == nil {
+		return errors.New("User " + *userName + " not found")
+	}
+	sep := "?"
+	if strings.Contains(*dsn, "?") {
+		sep = "&"
+	}
+	src, err := gorm.Open(mysql.Open(*dsn+sep+"charset=utf8mb4&parseTime=True&loc=Local"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Error),
+	})
+	if err != nil {
+		return err
+	}
+	var apps []nodeApp
+	if err := src.Raw("select id,name,uid,os from apps where deleted_at is null order by id").Scan(&apps).Error; err != nil {
+		return err
+	}
+	report := &importReport{}
+	var verify []*nodeVerify
+	for _, a := range apps {
+		uid := *fallback.Id
+		var nodeUser string
+		src.Raw("select username from users where id=?", a.Uid).Scan(&nodeUser)
+		if user := model.GetOne[model.User]("user_name", nodeUser); nodeUser != "" && user != nil {
+			uid = *user.Id
+		}
+		appOS := a.Os
+		if appOS != 2 {
+			appOS = 1
+		}
+		app, err := importApp(uid, a.Name, appOS, report)
+		if err != nil {
+			return err
+		}
+		var deployments []nodeDeployment
+		if err := src.Raw("select id,name,deployment_key,label_id from deployments where appid=? and deleted_at is null order by id", a.Id).Scan(&deployments).Error; err != nil {
+			return err
+		}
+		for _, d := range deployments {
+			v := &nodeVerify{name: a.Name + "/" + d.Name}
+			verify = append(verify, v)
+			if err := importNodeDeployment(src, app, d, *blobDir, *blobUrl, report, v); err != nil {
+				report.warn("%s: %s", v.name, err.Error())
+			}
+		}
+	}
+	report.print()
+	printNodeVerify(verify)
+	return nil
+}
+
+func importNodeDeployment(src *gorm.DB, app *model.App, d nodeDeployment, blobDir string, blobUrl string, report *importReport, v *nodeVerify) error {
+	target, err := importDeploymentByKey(app, d.Name, d.DeploymentKey, report)
+	if err != nil {
+		return err
+	}
+	var versions []nodeDeploymentVersion
+	if err := src.Raw("select id,app_version,current_package_id from deployments_versions where deployment_id=? and deleted_at is null", d.Id).Scan(&versions).Error; err != nil {
+		return err
+	}
+	current := map[int]int{}
+	appVersions := map[int]string{}
+	for _, dv := range versions {
+		appVersions[dv.Id] = dv.AppVersion
+		if dv.CurrentPackageId != nil {
+			current[dv.Id] = *dv.CurrentPackageId
+		}
+	}
+	var packs []nodePackage
+	err = src.Raw(`select p.id,p.deployment_version_id,p.description,p.package_hash,p.blob_url,p.size,p.label,p.created_at,m.active,m.installed,m.failed
+		from packages p left join packages_metrics m on m.package_id=p.id and m.deleted_at is null
+		where p.deployment_id=? and p.deleted_at is null order by p.id`, d.Id).Scan(&packs).Error
+	if err != nil {
+		return err
+	}
+	v.source = len(packs)
+	for _, p := range packs {
+		v.sourceInstalled += utils.IntValue(p.Installed)
+		name := v.name + "/" + p.Label
+		isCurrent := current[p.DeploymentVersionId] == p.Id
+		if target.exists(p.Label) {
+			report.Skipped++
+			v.existing++
+			continue
+		}
+		installed, err := importNodePackage(target, app, p, appVersions[p.DeploymentVersionId], isCurrent, blobDir, blobUrl)
+		if err != nil {
+			report.Skipped++
+			report.warn("%s: %s", name, err.Error())
+			if isCurrent {
+				v.currentMissing = append(v.currentMissing, appVersions[p.DeploymentVersionId])
+			}
+			continue
+		}
+		report.Packages++
+		v.imported++
+		v.importedInstalled += installed
+	}
+	// Node版的label_id即最后的v{n}
+	if d.LabelId > target.labelSeq {
+		target.labelSeq = d.LabelId
+	}
+	return target.finish()
+}
+
+// 返回导入的安装次数
+func importNodePackage(target *importDeployment, app *model.App, p nodePackage, appVersion string, isCurrent bool, blobDir string, blobUrl string) (int, error) {
+	dv, err := target.version(appVersion)
+	if err != nil {
+		return 0, err
+	}
+	data, err := readNodeBlob(p.BlobUrl, blobDir, blobUrl)
+	if err != nil {
+		return 0, err
+	}
+	if p.Size > 0 && int64(len(data)) != p.Size {
+		return 0, fmt.Errorf("blob size %d, expected %d", len(data), p.Size)
+	}
+	label := p.Label
+	hash := p.PackageHash
+	size := int64(len(data))
+	createTime := p.CreatedAt.UnixMilli()
+	active, installed, failed := utils.IntValue(p.Active), utils.IntValue(p.Installed), utils.IntValue(p.Failed)
+	pack := model.Package{
+		DeploymentVersionId: dv.Id,
+		Size:                &size,
+		Hash:                &hash,
+		Description:         p.Description,
+		Label:               &label,
+		Active:              &active,
+		Installed:           &installed,
+		Failed:              &failed,
+		CreateTime:          &createTime,
+		Uid:                 app.Uid,
+	}
+	if err := target.addPackage(&pack, data, isCurrent); err != nil {
+		return 0, err
+	}
+	return installed, nil
+}
+
+// blob_url是存储中的key
+func readNodeBlob(key string, blobDir string, blobUrl string) ([]byte, error) {
+	if blobDir != "" {
+		data, err := os.ReadFile(filepath.Join(blobDir, filepath.FromSlash(key)))
+		if err == nil || blobUrl == "" {
+			return data, err
+		}
+	}
+	return downloadBlob(strings.TrimRight(blobUrl, "/") + "/" + key)
+}
+
+func printNodeVerify(verify []*nodeVerify) {
+	fmt.Println("verification:")
+	fmt.Printf("  %-40s %8s %8s %8s %12s %12s\n", "deployment", "source", "imported", "existing", "installs src", "installs new")
+	for _, v := range verify {
+		status := "ok"
+		if v.imported+v.existing != v.source || len(v.currentMissing) > 0 {
+			status = "MISMATCH"
+		}
+		fmt.Printf("  %-40s %8d %8d %8d %12d %12d  %s\n", v.name, v.source, v.imported, v.existing, v.sourceInstalled, v.importedInstalled, status)
+		if len(v.currentMissing) > 0 {
+			fmt.Println("    current release not imported for versions: " + strings.Join(v.currentMissing, ","))
+		}
+	}
+}
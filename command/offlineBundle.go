@@ -0,0 +1,159 @@
+package command
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"com.lc.go.codepush/server/diff"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/storage"
+)
+
+// react-native-code-push保存在<CodePush>/<packageHash>/app.json中的包信息
+type offlinePackage struct {
+	AppVersion    string `json:"appVersion"`
+	DeploymentKey string `json:"deploymentKey"`
+	Description   string `json:"description"`
+	FailedInstall bool   `json:"failedInstall"`
+	IsMandatory   bool   `json:"isMandatory"`
+	Label         string `json:"label"`
+	PackageHash   string `json:"packageHash"`
+	PackageSize   int64  `json:"packageSize"`
+	// 相对包目录的bundle路径
+	BundlePath string `json:"bundlePath"`
+}
+
+// 把部署当前的发布导出成react-native-code-push的本地目录,打包时内置为默认更新:
+//
+//	<out>/CodePush/codepush.json          {"currentPackage":"<hash>"}
+//	<out>/CodePush/<hash>/app.json        包信息
+//	<out>/CodePush/<hash>/...             解压后的bundle和资源
+//
+// 没有可用发布时不生成任何文件并返回错误
+func OfflineBundle(args []string) error {
+	fs := flag.NewFlagSet("offline-bundle", flag.ContinueOnError)
+	deploymentKey := fs.String("deployment-key", "", "deployment key to export")
+	appVersion := fs.String("app-version", "", "binary version the bundle is embedded in")
+	bundleName := fs.String("bundle-name", "", "bundle name for multi-bundle apps")
+	out := fs.String("out", "", "output directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *deploymentKey == "" || *appVersion == "" || *out == "" {
+		return errors.New("-deployment-key, -app-version and -out are required")
+	}
+	deployment := model.Deployment{}.GetByKey(*deploymentKey)
+	if deployment == nil {
+		return errors.New("Deployment key not found")
+	}
+	version := model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, *bundleName, *appVersion)
+	if version == nil || version.CurrentPackage == nil {
+		return errors.New("No release for app version " + *appVersion)
+	}
+	pack := model.GetOne[model.Package]("id", *version.CurrentPackage)
+	if pack == nil || pack.Download == nil {
+		return errors.New("No release for app version " + *appVersion)
+	}
+	data, err := storage.Download(*pack.Download)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(*out, "CodePush", *pack.Hash)
+	bundlePath, err := unzipPackage(data, dir)
+	if err != nil {
+		return err
+	}
+	if bundlePath == "" {
+		return errors.New("No JS bundle found in release " + strconv.Itoa(*pack.Id))
+	}
+	info := offlinePackage{
+		AppVersion:    *appVersion,
+		DeploymentKey: *deploymentKey,
+		IsMandatory:   pack.IsMandatory != nil && *pack.IsMandatory,
+		Label:         strconv.Itoa(*pack.Id),
+		PackageHash:   *pack.Hash,
+		PackageSize:   *pack.Size,
+		BundlePath:    bundlePath,
+	}
+	if pack.Label != nil {
+		info.Label = *pack.Label
+	}
+	if pack.Description != nil {
+		info.Description = *pack.Description
+	}
+	if err := writeJSONFile(filepath.Join(dir, "app.json"), info); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(*out, "CodePush", "codepush.json"), map[string]string{"currentPackage": *pack.Hash}); err != nil {
+		return err
+	}
+	fmt.Println("exported " + info.Label + " (" + *pack.Hash + ") of " + *deployment.Name + " to " + dir)
+	return nil
+}
+
+// 返回第一个.bundle/.jsbundle文件相对dir的路径,以/开头
+func unzipPackage(data []byte, dir string) (string, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	if err := diff.CheckArchive(r); err != nil {
+		return "", err
+	}
+	bundlePath := ""
+	for _, f := range r.File {
+		name := filepath.FromSlash(f.Name)
+		file := filepath.Join(dir, name)
+		if !strings.HasPrefix(file, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return "", errors.New("Invalid file name in zip: " + f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return "", err
+		}
+		if err := extractFile(f, file); err != nil {
+			return "", err
+		}
+		if bundlePath == "" && (strings.HasSuffix(f.Name, ".bundle") || strings.HasSuffix(f.Name, ".jsbundle")) {
+			bundlePath = "/" + strings.TrimPrefix(f.Name, "/")
+		}
+	}
+	return bundlePath, nil
+}
+
+func extractFile(f *zip.File, file string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+func writeJSONFile(file string, obj any) error {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
@@ -0,0 +1,83 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"com.lc.go.codepush/server/db"
+	"com.lc.go.codepush/server/model"
+	"gorm.io/gorm"
+)
+
+// 用当前的deployment_key_secret重写所有部署的key_hmac/key_enc并清空明文列;
+// 明文和用deployment_key_previous_secret加密的部署都会迁移。-decrypt写回明文,用于关闭加密
+func RekeyDeployments(args []string) error {
+	fs := flag.NewFlagSet("rekey-deployments", flag.ContinueOnError)
+	decrypt := fs.Bool("decrypt", false, "write plaintext keys back and clear the encrypted columns")
+	dryRun := fs.Bool("dry-run", false, "only report what would change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*decrypt && !model.KeyEncryptionEnabled() {
+		return errors.New("deployment_key_secret is not set")
+	}
+	var deployments []model.Deployment
+	userDb, err := db.GetUserDB()
+	if err != nil {
+		return err
+	}
+	// 解密失败的部署在AfterFind中报错,整个命令不做任何修改
+	if err := userDb.Order("id").Find(&deployments).Error; err != nil {
+		return err
+	}
+	changed := 0
+	err = userDb.Transaction(func(tx *gorm.DB) error {
+		for _, deployment := range deployments {
+			if deployment.Key == nil || deployment.AppId == nil {
+				continue
+			}
+			if *decrypt {
+				if deployment.KeyEnc == nil {
+					continue
+				}
+				changed++
+				if !*dryRun {
+					if err := (model.Deployment{}).UpdateKeyColumns(tx, *deployment.Id, deployment.Key, nil, nil); err != nil {
+						return err
+					}
+				}
+			} else {
+				keyHmac, keyEnc, err := model.EncryptKey(*deployment.AppId, *deployment.Key)
+				if err != nil {
+					return err
+				}
+				// 已经用当前密钥加密的部署不再重写
+				if deployment.PlainKey == nil && deployment.KeyHmac != nil && *deployment.KeyHmac == keyHmac {
+					continue
+				}
+				changed++
+				if !*dryRun {
+					if err := (model.Deployment{}).UpdateKeyColumns(tx, *deployment.Id, nil, &keyHmac, &keyEnc); err != nil {
+						return err
+					}
+				}
+			}
+			if !*dryRun {
+				if err := (model.DeploymentLookup{}).Rebuild(tx, *deployment.Id); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("%d of %d deployments would be rewritten\n", changed, len(deployments))
+	} else {
+		fmt.Printf("%d of %d deployments rewritten\n", changed, len(deployments))
+	}
+	return nil
+}
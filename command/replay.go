@@ -0,0 +1,253 @@
+package command
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/utils"
+)
+
+// 调试日志(debug_log_output)中的一行
+type replayEntry struct {
+	DeploymentKeyHash string            `json:"deployment_key_hash"`
+	Request           map[string]string `json:"request"`
+	Response          map[string]any    `json:"response"`
+	Error             string            `json:"error"`
+	Code              int               `json:"code"`
+}
+
+type replayResult struct {
+	line    int
+	skipped string
+	diffs   []string
+}
+
+// 把采集到的update_check请求重放到本地服务,和记录的响应逐字段比较,有差异时返回错误,
+// 用于升级灰度/版本匹配逻辑前的回归检查
+func Replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	logFile := fs.String("log", "", "debug log file with recorded update_check requests")
+	baseUrl := fs.String("url", "http://127.0.0.1:8080", "server url")
+	keys := fs.String("keys", "", "comma separated deployment keys; the database is used when empty")
+	ignore := fs.String("ignore", "download_url,description,descriptions", "comma separated response fields not compared")
+	concurrency := fs.Int("concurrency", 4, "parallel requests")
+	maxDiffs := fs.Int("max-diffs", 20, "differences printed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *logFile == "" {
+		return errors.New("-log is required")
+	}
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+	entries, err := readReplayLog(*logFile)
+	if err != nil {
+		return err
+	}
+	keyByHash := replayKeys(*keys)
+	ignored := map[string]bool{}
+	for _, field := range strings.Split(*ignore, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			ignored[field] = true
+		}
+	}
+
+	results := make([]replayResult, len(entries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = replayOne(*baseUrl, entries[i], keyByHash, ignored)
+				results[i].line = i + 1
+			}
+		}()
+	}
+	for i := range entries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var same, different, skipped int
+	printed := 0
+	for _, r := range results {
+		switch {
+		case r.skipped != "":
+			skipped++
+		case len(r.diffs) == 0:
+			same++
+		default:
+			different++
+			if printed < *maxDiffs {
+				printed++
+				fmt.Printf("line %d: %s\n", r.line, strings.Join(r.diffs, "; "))
+			}
+		}
+	}
+	fmt.Printf("replayed %d, same %d, different %d, skipped %d\n", same+different, same, different, skipped)
+	if different > 0 {
+		return fmt.Errorf("%d responses differ", different)
+	}
+	return nil
+}
+
+func readReplayLog(path string) ([]replayEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []replayEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry := replayEntry{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// 日志中只有deployment key的hash,用已知的key还原
+func replayKeys(keys string) map[string]string {
+	keyByHash := map[string]string{}
+	add := func(key string) {
+		if key = strings.TrimSpace(key); key != "" {
+			keyByHash[utils.Sha256Hex(key)[:16]] = key
+		}
+	}
+	if keys != "" {
+		for _, key := range strings.Split(keys, ",") {
+			add(key)
+		}
+		return keyByHash
+	}
+	if deployments := model.GetList[model.Deployment]("id > ?", 0); deployments != nil {
+		for _, deployment := range *deployments {
+			add(utils.StringValue(deployment.Key))
+		}
+	}
+	return keyByHash
+}
+
+func replayOne(baseUrl string, entry replayEntry, keyByHash map[string]string, ignored map[string]bool) replayResult {
+	query := url.Values{}
+	for k, v := range entry.Request {
+		if v == "[redacted]" {
+			return replayResult{skipped: k + " is redacted"}
+		}
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	if query.Get("deployment_key") == "" {
+		key, ok := keyByHash[entry.DeploymentKeyHash]
+		if !ok {
+			return replayResult{skipped: "unknown deployment key"}
+		}
+		query.Set("deployment_key", key)
+	}
+	rep, err := http.Get(baseUrl + "/v0.1/public/codepush/update_check?" + query.Encode())
+	if err != nil {
+		return replayResult{diffs: []string{err.Error()}}
+	}
+	defer rep.Body.Close()
+	body, err := io.ReadAll(rep.Body)
+	if err != nil {
+		return replayResult{diffs: []string{err.Error()}}
+	}
+	if rep.StatusCode != http.StatusOK {
+		res := struct {
+			Code int `json:"code"`
+		}{}
+		json.Unmarshal(body, &res)
+		if entry.Error == "" {
+			return replayResult{diffs: []string{"expected update_info, got " + rep.Status + " " + strings.TrimSpace(string(body))}}
+		}
+		if res.Code != entry.Code {
+			return replayResult{diffs: []string{fmt.Sprintf("error code: recorded %d, replayed %d", entry.Code, res.Code)}}
+		}
+		return replayResult{}
+	}
+	if entry.Error != "" {
+		return replayResult{diffs: []string{"expected error " + entry.Error + ", got update_info"}}
+	}
+	res := map[string]map[string]any{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return replayResult{diffs: []string{err.Error()}}
+	}
+	info, ok := res["update_info"]
+	if !ok {
+		// camel_case客户端
+		info = map[string]any{}
+		for k, v := range res["updateInfo"] {
+			info[snakeCase(k)] = v
+		}
+	}
+	// 调试日志中的下载地址不带签名参数
+	if u, ok := info["download_url"].(string); ok {
+		if parsed, err := url.Parse(u); err == nil {
+			parsed.RawQuery = ""
+			info["download_url"] = parsed.String()
+		}
+	}
+	return replayResult{diffs: diffUpdateInfo(entry.Response, info, ignored)}
+}
+
+func diffUpdateInfo(recorded map[string]any, replayed map[string]any, ignored map[string]bool) []string {
+	fields := map[string]bool{}
+	for k := range recorded {
+		fields[k] = true
+	}
+	for k := range replayed {
+		fields[k] = true
+	}
+	var names []string
+	for k := range fields {
+		if !ignored[k] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var diffs []string
+	for _, k := range names {
+		if !reflect.DeepEqual(recorded[k], replayed[k]) {
+			diffs = append(diffs, fmt.Sprintf("%s: recorded %v, replayed %v", k, recorded[k], replayed[k]))
+		}
+	}
+	return diffs
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,180 @@
+package command
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+	"github.com/google/uuid"
+)
+
+var seedAppNames = []string{"Loyalty", "Ordering", "Rewards", "Kiosk", "Catering", "GiftCards", "Delivery", "Survey"}
+var seedVersions = []string{"1.0.0", "1.1.0", "1.2.0"}
+
+// 生成演示数据: 应用、部署、带真实zip包的发布和安装统计
+func Seed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	apps := fs.Int("apps", 5, "number of apps")
+	releases := fs.Int("releases", 20, "releases per app")
+	userName := fs.String("user", "admin", "owner of the apps")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	user := model.GetOne[model.User]("user_name", *userName)
+	if user == nil {
+		return errors.New("User " + *userName + " not found")
+	}
+	for i := 0; i < *apps; i++ {
+		appName := seedAppNames[i%len(seedAppNames)] + "-" + strconv.Itoa(i+1)
+		os := i%2 + 1
+		if (model.App{}).GetAppByUidAndAppName(*user.Id, appName) != nil {
+			fmt.Println("skip existing app " + appName)
+			continue
+		}
+		app := model.App{
+			Uid:        user.Id,
+			AppName:    &appName,
+			OS:         &os,
+			CreateTime: utils.GetTimeNow(),
+		}
+		if err := model.Create[model.App](&app); err != nil {
+			return err
+		}
+		for _, name := range []string{"Staging", "Production"} {
+			if err := seedDeployment(&app, name, *releases); err != nil {
+				return err
+			}
+		}
+		fmt.Println("seeded app " + appName)
+	}
+	return nil
+}
+
+func seedDeployment(app *model.App, name string, releases int) error {
+	deploymentName := name
+	key := uuid.NewString()
+	deployment := model.Deployment{
+		AppId:      app.Id,
+		Name:       &deploymentName,
+		Key:        &key,
+		CreateTime: utils.GetTimeNow(),
+	}
+	if err := model.Create[model.Deployment](&deployment); err != nil {
+		return err
+	}
+	// 发布平均分配到各个版本,每个版本最后一次发布为当前包
+	perVersion := releases / len(seedVersions)
+	if perVersion == 0 {
+		perVersion = 1
+	}
+	released := 0
+	for _, version := range seedVersions {
+		if released >= releases {
+			break
+		}
+		appVersion := version
+		versionNum := utils.FormatVersionStr(version)
+		bundleName := ""
+		deploymentVersion := model.DeploymentVersion{
+			DeploymentId: deployment.Id,
+			BundleName:   &bundleName,
+			AppVersion:   &appVersion,
+			VersionNum:   &versionNum,
+			CreateTime:   utils.GetTimeNow(),
+		}
+		if err := model.Create[model.DeploymentVersion](&deploymentVersion); err != nil {
+			return err
+		}
+		var pack *model.Package
+		for n := 0; n < perVersion && released < releases; n++ {
+			var err error
+			pack, err = seedPackage(app, &deployment, &deploymentVersion, released)
+			if err != nil {
+				return err
+			}
+			released++
+		}
+		deploymentVersion.CurrentPackage = pack.Id
+		deploymentVersion.UpdateTime = utils.GetTimeNow()
+		model.Update[model.DeploymentVersion](&deploymentVersion)
+		deployment.VersionId = deploymentVersion.Id
+	}
+	deployment.UpdateTime = utils.GetTimeNow()
+	model.Update[model.Deployment](&deployment)
+	return model.DeploymentLookup{}.Rebuild(nil, *deployment.Id)
+}
+
+func seedPackage(app *model.App, deployment *model.Deployment, deploymentVersion *model.DeploymentVersion, n int) (*model.Package, error) {
+	bundle, err := seedBundle(*app.AppName + "/" + *deployment.Name + "/" + strconv.Itoa(n))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(bundle)
+	hash := hex.EncodeToString(sum[:])
+	key := "seed/" + hash + ".zip"
+	if _, err := storage.Upload(key, bundle); err != nil {
+		return nil, err
+	}
+	size := int64(len(bundle))
+	description := "Demo release " + strconv.Itoa(n+1)
+	installed := rand.Intn(5000)
+	active := installed * (70 + rand.Intn(30)) / 100
+	failed := rand.Intn(installed/50 + 1)
+	pack := model.Package{
+		DeploymentId:        deployment.Id,
+		DeploymentVersionId: deploymentVersion.Id,
+		Size:                &size,
+		Hash:                &hash,
+		Download:            &key,
+		Description:         &description,
+		Active:              &active,
+		Installed:           &installed,
+		Failed:              &failed,
+		CreateTime:          utils.GetTimeNow(),
+		Uid:                 app.Uid,
+	}
+	if err := model.Create[model.Package](&pack); err != nil {
+		return nil, err
+	}
+	model.Package{}.AllocateLabel(&pack)
+	return &pack, nil
+}
+
+// 带几个随机文件的假bundle,大小接近真实的小型RN包
+func seedBundle(seed string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	files := map[string]int{
+		"index.android.bundle":            64 * 1024,
+		"assets/images/logo.png":          8 * 1024,
+		"assets/fonts/Roboto-Regular.ttf": 16 * 1024,
+		"assets/locales/en.json":          2 * 1024,
+	}
+	for name, size := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		content := make([]byte, size)
+		rand.Read(content)
+		if name == "index.android.bundle" {
+			content = append([]byte("/* "+seed+" */\n"), content...)
+		}
+		if _, err := f.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
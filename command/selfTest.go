@@ -0,0 +1,190 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/storage"
+	"golang.org/x/crypto/acme"
+)
+
+type endpoint struct {
+	name string
+	// host:port
+	addr string
+	// statsd等UDP地址只解析不连接
+	udp bool
+}
+
+// 检查配置中所有出站地址的连通性;私有模式下解析到公网IP的地址视为失败
+func SelfTest(args []string) error {
+	private := storage.PrivateMode()
+	failed := 0
+	for _, e := range outboundEndpoints() {
+		if err := checkEndpoint(e, private); err != nil {
+			fmt.Println("FAIL " + e.name + " " + e.addr + ": " + err.Error())
+			failed++
+			continue
+		}
+		fmt.Println("ok   " + e.name + " " + e.addr)
+	}
+	if private {
+		if err := checkPrivateConfig(); err != nil {
+			fmt.Println("FAIL private_mode: " + err.Error())
+			failed++
+		} else {
+			fmt.Println("ok   private_mode")
+		}
+	}
+	if failed > 0 {
+		return errors.New(strconv.Itoa(failed) + " self-test checks failed")
+	}
+	return nil
+}
+
+func outboundEndpoints() []endpoint {
+	configs := config.GetConfig()
+	list := []endpoint{
+		{name: "db", addr: net.JoinHostPort(configs.DBUser.Write.Host, strconv.FormatUint(uint64(configs.DBUser.Write.Port), 10))},
+		{name: "redis", addr: net.JoinHostPort(configs.Redis.Host, strconv.FormatUint(uint64(configs.Redis.Port), 10))},
+	}
+	add := func(name string, raw string) {
+		if raw == "" {
+			return
+		}
+		list = append(list, endpoint{name: name, addr: hostPort(raw)})
+	}
+	for _, name := range storage.Chain() {
+		switch name {
+		case "aws":
+			add("aws_s3_endpoint", configs.CodePush.Aws.Endpoint)
+		case "ftp":
+			add("ftp_server_url", configs.CodePush.Ftp.ServerUrl)
+		}
+	}
+	add("aws_replica_s3_endpoint", configs.CodePush.Replica.Endpoint)
+	if storage.EncryptionEnabled() {
+		kmsEndpoint := configs.BlobEncryption.KmsEndpoint
+		if kmsEndpoint == "" {
+			region := configs.BlobEncryption.KmsRegion
+			if region == "" {
+				region = configs.CodePush.Aws.Region
+			}
+			kmsEndpoint = "kms." + region + ".amazonaws.com"
+		}
+		add("blob_kms_endpoint", kmsEndpoint)
+	}
+	add("sentry_dsn", configs.Sentry.Dsn)
+	add("kafka_rest_url", configs.Kafka.RestUrl)
+	add("event_webhook_url", configs.EventBus.WebhookUrl)
+	add("approval_webhook_url", configs.ApprovalWebhookUrl)
+	add("anomaly_webhook_url", configs.Anomaly.WebhookUrl)
+	add("anomaly_slack_webhook_url", configs.Anomaly.SlackWebhookUrl)
+	add("oidc_userinfo_url", configs.Auth.OidcUserinfoUrl)
+	add("ldap_url", configs.Auth.Ldap.Url)
+	if configs.Tls.Addr != "" {
+		directory := configs.Tls.AcmeDirectoryUrl
+		if directory == "" {
+			directory = acme.LetsEncryptURL
+		}
+		add("acme_directory_url", directory)
+	}
+	if configs.Metrics.DatadogAddr != "" {
+		list = append(list, endpoint{name: "metrics_datadog_addr", addr: configs.Metrics.DatadogAddr, udp: true})
+	}
+	return list
+}
+
+// 支持完整URL和host[:port],没有端口时按scheme取默认值
+func hostPort(raw string) string {
+	if !strings.Contains(raw, "://") {
+		if _, _, err := net.SplitHostPort(raw); err == nil {
+			return raw
+		}
+		return net.JoinHostPort(raw, "443")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "443"
+	switch u.Scheme {
+	case "http":
+		port = "80"
+	case "ldap":
+		port = "389"
+	case "ldaps":
+		port = "636"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func checkEndpoint(e endpoint, private bool) error {
+	host, _, err := net.SplitHostPort(e.addr)
+	if err != nil {
+		return err
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+	if private {
+		for _, ip := range ips {
+			if !internalIP(ip) {
+				return errors.New("resolves to public address " + ip.String())
+			}
+		}
+	}
+	if e.udp {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", e.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func internalIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+}
+
+// 生成的下载地址必须是相对地址或指向内部地址
+func checkPrivateConfig() error {
+	configs := config.GetConfig()
+	if configs.CodePush.Aws.Accelerate {
+		return errors.New("aws_s3_accelerate requires public egress")
+	}
+	u, err := url.Parse(configs.Private.InternalUrl)
+	if err != nil {
+		return errors.New("internal_url: " + err.Error())
+	}
+	if u.Hostname() == "" {
+		return nil
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if !internalIP(ip) {
+			return errors.New("internal_url points to public address " + ip.String())
+		}
+		return nil
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return errors.New("internal_url: " + err.Error())
+	}
+	for _, ip := range ips {
+		if !internalIP(ip) {
+			return errors.New("internal_url resolves to public address " + ip.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/egress"
+	"com.lc.go.codepush/server/storage"
+)
+
+// 对存储链中的每个存储做一次写入/读取/下载链接检查
+func StorageCheck(args []string) error {
+	key := "storage-check/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	data := []byte("code-push-server-go storage check " + key)
+	for _, name := range storage.Chain() {
+		provider, err := storage.GetProvider(name)
+		if err != nil {
+			return err
+		}
+		if err := provider.Check(); err != nil {
+			return errors.New(name + " check error:" + err.Error())
+		}
+		if err := provider.Put(key, bytes.NewReader(data)); err != nil {
+			return errors.New(name + " put error:" + err.Error())
+		}
+		got, err := provider.Get(key)
+		if err != nil {
+			return errors.New(name + " get error:" + err.Error())
+		}
+		if !bytes.Equal(got, data) {
+			return errors.New(name + " get returned different content")
+		}
+		url, err := provider.DownloadUrl(key)
+		if err != nil {
+			return errors.New(name + " download url error:" + err.Error())
+		}
+		if name == "aws" {
+			if err := checkDownload(url, data); err != nil {
+				return errors.New(name + " download error:" + err.Error())
+			}
+		}
+		fmt.Println(name + " ok")
+	}
+	return nil
+}
+
+// 与S3请求一样经过egress代理
+func checkDownload(url string, data []byte) error {
+	resp, err := egress.Client(30 * time.Second).Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("status " + resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(body, data) {
+		return errors.New("downloaded content differs")
+	}
+	return nil
+}
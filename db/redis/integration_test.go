@@ -0,0 +1,104 @@
+//go:build integration
+
+// 需要redis: go run -tags integration ./testenv go test -tags integration -p 1 ./...
+package redis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testKey(name string) string {
+	return "it:" + strconv.FormatInt(time.Now().UnixNano(), 36) + ":" + name
+}
+
+func TestRedisObj(t *testing.T) {
+	type obj struct {
+		Label string `json:"label"`
+		Size  int    `json:"size"`
+	}
+	key := testKey("obj")
+	SetRedisObj(key, obj{"v1", 10}, time.Minute)
+	got := GetRedisObj[obj](key)
+	if got == nil || got.Label != "v1" || got.Size != 10 {
+		t.Fatalf("get: got %+v", got)
+	}
+	if ttl := GetTTL(key); ttl <= 0 || ttl > 60 {
+		t.Fatalf("ttl: got %d", ttl)
+	}
+	// DelRedisObj按模式删除
+	SetRedisObj(key+":a", obj{}, time.Minute)
+	DelRedisObj(key + "*")
+	if GetRedisObj[obj](key) != nil || GetRedisObj[obj](key+":a") != nil {
+		t.Fatal("del: keys still exist")
+	}
+}
+
+func TestSetNX(t *testing.T) {
+	key := testKey("lock")
+	if !SetNX(key, time.Minute) {
+		t.Fatal("first SetNX should succeed")
+	}
+	if SetNX(key, time.Minute) {
+		t.Fatal("second SetNX should fail")
+	}
+	DelRedisObj(key)
+	if !SetNX(key, time.Minute) {
+		t.Fatal("SetNX after delete should succeed")
+	}
+}
+
+func TestCounters(t *testing.T) {
+	key := testKey("hash")
+	IncrHash(key, "a", time.Minute)
+	IncrHash(key, "a", time.Minute)
+	IncrHash(key, "b", time.Minute)
+	counts := GetHashCounts(key)
+	if counts["a"] != 2 || counts["b"] != 1 {
+		t.Fatalf("hash counts: got %v", counts)
+	}
+	zkey := testKey("zset")
+	IncrScore(zkey, "low", time.Minute)
+	IncrScore(zkey, "high", time.Minute)
+	IncrScore(zkey, "high", time.Minute)
+	if top := TopMembers(zkey, 1); len(top) != 1 || top[0] != "high" {
+		t.Fatalf("top members: got %v", top)
+	}
+	ukey := testKey("hll")
+	CountUnique(ukey, "a", time.Minute)
+	CountUnique(ukey, "b", time.Minute)
+	if n := CountUnique(ukey, "a", time.Minute); n != 2 {
+		t.Fatalf("count unique: got %d", n)
+	}
+}
+
+func TestStream(t *testing.T) {
+	stream := testKey("stream")
+	if err := XGroupCreate(stream, "g"); err != nil {
+		t.Fatal(err)
+	}
+	// 消费组已存在时忽略
+	if err := XGroupCreate(stream, "g"); err != nil {
+		t.Fatal(err)
+	}
+	if err := XAdd(stream, 100, map[string]any{"type": "release"}); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := XReadGroup(stream, "g", "c1", ">", 10, time.Second)
+	if err != nil || len(messages) != 1 || messages[0].Values["type"] != "release" {
+		t.Fatalf("read: got %+v %v", messages, err)
+	}
+	// 没有确认的消息再次读取时仍然返回
+	pending, err := XReadGroup(stream, "g", "c1", "0", 10, 0)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("pending: got %+v %v", pending, err)
+	}
+	if err := XAck(stream, "g", messages[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = XReadGroup(stream, "g", "c1", "0", 10, 0)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("pending after ack: got %+v %v", pending, err)
+	}
+}
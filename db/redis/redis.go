@@ -3,12 +3,15 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"com.lc.go.codepush/server/config"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -55,6 +58,14 @@ func DelRedisObj(key string) {
 	}
 }
 
+// 按key删除,不扫描
+func DelKeys(keys ...string) {
+	redis, _ := GetRedis()
+	if err := redis.Del(ctx, keys...).Err(); err != nil {
+		log.Println(err.Error())
+	}
+}
+
 func GetRedisObj[T any](key string) *T {
 
 	redis, _ := GetRedis()
@@ -70,3 +81,209 @@ func GetRedisObj[T any](key string) *T {
 	}
 	return &obj
 }
+
+func ScanKeys(pattern string) []string {
+	redis, _ := GetRedis()
+	var keys []string
+	iter := redis.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		panic("Redis error:" + err.Error())
+	}
+	return keys
+}
+
+// 剩余过期秒数,key不存在时返回-2,没有过期时间时返回-1(与redis TTL一致)
+func GetTTL(key string) int64 {
+	redis, _ := GetRedis()
+	ttl, err := redis.TTL(ctx, key).Result()
+	if err != nil {
+		panic("Redis error:" + err.Error())
+	}
+	if ttl < 0 {
+		return int64(ttl)
+	}
+	return int64(ttl / time.Second)
+}
+
+// 有序集合计数加一,并刷新过期时间
+func IncrScore(key string, member string, duration time.Duration) {
+	redis, _ := GetRedis()
+	pipe := redis.Pipeline()
+	pipe.ZIncrBy(ctx, key, 1, member)
+	pipe.Expire(ctx, key, duration)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// 分数最高的前n个成员
+func TopMembers(key string, n int64) []string {
+	redis, _ := GetRedis()
+	members, err := redis.ZRevRange(ctx, key, 0, n-1).Result()
+	if err != nil {
+		log.Println(err.Error())
+		return nil
+	}
+	return members
+}
+
+// 哈希字段计数加一,并刷新过期时间
+func IncrHash(key string, field string, duration time.Duration) {
+	redis, _ := GetRedis()
+	pipe := redis.Pipeline()
+	pipe.HIncrBy(ctx, key, field, 1)
+	pipe.Expire(ctx, key, duration)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// 哈希中所有字段的计数
+func GetHashCounts(key string) map[string]int64 {
+	redis, _ := GetRedis()
+	values, err := redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		log.Println(err.Error())
+		return nil
+	}
+	counts := make(map[string]int64, len(values))
+	for field, value := range values {
+		counts[field], _ = strconv.ParseInt(value, 10, 64)
+	}
+	return counts
+}
+
+// 加入HyperLogLog并返回不同成员的大约个数,出错时返回0
+func CountUnique(key string, member string, duration time.Duration) int64 {
+	redis, _ := GetRedis()
+	pipe := redis.Pipeline()
+	pipe.PFAdd(ctx, key, member)
+	pipe.Expire(ctx, key, duration)
+	count := pipe.PFCount(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println(err.Error())
+		return 0
+	}
+	return count.Val()
+}
+
+// 加入HyperLogLog,并刷新过期时间
+func AddUnique(key string, member string, duration time.Duration) {
+	redis, _ := GetRedis()
+	pipe := redis.Pipeline()
+	pipe.PFAdd(ctx, key, member)
+	pipe.Expire(ctx, key, duration)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// 一个或多个HyperLogLog合并后不同成员的大约个数
+func GetUniqueCount(keys ...string) int64 {
+	redis, _ := GetRedis()
+	count, err := redis.PFCount(ctx, keys...).Result()
+	if err != nil {
+		log.Println(err.Error())
+		return 0
+	}
+	return count
+}
+
+// key不存在时设置并返回true,用于多实例之间的简单锁和去重
+func SetNX(key string, duration time.Duration) bool {
+	redis, _ := GetRedis()
+	ok, err := redis.SetNX(ctx, key, "1", duration).Result()
+	if err != nil {
+		log.Println(err.Error())
+		return false
+	}
+	return ok
+}
+
+// 只有持有token的实例可以续期和释放,锁超时后不会删掉其他实例拿到的锁
+var unlockScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`)
+var extendScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) end return 0`)
+
+// 获取锁,成功时返回释放和续期用的token
+func Lock(key string, duration time.Duration) (string, bool) {
+	client, _ := GetRedis()
+	token := uuid.NewString()
+	ok, err := client.SetNX(ctx, key, token, duration).Result()
+	if err != nil {
+		log.Println(err.Error())
+		return "", false
+	}
+	return token, ok
+}
+
+func Unlock(key string, token string) {
+	client, _ := GetRedis()
+	if err := unlockScript.Run(ctx, client, []string{key}, token).Err(); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// 延长锁的有效期,锁已经过期或被其他实例拿到时返回false
+func ExtendLock(key string, token string, duration time.Duration) bool {
+	client, _ := GetRedis()
+	n, err := extendScript.Run(ctx, client, []string{key}, token, duration.Milliseconds()).Int()
+	if err != nil {
+		log.Println(err.Error())
+		return false
+	}
+	return n == 1
+}
+
+type StreamMessage struct {
+	ID     string
+	Values map[string]any
+}
+
+// 写入stream,超过maxLen(近似)时删除最早的消息
+func XAdd(stream string, maxLen int64, values map[string]any) error {
+	client, _ := GetRedis()
+	return client.XAdd(ctx, &redis.XAddArgs{Stream: stream, MaxLen: maxLen, Approx: true, Values: values}).Err()
+}
+
+// 创建消费组,stream不存在时一起创建,消费组已存在时忽略
+func XGroupCreate(stream string, group string) error {
+	client, _ := GetRedis()
+	err := client.XGroupCreateMkStream(ctx, stream, group, "$").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// start为"0"时读取本消费者还没有确认的消息,为">"时等待新消息
+func XReadGroup(stream string, group string, consumer string, start string, count int64, block time.Duration) ([]StreamMessage, error) {
+	client, _ := GetRedis()
+	streams, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, start},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var messages []StreamMessage
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			messages = append(messages, StreamMessage{ID: m.ID, Values: m.Values})
+		}
+	}
+	return messages, nil
+}
+
+func XAck(stream string, group string, ids ...string) error {
+	client, _ := GetRedis()
+	return client.XAck(ctx, stream, group, ids...).Err()
+}
@@ -0,0 +1,123 @@
+package diff
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// react-native-code-push识别差量包的清单文件
+const manifestName = "hotcodepush.json"
+
+type manifest struct {
+	DeletedFiles []string `json:"deletedFiles"`
+}
+
+// 生成差量包:只包含新增和修改的文件,删除的文件写入hotcodepush.json
+func Generate(newZip []byte, baseZip []byte) ([]byte, error) {
+	release, err := Reserve(int64(2*len(newZip) + len(baseZip)))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	newReader, err := openArchive(newZip)
+	if err != nil {
+		return nil, err
+	}
+	baseReader, err := openArchive(baseZip)
+	if err != nil {
+		return nil, err
+	}
+	baseHashes, err := fileHashes(baseReader)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := newSpillFile()
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	newNames := make(map[string]bool)
+	for _, f := range newReader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		newNames[f.Name] = true
+		hash, err := fileHash(f)
+		if err != nil {
+			return nil, err
+		}
+		if baseHash, ok := baseHashes[f.Name]; ok && baseHash == hash {
+			continue
+		}
+		if err := copyFile(w, f); err != nil {
+			return nil, err
+		}
+	}
+	m := manifest{DeletedFiles: []string{}}
+	for name := range baseHashes {
+		if !newNames[name] {
+			m.DeletedFiles = append(m.DeletedFiles, name)
+		}
+	}
+	sort.Strings(m.DeletedFiles)
+	mw, err := w.Create(manifestName)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(mw).Encode(m); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes()
+}
+
+func fileHashes(r *zip.Reader) (map[string][32]byte, error) {
+	hashes := make(map[string][32]byte)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		hash, err := fileHash(f)
+		if err != nil {
+			return nil, err
+		}
+		hashes[f.Name] = hash
+	}
+	return hashes, nil
+}
+
+func fileHash(f *zip.File) ([32]byte, error) {
+	var sum [32]byte
+	rc, err := f.Open()
+	if err != nil {
+		return sum, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+func copyFile(w *zip.Writer, f *zip.File) error {
+	rc, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	header := f.FileHeader
+	fw, err := w.CreateRaw(&header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, rc)
+	return err
+}
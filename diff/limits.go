@@ -0,0 +1,118 @@
+package diff
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"com.lc.go.codepush/server/config"
+	"golang.org/x/sync/semaphore"
+)
+
+const mb = 1024 * 1024
+
+// 超过解压、内存或临时目录限制
+type LimitError struct {
+	Msg string
+}
+
+func (e *LimitError) Error() string {
+	return e.Msg
+}
+
+// 只看zip目录中的大小,archive/zip读取时会校验实际大小不超过声明的大小
+func CheckArchive(r *zip.Reader) error {
+	c := config.GetConfig().Diff
+	if len(r.File) > c.UnzipMaxFiles {
+		return &LimitError{fmt.Sprintf("zip has %d files, limit is %d", len(r.File), c.UnzipMaxFiles)}
+	}
+	var total uint64
+	for _, f := range r.File {
+		total += f.UncompressedSize64
+		if total > uint64(c.UnzipMaxMB)*mb {
+			return &LimitError{fmt.Sprintf("zip uncompressed size exceeds %dMB", c.UnzipMaxMB)}
+		}
+		// 小文件的压缩比没有意义
+		if f.UncompressedSize64 > mb && f.UncompressedSize64 > f.CompressedSize64*uint64(c.UnzipMaxRatio) {
+			return &LimitError{fmt.Sprintf("%s compression ratio exceeds %d", f.Name, c.UnzipMaxRatio)}
+		}
+	}
+	return nil
+}
+
+func openArchive(data []byte) (*zip.Reader, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckArchive(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+var workMemory = sync.OnceValue(func() *semaphore.Weighted {
+	return semaphore.NewWeighted(config.GetConfig().Diff.UnzipMemoryMB * mb)
+})
+
+// 按预计内存占用排队,返回的函数释放占用;请求中的解压、转换和签名也经过这里
+func Reserve(n int64) (func(), error) {
+	limit := config.GetConfig().Diff.UnzipMemoryMB * mb
+	if n > limit {
+		return nil, &LimitError{fmt.Sprintf("work needs %dMB memory, limit is %dMB", n/mb+1, limit/mb)}
+	}
+	if err := workMemory().Acquire(context.Background(), n); err != nil {
+		return nil, err
+	}
+	return func() { workMemory().Release(n) }, nil
+}
+
+// 所有任务的临时文件占用
+var tempUsed atomic.Int64
+
+// 生成结果写入临时文件,不在内存中反复扩容
+type spillFile struct {
+	f    *os.File
+	size int64
+}
+
+func newSpillFile() (*spillFile, error) {
+	f, err := os.CreateTemp(config.GetConfig().Diff.TempDir, "codepush-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{f: f}, nil
+}
+
+func (s *spillFile) Write(p []byte) (int, error) {
+	quota := config.GetConfig().Diff.TempQuotaMB * mb
+	if tempUsed.Add(int64(len(p))) > quota {
+		tempUsed.Add(-int64(len(p)))
+		return 0, &LimitError{fmt.Sprintf("temp dir quota of %dMB exceeded", quota/mb)}
+	}
+	n, err := s.f.Write(p)
+	s.size += int64(n)
+	if n < len(p) {
+		tempUsed.Add(-int64(len(p) - n))
+	}
+	return n, err
+}
+
+func (s *spillFile) Bytes() ([]byte, error) {
+	data := make([]byte, s.size)
+	if _, err := s.f.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *spillFile) Close() {
+	s.f.Close()
+	os.Remove(s.f.Name())
+	tempUsed.Add(-s.size)
+}
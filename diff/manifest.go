@@ -0,0 +1,93 @@
+package diff
+
+import (
+	"encoding/hex"
+	"sort"
+)
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// 包内文件清单(按路径排序),用于审计和比较两次发布
+func Manifest(data []byte) ([]ManifestFile, error) {
+	release, err := Reserve(int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	r, err := openArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	files := []ManifestFile{}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		hash, err := fileHash(f)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, ManifestFile{
+			Path:   f.Name,
+			Size:   int64(f.UncompressedSize64),
+			Sha256: hex.EncodeToString(hash[:]),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+type ChangedFile struct {
+	Path      string `json:"path"`
+	FromSize  int64  `json:"fromSize"`
+	ToSize    int64  `json:"toSize"`
+	SizeDelta int64  `json:"sizeDelta"`
+}
+
+type ManifestDiff struct {
+	Added     []ManifestFile `json:"added"`
+	Removed   []ManifestFile `json:"removed"`
+	Changed   []ChangedFile  `json:"changed"`
+	SizeDelta int64          `json:"sizeDelta"`
+}
+
+// 比较两个文件清单,sizeDelta为解压后大小的变化
+func CompareManifests(from []ManifestFile, to []ManifestFile) ManifestDiff {
+	result := ManifestDiff{Added: []ManifestFile{}, Removed: []ManifestFile{}, Changed: []ChangedFile{}}
+	fromFiles := make(map[string]ManifestFile, len(from))
+	for _, f := range from {
+		fromFiles[f.Path] = f
+	}
+	toFiles := make(map[string]bool, len(to))
+	for _, f := range to {
+		toFiles[f.Path] = true
+		old, ok := fromFiles[f.Path]
+		if !ok {
+			result.Added = append(result.Added, f)
+			result.SizeDelta += f.Size
+			continue
+		}
+		if old.Sha256 != f.Sha256 {
+			result.Changed = append(result.Changed, ChangedFile{
+				Path:      f.Path,
+				FromSize:  old.Size,
+				ToSize:    f.Size,
+				SizeDelta: f.Size - old.Size,
+			})
+			result.SizeDelta += f.Size - old.Size
+		}
+	}
+	for _, f := range from {
+		if !toFiles[f.Path] {
+			result.Removed = append(result.Removed, f)
+			result.SizeDelta -= f.Size
+		}
+	}
+	return result
+}
@@ -0,0 +1,245 @@
+package diff
+
+import (
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+)
+
+type job struct {
+	packageId  int
+	queuedTime time.Time
+}
+
+// Production部署的任务优先处理
+var (
+	highQueue chan job
+	lowQueue  chan job
+)
+
+type Stats struct {
+	Queued    int64 `json:"queued"`
+	Running   int64 `json:"running"`
+	Succeeded int64 `json:"succeeded"`
+	Failed    int64 `json:"failed"`
+	Dropped   int64 `json:"dropped"`
+}
+
+var queued, running, succeeded, failed, dropped atomic.Int64
+
+func GetStats() Stats {
+	return Stats{
+		Queued:    queued.Load(),
+		Running:   running.Load(),
+		Succeeded: succeeded.Load(),
+		Failed:    failed.Load(),
+		Dropped:   dropped.Load(),
+	}
+}
+
+func Enabled() bool {
+	c := config.GetConfig().Diff
+	return c.PackageCount > 0 || c.Zstd
+}
+
+// 启动固定数量的差量包生成worker,避免一次大包发布占满API的CPU
+func Start() {
+	if !Enabled() {
+		return
+	}
+	c := config.GetConfig().Diff
+	highQueue = make(chan job, c.QueueSize)
+	lowQueue = make(chan job, c.QueueSize)
+	for i := uint(0); i < c.Workers; i++ {
+		go worker()
+	}
+	go requeueMissing()
+}
+
+// 队列只在内存中,重启时排队的任务会丢失;启动后把最近一天没有差量包的包重新排队,
+// 多个实例同时启动时只有一个执行
+func requeueMissing() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("diff: requeue error:%v", r)
+			sentry.CapturePanic("diff", r, nil)
+		}
+	}()
+	if !redis.SetNX(constants.REDIS_DIFF+"requeue", 10*time.Minute) {
+		return
+	}
+	since := time.Now().Add(-24 * time.Hour).UnixMilli()
+	packs := model.Package{}.GetWithoutDiff(since, int(config.GetConfig().Diff.QueueSize))
+	if packs == nil {
+		return
+	}
+	for _, pack := range *packs {
+		deployment := model.GetOne[model.Deployment]("id", pack.DeploymentId)
+		if deployment == nil {
+			continue
+		}
+		Enqueue(*pack.Id, *deployment.Name == "Production")
+	}
+	if len(*packs) > 0 {
+		log.Printf("diff: requeued %d packages", len(*packs))
+	}
+}
+
+// 队列满时丢弃任务,客户端继续下载全量包
+func Enqueue(packageId int, production bool) {
+	if !Enabled() {
+		return
+	}
+	queue := lowQueue
+	if production {
+		queue = highQueue
+	}
+	select {
+	case queue <- job{packageId: packageId, queuedTime: time.Now()}:
+		queued.Add(1)
+	default:
+		dropped.Add(1)
+		log.Printf("diff: queue full, drop package %d", packageId)
+	}
+}
+
+func worker() {
+	for {
+		var j job
+		select {
+		case j = <-highQueue:
+		default:
+			select {
+			case j = <-highQueue:
+			case j = <-lowQueue:
+			}
+		}
+		queued.Add(-1)
+		running.Add(1)
+		run(j)
+		running.Add(-1)
+	}
+}
+
+func run(j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			failed.Add(1)
+			log.Printf("diff: package %d error:%v", j.packageId, r)
+			sentry.CapturePanic("diff", r, map[string]string{"packageId": strconv.Itoa(j.packageId)})
+		}
+	}()
+	pack := model.GetOne[model.Package]("id", j.packageId)
+	if pack == nil {
+		return
+	}
+	newData, err := storage.Download(*pack.Download)
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	generated := false
+	if config.GetConfig().Diff.Zstd && pack.ZstdDownload == nil {
+		generated = generateZstd(pack, newData)
+	}
+	if config.GetConfig().Diff.PackageCount > 0 {
+		bases := model.Package{}.GetDiffBasePacks(*pack.DeploymentVersionId, *pack.Id, int(config.GetConfig().Diff.PackageCount))
+		if bases != nil {
+			for _, base := range *bases {
+				// 重新排队的包可能已经在其他实例上生成过
+				if *base.Hash == *pack.Hash || (model.PackageDiff{}).Exists(*pack.Id, *base.Id) {
+					continue
+				}
+				if generateOne(j, pack, &base, newData) {
+					generated = true
+				}
+			}
+		}
+	}
+	if generated {
+		deployment := model.GetOne[model.Deployment]("id", pack.DeploymentId)
+		if deployment != nil {
+			redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		}
+	}
+}
+
+func generateOne(j job, pack *model.Package, base *model.Package, newData []byte) bool {
+	start := time.Now()
+	waitMs := start.Sub(j.queuedTime).Milliseconds()
+	packageDiff := model.PackageDiff{
+		PackageId:     pack.Id,
+		BasePackageId: base.Id,
+		BaseHash:      base.Hash,
+		WaitMs:        &waitMs,
+		CreateTime:    utils.GetTimeNow(),
+	}
+	diffData, err := generateFromBase(base, newData)
+	if err == nil && len(diffData) < len(newData) {
+		key := "diff/" + *pack.Hash + "_" + *base.Hash + ".zip"
+		if _, err = storage.Upload(key, diffData); err == nil {
+			size := int64(len(diffData))
+			packageDiff.Download = &key
+			packageDiff.Size = &size
+		}
+	}
+	status := constants.JOB_STATUS_SUCCEEDED
+	if err != nil {
+		status = constants.JOB_STATUS_FAILED
+		errMsg := err.Error()
+		packageDiff.Error = &errMsg
+		failed.Add(1)
+		sentry.CaptureError("diff", err, map[string]string{"packageId": strconv.Itoa(*pack.Id), "basePackageId": strconv.Itoa(*base.Id)})
+	} else if packageDiff.Download == nil {
+		// 差量包不比全量包小,不使用
+		return false
+	} else {
+		succeeded.Add(1)
+	}
+	durationMs := time.Since(start).Milliseconds()
+	metrics.Count("diff.jobs", 1, map[string]string{"status": status})
+	metrics.Timing("diff.duration", time.Since(start), nil)
+	packageDiff.Status = &status
+	packageDiff.DurationMs = &durationMs
+	model.Create[model.PackageDiff](&packageDiff)
+	log.Printf("diff: package %d base %d status=%s wait=%dms duration=%dms", *pack.Id, *base.Id, status, waitMs, durationMs)
+	return status == constants.JOB_STATUS_SUCCEEDED
+}
+
+// 只有比zip小时才保存
+func generateZstd(pack *model.Package, newData []byte) bool {
+	start := time.Now()
+	data, err := ZipToTarZstd(newData)
+	if err != nil {
+		log.Printf("zstd: package %d error:%s", *pack.Id, err.Error())
+		return false
+	}
+	if len(data) >= len(newData) {
+		return false
+	}
+	key := "zstd/" + *pack.Hash + ".tar.zst"
+	if _, err := storage.Upload(key, data); err != nil {
+		log.Printf("zstd: package %d upload error:%s", *pack.Id, err.Error())
+		return false
+	}
+	model.Package{}.UpdateZstd(*pack.Id, key, int64(len(data)))
+	log.Printf("zstd: package %d size %d -> %d duration=%dms", *pack.Id, len(newData), len(data), time.Since(start).Milliseconds())
+	return true
+}
+
+func generateFromBase(base *model.Package, newData []byte) ([]byte, error) {
+	baseData, err := storage.Download(*base.Download)
+	if err != nil {
+		return nil, err
+	}
+	return Generate(newData, baseData)
+}
@@ -0,0 +1,62 @@
+package diff
+
+import (
+	"archive/tar"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 把zip包重新打成tar.zst,文件内容整体压缩,比逐个deflate的zip小
+func ZipToTarZstd(data []byte) ([]byte, error) {
+	// 输出不超过输入,另加zstd编码器的窗口
+	release, err := Reserve(int64(2*len(data)) + 16*mb)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	r, err := openArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	out, err := newSpillFile()
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	zw, err := zstd.NewWriter(out, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(zw)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		hdr := &tar.Header{
+			Name:    f.Name,
+			Mode:    0644,
+			Size:    int64(f.UncompressedSize64),
+			ModTime: f.Modified,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(tw, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,135 @@
+package egress
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// 出站请求(S3/KMS、FTP、webhook等)使用的代理。egress_proxy为空时读取环境变量
+// HTTP_PROXY/HTTPS_PROXY/NO_PROXY;localhost和回环地址始终直连
+var proxyFunc = sync.OnceValue(func() func(*url.URL) (*url.URL, error) {
+	c := config.GetConfig().Egress
+	env := httpproxy.FromEnvironment()
+	if c.Proxy == "" {
+		return env.ProxyFunc()
+	}
+	noProxy := env.NoProxy
+	if c.NoProxy != "" {
+		noProxy = c.NoProxy
+	}
+	return (&httpproxy.Config{HTTPProxy: c.Proxy, HTTPSProxy: c.Proxy, NoProxy: noProxy}).ProxyFunc()
+})
+
+// 用于http.Transport.Proxy
+func Proxy(req *http.Request) (*url.URL, error) {
+	return proxyFunc()(req.URL)
+}
+
+// http.DefaultTransport的副本,代理在每次请求时按配置选择
+func Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = Proxy
+	return transport
+}
+
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport()}
+}
+
+var dialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// TCP连接(FTP控制和数据连接): http/https代理使用CONNECT隧道,socks5代理由x/net/proxy处理;
+// NO_PROXY按https://host:port判断
+func Dial(network string, addr string) (net.Conn, error) {
+	proxyUrl, err := proxyFunc()(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, err
+	}
+	if proxyUrl == nil {
+		return dialer.Dial(network, addr)
+	}
+	switch proxyUrl.Scheme {
+	case "http", "https":
+		return dialConnect(proxyUrl, addr)
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(proxyUrl, dialer)
+		if err != nil {
+			return nil, err
+		}
+		return d.Dial(network, addr)
+	}
+	return nil, errors.New("egress: unsupported proxy scheme " + proxyUrl.Scheme)
+}
+
+func dialConnect(proxyUrl *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyUrl.Host
+	if proxyUrl.Port() == "" {
+		if proxyUrl.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(proxyUrl.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(proxyUrl.Hostname(), "80")
+		}
+	}
+	conn, err := dialer.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyUrl.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyUrl.Hostname()})
+		if err := tlsConn.HandshakeContext(context.Background()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user := proxyUrl.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	rep, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	rep.Body.Close()
+	if rep.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.New("egress: proxy CONNECT " + addr + ": " + rep.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	// FTP服务器在连接后立即发送欢迎信息,可能已经读入reader
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,139 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/utils"
+)
+
+const (
+	RELEASE_CREATED = "release.created"
+	// 待审批或私有的发布通过审批或公开后生效
+	RELEASE_APPROVED    = "release.approved"
+	RELEASE_ROLLED_BACK = "release.rolled_back"
+	ROLLOUT_CHANGED     = "rollout.changed"
+	STATUS_REPORTED     = "status.reported"
+)
+
+// 消费者即redis stream的消费组,可以只在部分实例上运行
+const (
+	CONSUMER_CACHE   = "cache"
+	CONSUMER_WEBHOOK = "webhook"
+	CONSUMER_METRICS = "metrics"
+	CONSUMER_KAFKA   = "kafka"
+)
+
+type Event struct {
+	// outbox中的id,接收方可以用来去重;上报事件没有
+	Id            int    `json:"id,omitempty"`
+	Type          string `json:"type"`
+	Time          int64  `json:"time"`
+	Uid           int    `json:"uid,omitempty"`
+	AppId         int    `json:"appId,omitempty"`
+	DeploymentId  int    `json:"deploymentId,omitempty"`
+	DeploymentKey string `json:"deploymentKey,omitempty"`
+	PackageId     int    `json:"packageId,omitempty"`
+	Label         string `json:"label,omitempty"`
+	AppVersion    string `json:"appVersion,omitempty"`
+	// 发布时为包的status(为空表示已生效),上报时为SDK的status
+	Status  string `json:"status,omitempty"`
+	Rollout int    `json:"rollout,omitempty"`
+	Paused  bool   `json:"paused,omitempty"`
+}
+
+type Handler func(e Event)
+
+var handlers = map[string][]Handler{}
+
+// 在Start之前注册
+func Subscribe(consumer string, handler Handler) {
+	handlers[consumer] = append(handlers[consumer], handler)
+}
+
+// 不经过outbox直接发布,进程退出时可能丢失
+func Publish(e Event) {
+	e.Time = *utils.GetTimeNow()
+	deliver(e)
+}
+
+// 没有配置event_bus_stream或写入失败时在本进程内直接处理
+func deliver(e Event) {
+	c := config.GetConfig().EventBus
+	if c.Stream != "" {
+		data, err := json.Marshal(e)
+		if err == nil {
+			err = redis.XAdd(c.Stream, c.MaxLen, map[string]any{"event": string(data)})
+		}
+		if err == nil {
+			return
+		}
+		log.Printf("events: publish %s error:%s, handle locally", e.Type, err.Error())
+	}
+	for consumer := range handlers {
+		dispatch(consumer, e)
+	}
+}
+
+func dispatch(consumer string, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("events: %s %s error:%v", consumer, e.Type, r)
+			sentry.CapturePanic("events", r, map[string]string{"consumer": consumer, "type": e.Type})
+		}
+	}()
+	for _, handler := range handlers[consumer] {
+		handler(e)
+	}
+}
+
+// 启动outbox投递,并按event_bus_consumers为每个消费组启动一个读取协程
+func Start() {
+	c := config.GetConfig().EventBus
+	startOutbox()
+	if c.Stream == "" {
+		return
+	}
+	name, _ := os.Hostname()
+	for _, consumer := range c.Consumers {
+		if err := redis.XGroupCreate(c.Stream, consumer); err != nil {
+			log.Printf("events: create group %s error:%s", consumer, err.Error())
+		}
+		go consume(c.Stream, consumer, name)
+	}
+}
+
+// 先处理本实例重启前没有确认的消息,再等待新消息;处理完才确认
+func consume(stream string, consumer string, name string) {
+	start := "0"
+	for {
+		messages, err := redis.XReadGroup(stream, consumer, name, start, 100, 5*time.Second)
+		if err != nil {
+			log.Printf("events: read %s error:%s", consumer, err.Error())
+			time.Sleep(time.Second)
+			continue
+		}
+		if start == "0" && len(messages) == 0 {
+			start = ">"
+			continue
+		}
+		for _, m := range messages {
+			e := Event{}
+			if data, ok := m.Values["event"].(string); ok {
+				if err := json.Unmarshal([]byte(data), &e); err == nil {
+					dispatch(consumer, e)
+				} else {
+					log.Printf("events: decode %s error:%s", m.ID, err.Error())
+				}
+			}
+			if err := redis.XAck(stream, consumer, m.ID); err != nil {
+				log.Printf("events: ack %s error:%s", m.ID, err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/utils"
+	"com.lc.go.codepush/server/webhook"
+	"gorm.io/gorm"
+)
+
+// 在数据变更的事务中调用,事务回滚时事件也不会发出
+func Enqueue(tx *gorm.DB, e Event) error {
+	e.Time = *utils.GetTimeNow()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = model.Outbox{}.Add(tx, e.Type, string(data))
+	return err
+}
+
+// 投递锁的有效期,每投递一条续期一次
+const outboxLease = time.Minute
+
+// 每秒投递一次,同一时间只有一个实例投递
+func startOutbox() {
+	go func() {
+		cleaned := time.Time{}
+		for range time.Tick(time.Second) {
+			token, ok := redis.Lock(constants.REDIS_OUTBOX+"lock", outboxLease)
+			if !ok {
+				continue
+			}
+			clean := time.Since(cleaned) > time.Hour
+			relay(token, clean)
+			if clean {
+				cleaned = time.Now()
+			}
+		}
+	}()
+}
+
+// 先写入事件总线,再发送webhook;进程在两步之间退出时会重复投递,接收方按id去重
+// 只释放自己持有的锁;续期失败说明锁已经过期,可能被其他实例拿到,停止投递
+func relay(token string, clean bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("events: outbox error:%v", r)
+			sentry.CapturePanic("outbox", r, nil)
+		}
+	}()
+	lock := constants.REDIS_OUTBOX + "lock"
+	defer redis.Unlock(lock, token)
+	if clean {
+		cleanOutbox()
+	}
+	c := config.GetConfig().EventBus
+	rows := model.Outbox{}.GetDue(*utils.GetTimeNow(), 100)
+	if rows == nil {
+		return
+	}
+	for _, row := range *rows {
+		if !redis.ExtendLock(lock, token, outboxLease) {
+			log.Printf("events: outbox lock lost")
+			return
+		}
+		e := Event{}
+		if err := json.Unmarshal([]byte(*row.Payload), &e); err != nil {
+			log.Printf("events: outbox %d decode error:%s", *row.Id, err.Error())
+			model.Outbox{}.MarkFailed(*row.Id, *row.Attempts+1, nil, *utils.GetTimeNow())
+			continue
+		}
+		e.Id = *row.Id
+		if row.PublishTime == nil {
+			deliver(e)
+			model.Outbox{}.MarkPublished(*row.Id, *utils.GetTimeNow())
+		}
+		if c.WebhookUrl != "" {
+			if err := webhook.Post(c.WebhookUrl, e); err != nil {
+				retryOutbox(row, err)
+				continue
+			}
+		}
+		model.Outbox{}.MarkSent(*row.Id, *utils.GetTimeNow())
+	}
+}
+
+// 指数退避,最长间隔一小时,超过event_outbox_max_attempts后放弃
+func retryOutbox(row model.Outbox, err error) {
+	now := *utils.GetTimeNow()
+	attempts := *row.Attempts + 1
+	log.Printf("events: outbox %d webhook attempt %d error:%s", *row.Id, attempts, err.Error())
+	if attempts >= config.GetConfig().EventBus.OutboxMaxAttempts {
+		sentry.CaptureError("outbox", err, map[string]string{"id": strconv.Itoa(*row.Id), "type": *row.EventType})
+		model.Outbox{}.MarkFailed(*row.Id, attempts, nil, now)
+		return
+	}
+	delay := min(time.Duration(1<<min(attempts, 12))*time.Second, time.Hour)
+	next := now + delay.Milliseconds()
+	model.Outbox{}.MarkFailed(*row.Id, attempts, &next, now)
+}
+
+func cleanOutbox() {
+	days := config.GetConfig().EventBus.OutboxRetentionDays
+	before := *utils.GetTimeNow() - int64(days)*24*time.Hour.Milliseconds()
+	if err := (model.Outbox{}).DeleteSentBefore(before); err != nil {
+		log.Printf("events: clean outbox error:%s", err.Error())
+	}
+}
@@ -0,0 +1,85 @@
+package flags
+
+import (
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+)
+
+// 已知的功能开关及默认值,表中没有配置时使用
+const (
+	// update_check返回差量包
+	DIFF_SERVING = "diff_serving"
+	// 设置了部署secret时update_check校验客户端传的secret
+	SIGNING_ENFORCEMENT = "signing_enforcement"
+	// 不在灰度范围内或灰度暂停时,客户端回退到上一个全量发布的包
+	AUTO_ROLLBACK = "auto_rollback"
+	// 声明camel_case能力的客户端使用新的update_check字段名
+	NEW_API_VERSION = "new_api_version"
+)
+
+var defaults = map[string]bool{
+	DIFF_SERVING:        true,
+	SIGNING_ENFORCEMENT: true,
+	AUTO_ROLLBACK:       true,
+	NEW_API_VERSION:     true,
+}
+
+const cacheTTL = 60 * time.Second
+
+type flagCache struct {
+	// name -> 租户级开关
+	Tenant map[string]bool
+	// name -> appId -> 应用级开关
+	Apps map[string]map[int]bool
+}
+
+func cacheKey() string {
+	return constants.REDIS_FEATURE_FLAG + config.GetConfig().TenantName
+}
+
+func load() *flagCache {
+	cache := redis.GetRedisObj[flagCache](cacheKey())
+	if cache != nil {
+		return cache
+	}
+	cache = &flagCache{Tenant: map[string]bool{}, Apps: map[string]map[int]bool{}}
+	flags := model.FeatureFlag{}.GetByTenant(config.GetConfig().TenantName)
+	if flags != nil {
+		for _, f := range *flags {
+			if f.AppId == nil {
+				cache.Tenant[*f.Name] = *f.Enabled
+				continue
+			}
+			if cache.Apps[*f.Name] == nil {
+				cache.Apps[*f.Name] = map[int]bool{}
+			}
+			cache.Apps[*f.Name][*f.AppId] = *f.Enabled
+		}
+	}
+	redis.SetRedisObj(cacheKey(), cache, cacheTTL)
+	return cache
+}
+
+// 优先级: 应用级 > 租户级 > 默认值
+func Enabled(name string, appId int) bool {
+	cache := load()
+	if enabled, ok := cache.Apps[name][appId]; ok {
+		return enabled
+	}
+	if enabled, ok := cache.Tenant[name]; ok {
+		return enabled
+	}
+	return defaults[name]
+}
+
+func Defaults() map[string]bool {
+	return defaults
+}
+
+func Invalidate() {
+	redis.DelRedisObj(cacheKey())
+}
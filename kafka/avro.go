@@ -0,0 +1,86 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+const (
+	SCHEMA_EVENT       = "event"
+	SCHEMA_ACQUISITION = "acquisition"
+)
+
+type avroField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type avroRecord struct {
+	fields []avroField
+	json   string
+}
+
+// 字段与events.Event和analytics.Record的json名一致,没有union,缺少的字段写默认值
+var schemas = map[string]avroRecord{
+	SCHEMA_EVENT: newAvroRecord("Event", []avroField{
+		{"type", "string"},
+		{"time", "long"},
+		{"uid", "int"},
+		{"appId", "int"},
+		{"deploymentId", "int"},
+		{"deploymentKey", "string"},
+		{"packageId", "int"},
+		{"label", "string"},
+		{"appVersion", "string"},
+		{"status", "string"},
+		{"rollout", "int"},
+		{"paused", "boolean"},
+	}),
+	SCHEMA_ACQUISITION: newAvroRecord("Acquisition", []avroField{
+		{"time", "long"},
+		{"event", "string"},
+		{"deployment_key", "string"},
+		{"app_version", "string"},
+		{"label", "string"},
+		{"package_hash", "string"},
+		{"status", "string"},
+		{"country", "string"},
+	}),
+}
+
+func newAvroRecord(name string, fields []avroField) avroRecord {
+	data, _ := json.Marshal(map[string]any{
+		"type":      "record",
+		"name":      name,
+		"namespace": "codepush",
+		"fields":    fields,
+	})
+	return avroRecord{fields: fields, json: string(data)}
+}
+
+var avroDefaults = map[string]any{
+	"string":  "",
+	"long":    0,
+	"int":     0,
+	"boolean": false,
+}
+
+// avro的json编码要求每个字段都存在
+func avroValue(schema string, v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]any{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&m); err != nil {
+		return nil, err
+	}
+	for _, f := range schemas[schema].fields {
+		if _, ok := m[f.Name]; !ok {
+			m[f.Name] = avroDefaults[f.Type]
+		}
+	}
+	return m, nil
+}
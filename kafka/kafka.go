@@ -0,0 +1,170 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"com.lc.go.codepush/server/analytics"
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/egress"
+	"com.lc.go.codepush/server/events"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/sentry"
+)
+
+// 通过Kafka REST Proxy(v2)写入,不需要直连broker
+const (
+	contentTypeJson = "application/vnd.kafka.json.v2+json"
+	contentTypeAvro = "application/vnd.kafka.avro.v2+json"
+)
+
+type message struct {
+	topic string
+	// avro schema的名字
+	schema string
+	key    string
+	value  any
+}
+
+// 两种记录配置为同一个topic时也分开写入
+type batchKey struct {
+	topic  string
+	schema string
+}
+
+var queue chan message
+
+var started atomic.Bool
+
+var client = egress.Client(10 * time.Second)
+
+func Enabled() bool {
+	return config.GetConfig().Kafka.RestUrl != ""
+}
+
+func Start() {
+	if !Enabled() {
+		return
+	}
+	c := config.GetConfig().Kafka
+	queue = make(chan message, c.QueueSize)
+	go producer()
+	started.Store(true)
+}
+
+// 事件总线kafka消费者的处理函数
+func Event(e events.Event) {
+	add(config.GetConfig().Kafka.EventTopic, SCHEMA_EVENT, e.DeploymentKey, e)
+}
+
+// 客户端的update_check、下载和安装记录
+func Acquisition(rec analytics.Record) {
+	if rec.Time == 0 {
+		rec.Time = time.Now().UnixMilli()
+	}
+	add(config.GetConfig().Kafka.AcquisitionTopic, SCHEMA_ACQUISITION, rec.DeploymentKey, rec)
+}
+
+// 只是导出,队列满时丢弃
+func add(topic string, schema string, key string, value any) {
+	if topic == "" || !started.Load() {
+		return
+	}
+	select {
+	case queue <- message{topic: topic, schema: schema, key: key, value: value}:
+	default:
+		metrics.Count("kafka.dropped", 1, map[string]string{"topic": topic})
+	}
+}
+
+func producer() {
+	c := config.GetConfig().Kafka
+	ticker := time.NewTicker(time.Duration(c.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+	batch := map[batchKey][]message{}
+	size := 0
+	for {
+		select {
+		case m := <-queue:
+			k := batchKey{m.topic, m.schema}
+			batch[k] = append(batch[k], m)
+			size++
+			if size < int(c.BatchSize) {
+				continue
+			}
+		case <-ticker.C:
+			if size == 0 {
+				continue
+			}
+		}
+		for k, messages := range batch {
+			flush(k.topic, messages)
+		}
+		batch = map[batchKey][]message{}
+		size = 0
+	}
+}
+
+func flush(topic string, messages []message) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("kafka: produce %s error:%v", topic, r)
+			sentry.CapturePanic("kafka", r, nil)
+		}
+	}()
+	start := time.Now()
+	if err := produce(topic, messages); err != nil {
+		log.Printf("kafka: produce %d records to %s error:%s", len(messages), topic, err.Error())
+		metrics.Count("kafka.errors", 1, map[string]string{"topic": topic})
+		return
+	}
+	metrics.Count("kafka.records", int64(len(messages)), map[string]string{"topic": topic})
+	metrics.Timing("kafka.produce", time.Since(start), map[string]string{"topic": topic})
+}
+
+func produce(topic string, messages []message) error {
+	avro := config.GetConfig().Kafka.Format == "avro"
+	records := make([]map[string]any, 0, len(messages))
+	for _, m := range messages {
+		value := m.value
+		if avro {
+			v, err := avroValue(m.schema, m.value)
+			if err != nil {
+				return err
+			}
+			value = v
+		}
+		records = append(records, map[string]any{"key": m.key, "value": value})
+	}
+	body := map[string]any{"records": records}
+	contentType := contentTypeJson
+	if avro {
+		contentType = contentTypeAvro
+		body["key_schema"] = `"string"`
+		body["value_schema"] = schemas[messages[0].schema].json
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, config.GetConfig().Kafka.RestUrl+"/topics/"+topic, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	rep, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+	if rep.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", rep.StatusCode)
+	}
+	return nil
+}
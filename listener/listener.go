@@ -0,0 +1,111 @@
+package listener
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"com.lc.go.codepush/server/config"
+)
+
+// 监听地址:
+//   - host:port 或 :port 为TCP
+//   - unix:/run/codepush.sock 为Unix socket,启动时删除残留的socket文件
+//   - systemd 或 systemd:<FileDescriptorName> 使用systemd socket activation传入的fd
+func Listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return listenUnix(strings.TrimPrefix(addr, "unix:"))
+	case addr == "systemd" || strings.HasPrefix(addr, "systemd:"):
+		return systemdListener(strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+// 是否为本机Unix socket上的连接,这时对端地址为空或@
+func IsUnixPeer(remoteAddr string) bool {
+	return remoteAddr == "" || remoteAddr == "@"
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("listen: unix socket path is empty")
+	}
+	// 只删除socket文件,避免配置错误时删掉普通文件
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("listen: " + path + " exists and is not a socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(config.GetConfig().ListenSocketMode)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+var (
+	systemdOnce  sync.Once
+	systemdFiles []*os.File
+	systemdNames []string
+)
+
+// sd_listen_fds: fd从3开始,LISTEN_PID必须是当前进程;读取后清除环境变量,子进程不会继承
+func loadSystemdFiles() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < count; i++ {
+		fd := 3 + i
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		systemdFiles = append(systemdFiles, os.NewFile(uintptr(fd), name))
+		systemdNames = append(systemdNames, name)
+	}
+}
+
+// name为空时使用第一个fd,否则按socket单元的FileDescriptorName匹配
+func systemdListener(name string) (net.Listener, error) {
+	systemdOnce.Do(loadSystemdFiles)
+	for i, f := range systemdFiles {
+		if f == nil || (name != "" && systemdNames[i] != name) {
+			continue
+		}
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		systemdFiles[i] = nil
+		return ln, nil
+	}
+	if name == "" {
+		return nil, errors.New("listen: no socket passed by systemd (LISTEN_FDS)")
+	}
+	return nil, errors.New("listen: no socket named " + name + " passed by systemd (LISTEN_FDS)")
+}
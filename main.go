@@ -1,11 +1,29 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
+	"com.lc.go.codepush/server/analytics"
+	"com.lc.go.codepush/server/anomaly"
+	"com.lc.go.codepush/server/command"
 	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/diff"
+	"com.lc.go.codepush/server/events"
+	"com.lc.go.codepush/server/kafka"
+	"com.lc.go.codepush/server/listener"
+	"com.lc.go.codepush/server/metrics"
 	"com.lc.go.codepush/server/middleware"
+	"com.lc.go.codepush/server/report"
 	"com.lc.go.codepush/server/request"
+	"com.lc.go.codepush/server/rollup"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/tlscert"
 
 	"github.com/gin-contrib/gzip"
 
@@ -13,30 +31,79 @@ import (
 )
 
 func main() {
-	fmt.Println("code-push-server-go V1.0.5")
+	if len(os.Args) > 1 {
+		command.Run(os.Args[1:])
+		return
+	}
 	// gin.SetMode(gin.ReleaseMode)
-	g := gin.Default()
-	g.Use(gzip.Gzip(gzip.DefaultCompression))
-	g.Use(middleware.Recover)
 	configs := config.GetConfig()
+	fmt.Println(request.GetVersionInfo().Banner())
+	g := newEngine()
+	// 设置management_addr时管理接口使用独立的engine和中间件,公开端口上只有客户端接口
+	mg := g
+	if configs.ManagementAddr != "" {
+		mg = newEngine()
+	}
+	sentry.Init()
+	metrics.Init()
+	request.StartBuildInfoMetric()
+	storage.Start()
+	diff.Start()
+	anomaly.Start()
+	rollup.Start()
+	analytics.Start()
+	request.StartEphemeralCleanup()
+	request.StartReleaseRetention()
+	request.StartReleaseJobs()
+	request.StartRolloutRamp()
+	request.StartPublicIdBackfill()
+	report.Start()
+	kafka.Start()
+	request.SubscribeEvents()
+	events.Start()
 
 	// g.Static("/bundels", "bundels")
 
-	g.GET("/ping", func(c *gin.Context) {
+	ping := func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "pong",
 		})
-	})
+	}
+	g.GET("/ping", ping)
+	g.GET("/version", request.Client{}.Version)
+	if mg != g {
+		mg.GET("/ping", ping)
+		mg.GET("/version", request.Client{}.Version)
+	}
+
+	if handler := metrics.Handler(); handler != nil {
+		mg.GET(configs.Metrics.PrometheusPath, gin.WrapH(handler))
+	}
 
-	g.GET("/v0.1/public/codepush/update_check", request.Client{}.CheckUpdate)
-	g.POST("/v0.1/public/codepush/report_status/deploy", request.Client{}.ReportStatus)
-	g.POST("/v0.1/public/codepush/report_status/download", request.Client{}.Download)
+	clientRoutes := func(r gin.IRoutes) {
+		r.GET("/v0.1/public/codepush/update_check", request.Client{}.CheckUpdate)
+		r.POST("/v0.1/public/codepush/update_check/batch", request.Client{}.BatchCheckUpdate)
+		r.POST("/v0.1/public/codepush/report_status/deploy", request.Client{}.ReportStatus)
+		r.POST("/v0.1/public/codepush/report_status/download", request.Client{}.Download)
+		r.POST("/v0.1/public/codepush/pin", request.Client{}.Pin)
+		r.GET("/v0.1/public/codepush/blob/*key", request.Client{}.DownloadBlob)
+		r.HEAD("/v0.1/public/codepush/blob/*key", request.Client{}.DownloadBlob)
+	}
+	clientRoutes(g)
+	// 部署在共享ingress的子路径下时SDK的serverUrl带前缀,例如 https://host/codepush
+	if strings.TrimRight(configs.UrlPrefix, "/") != "" {
+		clientGroup := g.Group(configs.UrlPrefix)
+		clientGroup.GET("/ping", ping)
+		clientGroup.GET("/version", request.Client{}.Version)
+		clientRoutes(clientGroup)
+	}
 
-	apiGroup := g.Group(configs.UrlPrefix)
+	apiGroup := mg.Group(configs.UrlPrefix)
 	{
 		apiGroup.POST("/login", request.User{}.Login)
+		apiGroup.POST("/bootstrap", request.User{}.Bootstrap)
 	}
-	authApi := apiGroup.Use(middleware.CheckToken)
+	authApi := apiGroup.Use(middleware.CheckToken, middleware.CheckTotp, middleware.CheckViewer)
 	{
 		authApi.POST("/createApp", request.App{}.CreateApp)
 		authApi.POST("/createDeployment", request.App{}.CreateDeployment)
@@ -46,10 +113,142 @@ func main() {
 		authApi.POST("/delDeployment", request.App{}.DelDeployment)
 		authApi.POST("/lsDeployment", request.App{}.LsDeployment)
 		authApi.GET("/lsApp", request.App{}.LsApp)
+		authApi.POST("/setAppMetadata", request.App{}.SetAppMetadata)
+		authApi.POST("/uploadAppIcon", request.App{}.UploadAppIcon)
 		authApi.POST("/uploadBundle", request.App{}.UploadBundle)
+		authApi.POST("/releaseBatch", request.App{}.ReleaseBatch)
+		authApi.POST("/releaseBothPlatforms", request.App{}.ReleaseBothPlatforms)
+		authApi.POST("/delBundle", request.App{}.DelBundle)
+		authApi.POST("/lsDeletedBundle", request.App{}.LsDeletedBundle)
+		authApi.POST("/purgeDeletedBundle", request.App{}.PurgeDeletedBundle)
+		authApi.GET("/uploadProgress", request.App{}.UploadProgress)
+		authApi.GET("/releaseStatus", request.App{}.ReleaseStatus)
+		authApi.GET("/lsMetricRollup", request.App{}.LsMetricRollup)
+		authApi.GET("/staleClients", request.App{}.GetStaleClients)
 		authApi.POST("/rollback", request.App{}.Rollback)
+		authApi.GET("/downloadPackage", request.App{}.DownloadPackage)
+		authApi.GET("/comparePackage", request.App{}.ComparePackage)
+		authApi.GET("/compareDeployments", request.App{}.CompareDeployments)
+		authApi.GET("/search", request.App{}.Search)
+		authApi.POST("/setDebugLog", request.App{}.SetDebugLog)
+		authApi.GET("/attestation", request.App{}.GetAttestation)
+		authApi.GET("/attestationKey", request.App{}.GetAttestationKey)
+		authApi.POST("/setRollout", request.App{}.SetRollout)
+		authApi.POST("/pauseRollout", request.App{}.PauseRollout)
+		authApi.POST("/resumeRollout", request.App{}.ResumeRollout)
+		authApi.POST("/setRolloutRamp", request.App{}.SetRolloutRamp)
+		authApi.GET("/lsRolloutHistory", request.App{}.LsRolloutHistory)
+		authApi.GET("/getServedRelease", request.App{}.GetServedRelease)
+		authApi.POST("/setBinaryVersion", request.App{}.SetBinaryVersion)
+		authApi.POST("/lsBinaryVersion", request.App{}.LsBinaryVersion)
+		authApi.POST("/delBinaryVersion", request.App{}.DelBinaryVersion)
+		authApi.POST("/setDeploymentApproval", request.App{}.SetDeploymentApproval)
+		authApi.POST("/setForceBinaryUpdate", request.App{}.SetForceBinaryUpdate)
+		authApi.POST("/lsPendingBundle", request.App{}.LsPendingBundle)
+		authApi.POST("/approveBundle", request.App{}.ApproveBundle)
+		authApi.POST("/rejectBundle", request.App{}.RejectBundle)
+		authApi.POST("/addDeploymentFreeze", request.App{}.AddDeploymentFreeze)
+		authApi.POST("/lsDeploymentFreeze", request.App{}.LsDeploymentFreeze)
+		authApi.POST("/delDeploymentFreeze", request.App{}.DelDeploymentFreeze)
+		authApi.POST("/addClientRule", request.App{}.AddClientRule)
+		authApi.POST("/lsClientRule", request.App{}.LsClientRule)
+		authApi.POST("/delClientRule", request.App{}.DelClientRule)
+		authApi.POST("/setBuildPin", request.App{}.SetBuildPin)
+		authApi.POST("/lsBuildPin", request.App{}.LsBuildPin)
+		authApi.POST("/delBuildPin", request.App{}.DelBuildPin)
+		authApi.POST("/createPinLink", request.App{}.CreatePinLink)
+		authApi.POST("/createEphemeralDeployment", request.App{}.CreateEphemeralDeployment)
+		authApi.PATCH("/app", request.App{}.RenameApp)
+		authApi.PATCH("/deployment", request.App{}.RenameDeployment)
+		authApi.POST("/setDeploymentPolicy", request.App{}.SetDeploymentPolicy)
+		authApi.GET("/deploymentPolicy", request.App{}.GetDeploymentPolicy)
+		authApi.POST("/setLabelFormat", request.App{}.SetLabelFormat)
+		authApi.POST("/publishPrivateBundle", request.App{}.PublishPrivateBundle)
+		authApi.POST("/createInviteToken", request.App{}.CreateInviteToken)
+		authApi.POST("/lsInviteToken", request.App{}.LsInviteToken)
+		authApi.POST("/delInviteToken", request.App{}.DelInviteToken)
+		authApi.POST("/setDeploymentSecret", request.App{}.SetDeploymentSecret)
 		authApi.POST("/changePassword", request.User{}.ChangePassword)
+		authApi.POST("/enrollTotp", request.User{}.EnrollTotp)
+		authApi.POST("/activateTotp", request.User{}.ActivateTotp)
+		authApi.POST("/disableTotp", request.User{}.DisableTotp)
+		authApi.POST("/auth/introspect", request.User{}.Introspect)
+	}
+	adminApi := apiGroup.Group("/admin", middleware.CheckAdmin)
+	{
+		adminApi.POST("/lsCache", request.Admin{}.LsCache)
+		adminApi.POST("/delCache", request.Admin{}.DelCache)
+		adminApi.POST("/cache/rebuild", request.Admin{}.RebuildCache)
+		adminApi.GET("/cache/rebuild", request.Admin{}.RebuildCacheStatus)
+		adminApi.GET("/metricDrift", request.Admin{}.GetMetricDrift)
+		adminApi.GET("/diffStats", request.Admin{}.DiffStats)
+		adminApi.POST("/reconcileMetrics", request.Admin{}.ReconcileMetrics)
+		adminApi.GET("/lsFeatureFlag", request.Admin{}.LsFeatureFlag)
+		adminApi.POST("/setFeatureFlag", request.Admin{}.SetFeatureFlag)
+		adminApi.POST("/delFeatureFlag", request.Admin{}.DelFeatureFlag)
+		adminApi.GET("/lsCustomDomain", request.Admin{}.LsCustomDomain)
+		adminApi.POST("/addCustomDomain", request.Admin{}.AddCustomDomain)
+		adminApi.POST("/delCustomDomain", request.Admin{}.DelCustomDomain)
+		adminApi.GET("/lsTenant", request.Admin{}.LsTenant)
+		adminApi.POST("/provisionTenant", request.Admin{}.ProvisionTenant)
+		adminApi.POST("/setTenantPlan", request.Admin{}.SetTenantPlan)
+		adminApi.POST("/impersonate", request.Admin{}.Impersonate)
+		adminApi.POST("/endImpersonation", request.Admin{}.EndImpersonation)
+		adminApi.GET("/lsImpersonation", request.Admin{}.LsImpersonation)
 	}
 
-	g.Run(configs.Port)
+	if mg != g {
+		management := newServer(configs.ManagementAddr, mg)
+		go serve(management)
+	}
+	server := newServer(configs.Port, g)
+	if tlscert.Enabled() {
+		stopped := tlscert.ListenAndServe(server)
+		server.Handler = tlscert.HTTPHandler(g)
+		serve(server)
+		// https监听出错后http端口已经关闭
+		<-stopped
+		os.Exit(1)
+	}
+	serve(server)
+}
+
+// 地址可以是TCP、unix:/path或systemd传入的socket
+func serve(server *http.Server) {
+	ln, err := listener.Listen(server.Addr)
+	if err != nil {
+		panic(err)
+	}
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		panic(err)
+	}
+}
+
+func newEngine() *gin.Engine {
+	configs := config.GetConfig()
+	g := gin.Default()
+	g.SetTrustedProxies(configs.TrustedProxies)
+	g.Use(middleware.RealIP())
+	g.Use(middleware.AccessLog())
+	g.Use(middleware.Metrics())
+	// 下载代理返回原始长度,HEAD预检需要正确的Content-Length
+	g.Use(gzip.Gzip(configs.Http.GzipLevel, gzip.WithExcludedPathsRegexs([]string{"/v0\\.1/public/codepush/blob/"})))
+	g.Use(middleware.Recover)
+	g.Use(middleware.BodyLimit())
+	return g
+}
+
+func newServer(addr string, handler http.Handler) *http.Server {
+	configs := config.GetConfig()
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		IdleTimeout:       time.Duration(configs.Http.IdleTimeout) * time.Second,
+		ReadTimeout:       time.Duration(configs.Http.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(configs.Http.ReadHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(configs.Http.WriteTimeout) * time.Second,
+		MaxHeaderBytes:    configs.Http.MaxHeaderBytes,
+	}
+	server.SetKeepAlivesEnabled(configs.Http.KeepAlive)
+	return server
 }
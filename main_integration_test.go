@@ -0,0 +1,48 @@
+//go:build integration
+
+// 需要mysql、redis和minio: go run -tags integration ./testenv go test -tags integration -p 1 ./...
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"com.lc.go.codepush/server/command"
+	"com.lc.go.codepush/server/config"
+)
+
+// 在测试进程中启动服务,用e2e命令跑一遍发布 -> update_check -> report_status -> 回滚
+func TestReleaseLifecycle(t *testing.T) {
+	configs := config.GetConfig()
+	if configs.BootstrapToken == "" {
+		t.Fatal("bootstrap_token is required")
+	}
+	// 有参数时main会当作子命令执行
+	os.Args = os.Args[:1]
+	go main()
+
+	baseUrl := "http://" + configs.Port
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		resp, err := http.Get(baseUrl + "/ping")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server not ready")
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	prefix := configs.UrlPrefix
+	if prefix == "/" {
+		prefix = ""
+	}
+	if err := command.E2e([]string{"-url", baseUrl, "-prefix", prefix, "-bootstrap-token", configs.BootstrapToken}); err != nil {
+		t.Fatal(err)
+	}
+}
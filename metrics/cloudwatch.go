@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+func init() {
+	Register("cloudwatch", newCloudWatchSink)
+}
+
+const cloudWatchFlushInterval = time.Minute
+
+// EMF一个指标最多100个值
+const cloudWatchMaxValues = 100
+
+// CloudWatch embedded metric format: 按分钟聚合后写成JSON日志,由CloudWatch agent或Lambda/ECS日志驱动采集
+// 不需要调用PutMetricData,也不需要额外的IAM权限
+type cloudWatchPoint struct {
+	name   string
+	unit   string
+	tags   map[string]string
+	values []float64
+	gauge  bool
+}
+
+type cloudWatchSink struct {
+	mu        sync.Mutex
+	out       io.Writer
+	namespace string
+	global    map[string]string
+	points    map[string]*cloudWatchPoint
+}
+
+func newCloudWatchSink() (Sink, error) {
+	c := config.GetConfig().Metrics
+	var out io.Writer = os.Stdout
+	if c.CloudWatchOutput != "" && c.CloudWatchOutput != "stdout" {
+		out = &lumberjack.Logger{Filename: c.CloudWatchOutput, MaxSize: 100, MaxBackups: 3}
+	}
+	s := &cloudWatchSink{
+		out:       out,
+		namespace: c.CloudWatchNamespace,
+		global:    globalTags(),
+		points:    map[string]*cloudWatchPoint{},
+	}
+	go func() {
+		for range time.Tick(cloudWatchFlushInterval) {
+			s.flush()
+		}
+	}()
+	return s, nil
+}
+
+func pointKey(name string, tags map[string]string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range labelNames(tags) {
+		b.WriteString("|" + k + "=" + tags[k])
+	}
+	return b.String()
+}
+
+func (s *cloudWatchSink) add(name string, unit string, value float64, tags map[string]string, gauge bool) {
+	key := pointKey(name, tags)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.points[key]
+	if !ok {
+		p = &cloudWatchPoint{name: name, unit: unit, tags: tags, gauge: gauge}
+		s.points[key] = p
+	}
+	switch {
+	case gauge:
+		p.values = []float64{value}
+	case unit == "Count":
+		if len(p.values) == 0 {
+			p.values = []float64{0}
+		}
+		p.values[0] += value
+	case len(p.values) < cloudWatchMaxValues:
+		p.values = append(p.values, value)
+	}
+}
+
+func (s *cloudWatchSink) Count(name string, value int64, tags map[string]string) {
+	s.add(name, "Count", float64(value), tags, false)
+}
+
+func (s *cloudWatchSink) Timing(name string, d time.Duration, tags map[string]string) {
+	s.add(name, "Milliseconds", float64(d.Microseconds())/1000, tags, false)
+}
+
+func (s *cloudWatchSink) Gauge(name string, value float64, tags map[string]string) {
+	s.add(name, "None", value, tags, true)
+}
+
+func (s *cloudWatchSink) flush() {
+	s.mu.Lock()
+	points := s.points
+	s.points = map[string]*cloudWatchPoint{}
+	s.mu.Unlock()
+	timestamp := time.Now().UnixMilli()
+	for _, p := range points {
+		dimensions := map[string]string{}
+		for k, v := range s.global {
+			dimensions[k] = v
+		}
+		for k, v := range p.tags {
+			dimensions[k] = v
+		}
+		names := labelNames(dimensions)
+		entry := map[string]any{
+			"_aws": map[string]any{
+				"Timestamp": timestamp,
+				"CloudWatchMetrics": []map[string]any{{
+					"Namespace":  s.namespace,
+					"Dimensions": [][]string{names},
+					"Metrics":    []map[string]string{{"Name": p.name, "Unit": p.unit}},
+				}},
+			},
+		}
+		for k, v := range dimensions {
+			entry[k] = v
+		}
+		if len(p.values) == 1 {
+			entry[p.name] = p.values[0]
+		} else {
+			entry[p.name] = p.values
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("metrics: cloudwatch error:%s", err.Error())
+			continue
+		}
+		s.out.Write(append(line, '\n'))
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"github.com/DataDog/datadog-go/v5/statsd"
+)
+
+func init() {
+	Register("datadog", newDatadogSink)
+}
+
+// 通过UDP发送到本机或sidecar的DogStatsD agent
+type datadogSink struct {
+	client *statsd.Client
+}
+
+func newDatadogSink() (Sink, error) {
+	c := config.GetConfig().Metrics
+	var global []string
+	for k, v := range globalTags() {
+		global = append(global, k+":"+v)
+	}
+	client, err := statsd.New(c.DatadogAddr, statsd.WithNamespace(c.DatadogNamespace), statsd.WithTags(global))
+	if err != nil {
+		return nil, err
+	}
+	return &datadogSink{client: client}, nil
+}
+
+func datadogTags(tags map[string]string) []string {
+	list := make([]string, 0, len(tags))
+	for k, v := range tags {
+		list = append(list, k+":"+v)
+	}
+	return list
+}
+
+func (s *datadogSink) Count(name string, value int64, tags map[string]string) {
+	s.client.Count(name, value, datadogTags(tags), 1)
+}
+
+func (s *datadogSink) Timing(name string, d time.Duration, tags map[string]string) {
+	s.client.Timing(name, d, datadogTags(tags), 1)
+}
+
+func (s *datadogSink) Gauge(name string, value float64, tags map[string]string) {
+	s.client.Gauge(name, value, datadogTags(tags), 1)
+}
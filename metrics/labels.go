@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"sync"
+
+	"com.lc.go.codepush/server/config"
+)
+
+const (
+	appLabelOther   = "other"
+	appLabelUnknown = "unknown"
+)
+
+var appLabels = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: map[string]bool{}}
+
+// app标签的值,限制时间序列数量:
+// 配置了allowlist时只有其中的应用单独统计,否则前metrics_max_app_labels个应用单独统计,其余归入other
+func AppLabel(app string) string {
+	if app == "" {
+		return appLabelUnknown
+	}
+	c := config.GetConfig().Metrics
+	if len(c.AppLabelAllowlist) > 0 {
+		for _, allowed := range c.AppLabelAllowlist {
+			if allowed == app {
+				return app
+			}
+		}
+		return appLabelOther
+	}
+	appLabels.Lock()
+	defer appLabels.Unlock()
+	if appLabels.seen[app] {
+		return app
+	}
+	if uint(len(appLabels.seen)) >= c.MaxAppLabels {
+		return appLabelOther
+	}
+	appLabels.seen[app] = true
+	return app
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+)
+
+// 指标输出,自定义监控系统可以实现该接口并在init中Register
+// name使用点分隔,例如 update_check, http.request_duration,各实现自行转换命名
+type Sink interface {
+	Count(name string, value int64, tags map[string]string)
+	Timing(name string, d time.Duration, tags map[string]string)
+	Gauge(name string, value float64, tags map[string]string)
+}
+
+// 需要暴露HTTP接口的Sink,例如prometheus
+type HandlerSink interface {
+	Handler() http.Handler
+}
+
+type Factory func() (Sink, error)
+
+var (
+	factories = map[string]Factory{}
+	lock      sync.RWMutex
+	sinks     []Sink
+)
+
+func Register(name string, factory Factory) {
+	lock.Lock()
+	defer lock.Unlock()
+	factories[name] = factory
+}
+
+// 按metrics_sinks配置创建,创建失败的Sink只打印日志
+func Init() {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, name := range config.GetConfig().Metrics.Sinks {
+		factory := factories[name]
+		if factory == nil {
+			log.Printf("metrics: unknown sink %s", name)
+			continue
+		}
+		sink, err := factory()
+		if err != nil {
+			log.Printf("metrics: sink %s error:%s", name, err.Error())
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+}
+
+// 没有启用需要HTTP接口的Sink时返回nil
+func Handler() http.Handler {
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, sink := range sinks {
+		if h, ok := sink.(HandlerSink); ok {
+			return h.Handler()
+		}
+	}
+	return nil
+}
+
+func Count(name string, value int64, tags map[string]string) {
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, sink := range sinks {
+		sink.Count(name, value, tags)
+	}
+}
+
+func Timing(name string, d time.Duration, tags map[string]string) {
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, sink := range sinks {
+		sink.Timing(name, d, tags)
+	}
+}
+
+func Gauge(name string, value float64, tags map[string]string) {
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, sink := range sinks {
+		sink.Gauge(name, value, tags)
+	}
+}
+
+// 所有Sink共用的全局标签
+func globalTags() map[string]string {
+	c := config.GetConfig()
+	tags := map[string]string{"tenant": c.TenantName, "environment": c.Environment}
+	if c.Region != "" {
+		tags["region"] = c.Region
+	}
+	return tags
+}
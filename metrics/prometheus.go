@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func init() {
+	Register("prometheus", newPrometheusSink)
+}
+
+// 第一次使用时按标签名创建指标,之后同名指标缺少的标签填空字符串,多出的标签忽略
+type promMetric struct {
+	labels    []string
+	counter   *prometheus.CounterVec
+	histogram *prometheus.HistogramVec
+	gauge     *prometheus.GaugeVec
+}
+
+type prometheusSink struct {
+	mu       sync.Mutex
+	registry *prometheus.Registry
+	// 所有指标带上tenant/environment/region标签,多租户共用一个Prometheus时区分来源
+	registerer prometheus.Registerer
+	metrics    map[string]*promMetric
+}
+
+func newPrometheusSink() (Sink, error) {
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(globalTags(), registry)
+	registerer.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return &prometheusSink{registry: registry, registerer: registerer, metrics: map[string]*promMetric{}}, nil
+}
+
+func (s *prometheusSink) Handler() http.Handler {
+	return promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{})
+}
+
+// update_check -> codepush_update_check_total
+func promName(name string, suffix string) string {
+	return "codepush_" + strings.NewReplacer(".", "_", "-", "_").Replace(name) + suffix
+}
+
+func labelNames(tags map[string]string) []string {
+	names := make([]string, 0, len(tags))
+	for k := range tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *promMetric) values(tags map[string]string) []string {
+	values := make([]string, len(m.labels))
+	for i, label := range m.labels {
+		values[i] = tags[label]
+	}
+	return values
+}
+
+func (s *prometheusSink) get(name string, tags map[string]string, create func(labels []string) *promMetric) *promMetric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.metrics[name]
+	if !ok {
+		m = create(labelNames(tags))
+		s.metrics[name] = m
+	}
+	return m
+}
+
+func (s *prometheusSink) Count(name string, value int64, tags map[string]string) {
+	m := s.get(name, tags, func(labels []string) *promMetric {
+		counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: promName(name, "_total")}, labels)
+		s.registerer.MustRegister(counter)
+		return &promMetric{labels: labels, counter: counter}
+	})
+	if m.counter != nil {
+		m.counter.WithLabelValues(m.values(tags)...).Add(float64(value))
+	}
+}
+
+func (s *prometheusSink) Timing(name string, d time.Duration, tags map[string]string) {
+	m := s.get(name, tags, func(labels []string) *promMetric {
+		histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: promName(name, "_seconds"), Buckets: prometheus.DefBuckets}, labels)
+		s.registerer.MustRegister(histogram)
+		return &promMetric{labels: labels, histogram: histogram}
+	})
+	if m.histogram != nil {
+		m.histogram.WithLabelValues(m.values(tags)...).Observe(d.Seconds())
+	}
+}
+
+func (s *prometheusSink) Gauge(name string, value float64, tags map[string]string) {
+	m := s.get(name, tags, func(labels []string) *promMetric {
+		gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: promName(name, "")}, labels)
+		s.registerer.MustRegister(gauge)
+		return &promMetric{labels: labels, gauge: gauge}
+	})
+	if m.gauge != nil {
+		m.gauge.WithLabelValues(m.values(tags)...).Set(value)
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+type accessLogEntry struct {
+	Time              string  `json:"time"`
+	Method            string  `json:"method"`
+	Path              string  `json:"path"`
+	Status            int     `json:"status"`
+	LatencyMs         float64 `json:"latency_ms"`
+	Bytes             int     `json:"bytes"`
+	ClientIp          string  `json:"client_ip,omitempty"`
+	UserAgent         string  `json:"user_agent,omitempty"`
+	DeploymentKeyHash string  `json:"deployment_key_hash,omitempty"`
+}
+
+var accessLogMu sync.Mutex
+
+// 訪問日誌: 與應用日誌分開輸出,支持採樣和字段脫敏,5xx總是記錄
+func AccessLog() gin.HandlerFunc {
+	c := config.GetConfig().AccessLog
+	var out io.Writer
+	switch c.Output {
+	case "":
+		return func(ctx *gin.Context) {}
+	case "stdout":
+		out = os.Stdout
+	default:
+		out = &lumberjack.Logger{
+			Filename:   c.Output,
+			MaxSize:    c.MaxSizeMB,
+			MaxBackups: c.MaxBackups,
+			MaxAge:     c.MaxAgeDays,
+		}
+	}
+	redact := make(map[string]bool)
+	for _, field := range c.Redact {
+		redact[field] = true
+	}
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		status := ctx.Writer.Status()
+		if status < 500 && c.SampleRate < 1 && rand.Float64() >= c.SampleRate {
+			return
+		}
+		// 使用路由模板,避免把路徑參數寫入日誌
+		path := ctx.FullPath()
+		if path == "" {
+			path = "unmatched"
+		}
+		entry := accessLogEntry{
+			Time:      start.Format(time.RFC3339),
+			Method:    ctx.Request.Method,
+			Path:      path,
+			Status:    status,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			Bytes:     ctx.Writer.Size(),
+		}
+		if !redact["client_ip"] {
+			entry.ClientIp = ctx.GetString(constants.GIN_CLIENT_IP)
+		}
+		if !redact["user_agent"] {
+			entry.UserAgent = ctx.Request.UserAgent()
+		}
+		if key := ctx.GetString(constants.GIN_DEPLOYMENT_KEY); key != "" && !redact["deployment_key_hash"] {
+			entry.DeploymentKeyHash = utils.Sha256Hex(key)[:16]
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("access log error:%s", err.Error())
+			return
+		}
+		accessLogMu.Lock()
+		out.Write(append(line, '\n'))
+		accessLogMu.Unlock()
+	}
+}
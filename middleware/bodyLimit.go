@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+)
+
+// 限制請求體大小,路由未單獨配置時使用http_max_body_mb,0表示不限制
+func BodyLimit() gin.HandlerFunc {
+	httpConfig := config.GetConfig().Http
+	prefix := strings.TrimRight(config.GetConfig().UrlPrefix, "/")
+	return func(ctx *gin.Context) {
+		limitMB := httpConfig.MaxBodyMB
+		if routeMB, ok := httpConfig.RouteMaxBodyMB[strings.TrimPrefix(ctx.FullPath(), prefix)]; ok {
+			limitMB = routeMB
+		}
+		if limitMB <= 0 || ctx.Request.Body == nil {
+			return
+		}
+		limit := limitMB * 1024 * 1024
+		if ctx.Request.ContentLength > limit {
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"code":    constants.ERR_BODY_TOO_LARGE,
+				"error":   constants.ErrName(constants.ERR_BODY_TOO_LARGE),
+				"msg":     "Request body too large",
+				"success": false,
+			})
+			ctx.Abort()
+			return
+		}
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+	}
+}
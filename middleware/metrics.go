@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// 請求數與耗時,按路由模板和狀態碼分組
+func Metrics() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		tags := map[string]string{
+			"method": ctx.Request.Method,
+			"route":  route,
+			"status": strconv.Itoa(ctx.Writer.Status()),
+		}
+		metrics.Count("http.requests", 1, tags)
+		metrics.Timing("http.request_duration", time.Since(start), tags)
+	}
+}
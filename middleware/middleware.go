@@ -1,14 +1,18 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"reflect"
+	"strings"
 
+	"com.lc.go.codepush/server/auth"
+	"com.lc.go.codepush/server/config"
 	"com.lc.go.codepush/server/model"
 	"com.lc.go.codepush/server/model/constants"
-	"com.lc.go.codepush/server/utils"
+	"com.lc.go.codepush/server/sentry"
 	"github.com/gin-gonic/gin"
 )
 
@@ -18,30 +22,117 @@ func CheckToken(ctx *gin.Context) {
 	if token == "" {
 		token = ctx.GetHeader("token")
 	}
+	if token == "" {
+		token = strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	}
 
 	if token == "" {
 		log.Panic("Token can't null")
 	}
 
-	tokenNow := model.GetOne[model.Token]("token=?", token)
-
-	if *utils.GetTimeNow() > *tokenNow.ExpireTime || *tokenNow.Del {
+	principal, err := auth.ValidateToken(ctx.Request.Context(), token)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidToken) {
+			log.Printf("auth: validate token error:%s", err.Error())
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"code": 1100,
-			"msg":  "Token expire",
+			"code":  constants.ERR_TOKEN_EXPIRED,
+			"error": constants.ErrName(constants.ERR_TOKEN_EXPIRED),
+			"msg":   "Token expire",
 		})
 		ctx.Abort()
-	} else {
-		if (tokenNow != nil && tokenNow.Del != nil && *tokenNow.Del) || tokenNow == nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"code": 1100,
-				"msg":  "Token expire",
-			})
-			ctx.Abort()
+		return
+	}
+
+	// 管理員代入其他用戶,會話無效時拒絕而不是以管理員身份繼續
+	if sessionId := ctx.GetHeader("X-Impersonation-Session"); sessionId != "" {
+		impersonated, session := auth.Impersonate(principal, sessionId)
+		if impersonated == nil {
+			denied(ctx, "Impersonation session is invalid or expired")
+			return
+		}
+		if impersonationBlocked(ctx.FullPath()) {
+			denied(ctx, "Not allowed while impersonating")
+			return
+		}
+		principal = impersonated
+		ctx.Set(constants.GIN_IMPERSONATOR, session.AdminUid)
+		ctx.Header("X-Impersonated-User", session.UserName)
+		ctx.Header("X-Impersonator", session.AdminName)
+	}
+
+	ctx.Set(constants.GIN_USER_ID, principal.Uid)
+	ctx.Set(constants.GIN_PRINCIPAL, principal)
+}
+
+// 代入時不能修改被代入用戶的憑證
+func impersonationBlocked(path string) bool {
+	for _, suffix := range []string{"/changePassword", "/enrollTotp", "/activateTotp", "/disableTotp"} {
+		if strings.HasSuffix(path, suffix) {
+			return true
 		}
 	}
+	return false
+}
 
-	ctx.Set(constants.GIN_USER_ID, *tokenNow.Uid)
+func denied(ctx *gin.Context, msg string) {
+	ctx.JSON(http.StatusForbidden, gin.H{
+		"code":  constants.ERR_PERMISSION_DENIED,
+		"error": constants.ErrName(constants.ERR_PERMISSION_DENIED),
+		"msg":   msg,
+	})
+	ctx.Abort()
+}
+
+// 套餐(或全局)要求兩步驗證時,未綁定的本地賬號只能訪問綁定接口
+func CheckTotp(ctx *gin.Context) {
+	// 代入時管理員已經通過自己的驗證
+	if ctx.GetInt(constants.GIN_IMPERSONATOR) != 0 {
+		return
+	}
+	path := ctx.FullPath()
+	if strings.HasSuffix(path, "/enrollTotp") || strings.HasSuffix(path, "/activateTotp") {
+		return
+	}
+	user := model.GetOne[model.User]("id=?", ctx.MustGet(constants.GIN_USER_ID).(int))
+	if user == nil || user.Password == nil || (user.TotpEnabled != nil && *user.TotpEnabled) {
+		return
+	}
+	if (model.TenantPlan{}).RequiresTotp(*user.Id, config.GetConfig().TotpRequired) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"code":  constants.ERR_TOTP_REQUIRED,
+			"error": constants.ErrName(constants.ERR_TOTP_REQUIRED),
+			"msg":   "Two-factor enrollment required",
+		})
+		ctx.Abort()
+	}
+}
+
+// viewer只能查詢,以及修改自己的密碼和兩步驗證
+func CheckViewer(ctx *gin.Context) {
+	principal, ok := ctx.MustGet(constants.GIN_PRINCIPAL).(*auth.Principal)
+	if !ok || principal.Role != constants.ROLE_VIEWER || ctx.Request.Method == http.MethodGet {
+		return
+	}
+	path := ctx.FullPath()
+	name := path[strings.LastIndex(path, "/")+1:]
+	if strings.HasPrefix(name, "ls") || name == "checkBundle" || impersonationBlocked(path) {
+		return
+	}
+	denied(ctx, "Viewers have read-only access")
+}
+
+// 僅管理員可訪問
+func CheckAdmin(ctx *gin.Context) {
+	principal, ok := ctx.MustGet(constants.GIN_PRINCIPAL).(*auth.Principal)
+	if !ok || principal.Role != constants.ROLE_ADMIN {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"code":  constants.ERR_PERMISSION_DENIED,
+			"error": constants.ErrName(constants.ERR_PERMISSION_DENIED),
+			"msg":   "Permission denied",
+		})
+		ctx.Abort()
+	}
 }
 
 // 異常處理
@@ -49,6 +140,8 @@ func Recover(c *gin.Context) {
 	c.Writer.Header().Add("Access-Control-Allow-Origin", "*")
 	c.Writer.Header().Add("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
 	c.Writer.Header().Add("Access-Control-Allow-Headers", "*")
+	// 管理頁面讀取ETag做If-Match
+	c.Writer.Header().Add("Access-Control-Expose-Headers", "ETag")
 	lang := c.GetHeader("Accept-Language")
 	c.Set(constants.GIN_LANG, lang)
 	// 加载defer异常处理
@@ -56,6 +149,29 @@ func Recover(c *gin.Context) {
 		if err := recover(); err != nil {
 			c.Writer.WriteHeader(http.StatusInternalServerError)
 			log.Printf("Error:%s", err)
+			// 帶錯誤碼的異常
+			if e, ok := err.(constants.ErrObj); ok {
+				status := e.Status
+				if status == 0 {
+					status = http.StatusInternalServerError
+				}
+				if status >= http.StatusInternalServerError {
+					sentry.CapturePanic("http", err, requestTags(c))
+				}
+				res := gin.H{
+					"code":    e.Code,
+					"error":   constants.ErrName(e.Code),
+					"msg":     e.Msg,
+					"success": false,
+				}
+				if len(e.Errors) > 0 {
+					res["errors"] = e.Errors
+				}
+				c.JSON(status, res)
+				c.Abort()
+				return
+			}
+			sentry.CapturePanic("http", err, requestTags(c))
 			// 返回统一的Json风格
 			var msgStr string
 			if fmt.Sprint(reflect.TypeOf(err)) == "string" {
@@ -64,7 +180,8 @@ func Recover(c *gin.Context) {
 				msgStr = "system error"
 			}
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"code":    500,
+				"code":    constants.ERR_INTERNAL,
+				"error":   constants.ErrName(constants.ERR_INTERNAL),
 				"msg":     msgStr,
 				"success": false,
 			})
@@ -80,3 +197,10 @@ func Recover(c *gin.Context) {
 	//继续操作
 	c.Next()
 }
+
+func requestTags(c *gin.Context) map[string]string {
+	return map[string]string{
+		"method": c.Request.Method,
+		"route":  c.FullPath(),
+	}
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/listener"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+)
+
+// 解析真實客戶端IP: 只有直連地址在trusted_proxies中時才讀取轉發頭,
+// 從右往左跳過可信代理,第一個不可信的地址即為客戶端
+func RealIP() gin.HandlerFunc {
+	trusted := parseCIDRs(config.GetConfig().TrustedProxies)
+	return func(ctx *gin.Context) {
+		ctx.Set(constants.GIN_CLIENT_IP, realIP(ctx.Request, trusted))
+	}
+}
+
+func parseCIDRs(items []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			if strings.Contains(item, ":") {
+				item += "/128"
+			} else {
+				item += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			log.Printf("trusted_proxies: invalid %s", item)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func realIP(r *http.Request, trusted []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	remoteIP := net.ParseIP(remote)
+	// Unix socket上只有本機的反向代理能連入,視為可信代理
+	unixPeer := listener.IsUnixPeer(r.RemoteAddr)
+	if !unixPeer && (remoteIP == nil || !isTrusted(remoteIP, trusted)) {
+		return remote
+	}
+	chain := forwardedChain(r.Header)
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			break
+		}
+		client = chain[i]
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return client
+}
+
+// 優先使用RFC 7239 Forwarded,其次X-Forwarded-For,最後X-Real-IP
+func forwardedChain(header http.Header) []string {
+	var chain []string
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					chain = append(chain, cleanHost(v))
+				}
+			}
+		}
+		return chain
+	}
+	if values := header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, item := range strings.Split(strings.Join(values, ","), ",") {
+			chain = append(chain, cleanHost(item))
+		}
+		return chain
+	}
+	if realIp := header.Get("X-Real-IP"); realIp != "" {
+		chain = append(chain, cleanHost(realIp))
+	}
+	return chain
+}
+
+// 去掉引號、IPv6的方括號和端口
+func cleanHost(v string) string {
+	v = strings.Trim(strings.TrimSpace(v), `"`)
+	if strings.HasPrefix(v, "[") {
+		if end := strings.Index(v, "]"); end > 0 {
+			return v[1:end]
+		}
+	}
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		return host
+	}
+	return v
+}
@@ -1,11 +1,20 @@
 package model
 
 type App struct {
-	Id         *int    `gorm:"primarykey;autoIncrement;size:32"`
+	Id         *int    `gorm:"primarykey;autoIncrement;size:64"`
+	PublicId   *string `json:"-"`
 	Uid        *int    `json:"uid"`
 	AppName    *string `json:"appName"`
 	OS         *int    `json:"os"`
 	CreateTime *int64  `json:"createTime"`
+	// 展示信息,图标保存在存储中
+	DisplayName  *string `json:"displayName"`
+	Platform     *string `json:"platform"`
+	Icon         *string `json:"icon"`
+	AppStoreUrl  *string `json:"appStoreUrl"`
+	PlayStoreUrl *string `json:"playStoreUrl"`
+	// 乐观锁版本,只由BumpRowVersion修改,作为ETag返回
+	RowVersion *int `gorm:"default:0;<-:create" json:"rowVersion"`
 }
 
 func (App) GetAppByUidAndAppName(uid int, appName string) *App {
@@ -16,3 +25,7 @@ func (App) GetAppByUidAndAppName(uid int, appName string) *App {
 	}
 	return app
 }
+
+func (App) UpdateName(id int, appName string) {
+	userDb.Raw("update app set app_name=? where id=?", appName, id).Scan(&App{})
+}
@@ -0,0 +1,46 @@
+package model
+
+import "com.lc.go.codepush/server/utils"
+
+type AuditLog struct {
+	Id         *int    `gorm:"primarykey;autoIncrement;size:32"`
+	Uid        *int    `json:"uid"`
+	Action     *string `json:"action"`
+	Target     *string `json:"target"`
+	Detail     *string `json:"detail"`
+	ClientIp   *string `json:"clientIp"`
+	CreateTime *int64  `json:"createTime"`
+	// 管理员代入uid操作时为管理员的uid
+	ImpersonatorUid *int `json:"impersonatorUid"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_log"
+}
+
+func AddAuditLog(uid int, action string, target string, detail string) {
+	AddAuditLogFrom("", uid, action, target, detail)
+}
+
+// clientIp为空表示后台任务或命令行
+func AddAuditLogFrom(clientIp string, uid int, action string, target string, detail string) {
+	AddImpersonatedAuditLog(clientIp, uid, 0, action, target, detail)
+}
+
+// impersonatorUid为0表示用户本人操作
+func AddImpersonatedAuditLog(clientIp string, uid int, impersonatorUid int, action string, target string, detail string) {
+	auditLog := AuditLog{
+		Uid:        &uid,
+		Action:     &action,
+		Target:     &target,
+		Detail:     &detail,
+		CreateTime: utils.GetTimeNow(),
+	}
+	if clientIp != "" {
+		auditLog.ClientIp = &clientIp
+	}
+	if impersonatorUid != 0 {
+		auditLog.ImpersonatorUid = &impersonatorUid
+	}
+	Create[AuditLog](&auditLog)
+}
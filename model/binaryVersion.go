@@ -0,0 +1,33 @@
+package model
+
+type BinaryVersion struct {
+	Id          *int    `gorm:"primarykey;autoIncrement;size:32"`
+	AppId       *int    `json:"appId"`
+	AppVersion  *string `json:"appVersion"`
+	TargetRange *string `json:"targetRange"`
+	Live        *bool   `json:"live"`
+	UpdateTime  *int64  `json:"updateTime"`
+	CreateTime  *int64  `json:"createTime"`
+}
+
+func (BinaryVersion) TableName() string {
+	return "binary_version"
+}
+
+func (BinaryVersion) GetByAppIdAndVersion(appId int, version string) *BinaryVersion {
+	var binaryVersion *BinaryVersion
+	err := userDb.Where("app_id", appId).Where("app_version", version).First(&binaryVersion).Error
+	if err != nil {
+		return nil
+	}
+	return binaryVersion
+}
+
+func (BinaryVersion) GetByAppId(appId int) *[]BinaryVersion {
+	var binaryVersions *[]BinaryVersion
+	err := userDb.Where("app_id", appId).Order("id").Find(&binaryVersions).Error
+	if err != nil {
+		return nil
+	}
+	return binaryVersions
+}
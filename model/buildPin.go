@@ -0,0 +1,37 @@
+package model
+
+// 同一个appVersion下按安装包构建号(iOS CFBundleVersion / Android versionCode)固定到某个包
+type BuildPin struct {
+	Id                  *int    `gorm:"primarykey;autoIncrement;size:32"`
+	DeploymentId        *int    `json:"deploymentId"`
+	DeploymentVersionId *int    `json:"deploymentVersionId"`
+	AppVersion          *string `json:"appVersion"`
+	BuildNumber         *string `json:"buildNumber"`
+	PackageId           *int    `json:"packageId"`
+	Label               *string `json:"label"`
+	Note                *string `json:"note"`
+	Uid                 *int    `json:"uid"`
+	CreateTime          *int64  `json:"createTime"`
+}
+
+func (BuildPin) TableName() string {
+	return "build_pin"
+}
+
+func (BuildPin) GetByDeploymentId(deploymentId int) *[]BuildPin {
+	var pins *[]BuildPin
+	err := userDb.Where("deployment_id", deploymentId).Order("id").Find(&pins).Error
+	if err != nil {
+		return nil
+	}
+	return pins
+}
+
+func (BuildPin) GetByBuildNumber(deploymentVersionId int, buildNumber string) *BuildPin {
+	var pin *BuildPin
+	err := userDb.Where("deployment_version_id=? and build_number=?", deploymentVersionId, buildNumber).First(&pin).Error
+	if err != nil {
+		return nil
+	}
+	return pin
+}
@@ -0,0 +1,30 @@
+package model
+
+import "com.lc.go.codepush/server/utils"
+
+// 按clientUniqueId固定到某个包或禁止更新,以*结尾时按前缀匹配一组设备
+type ClientRule struct {
+	Id             *int    `gorm:"primarykey;autoIncrement;size:32"`
+	DeploymentId   *int    `json:"deploymentId"`
+	ClientUniqueId *string `json:"clientUniqueId"`
+	Action         *string `json:"action"`
+	PackageId      *int    `json:"packageId"`
+	Note           *string `json:"note"`
+	Uid            *int    `json:"uid"`
+	CreateTime     *int64  `json:"createTime"`
+	// 为空时一直有效
+	ExpireTime *int64 `json:"expireTime"`
+}
+
+func (ClientRule) TableName() string {
+	return "client_rule"
+}
+
+func (ClientRule) GetByDeploymentId(deploymentId int) *[]ClientRule {
+	var rules *[]ClientRule
+	err := userDb.Where("deployment_id", deploymentId).Where("expire_time is null or expire_time>?", *utils.GetTimeNow()).Order("id").Find(&rules).Error
+	if err != nil {
+		return nil
+	}
+	return rules
+}
@@ -1,12 +1,64 @@
 package constants
 
 const (
-	GIN_USER_ID = "GIN_USER_ID"
-	GIN_LANG    = "LANG"
+	GIN_USER_ID   = "GIN_USER_ID"
+	GIN_LANG      = "LANG"
+	GIN_PRINCIPAL = "GIN_PRINCIPAL"
+	// 客户端接口的deployment key,访问日志中记录其hash
+	GIN_DEPLOYMENT_KEY = "GIN_DEPLOYMENT_KEY"
+	// 按trusted_proxies解析出的客户端IP
+	GIN_CLIENT_IP = "GIN_CLIENT_IP"
+	// 代入其他用户的管理员uid
+	GIN_IMPERSONATOR = "GIN_IMPERSONATOR"
 )
 const (
-	REDIS_TOKEN_INFO  = "TOKEN:"
-	REDIS_UPDATE_INFO = "UPDATE_INFO:"
+	REDIS_TOKEN_INFO    = "TOKEN:"
+	REDIS_UPDATE_INFO   = "UPDATE_INFO:"
+	REDIS_OIDC_TOKEN    = "OIDC_TOKEN:"
+	REDIS_UPLOAD        = "UPLOAD:"
+	REDIS_FEATURE_FLAG  = "FEATURE_FLAG:"
+	REDIS_TRAFFIC       = "TRAFFIC:"
+	REDIS_UNKNOWN_KEY   = "UNKNOWN_KEY:"
+	REDIS_CACHE_REBUILD = "CACHE_REBUILD:"
+	REDIS_CHECK_COUNT   = "CHECK_COUNT:"
+	REDIS_ANOMALY       = "ANOMALY:"
+	REDIS_CHECK_HOURLY  = "CHECK_HOURLY:"
+	REDIS_ROLLUP        = "ROLLUP:"
+	REDIS_PIN_LINK      = "PIN_LINK:"
+	REDIS_EPHEMERAL     = "EPHEMERAL:"
+	REDIS_RETENTION     = "RETENTION:"
+	REDIS_ROLLOUT_RAMP  = "ROLLOUT_RAMP:"
+	REDIS_OUTBOX        = "OUTBOX:"
+	REDIS_ACME          = "ACME:"
+	REDIS_QUOTA         = "QUOTA:"
+	REDIS_CLIENT_STATE  = "CLIENT_STATE:"
+	REDIS_DEBUG_LOG     = "DEBUG_LOG:"
+	REDIS_IMPERSONATION = "IMPERSONATION:"
+	REDIS_REPLICATION   = "REPLICATION:"
+	REDIS_STORE_PENDING = "STORAGE_PENDING:"
+	REDIS_DIFF          = "DIFF:"
+)
+
+const (
+	ROLE_ADMIN     = "admin"
+	ROLE_DEVELOPER = "developer"
+	ROLE_VIEWER    = "viewer"
+)
+
+const (
+	REPLICATION_PENDING   = "pending"
+	REPLICATION_SUCCEEDED = "succeeded"
+	REPLICATION_FAILED    = "failed"
+)
+
+const (
+	PACKAGE_STATUS_PENDING  = "pending"
+	PACKAGE_STATUS_APPROVED = "approved"
+	PACKAGE_STATUS_REJECTED = "rejected"
+	// 只下发给带邀请码的客户端
+	PACKAGE_STATUS_PRIVATE = "private"
+	// 从其他服务器导入的已停用的发布
+	PACKAGE_STATUS_DISABLED = "disabled"
 )
 
 const (
@@ -14,9 +66,91 @@ const (
 	CONFIG_REGISTER_VERIFICATION = "CONFIG_REGISTER_VERIFICATION"
 )
 
+// panic(ErrObj{...})时Recover按Status和Code返回
 type ErrObj struct {
-	Code int    `json:"code"`
-	Msg  string `json:"msg"`
+	Status int          `json:"-"`
+	Code   int          `json:"code"`
+	Msg    string       `json:"msg"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// 请求参数校验失败的字段
+type FieldError struct {
+	Code    string `json:"code"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ErrObj) Error() string {
+	return e.Msg
+}
+
+const (
+	ERR_INTERNAL                 = 500
+	ERR_TOKEN_EXPIRED            = 1100
+	ERR_TOTP_REQUIRED            = 1101
+	ERR_PERMISSION_DENIED        = 1102
+	ERR_BOOTSTRAP_DISABLED       = 1103
+	ERR_BODY_TOO_LARGE           = 1104
+	ERR_VALIDATION               = 1105
+	ERR_ARCHIVE_TOO_LARGE        = 1106
+	ERR_DEPLOYMENT_KEY_NOT_FOUND = 1200
+	ERR_PIN_LINK_EXPIRED         = 1201
+	ERR_APP_NOT_FOUND            = 1202
+	ERR_DEPLOYMENT_NOT_FOUND     = 1203
+	ERR_PACKAGE_NOT_FOUND        = 1204
+	ERR_NOT_FOUND                = 1205
+	ERR_APP_EXISTS               = 1206
+	ERR_DEPLOYMENT_EXISTS        = 1207
+	ERR_APP_NOT_EMPTY            = 1208
+	ERR_DUPLICATE_PACKAGE        = 1209
+	ERR_DEPLOYMENT_FROZEN        = 1210
+	ERR_ROLLOUT_COMPLETE         = 1211
+	ERR_ROLLOUT_STATE            = 1212
+	ERR_PACKAGE_STATE            = 1213
+	ERR_POLICY_VIOLATION         = 1214
+	ERR_DOMAIN_EXISTS            = 1215
+	ERR_USER_EXISTS              = 1216
+	ERR_QUOTA_EXCEEDED           = 1217
+	ERR_VERSION_CONFLICT         = 1218
+)
+
+// 响应中的error字段,客户端按它判断错误类型,不要解析msg
+var errNames = map[int]string{
+	ERR_INTERNAL:                 "INTERNAL_ERROR",
+	ERR_TOKEN_EXPIRED:            "TOKEN_EXPIRED",
+	ERR_TOTP_REQUIRED:            "TOTP_REQUIRED",
+	ERR_PERMISSION_DENIED:        "PERMISSION_DENIED",
+	ERR_BOOTSTRAP_DISABLED:       "BOOTSTRAP_DISABLED",
+	ERR_BODY_TOO_LARGE:           "BODY_TOO_LARGE",
+	ERR_VALIDATION:               "VALIDATION_FAILED",
+	ERR_ARCHIVE_TOO_LARGE:        "ARCHIVE_TOO_LARGE",
+	ERR_DEPLOYMENT_KEY_NOT_FOUND: "DEPLOYMENT_KEY_NOT_FOUND",
+	ERR_PIN_LINK_EXPIRED:         "PIN_LINK_EXPIRED",
+	ERR_APP_NOT_FOUND:            "APP_NOT_FOUND",
+	ERR_DEPLOYMENT_NOT_FOUND:     "DEPLOYMENT_NOT_FOUND",
+	ERR_PACKAGE_NOT_FOUND:        "PACKAGE_NOT_FOUND",
+	ERR_NOT_FOUND:                "NOT_FOUND",
+	ERR_APP_EXISTS:               "APP_EXISTS",
+	ERR_DEPLOYMENT_EXISTS:        "DEPLOYMENT_EXISTS",
+	ERR_APP_NOT_EMPTY:            "APP_NOT_EMPTY",
+	ERR_DUPLICATE_PACKAGE:        "DUPLICATE_PACKAGE",
+	ERR_DEPLOYMENT_FROZEN:        "DEPLOYMENT_FROZEN",
+	ERR_ROLLOUT_COMPLETE:         "ROLLOUT_COMPLETE",
+	ERR_ROLLOUT_STATE:            "ROLLOUT_STATE",
+	ERR_PACKAGE_STATE:            "PACKAGE_STATE",
+	ERR_POLICY_VIOLATION:         "POLICY_VIOLATION",
+	ERR_DOMAIN_EXISTS:            "DOMAIN_EXISTS",
+	ERR_USER_EXISTS:              "USER_EXISTS",
+	ERR_QUOTA_EXCEEDED:           "QUOTA_EXCEEDED",
+	ERR_VERSION_CONFLICT:         "VERSION_CONFLICT",
+}
+
+func ErrName(code int) string {
+	if name, ok := errNames[code]; ok {
+		return name
+	}
+	return errNames[ERR_INTERNAL]
 }
 
 type PageData[T any] struct {
@@ -32,3 +166,66 @@ type PageBean struct {
 func (PageBean) GetNew() PageBean {
 	return PageBean{Rows: 10}
 }
+
+const (
+	UPLOAD_STAGE_RECEIVING  = "receiving"
+	UPLOAD_STAGE_STORING    = "storing"
+	UPLOAD_STAGE_VALIDATING = "validating"
+	UPLOAD_STAGE_DONE       = "done"
+	UPLOAD_STAGE_FAILED     = "failed"
+)
+
+const (
+	JOB_STATUS_PENDING   = "pending"
+	JOB_STATUS_RUNNING   = "running"
+	JOB_STATUS_SUCCEEDED = "succeeded"
+	JOB_STATUS_FAILED    = "failed"
+)
+
+const (
+	JOB_KIND_UPLOAD  = "upload"
+	JOB_KIND_RELEASE = "release"
+)
+
+const (
+	ROLLUP_HOUR  = "hour"
+	ROLLUP_DAY   = "day"
+	ROLLUP_MONTH = "month"
+)
+
+const (
+	METRIC_UPDATE_CHECK = "update_check"
+	METRIC_ACTIVE       = "active"
+	METRIC_FAILED       = "failed"
+	METRIC_INSTALLED    = "installed"
+)
+
+const (
+	ROLLOUT_ACTION_SET    = "set"
+	ROLLOUT_ACTION_PAUSE  = "pause"
+	ROLLOUT_ACTION_RESUME = "resume"
+	// 设置按时间自动灰度,以及之后每次自动调整
+	ROLLOUT_ACTION_RAMP = "ramp"
+	ROLLOUT_ACTION_AUTO = "auto"
+)
+
+// release_history中当前包变更的原因
+const (
+	RELEASE_ACTION_RELEASE  = "release"
+	RELEASE_ACTION_ROLLBACK = "rollback"
+	RELEASE_ACTION_APPROVE  = "approve"
+	RELEASE_ACTION_PUBLISH  = "publish"
+	RELEASE_ACTION_IMPORT   = "import"
+)
+
+const (
+	CLIENT_RULE_PIN   = "pin"
+	CLIENT_RULE_BLOCK = "block"
+)
+
+const (
+	CAPABILITY_DIFF         = "diff"
+	CAPABILITY_ZSTD         = "zstd"
+	CAPABILITY_CAMEL_CASE   = "camel_case"
+	PACKAGE_FORMAT_TAR_ZSTD = "tar.zst"
+)
@@ -0,0 +1,33 @@
+package model
+
+// 租户自己的域名,通过ACME自动申请证书
+type CustomDomain struct {
+	Id         *int    `gorm:"primarykey;autoIncrement;size:32"`
+	Tenant     *string `json:"tenant"`
+	Domain     *string `json:"domain"`
+	Uid        *int    `json:"uid"`
+	CreateTime *int64  `json:"createTime"`
+}
+
+func (CustomDomain) TableName() string {
+	return "custom_domain"
+}
+
+func (CustomDomain) GetByTenant(tenant string) *[]CustomDomain {
+	var domains *[]CustomDomain
+	err := userDb.Where("tenant", tenant).Order("id").Find(&domains).Error
+	if err != nil {
+		return nil
+	}
+	return domains
+}
+
+// 域名全局唯一,不区分租户
+func (CustomDomain) GetByDomain(domain string) *CustomDomain {
+	var customDomain *CustomDomain
+	err := userDb.Where("domain", domain).First(&customDomain).Error
+	if err != nil {
+		return nil
+	}
+	return customDomain
+}
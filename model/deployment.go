@@ -1,13 +1,51 @@
 package model
 
+import (
+	"com.lc.go.codepush/server/utils"
+	"gorm.io/gorm"
+)
+
 type Deployment struct {
-	Id         *int    `gorm:"primarykey;autoIncrement;size:32"`
+	Id         *int    `gorm:"primarykey;autoIncrement;size:64"`
+	PublicId   *string `json:"-"`
 	AppId      *int    `json:"appId"`
 	Name       *string `json:"name"`
-	Key        *string `json:"key"`
+	Key        *string `gorm:"-" json:"key"`
 	VersionId  *int    `json:"versionId"`
 	UpdateTime *int64  `json:"updateTime"`
 	CreateTime *int64  `json:"createTime"`
+	// 发布需要其他用户审批后才生效
+	RequireApproval *bool   `json:"requireApproval"`
+	Approvers       *string `json:"approvers"`
+	// 客户端需要在update_check中带上sha256(secret),库中保存sha256(sha256(secret))
+	SecretHash         *string `json:"-"`
+	PreviousSecretHash *string `json:"-"`
+	// 紧急情况下让所有客户端去应用商店更新
+	ForceBinaryUpdate  *bool   `json:"forceBinaryUpdate"`
+	ForceBinaryMessage *string `json:"forceBinaryMessage"`
+	ForceBinaryUrl     *string `json:"forceBinaryUrl"`
+	// 多语言提示(json, locale -> 文本)
+	ForceBinaryMessages *string `json:"forceBinaryMessages"`
+	// 临时部署(CI按分支创建),超过这么多天没有发布和update_check时自动删除
+	EphemeralDays  *int   `json:"ephemeralDays"`
+	LastActiveTime *int64 `json:"lastActiveTime"`
+	// 标签模板,例如 v{n};为空时使用label_mode
+	LabelFormat *string `json:"labelFormat"`
+	// 最后分配的{n}
+	LabelSeq *int `json:"labelSeq"`
+	// 发布策略: 请求没有指定时使用的默认值,EnforcePolicy为true时请求不能放宽
+	DefaultMandatory *bool  `json:"defaultMandatory"`
+	DefaultRollout   *int   `json:"defaultRollout"`
+	MaxPackageSize   *int64 `json:"maxPackageSize"`
+	EnforcePolicy    *bool  `json:"enforcePolicy"`
+	// Key由AfterFind从key_enc解密,或来自未加密的key列
+	PlainKey *string `gorm:"column:key" json:"-"`
+	// hmac(deployment_key_secret, key),用于按key查询
+	KeyHmac *string `json:"-"`
+	// 用按应用派生的密钥加密
+	KeyEnc *string `json:"-"`
+	// 乐观锁版本,只由BumpRowVersion修改,作为ETag返回
+	RowVersion *int `gorm:"default:0;<-:create" json:"rowVersion"`
 }
 
 func (Deployment) TableName() string {
@@ -23,6 +61,16 @@ func (Deployment) GetByAppidAndName(appId int, name string) *Deployment {
 	return deployment
 }
 
+// 所有用户的同名应用中的同名部署,审批人不是应用的所有者
+func (Deployment) GetByAppNameAndName(appName string, name string) *[]Deployment {
+	var deployments *[]Deployment
+	err := userDb.Joins("join apps on apps.id=deployment.app_id").Where("apps.app_name=? and deployment.name=?", appName, name).Find(&deployments).Error
+	if err != nil {
+		return nil
+	}
+	return deployments
+}
+
 func (Deployment) GetByAppids(appId int) *[]Deployment {
 	var deployment *[]Deployment
 	err := userDb.Where("app_id", appId).Find(&deployment).Error
@@ -31,3 +79,48 @@ func (Deployment) GetByAppids(appId int) *[]Deployment {
 	}
 	return deployment
 }
+
+func (Deployment) UpdateSecretHash(tx *gorm.DB, id int, secretHash *string, previousSecretHash *string) error {
+	return tx.Exec("update deployment set secret_hash=?,previous_secret_hash=? where id=?", secretHash, previousSecretHash, id).Error
+}
+
+func (Deployment) UpdateLastActiveTime(id int, lastActiveTime int64) {
+	userDb.Raw("update deployment set last_active_time=? where id=?", lastActiveTime, id).Scan(&Deployment{})
+}
+
+// 最后活动时间早于ephemeral_days天前的临时部署
+func (Deployment) GetExpiredEphemeral(now int64) *[]Deployment {
+	var deployments *[]Deployment
+	err := userDb.Where("ephemeral_days is not null").Where("last_active_time<?-ephemeral_days*86400000", now).Order("id").Find(&deployments).Error
+	if err != nil {
+		return nil
+	}
+	return deployments
+}
+
+func (Deployment) UpdateLabelFormat(tx *gorm.DB, id int, format *string, seq int) error {
+	if tx == nil {
+		tx = userDb
+	}
+	return tx.Exec("update deployment set label_format=?,label_seq=?,update_time=? where id=?", format, seq, *utils.GetTimeNow(), id).Error
+}
+
+// 发布策略的字段可以清空,不能用Updates
+func (Deployment) UpdatePolicy(tx *gorm.DB, deployment *Deployment) error {
+	return tx.Exec("update deployment set default_mandatory=?,default_rollout=?,max_package_size=?,enforce_policy=?,require_approval=?,update_time=? where id=?",
+		deployment.DefaultMandatory, deployment.DefaultRollout, deployment.MaxPackageSize, deployment.EnforcePolicy, deployment.RequireApproval, deployment.UpdateTime, *deployment.Id).Error
+}
+
+// 在发布事务中递增标签序号,并发发布时行锁保证不重复
+func (Deployment) NextLabelSeq(tx *gorm.DB, id int) (int, error) {
+	if err := tx.Exec("update deployment set label_seq=ifnull(label_seq,0)+1 where id=?", id).Error; err != nil {
+		return 0, err
+	}
+	var seq int
+	err := tx.Raw("select label_seq from deployment where id=?", id).Scan(&seq).Error
+	return seq, err
+}
+
+func (Deployment) UpdateName(id int, name string) {
+	userDb.Raw("update deployment set name=?,update_time=? where id=?", name, *utils.GetTimeNow(), id).Scan(&Deployment{})
+}
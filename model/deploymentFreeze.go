@@ -0,0 +1,26 @@
+package model
+
+type DeploymentFreeze struct {
+	Id           *int    `gorm:"primarykey;autoIncrement;size:32"`
+	DeploymentId *int    `json:"deploymentId"`
+	StartDay     *int    `json:"startDay"`
+	StartTime    *string `json:"startTime"`
+	EndDay       *int    `json:"endDay"`
+	EndTime      *string `json:"endTime"`
+	Timezone     *string `json:"timezone"`
+	Overriders   *string `json:"overriders"`
+	CreateTime   *int64  `json:"createTime"`
+}
+
+func (DeploymentFreeze) TableName() string {
+	return "deployment_freeze"
+}
+
+func (DeploymentFreeze) GetByDeploymentId(deploymentId int) *[]DeploymentFreeze {
+	var freezes *[]DeploymentFreeze
+	err := userDb.Where("deployment_id", deploymentId).Order("id").Find(&freezes).Error
+	if err != nil {
+		return nil
+	}
+	return freezes
+}
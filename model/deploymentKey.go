@@ -0,0 +1,185 @@
+package model
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+
+	"com.lc.go.codepush/server/config"
+	"gorm.io/gorm"
+)
+
+// 开启deployment_key_secret后库中只保存key_hmac和key_enc,旧数据由rekey-deployments迁移
+func KeyEncryptionEnabled() bool {
+	return config.GetConfig().DeploymentKey.Secret != ""
+}
+
+func keySecrets() []string {
+	c := config.GetConfig().DeploymentKey
+	secrets := []string{}
+	if c.Secret != "" {
+		secrets = append(secrets, c.Secret)
+	}
+	if c.PreviousSecret != "" {
+		secrets = append(secrets, c.PreviousSecret)
+	}
+	return secrets
+}
+
+func keyHmac(secret string, key string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("lookup\n" + key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 由主密钥和用途派生的加密密钥
+func deriveCipher(secret string, purpose string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 每个应用一个加密密钥,由主密钥和appId派生
+func appKeyCipher(secret string, appId int) (cipher.AEAD, error) {
+	return deriveCipher(secret, "app\n"+strconv.Itoa(appId))
+}
+
+// 用deployment_key_secret加密其他需要保密的数据(例如ACME私钥),name作为附加数据,密文不能换到其他name下使用
+func EncryptSecret(name string, data []byte) ([]byte, error) {
+	aead, err := deriveCipher(config.GetConfig().DeploymentKey.Secret, "secret\n"+name)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, []byte(name)), nil
+}
+
+// 依次尝试当前和旧密钥
+func DecryptSecret(name string, data []byte) ([]byte, error) {
+	for _, secret := range keySecrets() {
+		aead, err := deriveCipher(secret, "secret\n"+name)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < aead.NonceSize() {
+			return nil, errors.New("secret: ciphertext too short")
+		}
+		plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(name))
+		if err == nil {
+			return plain, nil
+		}
+	}
+	return nil, errors.New("secret: cannot decrypt " + name + " with deployment_key_secret")
+}
+
+// 按key查询时的候选值: 当前和旧密钥的hmac,以及未迁移的明文
+func KeyLookupValues(key string) []string {
+	values := []string{key}
+	for _, secret := range keySecrets() {
+		values = append(values, keyHmac(secret, key))
+	}
+	return values
+}
+
+// 返回使用当前密钥的(key_hmac, key_enc)
+func EncryptKey(appId int, key string) (string, string, error) {
+	secret := config.GetConfig().DeploymentKey.Secret
+	aead, err := appKeyCipher(secret, appId)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	// appId作为附加数据,密文不能挪到其他应用的部署上
+	sealed := aead.Seal(nonce, nonce, []byte(key), []byte(strconv.Itoa(appId)))
+	return keyHmac(secret, key), base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// 依次尝试当前和旧密钥
+func decryptKey(appId int, enc string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", err
+	}
+	for _, secret := range keySecrets() {
+		aead, err := appKeyCipher(secret, appId)
+		if err != nil {
+			return "", err
+		}
+		if len(data) < aead.NonceSize() {
+			return "", errors.New("deployment key: ciphertext too short")
+		}
+		plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(strconv.Itoa(appId)))
+		if err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", errors.New("deployment key: cannot decrypt key of app " + strconv.Itoa(appId) + " with deployment_key_secret")
+}
+
+func (d *Deployment) BeforeCreate(tx *gorm.DB) error {
+	assignId(&d.Id)
+	if err := assignPublicId(&d.PublicId); err != nil {
+		return err
+	}
+	if d.Key == nil {
+		return nil
+	}
+	if !KeyEncryptionEnabled() {
+		d.PlainKey = d.Key
+		return nil
+	}
+	if d.AppId == nil {
+		return errors.New("deployment key: app_id is required")
+	}
+	hmacValue, keyEnc, err := EncryptKey(*d.AppId, *d.Key)
+	if err != nil {
+		return err
+	}
+	d.PlainKey = nil
+	d.KeyHmac = &hmacValue
+	d.KeyEnc = &keyEnc
+	return nil
+}
+
+func (d *Deployment) AfterFind(tx *gorm.DB) error {
+	if d.KeyEnc == nil || d.AppId == nil {
+		d.Key = d.PlainKey
+		return nil
+	}
+	key, err := decryptKey(*d.AppId, *d.KeyEnc)
+	if err != nil {
+		return err
+	}
+	d.Key = &key
+	return nil
+}
+
+func (Deployment) GetByKey(key string) *Deployment {
+	var deployment *Deployment
+	err := userDb.Where("`key` = ? or key_hmac in ?", key, KeyLookupValues(key)[1:]).First(&deployment).Error
+	if err != nil {
+		return nil
+	}
+	return deployment
+}
+
+// rekey-deployments: keyHmac和keyEnc为nil时写回明文
+func (Deployment) UpdateKeyColumns(tx *gorm.DB, id int, plainKey *string, keyHmac *string, keyEnc *string) error {
+	return tx.Exec("update deployment set `key`=?,key_hmac=?,key_enc=? where id=?", plainKey, keyHmac, keyEnc, id).Error
+}
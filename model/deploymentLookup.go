@@ -0,0 +1,55 @@
+package model
+
+import (
+	"com.lc.go.codepush/server/utils"
+	"gorm.io/gorm"
+)
+
+// deploymentKey+bundleName+appVersion -> 部署/版本/当前包,update_check只需一次主键查询
+// 开启deployment_key_secret后deploymentKey列保存hmac
+// 发布、回滚、审批和删除部署时在同一个事务中重建
+type DeploymentLookup struct {
+	DeploymentKey       *string `gorm:"primarykey" json:"deploymentKey"`
+	BundleName          *string `gorm:"primarykey" json:"bundleName"`
+	AppVersion          *string `gorm:"primarykey" json:"appVersion"`
+	DeploymentId        *int    `json:"deploymentId"`
+	AppId               *int    `json:"appId"`
+	DeploymentVersionId *int    `json:"deploymentVersionId"`
+	PackageId           *int    `json:"packageId"`
+	NewVersion          *string `json:"newVersion"`
+	UpdateTime          *int64  `json:"updateTime"`
+}
+
+func (DeploymentLookup) TableName() string {
+	return "deployment_lookup"
+}
+
+func (DeploymentLookup) Resolve(deploymentKey string, bundleName string, appVersion string) *DeploymentLookup {
+	var lookup *DeploymentLookup
+	err := userDb.Where("deployment_key in ? and bundle_name=? and app_version=?", KeyLookupValues(deploymentKey), bundleName, appVersion).First(&lookup).Error
+	if err != nil {
+		return nil
+	}
+	return lookup
+}
+
+// tx为nil时使用默认连接
+func (DeploymentLookup) Rebuild(tx *gorm.DB, deploymentId int) error {
+	if tx == nil {
+		tx = userDb
+	}
+	if err := tx.Exec("delete from deployment_lookup where deployment_id=?", deploymentId).Error; err != nil {
+		return err
+	}
+	return tx.Exec("insert into deployment_lookup (deployment_key,bundle_name,app_version,deployment_id,app_id,deployment_version_id,package_id,new_version,update_time) "+
+		"select ifnull(d.key_hmac,d.`key`),v.bundle_name,v.app_version,d.id,d.app_id,v.id,v.current_package,"+
+		"(select n.app_version from deployment_version n where n.deployment_id=d.id and n.bundle_name=v.bundle_name order by n.version_num desc limit 1),? "+
+		"from deployment d join deployment_version v on v.deployment_id=d.id where d.id=?", *utils.GetTimeNow(), deploymentId).Error
+}
+
+func (DeploymentLookup) DeleteByDeploymentId(tx *gorm.DB, deploymentId int) error {
+	if tx == nil {
+		tx = userDb
+	}
+	return tx.Exec("delete from deployment_lookup where deployment_id=?", deploymentId).Error
+}
@@ -1,8 +1,11 @@
 package model
 
+import "gorm.io/gorm"
+
 type DeploymentVersion struct {
 	Id             *int    `gorm:"primarykey;autoIncrement;size:32"`
 	DeploymentId   *int    `json:"deploymentId"`
+	BundleName     *string `json:"bundleName"`
 	AppVersion     *string `json:"appVersion"`
 	VersionNum     *int64  `json:"version_num"`
 	CurrentPackage *int    `json:"currentPackage"`
@@ -14,24 +17,33 @@ func (DeploymentVersion) TableName() string {
 	return "deployment_version"
 }
 
-func (DeploymentVersion) GetByKeyDeploymentIdAndVersion(deploymentId int, version string) *DeploymentVersion {
+// bundleName为空表示应用的主bundle
+func (DeploymentVersion) GetByKeyDeploymentIdAndVersion(deploymentId int, bundleName string, version string) *DeploymentVersion {
 	var deploymentVersion *DeploymentVersion
-	err := userDb.Where("deployment_id", deploymentId).Where("app_version", version).First(&deploymentVersion).Error
+	err := userDb.Where("deployment_id", deploymentId).Where("bundle_name", bundleName).Where("app_version", version).First(&deploymentVersion).Error
 	if err != nil {
 		return nil
 	}
 	return deploymentVersion
 }
 
-func (DeploymentVersion) GetNewVersionByKeyDeploymentId(deploymentId int) *DeploymentVersion {
+// 同一个bundle中版本号最大的,子bundle的版本号与主bundle无关
+func (DeploymentVersion) GetNewVersionByKeyDeploymentId(deploymentId int, bundleName string) *DeploymentVersion {
 	var deploymentVersion *DeploymentVersion
-	err := userDb.Where("deployment_id", deploymentId).Order("version_num desc").First(&deploymentVersion).Error
+	err := userDb.Where("deployment_id", deploymentId).Where("bundle_name", bundleName).Order("version_num desc").First(&deploymentVersion).Error
 	if err != nil {
 		return nil
 	}
 	return deploymentVersion
 }
 
-func (DeploymentVersion) UpdateCurrentPackage(id int, pid *int) {
-	userDb.Raw("update deployment_version set current_package=? where id=?", pid, id).Scan(&DeploymentVersion{})
+// 同时写入release_history,tx为nil时不使用事务
+func (DeploymentVersion) UpdateCurrentPackage(tx *gorm.DB, id int, pid *int, action string, uid int) error {
+	if tx == nil {
+		tx = userDb
+	}
+	if err := tx.Exec("update deployment_version set current_package=? where id=?", pid, id).Error; err != nil {
+		return err
+	}
+	return ReleaseHistory{}.Add(tx, id, pid, action, uid)
 }
@@ -0,0 +1,40 @@
+package model
+
+// 按租户/应用开关服务端功能,app_id为空时对整个租户生效
+type FeatureFlag struct {
+	Id         *int    `gorm:"primarykey;autoIncrement;size:32"`
+	Tenant     *string `json:"tenant"`
+	Name       *string `json:"name"`
+	AppId      *int    `json:"appId"`
+	Enabled    *bool   `json:"enabled"`
+	CreateTime *int64  `json:"createTime"`
+	UpdateTime *int64  `json:"updateTime"`
+}
+
+func (FeatureFlag) TableName() string {
+	return "feature_flag"
+}
+
+func (FeatureFlag) GetByTenant(tenant string) *[]FeatureFlag {
+	var flags *[]FeatureFlag
+	err := userDb.Where("tenant", tenant).Order("id").Find(&flags).Error
+	if err != nil {
+		return nil
+	}
+	return flags
+}
+
+func (FeatureFlag) GetByName(tenant string, name string, appId *int) *FeatureFlag {
+	var flag *FeatureFlag
+	query := userDb.Where("tenant", tenant).Where("name", name)
+	if appId == nil {
+		query = query.Where("app_id is null")
+	} else {
+		query = query.Where("app_id", *appId)
+	}
+	err := query.First(&flag).Error
+	if err != nil {
+		return nil
+	}
+	return flag
+}
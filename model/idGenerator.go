@@ -0,0 +1,158 @@
+package model
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// snowflake id: 32位秒级时间戳(从2024-01-01起) | 8位id_node | 13位序号,共53位,
+// 前端JSON解析不会丢精度;同一秒序号用完时借用下一秒
+const (
+	snowflakeEpoch    = 1704067200
+	snowflakeNodeBits = 8
+	snowflakeSeqBits  = 13
+)
+
+var (
+	snowflakeMu   sync.Mutex
+	snowflakeLast int64
+	snowflakeSeq  int64
+)
+
+// id_generator为auto时返回false,由数据库自增
+func nextId() (int, bool) {
+	c := config.GetConfig()
+	if c.IdGenerator != "snowflake" && c.IdGenerator != "uuidv7" {
+		return 0, false
+	}
+	snowflakeMu.Lock()
+	defer snowflakeMu.Unlock()
+	now := time.Now().Unix() - snowflakeEpoch
+	if now > snowflakeLast {
+		snowflakeLast = now
+		snowflakeSeq = 0
+	} else {
+		// 时钟回拨或同一秒内,继续使用上一次的时间
+		snowflakeSeq++
+		if snowflakeSeq >= 1<<snowflakeSeqBits {
+			snowflakeLast++
+			snowflakeSeq = 0
+		}
+	}
+	id := snowflakeLast<<(snowflakeNodeBits+snowflakeSeqBits) | int64(c.IdNode)<<snowflakeSeqBits | snowflakeSeq
+	return int(id), true
+}
+
+func assignId(id **int) {
+	if *id != nil {
+		return
+	}
+	if next, ok := nextId(); ok {
+		*id = &next
+	}
+}
+
+// id_generator为uuidv7时app、deployment和package另有uuidv7字符串id(public_id),
+// 接口中代替整数id返回,外部无法按顺序猜出其他应用的id;整数id只在内部和数据库中使用
+func PublicIds() bool {
+	return config.GetConfig().IdGenerator == "uuidv7"
+}
+
+func assignPublicId(id **string) error {
+	if *id != nil || !PublicIds() {
+		return nil
+	}
+	v, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	s := v.String()
+	*id = &s
+	return nil
+}
+
+func (a *App) BeforeCreate(tx *gorm.DB) error {
+	assignId(&a.Id)
+	return assignPublicId(&a.PublicId)
+}
+
+func (p *Package) BeforeCreate(tx *gorm.DB) error {
+	assignId(&p.Id)
+	return assignPublicId(&p.PublicId)
+}
+
+// 开启uuidv7之前创建的行补上public_id,每次处理limit行,返回处理的行数;
+// 只更新public_id为空的行,多个实例同时执行不会覆盖
+func BackfillPublicIds(limit int) (int, error) {
+	count := 0
+	for _, table := range []string{"apps", "deployment", "package"} {
+		var ids []int
+		if err := userDb.Raw("select id from "+table+" where public_id is null limit ?", limit-count).Scan(&ids).Error; err != nil {
+			return count, err
+		}
+		for _, id := range ids {
+			v, err := uuid.NewV7()
+			if err != nil {
+				return count, err
+			}
+			if err := userDb.Exec("update "+table+" set public_id=? where id=? and public_id is null", v.String(), id).Error; err != nil {
+				return count, err
+			}
+			count++
+		}
+		if count >= limit {
+			break
+		}
+	}
+	return count, nil
+}
+
+// 接口中的id,开启uuidv7时为public_id
+func (p Package) ExternalId() any {
+	if PublicIds() {
+		return p.PublicId
+	}
+	return p.Id
+}
+
+// 开启uuidv7时Id返回public_id,不返回指向其他表的整数id
+
+func (a App) MarshalJSON() ([]byte, error) {
+	type plain App
+	if !PublicIds() {
+		return json.Marshal(plain(a))
+	}
+	return json.Marshal(struct {
+		plain
+		Id *string
+	}{plain(a), a.PublicId})
+}
+
+func (d Deployment) MarshalJSON() ([]byte, error) {
+	type plain Deployment
+	if !PublicIds() {
+		return json.Marshal(plain(d))
+	}
+	return json.Marshal(struct {
+		plain
+		Id    *string
+		AppId *int `json:"appId,omitempty"`
+	}{plain: plain(d), Id: d.PublicId})
+}
+
+func (p Package) MarshalJSON() ([]byte, error) {
+	type plain Package
+	if !PublicIds() {
+		return json.Marshal(plain(p))
+	}
+	return json.Marshal(struct {
+		plain
+		Id           *string
+		DeploymentId *int `json:"deploymentId,omitempty"`
+	}{plain: plain(p), Id: p.PublicId})
+}
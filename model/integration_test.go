@@ -0,0 +1,183 @@
+//go:build integration
+
+// 需要mysql: go run -tags integration ./testenv go test -tags integration -p 1 ./...
+package model
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/google/uuid"
+)
+
+func createTestDeployment(t *testing.T) (*App, *Deployment) {
+	t.Helper()
+	name := "it-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	uid := 1
+	os := 1
+	app := App{Uid: &uid, AppName: &name, OS: &os, CreateTime: utils.GetTimeNow()}
+	if err := Create[App](&app); err != nil {
+		t.Fatal(err)
+	}
+	deploymentName := "Staging"
+	key := uuid.NewString()
+	deployment := Deployment{AppId: app.Id, Name: &deploymentName, Key: &key, CreateTime: utils.GetTimeNow()}
+	if err := Create[Deployment](&deployment); err != nil {
+		t.Fatal(err)
+	}
+	return &app, &deployment
+}
+
+func createTestPackage(t *testing.T, deployment *Deployment, version *DeploymentVersion, hash string) *Package {
+	t.Helper()
+	size := int64(10)
+	zero := 0
+	pack := Package{
+		DeploymentId:        deployment.Id,
+		DeploymentVersionId: version.Id,
+		Size:                &size,
+		Hash:                &hash,
+		Download:            &hash,
+		Active:              &zero,
+		Failed:              &zero,
+		Installed:           &zero,
+		CreateTime:          utils.GetTimeNow(),
+	}
+	if err := Create[Package](&pack); err != nil {
+		t.Fatal(err)
+	}
+	label := Package{}.NewLabel(*pack.Id)
+	pack.Label = &label
+	Update[Package](&pack)
+	return &pack
+}
+
+// 发布两次 -> 查询 -> 上报 -> 回滚,每一步后重建deployment_lookup
+func TestReleaseLifecycle(t *testing.T) {
+	_, deployment := createTestDeployment(t)
+	bundleName := ""
+	appVersion := "1.0.0"
+	versionNum := utils.FormatVersionStr(appVersion)
+	version := DeploymentVersion{DeploymentId: deployment.Id, BundleName: &bundleName, AppVersion: &appVersion, VersionNum: &versionNum, CreateTime: utils.GetTimeNow()}
+	if err := Create[DeploymentVersion](&version); err != nil {
+		t.Fatal(err)
+	}
+	first := createTestPackage(t, deployment, &version, "hash-1")
+	DeploymentVersion{}.UpdateCurrentPackage(nil, *version.Id, first.Id, constants.RELEASE_ACTION_RELEASE, 1)
+	second := createTestPackage(t, deployment, &version, "hash-2")
+	DeploymentVersion{}.UpdateCurrentPackage(nil, *version.Id, second.Id, constants.RELEASE_ACTION_RELEASE, 1)
+	if err := (DeploymentLookup{}).Rebuild(nil, *deployment.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := DeploymentLookup{}.Resolve(*deployment.Key, bundleName, appVersion)
+	if lookup == nil || *lookup.PackageId != *second.Id {
+		t.Fatalf("resolve: expected package %d, got %+v", *second.Id, lookup)
+	}
+	if lookup.NewVersion == nil || *lookup.NewVersion != appVersion {
+		t.Fatalf("resolve: expected new version %s, got %v", appVersion, lookup.NewVersion)
+	}
+
+	if err := (Package{}).AddCounts(map[int][3]int{*second.Id: {1, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	counted := GetOne[Package]("id", *second.Id)
+	if *counted.Active != 1 || *counted.Installed != 1 {
+		t.Fatalf("add counts: got active=%d installed=%d", *counted.Active, *counted.Installed)
+	}
+
+	previous := Package{}.GetRollbackPack(*deployment.Id, *second.Id, *version.Id)
+	if previous == nil || *previous.Id != *first.Id {
+		t.Fatalf("rollback: expected package %d, got %+v", *first.Id, previous)
+	}
+	DeploymentVersion{}.UpdateCurrentPackage(nil, *version.Id, previous.Id, constants.RELEASE_ACTION_ROLLBACK, 1)
+	if err := (DeploymentLookup{}).Rebuild(nil, *deployment.Id); err != nil {
+		t.Fatal(err)
+	}
+	lookup = DeploymentLookup{}.Resolve(*deployment.Key, bundleName, appVersion)
+	if lookup == nil || *lookup.PackageId != *first.Id {
+		t.Fatalf("resolve after rollback: expected package %d, got %+v", *first.Id, lookup)
+	}
+	history := ReleaseHistory{}.GetAt(*version.Id, *utils.GetTimeNow())
+	if history == nil || *history.Action != constants.RELEASE_ACTION_ROLLBACK {
+		t.Fatalf("release history: expected rollback, got %+v", history)
+	}
+}
+
+// 子bundle的版本不影响主bundle的new_version
+func TestLookupNewVersionPerBundle(t *testing.T) {
+	_, deployment := createTestDeployment(t)
+	for _, v := range []struct{ bundle, version string }{{"", "1.0.0"}, {"sub", "3.0.0"}} {
+		bundleName, appVersion := v.bundle, v.version
+		versionNum := utils.FormatVersionStr(appVersion)
+		version := DeploymentVersion{DeploymentId: deployment.Id, BundleName: &bundleName, AppVersion: &appVersion, VersionNum: &versionNum, CreateTime: utils.GetTimeNow()}
+		if err := Create[DeploymentVersion](&version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := (DeploymentLookup{}).Rebuild(nil, *deployment.Id); err != nil {
+		t.Fatal(err)
+	}
+	if v := (DeploymentVersion{}).GetNewVersionByKeyDeploymentId(*deployment.Id, ""); v == nil || *v.AppVersion != "1.0.0" {
+		t.Fatalf("main bundle new version: got %+v", v)
+	}
+	lookup := DeploymentLookup{}.Resolve(*deployment.Key, "", "1.0.0")
+	if lookup == nil || *lookup.NewVersion != "1.0.0" {
+		t.Fatalf("main bundle lookup: got %+v", lookup)
+	}
+}
+
+func TestBumpRowVersion(t *testing.T) {
+	app, _ := createTestDeployment(t)
+	version, err := BumpRowVersion(nil, "apps", *app.Id, nil)
+	if err != nil || version != 1 {
+		t.Fatalf("bump: got %d %v", version, err)
+	}
+	stale := 0
+	if _, err := BumpRowVersion(nil, "apps", *app.Id, &stale); err != ErrRowVersion {
+		t.Fatalf("stale bump: expected ErrRowVersion, got %v", err)
+	}
+	if version, err = BumpRowVersion(nil, "apps", *app.Id, &version); err != nil || version != 2 {
+		t.Fatalf("bump with expected: got %d %v", version, err)
+	}
+}
+
+// 并发初始化时只有一个成功;会清空users表,依赖-p 1顺序执行各个包
+func TestCreateFirstUser(t *testing.T) {
+	if err := userDb.Exec("delete from users").Error; err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "bootstrap-" + strconv.Itoa(i)
+			role := constants.ROLE_ADMIN
+			accessKey := uuid.NewString()
+			ok, err := User{}.CreateFirst(&User{UserName: &name, Role: &role}, &Token{Token: &accessKey})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if created != 1 || (User{}).Count() != 1 {
+		t.Fatalf("expected one bootstrap, got %d created and %d users", created, (User{}).Count())
+	}
+	if err := userDb.Exec("delete from users").Error; err != nil {
+		t.Fatal(err)
+	}
+}
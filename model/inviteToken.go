@@ -0,0 +1,39 @@
+package model
+
+import "com.lc.go.codepush/server/utils"
+
+// 预发布(private)包的邀请码,只保存hash
+type InviteToken struct {
+	Id           *int    `gorm:"primarykey;autoIncrement;size:32"`
+	DeploymentId *int    `json:"deploymentId"`
+	PackageId    *int    `json:"packageId"`
+	TokenHash    *string `json:"-"`
+	Note         *string `json:"note"`
+	Uid          *int    `json:"uid"`
+	// 为空时一直有效
+	ExpireTime *int64 `json:"expireTime"`
+	CreateTime *int64 `json:"createTime"`
+}
+
+func (InviteToken) TableName() string {
+	return "invite_token"
+}
+
+func (InviteToken) GetByDeploymentId(deploymentId int) *[]InviteToken {
+	var tokens *[]InviteToken
+	err := userDb.Where("deployment_id", deploymentId).Order("id").Find(&tokens).Error
+	if err != nil {
+		return nil
+	}
+	return tokens
+}
+
+// 未过期的邀请码
+func (InviteToken) GetValidByDeploymentId(deploymentId int) *[]InviteToken {
+	var tokens *[]InviteToken
+	err := userDb.Where("deployment_id", deploymentId).Where("expire_time is null or expire_time>?", *utils.GetTimeNow()).Order("id").Find(&tokens).Error
+	if err != nil {
+		return nil
+	}
+	return tokens
+}
@@ -0,0 +1,59 @@
+package model
+
+import "com.lc.go.codepush/server/config"
+
+// 与包计数在同一事务中写入的上报增量,用于核对和修复按小时汇总的指标
+type MetricEvent struct {
+	Id           *int64 `gorm:"primarykey;autoIncrement" json:"-"`
+	DeploymentId *int   `json:"deploymentId"`
+	PackageId    *int   `json:"packageId"`
+	Active       *int   `json:"active"`
+	Failed       *int   `json:"failed"`
+	Installed    *int   `json:"installed"`
+	CreateTime   *int64 `json:"createTime"`
+}
+
+func (MetricEvent) TableName() string {
+	return "metric_event"
+}
+
+// rollup_reconcile_hours为0时不写入
+func MetricEventsEnabled() bool {
+	c := config.GetConfig().Rollup
+	return c.Enabled && c.ReconcileHours > 0
+}
+
+// 某个包在一小时内的上报合计
+type MetricEventSum struct {
+	BucketTime   int64
+	DeploymentId int
+	PackageId    int
+	Active       int64
+	Failed       int64
+	Installed    int64
+}
+
+// [from,to)之间按UTC整点汇总
+func (MetricEvent) SumByHour(from int64, to int64) *[]MetricEventSum {
+	var list *[]MetricEventSum
+	err := userDb.Raw("select create_time-create_time%3600000 as bucket_time,deployment_id,package_id,sum(active) as active,sum(failed) as failed,sum(installed) as installed"+
+		" from metric_event where create_time>=? and create_time<? group by bucket_time,deployment_id,package_id", from, to).Scan(&list).Error
+	if err != nil {
+		return nil
+	}
+	return list
+}
+
+// 最早一条的时间,没有数据时返回0
+func (MetricEvent) FirstTime() int64 {
+	var first *int64
+	userDb.Raw("select min(create_time) from metric_event").Scan(&first)
+	if first == nil {
+		return 0
+	}
+	return *first
+}
+
+func (MetricEvent) DeleteBefore(before int64) error {
+	return userDb.Exec("delete from metric_event where create_time<?", before).Error
+}
@@ -0,0 +1,49 @@
+package model
+
+import "gorm.io/gorm/clause"
+
+// 按小时/天/月汇总的指标,package_id为0表示部署级别的指标(例如update_check)
+type MetricRollup struct {
+	Id           *int64  `gorm:"primarykey;autoIncrement" json:"-"`
+	Granularity  *string `json:"granularity"`
+	BucketTime   *int64  `json:"bucketTime"`
+	DeploymentId *int    `json:"deploymentId"`
+	PackageId    *int    `json:"packageId"`
+	Metric       *string `json:"metric"`
+	Value        *int64  `json:"value"`
+}
+
+func (MetricRollup) TableName() string {
+	return "metric_rollup"
+}
+
+// 重复执行时覆盖已有的值
+func (MetricRollup) Upsert(rows []MetricRollup) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return userDb.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"value"})}).CreateInBatches(&rows, 500).Error
+}
+
+// [from,to)之间的数据
+func (MetricRollup) GetRange(granularity string, from int64, to int64) *[]MetricRollup {
+	var list *[]MetricRollup
+	err := userDb.Where("granularity=? and bucket_time>=? and bucket_time<?", granularity, from, to).Order("id").Find(&list).Error
+	if err != nil {
+		return nil
+	}
+	return list
+}
+
+func (MetricRollup) GetByDeploymentId(deploymentId int, granularity string, from int64, to int64) *[]MetricRollup {
+	var list *[]MetricRollup
+	err := userDb.Where("deployment_id=? and granularity=? and bucket_time>=? and bucket_time<?", deploymentId, granularity, from, to).Order("bucket_time,package_id,metric").Find(&list).Error
+	if err != nil {
+		return nil
+	}
+	return list
+}
+
+func (MetricRollup) DeleteBefore(granularity string, before int64) error {
+	return userDb.Exec("delete from metric_rollup where granularity=? and bucket_time<?", granularity, before).Error
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"com.lc.go.codepush/server/utils"
+	"gorm.io/gorm"
+)
+
+// 与数据变更在同一事务中写入的事件,提交后由后台任务投递
+type Outbox struct {
+	Id        *int    `gorm:"primarykey;autoIncrement;size:32"`
+	EventType *string `json:"eventType"`
+	Payload   *string `json:"payload"`
+	Attempts  *int    `json:"attempts"`
+	NextTime  *int64  `json:"nextTime"`
+	// 已写入事件总线
+	PublishTime *int64 `json:"publishTime"`
+	// webhook也已送达,之后不再处理
+	SentTime   *int64 `json:"sentTime"`
+	CreateTime *int64 `json:"createTime"`
+}
+
+func (Outbox) TableName() string {
+	return "outbox"
+}
+
+func (Outbox) Add(tx *gorm.DB, eventType string, payload string) (*Outbox, error) {
+	now := utils.GetTimeNow()
+	attempts := 0
+	row := Outbox{
+		EventType:  &eventType,
+		Payload:    &payload,
+		Attempts:   &attempts,
+		NextTime:   now,
+		CreateTime: now,
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func (Outbox) GetDue(now int64, limit int) *[]Outbox {
+	var rows *[]Outbox
+	err := userDb.Where("sent_time is null and next_time<=?", now).Order("id").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil
+	}
+	return rows
+}
+
+func (Outbox) MarkPublished(id int, now int64) {
+	userDb.Raw("update outbox set publish_time=? where id=?", now, id).Scan(&Outbox{})
+}
+
+func (Outbox) MarkSent(id int, now int64) {
+	userDb.Raw("update outbox set sent_time=? where id=?", now, id).Scan(&Outbox{})
+}
+
+// next为nil时放弃,不再重试
+func (Outbox) MarkFailed(id int, attempts int, next *int64, now int64) {
+	if next == nil {
+		userDb.Raw("update outbox set attempts=?,sent_time=? where id=?", attempts, now, id).Scan(&Outbox{})
+		return
+	}
+	userDb.Raw("update outbox set attempts=?,next_time=? where id=?", attempts, *next, id).Scan(&Outbox{})
+}
+
+func (Outbox) DeleteSentBefore(time int64) error {
+	return userDb.Where("sent_time<?", time).Delete(Outbox{}).Error
+}
@@ -1,7 +1,21 @@
 package model
 
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
 type Package struct {
-	Id                  *int    `gorm:"primarykey;autoIncrement;size:32"`
+	Id                  *int    `gorm:"primarykey;autoIncrement;size:64"`
+	PublicId            *string `json:"-"`
 	DeploymentId        *int    `json:"deploymentId"`
 	DeploymentVersionId *int    `json:"deploymentVersionId"`
 	Size                *int64  `json:"size"`
@@ -12,29 +26,278 @@ type Package struct {
 	Installed           *int    `json:"installed"`
 	CreateTime          *int64  `json:"create_time"`
 	Description         *string `json:"description"`
+	ReplicationStatus   *string `json:"replicationStatus"`
+	// 复制失败后的重试次数和下次重试时间
+	ReplicationAttempts *int    `gorm:"default:0" json:"-"`
+	ReplicationNextTime *int64  `json:"-"`
+	Label               *string `json:"label"`
+	IdempotencyKey      *string `json:"-"`
+	Uid                 *int    `json:"uid"`
+	Status              *string `json:"status"`
+	ApprovedBy          *int    `json:"approvedBy"`
+	Rollout             *int    `json:"rollout"`
+	RolloutPaused       *bool   `json:"rolloutPaused"`
+	// 按时间自动灰度: 从RolloutRampStart开始,RolloutRampMinutes内由RolloutRampFrom升到100,RolloutRampSteps为0时线性
+	RolloutRampStart   *int64 `json:"rolloutRampStart"`
+	RolloutRampMinutes *int   `json:"rolloutRampMinutes"`
+	RolloutRampSteps   *int   `json:"rolloutRampSteps"`
+	RolloutRampFrom    *int   `json:"rolloutRampFrom"`
+	IsMandatory        *bool  `json:"isMandatory"`
+	// 发布时附带的自定义键值(json),原样返回给SDK
+	Metadata *string `json:"metadata"`
+	// 多语言description(json, locale -> 文本)
+	Descriptions *string `json:"descriptions"`
+	// tar.zst格式的包
+	ZstdDownload *string `json:"zstdDownload"`
+	ZstdSize     *int64  `json:"zstdSize"`
+	// CI提供的来源信息(json),用于生成attestation
+	Provenance *string `json:"provenance"`
+	// 存储中zip的sha256,第一次生成attestation时计算
+	BlobSha256 *string `json:"blobSha256"`
+	// 乐观锁版本,只由BumpRowVersion修改,作为ETag返回
+	RowVersion *int `gorm:"default:0;<-:create" json:"rowVersion"`
 }
 
 func (Package) TableName() string {
 	return "package"
 }
 
-func (Package) AddActive(pid int) {
-	userDb.Raw("update package set active=active+1 where id=?", pid).Scan(&Package{})
+// packageId -> [active, failed, installed]的增量,在一个事务中写入
+func (Package) AddCounts(counts map[int][3]int) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	events := MetricEventsEnabled()
+	now := utils.GetTimeNow()
+	return userDb.Transaction(func(tx *gorm.DB) error {
+		for pid, c := range counts {
+			if err := tx.Exec("update package set active=active+?,failed=failed+?,installed=installed+? where id=?", c[0], c[1], c[2], pid).Error; err != nil {
+				return err
+			}
+			if !events {
+				continue
+			}
+			// 增量和计数一起提交,汇总数据出错时可以重新计算
+			if err := tx.Exec("insert into metric_event (deployment_id,package_id,active,failed,installed,create_time) select deployment_id,id,?,?,?,? from package where id=?", c[0], c[1], c[2], *now, pid).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (Package) AddFailed(pid int) {
-	userDb.Raw("update package set failed=failed+1 where id=?", pid).Scan(&Package{})
+func (Package) GetRollbackPack(deploymentId int, lastPakcId int, deploymentVersionId int) *Package {
+	var lastPackage *Package
+	err := userDb.Where("deployment_id=?", deploymentId).Where("id<?", lastPakcId).Where("deployment_version_id", deploymentVersionId).Where("status is null or status=?", constants.PACKAGE_STATUS_APPROVED).Order("id desc").First(&lastPackage).Error
+	if err != nil {
+		return nil
+	}
+	return lastPackage
 }
 
-func (Package) AddInstalled(pid int) {
-	userDb.Raw("update package set installed=installed+1 where id=?", pid).Scan(&Package{})
+// 没有release_history时按发布时间推断某个时间点的当前包
+func (Package) GetReleasedBefore(deploymentVersionId int, time int64) *Package {
+	var pack *Package
+	err := userDb.Where("deployment_version_id=? and create_time<=?", deploymentVersionId, time).Where("status is null or status=?", constants.PACKAGE_STATUS_APPROVED).Order("id desc").First(&pack).Error
+	if err != nil {
+		return nil
+	}
+	return pack
 }
 
-func (Package) GetRollbackPack(deploymentId int, lastPakcId int, deploymentVersionId int) *Package {
-	var lastPackage *Package
-	err := userDb.Where("deployment_id=?", deploymentId).Where("id<?", lastPakcId).Where("deployment_version_id", deploymentVersionId).Order("id desc").First(&lastPackage).Error
+// 等待复制并且已到重试时间的包
+func (Package) GetReplicationDue(now int64, limit int) *[]Package {
+	var packs *[]Package
+	err := userDb.Where("replication_status=? and (replication_next_time is null or replication_next_time<=?)", constants.REPLICATION_PENDING, now).Order("id").Limit(limit).Find(&packs).Error
 	if err != nil {
 		return nil
 	}
-	return lastPackage
+	return packs
+}
+
+func (Package) UpdateReplicationStatus(pid int, status string) {
+	userDb.Raw("update package set replication_status=? where id=?", status, pid).Scan(&Package{})
+}
+
+func (Package) UpdateReplicationRetry(pid int, attempts int, next int64) {
+	userDb.Raw("update package set replication_attempts=?,replication_next_time=? where id=?", attempts, next, pid).Scan(&Package{})
+}
+
+func (Package) UpdateRollout(tx *gorm.DB, pid int, rollout int, paused bool) error {
+	return tx.Exec("update package set rollout=?,rollout_paused=? where id=?", rollout, paused, pid).Error
+}
+
+// start为nil时取消自动灰度;tx为nil时不使用事务
+func (Package) UpdateRolloutRamp(tx *gorm.DB, pid int, start *int64, minutes int, steps int, from int) error {
+	if tx == nil {
+		tx = userDb
+	}
+	if start == nil {
+		return tx.Exec("update package set rollout_ramp_start=null,rollout_ramp_minutes=null,rollout_ramp_steps=null,rollout_ramp_from=null where id=?", pid).Error
+	}
+	return tx.Exec("update package set rollout_ramp_start=?,rollout_ramp_minutes=?,rollout_ramp_steps=?,rollout_ramp_from=? where id=?", *start, minutes, steps, from, pid).Error
+}
+
+// 正在自动灰度且没有暂停的已发布包
+func (Package) GetRolloutRamping(limit int) *[]Package {
+	var packs *[]Package
+	err := userDb.Where("rollout_ramp_start is not null").Where("rollout_paused is null or rollout_paused=0").Where("status is null or status=?", constants.PACKAGE_STATUS_APPROVED).Order("id").Limit(limit).Find(&packs).Error
+	if err != nil {
+		return nil
+	}
+	return packs
+}
+
+func (Package) UpdateZstd(pid int, download string, size int64) {
+	userDb.Raw("update package set zstd_download=?,zstd_size=? where id=?", download, size, pid).Scan(&Package{})
+}
+
+func (Package) UpdateLabel(pid int, label string) {
+	userDb.Raw("update package set label=? where id=?", label, pid).Scan(&Package{})
+}
+
+// 发布标签,多区域部署时使用region或uuid避免标签冲突
+func (Package) NewLabel(pid int) string {
+	configs := config.GetConfig()
+	switch configs.LabelMode {
+	case "region":
+		return configs.Region + "-" + strconv.Itoa(pid)
+	case "uuid":
+		return uuid.NewString()
+	}
+	return strconv.Itoa(pid)
+}
+
+func (Package) AllocateLabel(pack *Package) {
+	label := Package{}.NewLabel(*pack.Id)
+	Package{}.UpdateLabel(*pack.Id, label)
+	pack.Label = &label
+}
+
+func (Package) GetByIdempotencyKey(deploymentId int, key string) *Package {
+	var pack *Package
+	err := userDb.Where("deployment_id", deploymentId).Where("idempotency_key", key).First(&pack).Error
+	if err != nil {
+		return nil
+	}
+	return pack
+}
+
+// 写入package时违反了uk_idempotency_key,即同一个Idempotency-Key的并发请求已经创建了包
+func IsDuplicateIdempotencyKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "uk_idempotency_key")
+}
+
+func (Package) UpdateStatus(tx *gorm.DB, pid int, status string, approvedBy *int) error {
+	return tx.Exec("update package set status=?,approved_by=? where id=?", status, approvedBy, pid).Error
+}
+
+func (Package) GetByDeploymentIdAndStatus(deploymentId int, status string) *[]Package {
+	var packs *[]Package
+	err := userDb.Where("deployment_id", deploymentId).Where("status", status).Order("id").Find(&packs).Error
+	if err != nil {
+		return nil
+	}
+	return packs
+}
+
+// 只查询计数字段,用于指标汇总
+func (Package) GetCounters() *[]Package {
+	var packs *[]Package
+	err := userDb.Select("id,deployment_id,active,failed,installed").Order("id").Find(&packs).Error
+	if err != nil {
+		return nil
+	}
+	return packs
+}
+
+// 某个时间之后发布(审批通过或不需要审批)的包
+func (Package) GetReleasedAfter(createTime int64) *[]Package {
+	var packs *[]Package
+	err := userDb.Where("create_time>=?", createTime).Where("status is null or status=?", constants.PACKAGE_STATUS_APPROVED).Order("id").Find(&packs).Error
+	if err != nil {
+		return nil
+	}
+	return packs
+}
+
+// 同一版本下最近的几个已发布的历史包,用于生成差量包
+func (Package) GetDiffBasePacks(deploymentVersionId int, packageId int, limit int) *[]Package {
+	var packs *[]Package
+	err := userDb.Where("deployment_version_id", deploymentVersionId).Where("id<?", packageId).Where("status is null or status=?", constants.PACKAGE_STATUS_APPROVED).Order("id desc").Limit(limit).Find(&packs).Error
+	if err != nil {
+		return nil
+	}
+	return packs
+}
+
+// 客户端上报时只有deploymentKey和label
+func (Package) GetByDeploymentKeyAndLabel(deploymentKey string, label string) *Package {
+	var pack *Package
+	err := userDb.Joins("join deployment on deployment.id=package.deployment_id").Where("deployment.key = ? or deployment.key_hmac in ?", deploymentKey, KeyLookupValues(deploymentKey)[1:]).Where("package.label", label).First(&pack).Error
+	if err != nil {
+		return nil
+	}
+	return pack
+}
+
+// create_time之后创建、还没有差量包记录和zstd包的包
+func (Package) GetWithoutDiff(since int64, limit int) *[]Package {
+	var packs *[]Package
+	err := userDb.Where("create_time>=? and zstd_download is null and not exists (select 1 from package_diff where package_diff.package_id=package.id)", since).
+		Order("id").Limit(limit).Find(&packs).Error
+	if err != nil {
+		return nil
+	}
+	return packs
+}
+
+// 部署中已使用的标签,包括回收站中还可以恢复的;tx为nil时不使用事务
+func (Package) LabelTaken(tx *gorm.DB, deploymentId int, label string) bool {
+	if tx == nil {
+		tx = userDb
+	}
+	var count int64
+	tx.Raw("select (select count(*) from package where deployment_id=? and label=?)+(select count(*) from package_tombstone where deployment_id=? and label=?)",
+		deploymentId, label, deploymentId, label).Scan(&count)
+	return count > 0
+}
+
+func (Package) GetByDeploymentIdAndLabel(deploymentId int, label string) *Package {
+	var pack *Package
+	err := userDb.Where("deployment_id", deploymentId).Where("label", label).First(&pack).Error
+	if err != nil {
+		return nil
+	}
+	return pack
+}
+
+// 其他部署中引用同一个文件的包的数量
+func (Package) CountOtherByDownload(deploymentId int, download string) int64 {
+	var count int64
+	userDb.Model(&Package{}).Where("deployment_id<>?", deploymentId).Where("download=? or zstd_download=?", download, download).Count(&count)
+	return count
+}
+
+func (Package) CountByDownload(download string) int64 {
+	var count int64
+	userDb.Model(&Package{}).Where("download=? or zstd_download=?", download, download).Count(&count)
+	return count
+}
+
+// 每个版本保留最新的keep个包,当前包不删除
+func (Package) GetBeyondRetention(keep int, limit int) *[]Package {
+	var packs *[]Package
+	err := userDb.Where("(select count(*) from package n where n.deployment_version_id=package.deployment_version_id and n.id>package.id)>=?", keep).
+		Where("id not in (select current_package from deployment_version where current_package is not null)").
+		Order("id").Limit(limit).Find(&packs).Error
+	if err != nil {
+		return nil
+	}
+	return packs
+}
+
+func (Package) UpdateBlobSha256(pid int, sha256 string) {
+	userDb.Raw("update package set blob_sha256=? where id=?", sha256, pid).Scan(&Package{})
 }
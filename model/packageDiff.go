@@ -0,0 +1,41 @@
+package model
+
+import "com.lc.go.codepush/server/model/constants"
+
+// 新发布包相对历史包的差量包
+type PackageDiff struct {
+	Id            *int    `gorm:"primarykey;autoIncrement;size:32"`
+	PackageId     *int    `json:"packageId"`
+	BasePackageId *int    `json:"basePackageId"`
+	BaseHash      *string `json:"baseHash"`
+	Download      *string `json:"download"`
+	Size          *int64  `json:"size"`
+	Status        *string `json:"status"`
+	Error         *string `json:"error"`
+	WaitMs        *int64  `json:"waitMs"`
+	DurationMs    *int64  `json:"durationMs"`
+	CreateTime    *int64  `json:"createTime"`
+}
+
+func (PackageDiff) TableName() string {
+	return "package_diff"
+}
+
+func (PackageDiff) GetSucceededByPackageId(packageId int) *[]PackageDiff {
+	var diffs *[]PackageDiff
+	err := userDb.Where("package_id", packageId).Where("status", constants.JOB_STATUS_SUCCEEDED).Find(&diffs).Error
+	if err != nil {
+		return nil
+	}
+	return diffs
+}
+
+func (PackageDiff) Exists(packageId int, basePackageId int) bool {
+	var count int64
+	userDb.Model(&PackageDiff{}).Where("package_id=? and base_package_id=?", packageId, basePackageId).Count(&count)
+	return count > 0
+}
+
+func (PackageDiff) DeleteByPackageId(packageId int) error {
+	return userDb.Where("package_id", packageId).Delete(PackageDiff{}).Error
+}
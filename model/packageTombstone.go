@@ -0,0 +1,40 @@
+package model
+
+// 删除的发布包(回收站),文件保留到purge
+type PackageTombstone struct {
+	Id                  *int    `gorm:"primarykey;autoIncrement;size:32"`
+	PackageId           *int    `json:"packageId"`
+	DeploymentId        *int    `json:"deploymentId"`
+	DeploymentVersionId *int    `json:"deploymentVersionId"`
+	Label               *string `json:"label"`
+	Hash                *string `json:"hash"`
+	Size                *int64  `json:"size"`
+	Download            *string `json:"-"`
+	ZstdDownload        *string `json:"-"`
+	Description         *string `json:"description"`
+	Reason              *string `json:"reason"`
+	// 0表示保留策略自动删除
+	Uid         *int   `json:"uid"`
+	ReleaseTime *int64 `json:"releaseTime"`
+	DeleteTime  *int64 `json:"deleteTime"`
+}
+
+func (PackageTombstone) TableName() string {
+	return "package_tombstone"
+}
+
+func (PackageTombstone) GetByDeploymentId(deploymentId int) *[]PackageTombstone {
+	var tombstones *[]PackageTombstone
+	err := userDb.Where("deployment_id", deploymentId).Order("id desc").Find(&tombstones).Error
+	if err != nil {
+		return nil
+	}
+	return tombstones
+}
+
+// 其他删除记录中引用同一个文件的数量
+func (PackageTombstone) CountOtherByDownload(id int, download string) int64 {
+	var count int64
+	userDb.Model(&PackageTombstone{}).Where("id<>?", id).Where("download=? or zstd_download=?", download, download).Count(&count)
+	return count
+}
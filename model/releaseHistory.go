@@ -0,0 +1,56 @@
+package model
+
+import (
+	"com.lc.go.codepush/server/utils"
+	"gorm.io/gorm"
+)
+
+// deployment_version.current_package的每次变更,用于查询某个时间点下发的包
+type ReleaseHistory struct {
+	Id                  *int `gorm:"primarykey;autoIncrement;size:32"`
+	DeploymentVersionId *int `json:"deploymentVersionId"`
+	// nil表示回滚到安装包自带的bundle
+	PackageId  *int    `json:"packageId"`
+	Action     *string `json:"action"`
+	Uid        *int    `json:"uid"`
+	CreateTime *int64  `json:"createTime"`
+}
+
+func (ReleaseHistory) TableName() string {
+	return "release_history"
+}
+
+// tx为nil时直接写入
+func (ReleaseHistory) Add(tx *gorm.DB, deploymentVersionId int, packageId *int, action string, uid int) error {
+	if tx == nil {
+		tx = userDb
+	}
+	history := ReleaseHistory{
+		DeploymentVersionId: &deploymentVersionId,
+		PackageId:           packageId,
+		Action:              &action,
+		Uid:                 &uid,
+		CreateTime:          utils.GetTimeNow(),
+	}
+	return tx.Create(&history).Error
+}
+
+// time时已经生效的最后一次变更
+func (ReleaseHistory) GetAt(deploymentVersionId int, time int64) *ReleaseHistory {
+	var history *ReleaseHistory
+	err := userDb.Where("deployment_version_id=? and create_time<=?", deploymentVersionId, time).Order("create_time desc,id desc").First(&history).Error
+	if err != nil {
+		return nil
+	}
+	return history
+}
+
+// 开始记录之前的时间点只能按发布时间推断
+func (ReleaseHistory) GetFirst(deploymentVersionId int) *ReleaseHistory {
+	var history *ReleaseHistory
+	err := userDb.Where("deployment_version_id", deploymentVersionId).Order("create_time,id").First(&history).Error
+	if err != nil {
+		return nil
+	}
+	return history
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+)
+
+// 异步上传和发布任务,执行中的任务定期刷新update_time,长时间没有刷新的任务由其他实例接手
+type ReleaseJob struct {
+	ProcessingId *string `gorm:"primarykey" json:"processingId"`
+	Uid          *int    `json:"-"`
+	Kind         *string `json:"kind"`
+	Status       *string `json:"status"`
+	// 重新执行需要的请求内容(json)
+	Payload    *string `json:"-"`
+	Result     *string `json:"-"`
+	Error      *string `json:"error,omitempty"`
+	CreateTime *int64  `json:"createTime"`
+	UpdateTime *int64  `json:"updateTime"`
+}
+
+func (ReleaseJob) TableName() string {
+	return "release_job"
+}
+
+func (ReleaseJob) Get(uid int, processingId string) *ReleaseJob {
+	var job *ReleaseJob
+	err := userDb.Where("processing_id=? and uid=?", processingId, uid).First(&job).Error
+	if err != nil {
+		return nil
+	}
+	return job
+}
+
+func (ReleaseJob) Finish(processingId string, status string, result *string, errMsg *string) error {
+	return userDb.Exec("update release_job set status=?,result=?,error=?,update_time=? where processing_id=?", status, result, errMsg, *utils.GetTimeNow(), processingId).Error
+}
+
+func (ReleaseJob) Heartbeat(processingId string) {
+	userDb.Exec("update release_job set update_time=? where processing_id=? and status=?", *utils.GetTimeNow(), processingId, constants.JOB_STATUS_RUNNING)
+}
+
+// update_time早于before的未完成任务
+func (ReleaseJob) GetStale(before int64) *[]ReleaseJob {
+	var jobs *[]ReleaseJob
+	err := userDb.Where("status in ? and update_time<?", []string{constants.JOB_STATUS_PENDING, constants.JOB_STATUS_RUNNING}, before).Find(&jobs).Error
+	if err != nil {
+		return nil
+	}
+	return jobs
+}
+
+// 按读取时的update_time接手任务,多个实例同时接手时只有一个成功
+func (ReleaseJob) Claim(processingId string, updateTime int64) bool {
+	result := userDb.Exec("update release_job set status=?,update_time=? where processing_id=? and update_time=? and status in ?",
+		constants.JOB_STATUS_RUNNING, *utils.GetTimeNow(), processingId, updateTime, []string{constants.JOB_STATUS_PENDING, constants.JOB_STATUS_RUNNING})
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func (ReleaseJob) DeleteFinishedBefore(before int64) error {
+	return userDb.Exec("delete from release_job where status in ? and update_time<?", []string{constants.JOB_STATUS_SUCCEEDED, constants.JOB_STATUS_FAILED}, before).Error
+}
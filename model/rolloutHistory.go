@@ -0,0 +1,39 @@
+package model
+
+import "com.lc.go.codepush/server/utils"
+
+// 每次灰度变更的记录
+type RolloutHistory struct {
+	Id          *int    `gorm:"primarykey;autoIncrement;size:32"`
+	PackageId   *int    `json:"packageId"`
+	Uid         *int    `json:"uid"`
+	Action      *string `json:"action"`
+	FromRollout *int    `json:"fromRollout"`
+	ToRollout   *int    `json:"toRollout"`
+	CreateTime  *int64  `json:"createTime"`
+}
+
+func (RolloutHistory) TableName() string {
+	return "rollout_history"
+}
+
+func (RolloutHistory) Add(packageId int, uid int, action string, from *int, to int) {
+	history := RolloutHistory{
+		PackageId:   &packageId,
+		Uid:         &uid,
+		Action:      &action,
+		FromRollout: from,
+		ToRollout:   &to,
+		CreateTime:  utils.GetTimeNow(),
+	}
+	Create[RolloutHistory](&history)
+}
+
+func (RolloutHistory) GetByPackageId(packageId int) *[]RolloutHistory {
+	var list *[]RolloutHistory
+	err := userDb.Where("package_id", packageId).Order("id").Find(&list).Error
+	if err != nil {
+		return nil
+	}
+	return list
+}
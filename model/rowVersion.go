@@ -0,0 +1,33 @@
+package model
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var ErrRowVersion = errors.New("row version conflict")
+
+// 乐观锁: 修改apps/deployment/package前把row_version加一并返回新值;
+// expected不为nil时只有当前版本等于expected才修改,否则返回ErrRowVersion
+func BumpRowVersion(tx *gorm.DB, table string, id int, expected *int) (int, error) {
+	if tx == nil {
+		tx = userDb
+	}
+	query := "update `" + table + "` set row_version=row_version+1 where id=?"
+	args := []any{id}
+	if expected != nil {
+		query += " and row_version=?"
+		args = append(args, *expected)
+	}
+	result := tx.Exec(query, args...)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, ErrRowVersion
+	}
+	var version int
+	err := tx.Raw("select row_version from `"+table+"` where id=?", id).Scan(&version).Error
+	return version, err
+}
@@ -0,0 +1,62 @@
+package model
+
+import "strings"
+
+type SearchApp struct {
+	AppName     string `json:"appName"`
+	DisplayName string `json:"displayName,omitempty"`
+	Platform    string `json:"platform,omitempty"`
+}
+
+type SearchDeployment struct {
+	AppName    string `json:"appName"`
+	Deployment string `json:"deployment"`
+}
+
+type SearchRelease struct {
+	AppName     string `json:"appName"`
+	Deployment  string `json:"deployment"`
+	AppVersion  string `json:"appVersion"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Status      string `json:"status,omitempty"`
+	CreateTime  int64  `json:"createTime"`
+}
+
+// like中的%和_按字面匹配
+func likePattern(q string) string {
+	q = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q)
+	return "%" + q + "%"
+}
+
+// 只查询uid自己的应用
+func (SearchApp) Find(uid int, q string, limit int) []SearchApp {
+	rows := []SearchApp{}
+	userDb.Raw("select app_name,ifnull(display_name,'') display_name,ifnull(platform,'') platform from apps where uid=? and (app_name like ? or display_name like ?) order by app_name limit ?",
+		uid, likePattern(q), likePattern(q), limit).Scan(&rows)
+	return rows
+}
+
+func (SearchDeployment) Find(uid int, q string, limit int) []SearchDeployment {
+	rows := []SearchDeployment{}
+	userDb.Raw("select a.app_name,d.name deployment from deployment d join apps a on d.app_id=a.id where a.uid=? and d.name like ? order by a.app_name,d.name limit ?",
+		uid, likePattern(q), limit).Scan(&rows)
+	return rows
+}
+
+// fulltext为true时使用package上的FULLTEXT索引,否则使用like;标签总是精确匹配
+func (SearchRelease) Find(uid int, q string, limit int, fulltext bool) []SearchRelease {
+	rows := []SearchRelease{}
+	match := "p.description like ? or p.descriptions like ?"
+	args := []any{uid, q, likePattern(q), likePattern(q), limit}
+	if fulltext {
+		match = "match(p.description,p.descriptions) against(? in natural language mode)"
+		args = []any{uid, q, q, limit}
+	}
+	userDb.Raw("select a.app_name,d.name deployment,ifnull(v.app_version,'') app_version,ifnull(p.label,'') label,"+
+		"ifnull(p.description,'') description,ifnull(p.status,'') status,ifnull(p.create_time,0) create_time "+
+		"from package p join deployment d on p.deployment_id=d.id join apps a on d.app_id=a.id "+
+		"left join deployment_version v on p.deployment_version_id=v.id "+
+		"where a.uid=? and (p.label=? or "+match+") order by p.id desc limit ?", args...).Scan(&rows)
+	return rows
+}
@@ -0,0 +1,35 @@
+package model
+
+// 只写入了备用存储,等待同步回主存储的对象
+type StoragePending struct {
+	Id         *int    `gorm:"primarykey;autoIncrement;size:32"`
+	ObjectKey  *string `json:"objectKey"`
+	Provider   *string `json:"provider"`
+	CreateTime *int64  `json:"createTime"`
+}
+
+func (StoragePending) TableName() string {
+	return "storage_pending"
+}
+
+func (StoragePending) GetByObjectKey(key string) *StoragePending {
+	var pending *StoragePending
+	err := userDb.Where("object_key", key).First(&pending).Error
+	if err != nil {
+		return nil
+	}
+	return pending
+}
+
+func (StoragePending) GetList(limit int) *[]StoragePending {
+	var pendings *[]StoragePending
+	err := userDb.Order("id").Limit(limit).Find(&pendings).Error
+	if err != nil {
+		return nil
+	}
+	return pendings
+}
+
+func (StoragePending) DeleteByObjectKey(key string) error {
+	return userDb.Where("object_key", key).Delete(StoragePending{}).Error
+}
@@ -0,0 +1,88 @@
+package model
+
+// 开通的租户账号和套餐限制,限制为空或0表示不限制
+type TenantPlan struct {
+	Id     *int    `gorm:"primarykey;autoIncrement;size:32"`
+	Tenant *string `json:"tenant"`
+	Uid    *int    `json:"uid"`
+	Plan   *string `json:"plan"`
+	// 门户中的外部ID,例如客户编号
+	ExternalId     *string `json:"externalId"`
+	MaxApps        *int    `json:"maxApps"`
+	MaxDeployments *int    `json:"maxDeployments"`
+	MaxStorageGB   *int    `gorm:"column:max_storage_gb" json:"maxStorageGB"`
+	// 每月update_check的不同客户端数
+	MaxMonthlyActive *int `json:"maxMonthlyActive"`
+	// 为空时按全局的totp_required
+	TotpRequired *bool  `json:"totpRequired"`
+	CreateTime   *int64 `json:"createTime"`
+	UpdateTime   *int64 `json:"updateTime"`
+}
+
+func (TenantPlan) TableName() string {
+	return "tenant_plan"
+}
+
+func (TenantPlan) GetByTenant(tenant string) *[]TenantPlan {
+	var plans *[]TenantPlan
+	err := userDb.Where("tenant", tenant).Order("id").Find(&plans).Error
+	if err != nil {
+		return nil
+	}
+	return plans
+}
+
+func (TenantPlan) GetByUid(uid int) *TenantPlan {
+	var plan *TenantPlan
+	err := userDb.Where("uid", uid).First(&plan).Error
+	if err != nil {
+		return nil
+	}
+	return plan
+}
+
+// 账号是否必须开启两步验证,套餐没有设置时返回defaultValue
+func (TenantPlan) RequiresTotp(uid int, defaultValue bool) bool {
+	plan := TenantPlan{}.GetByUid(uid)
+	if plan == nil || plan.TotpRequired == nil {
+		return defaultValue
+	}
+	return *plan.TotpRequired
+}
+
+func (TenantPlan) CountApps(uid int) int64 {
+	var count int64
+	userDb.Model(&App{}).Where("uid", uid).Count(&count)
+	return count
+}
+
+func (TenantPlan) CountDeployments(uid int) int64 {
+	var count int64
+	userDb.Model(&Deployment{}).Where("app_id in (?)", userDb.Model(&App{}).Select("id").Where("uid", uid)).Count(&count)
+	return count
+}
+
+// 账号下所有包(不含差量包)的大小
+func (TenantPlan) StorageBytes(uid int) int64 {
+	var size int64
+	userDb.Raw("select coalesce(sum(p.size),0)+coalesce(sum(p.zstd_size),0) from package p join deployment d on p.deployment_id=d.id join apps a on d.app_id=a.id where a.uid=?", uid).Scan(&size)
+	return size
+}
+
+// 修改套餐后刷新这些部署的update_check缓存
+func (TenantPlan) DeploymentKeys(uid int) []string {
+	var deployments []Deployment
+	userDb.Where("app_id in (?)", userDb.Model(&App{}).Select("id").Where("uid", uid)).Find(&deployments)
+	keys := make([]string, 0, len(deployments))
+	for _, deployment := range deployments {
+		if deployment.Key != nil {
+			keys = append(keys, *deployment.Key)
+		}
+	}
+	return keys
+}
+
+func (TenantPlan) UpdateLimits(plan *TenantPlan) error {
+	return userDb.Raw("update tenant_plan set plan=?,external_id=?,max_apps=?,max_deployments=?,max_storage_gb=?,max_monthly_active=?,totp_required=?,update_time=? where id=?",
+		plan.Plan, plan.ExternalId, plan.MaxApps, plan.MaxDeployments, plan.MaxStorageGB, plan.MaxMonthlyActive, plan.TotpRequired, plan.UpdateTime, plan.Id).Scan(&TenantPlan{}).Error
+}
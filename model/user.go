@@ -1,15 +1,101 @@
 package model
 
+import (
+	"errors"
+
+	"com.lc.go.codepush/server/model/constants"
+	"gorm.io/gorm"
+)
+
 type User struct {
 	Id       *int    `gorm:"primarykey;autoIncrement;size:32"`
 	UserName *string `gorm:"size:200" json:"userName"`
 	Password *string `gorm:"size:45" json:"-"`
+	Role     *string `gorm:"size:45" json:"role"`
+
+	TotpSecret        *string `json:"-"`
+	TotpEnabled       *bool   `json:"totpEnabled"`
+	TotpRecoveryCodes *string `json:"-"`
+	// 最近一次使用的动态码时间步,同一个动态码不能重复使用
+	TotpLastStep *int64 `json:"-"`
+
+	// 外部认证(oidc、ldap)创建的账号,按provider、issuer和subject识别,不按用户名
+	AuthProvider *string `gorm:"size:16" json:"-"`
+	AuthIssuer   *string `gorm:"size:255" json:"-"`
+	AuthSubject  *string `gorm:"size:255" json:"-"`
 }
 
 func (User) TableName() string {
 	return "users"
 }
 
+func (User) GetByExternalId(provider string, issuer string, subject string) *User {
+	var user *User
+	err := userDb.Where("auth_provider=? and auth_issuer=? and auth_subject=?", provider, issuer, subject).First(&user).Error
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
 func (User) ChangePassword(uid int, password string) error {
 	return userDb.Raw("update users set password=? where id=?", password, uid).Scan(&User{}).Error
 }
+
+// 没有设置角色的账号按developer处理,管理员需要明确设置role=admin
+func (u User) GetRole() string {
+	if u.Role == nil || *u.Role == "" {
+		return constants.ROLE_DEVELOPER
+	}
+	return *u.Role
+}
+
+func (User) UpdateRole(uid int, role string) error {
+	return userDb.Raw("update users set role=? where id=?", role, uid).Scan(&User{}).Error
+}
+
+func (User) UpdateTotp(uid int, secret *string, enabled bool, recoveryCodes *string) error {
+	return userDb.Raw("update users set totp_secret=?,totp_enabled=?,totp_recovery_codes=? where id=?", secret, enabled, recoveryCodes, uid).Scan(&User{}).Error
+}
+
+// 只接受比上次更晚的时间步,并发提交同一个动态码时只有一个成功
+func (User) UseTotpStep(uid int, step int64) bool {
+	result := userDb.Exec("update users set totp_last_step=? where id=? and (totp_last_step is null or totp_last_step<?)", step, uid, step)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func (User) Count() int64 {
+	var count int64
+	userDb.Model(&User{}).Count(&count)
+	return count
+}
+
+// 第一个账号固定使用id=1,多个实例同时初始化时由主键冲突保证只有一个成功;
+// 用户表不为空时返回false
+func (User) CreateFirst(user *User, token *Token) (bool, error) {
+	err := userDb.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&User{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errUsersExist
+		}
+		id := 1
+		user.Id = &id
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		token.Uid = user.Id
+		return tx.Create(token).Error
+	})
+	if err == nil {
+		return true, nil
+	}
+	if err == errUsersExist || (User{}).Count() > 0 {
+		return false, nil
+	}
+	return false, err
+}
+
+var errUsersExist = errors.New("users already exist")
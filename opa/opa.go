@@ -0,0 +1,67 @@
+package opa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/egress"
+)
+
+type Decision struct {
+	Allow   bool
+	Reasons []string
+}
+
+func Enabled() bool {
+	return config.GetConfig().Opa.Url != ""
+}
+
+// POST /v1/data/<path>,策略没有定义结果时视为拒绝
+func Evaluate(input any) (*Decision, error) {
+	c := config.GetConfig().Opa
+	data, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	client := egress.Client(time.Duration(c.Timeout) * time.Millisecond)
+	rep, err := client.Post(c.Url+"/v1/data/"+c.Path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+	if rep.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", rep.StatusCode)
+	}
+	body := struct {
+		Result json.RawMessage `json:"result"`
+	}{}
+	if err := json.NewDecoder(rep.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return parseResult(body.Result)
+}
+
+func parseResult(result json.RawMessage) (*Decision, error) {
+	if len(result) == 0 {
+		return &Decision{Reasons: []string{"policy " + config.GetConfig().Opa.Path + " is undefined"}}, nil
+	}
+	allow := false
+	if err := json.Unmarshal(result, &allow); err == nil {
+		return &Decision{Allow: allow}, nil
+	}
+	obj := struct {
+		Allow   bool     `json:"allow"`
+		Reasons []string `json:"reasons"`
+		// 常见的deny规则写法,有内容时拒绝
+		Deny []string `json:"deny"`
+	}{}
+	if err := json.Unmarshal(result, &obj); err != nil {
+		return nil, fmt.Errorf("unexpected result %s", string(result))
+	}
+	decision := &Decision{Allow: obj.Allow && len(obj.Deny) == 0, Reasons: append(obj.Reasons, obj.Deny...)}
+	return decision, nil
+}
@@ -0,0 +1,131 @@
+package report
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/sentry"
+)
+
+// 计数的下标与model.Package{}.AddCounts一致
+const (
+	ACTIVE = iota
+	FAILED
+	INSTALLED
+)
+
+type event struct {
+	deploymentKey string
+	label         string
+	kind          int
+}
+
+// 同一个包的多次上报合并为一次更新
+type batchKey struct {
+	deploymentKey string
+	label         string
+}
+
+var queue chan event
+
+var started atomic.Bool
+
+// 按report_workers启动写入worker,为0时不启动,每个请求直接写入
+func Start() {
+	c := config.GetConfig().Report
+	if c.Workers == 0 {
+		return
+	}
+	queue = make(chan event, c.QueueSize)
+	for i := uint(0); i < c.Workers; i++ {
+		go worker()
+	}
+	started.Store(true)
+}
+
+// 队列满时直接写入,不丢弃上报
+func Add(deploymentKey string, label string, kind int) {
+	if label == "" {
+		return
+	}
+	e := event{deploymentKey: deploymentKey, label: label, kind: kind}
+	if started.Load() {
+		select {
+		case queue <- e:
+			return
+		default:
+			metrics.Count("report.queue_full", 1, nil)
+		}
+	}
+	flush([]event{e})
+}
+
+func worker() {
+	c := config.GetConfig().Report
+	ticker := time.NewTicker(time.Duration(c.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+	batch := make([]event, 0, c.BatchSize)
+	for {
+		select {
+		case e := <-queue:
+			batch = append(batch, e)
+			if len(batch) < int(c.BatchSize) {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func flush(batch []event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("report: flush %d events error:%v", len(batch), r)
+			sentry.CapturePanic("report", r, nil)
+		}
+	}()
+	start := time.Now()
+	grouped := map[batchKey][3]int{}
+	for _, e := range batch {
+		k := batchKey{e.deploymentKey, e.label}
+		counts := grouped[k]
+		counts[e.kind]++
+		grouped[k] = counts
+	}
+	counts := make(map[int][3]int, len(grouped))
+	for k, c := range grouped {
+		pack := resolve(k.deploymentKey, k.label)
+		if pack == nil {
+			continue
+		}
+		sum := counts[*pack.Id]
+		for i := range c {
+			sum[i] += c[i]
+		}
+		counts[*pack.Id] = sum
+	}
+	if err := (model.Package{}).AddCounts(counts); err != nil {
+		log.Printf("report: write %d packages error:%s", len(counts), err.Error())
+		sentry.CaptureError("report", err, nil)
+		return
+	}
+	metrics.Count("report.events", int64(len(batch)), nil)
+	metrics.Timing("report.flush", time.Since(start), nil)
+}
+
+// 标签只在部署内唯一,旧版SDK没有deployment_key时按标签查询
+func resolve(deploymentKey string, label string) *model.Package {
+	if deploymentKey != "" {
+		return model.Package{}.GetByDeploymentKeyAndLabel(deploymentKey, label)
+	}
+	return model.GetOne[model.Package]("label=?", label)
+}
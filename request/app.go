@@ -2,25 +2,22 @@ package request
 
 import (
 	"bytes"
+	"io"
 	"log"
 	"net/http"
-	"os"
-	"path"
 
 	"com.lc.go.codepush/server/config"
 	"com.lc.go.codepush/server/db"
 	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/diff"
+	"com.lc.go.codepush/server/events"
 	"com.lc.go.codepush/server/model"
 	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/storage"
 	"com.lc.go.codepush/server/utils"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
-	"github.com/jlaffaye/ftp"
 	"gorm.io/gorm"
 )
 
@@ -37,11 +34,12 @@ func (App) CreateApp(ctx *gin.Context) {
 		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
 		oldApp := model.App{}.GetAppByUidAndAppName(uid, *createAppInfo.AppName)
 		if oldApp != nil {
-			log.Panic("AppName " + *createAppInfo.AppName + " exist")
+			panic(errConflict(constants.ERR_APP_EXISTS, "AppName "+*createAppInfo.AppName+" exist"))
 		}
 		if *createAppInfo.OS != 1 && *createAppInfo.OS != 2 {
-			log.Panic("OS error")
+			panic(errInvalid("oneof", "os", "must be 1 (iOS) or 2 (Android)"))
 		}
+		checkAppQuota(uid)
 		newApp := model.App{
 			Uid:        &uid,
 			AppName:    createAppInfo.AppName,
@@ -53,7 +51,7 @@ func (App) CreateApp(ctx *gin.Context) {
 			"success": true,
 		})
 	} else {
-		log.Panic(err.Error())
+		panic(bindError(err))
 	}
 }
 
@@ -62,75 +60,311 @@ type createBundleReq struct {
 	Deployment  *string `json:"deployment" binding:"required"`
 	DownloadUrl *string `json:"downloadUrl" binding:"required"`
 	Description *string `json:"description"`
-	Version     *string `json:"version" binding:"required"`
-	Size        *int64  `json:"size" binding:"required"`
-	Hash        *string `json:"hash" binding:"required"`
+	// 按客户端语言返回的description,例如 {"zh-TW":"..."}
+	Descriptions map[string]string `json:"descriptions"`
+	Version      *string           `json:"version"`
+	Size         *int64            `json:"size" binding:"required"`
+	Hash         *string           `json:"hash" binding:"required"`
+	BundleName   *string           `json:"bundleName"`
+	// 灰度百分比,为空时使用部署的defaultRollout,都没有时全量发布
+	Rollout *int `json:"rollout" binding:"omitempty,min=1,max=100"`
+	// 强制更新,为空时使用部署的defaultMandatory
+	IsMandatory *bool `json:"isMandatory"`
+	// 在update_check的metadata和X-CodePush-Meta-*响应头中返回
+	Metadata map[string]string `json:"metadata"`
+	// 预发布,只下发给带邀请码的客户端,之后用publishPrivateBundle公开
+	Private bool `json:"private"`
+
+	FreezeOverrideReason *string `json:"freezeOverrideReason"`
+	// 异步上传返回的processingId,异步发布时等待上传完成
+	UploadProcessingId *string `json:"uploadProcessingId"`
+	// CI的来源信息,getAttestation中返回
+	Provenance *provenanceReq `json:"provenance"`
+	// 构建bundle时对应的安装包信息: Info.plist、AndroidManifest.xml或JSON,version为空时从中推断
+	BinaryMetadata *string `json:"binaryMetadata"`
 }
 
 func (App) CreateBundle(ctx *gin.Context) {
 	createBundleReq := createBundleReq{}
 	if err := ctx.ShouldBindBodyWith(&createBundleReq, binding.JSON); err == nil {
 		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		tracker := newProgressTracker(ctx, constants.UPLOAD_STAGE_VALIDATING)
+		defer tracker.finish()
 
 		app := model.App{}.GetAppByUidAndAppName(uid, *createBundleReq.AppName)
 		if app == nil {
-			log.Panic("App not found")
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
 		}
+		warning := inferBinaryVersion(&createBundleReq.Version, createBundleReq.BinaryMetadata)
+		warning = joinWarning(warning, checkBinaryVersion(*app.Id, *createBundleReq.Version))
 		deployment := model.Deployment{}.GetByAppidAndName(*app.Id, *createBundleReq.Deployment)
 		if deployment == nil {
-			log.Panic("Deployment " + *createBundleReq.Deployment + " not found")
+			panic(errNotFound(constants.ERR_DEPLOYMENT_NOT_FOUND, "Deployment "+*createBundleReq.Deployment+" not found"))
+		}
+		// dry run不写入任何数据,也不返回之前请求已创建的包
+		dryRun := ctx.Query("dryRun") == "true"
+		// a retried request (e.g. after a region failover) returns the package created by the first attempt
+		idempotencyKey := ctx.GetHeader("Idempotency-Key")
+		if idempotencyKey != "" && !dryRun {
+			oldPack := model.Package{}.GetByIdempotencyKey(*deployment.Id, idempotencyKey)
+			if oldPack != nil {
+				ctx.JSON(http.StatusOK, gin.H{
+					"success": true,
+					"label":   oldPack.Label,
+				})
+				return
+			}
 		}
-		deploymentVersion := model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, *createBundleReq.Version)
-		if deploymentVersion == nil {
-			versionNum := utils.FormatVersionStr(*createBundleReq.Version)
-			deploymentVersion = &model.DeploymentVersion{
-				DeploymentId: deployment.Id,
-				AppVersion:   createBundleReq.Version,
-				VersionNum:   &versionNum,
-				CreateTime:   utils.GetTimeNow(),
+		checkFreeze(ctx, uid, deployment, createBundleReq.FreezeOverrideReason, dryRun)
+		// 异步发布执行时按当时的部署策略补默认值
+		queued := createBundleReq
+		applyDeploymentPolicy(deployment, &createBundleReq)
+		checkStorageQuota(*app.Uid, *createBundleReq.Size)
+		checkReleaseGate(ctx, uid, GATE_ACTION_RELEASE, deployment, createBundleReq)
+		if dryRun {
+			dryRunBundle(ctx, deployment, &createBundleReq, warning)
+			return
+		}
+		if ctx.Query("async") == "true" {
+			jobTracker := tracker.detach()
+			payload := &releaseJobPayload{
+				Request:        queued,
+				IdempotencyKey: idempotencyKey,
+				Warning:        warning,
+				ClientIp:       ctx.GetString(constants.GIN_CLIENT_IP),
+				UserAgent:      ctx.Request.UserAgent(),
+				Impersonator:   ctx.GetInt(constants.GIN_IMPERSONATOR),
 			}
-			model.Create[model.DeploymentVersion](deploymentVersion)
-
-			if deployment.VersionId != nil {
-				deploymentVersionOld := model.GetOne[model.DeploymentVersion]("id=?", *deployment.VersionId)
-				if utils.FormatVersionStr(*deploymentVersionOld.AppVersion) < utils.FormatVersionStr(*createBundleReq.Version) {
-					deployment.VersionId = deploymentVersion.Id
-					deployment.UpdateTime = utils.GetTimeNow()
-					model.Update[model.Deployment](deployment)
-				}
-			} else {
-				deployment.VersionId = deploymentVersion.Id
-				deployment.UpdateTime = utils.GetTimeNow()
-				model.Update[model.Deployment](deployment)
+			startReleaseJob(ctx, constants.JOB_KIND_RELEASE, payload, func(jobCtx *gin.Context, processingId string) gin.H {
+				defer jobTracker.finish()
+				return executeReleaseJob(jobCtx, processingId, payload)
+			})
+			return
+		}
+		ctx.JSON(http.StatusOK, releaseBundle(uid, app, deployment, &createBundleReq, idempotencyKey, warning))
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 创建发布包,同步和异步发布共用
+func releaseBundle(uid int, app *model.App, deployment *model.Deployment, createBundleReq *createBundleReq, idempotencyKey string, warning string) gin.H {
+	var newPackage *model.Package
+	userDb, _ := db.GetUserDB()
+	err := userDb.Transaction(func(tx *gorm.DB) error {
+		var err error
+		newPackage, err = createRelease(tx, uid, deployment, createBundleReq, idempotencyKey)
+		return err
+	})
+	if err != nil && idempotencyKey != "" && model.IsDuplicateIdempotencyKey(err) {
+		// 同一个Idempotency-Key的并发请求先提交了,返回它创建的包
+		if oldPack := (model.Package{}).GetByIdempotencyKey(*deployment.Id, idempotencyKey); oldPack != nil {
+			return gin.H{
+				"success": true,
+				"label":   oldPack.Label,
 			}
-		} else {
-			nowPack := model.GetOne[model.Package]("id=?", deploymentVersion.CurrentPackage)
-			if nowPack != nil && *nowPack.Hash == *createBundleReq.Hash {
-				log.Panic("Upload package no modification")
+		}
+	}
+	if err != nil {
+		log.Panic("ReleaseError:" + err.Error())
+	}
+	afterRelease(uid, deployment, newPackage)
+	rep := gin.H{
+		"success": true,
+		"label":   newPackage.Label,
+	}
+	if newPackage.Status != nil && *newPackage.Status != constants.PACKAGE_STATUS_APPROVED {
+		rep["status"] = *newPackage.Status
+	}
+	if warning != "" {
+		rep["warning"] = warning
+	}
+	return rep
+}
+
+func newerThanCurrent(versionId int, version string) bool {
+	current := model.GetOne[model.DeploymentVersion]("id=?", versionId)
+	return current == nil || utils.FormatVersionStr(*current.AppVersion) < utils.FormatVersionStr(version)
+}
+
+// 在事务中写入版本、包和deployment_lookup,批量发布时多个部署共用一个事务
+func createRelease(tx *gorm.DB, uid int, deployment *model.Deployment, createBundleReq *createBundleReq, idempotencyKey string) (*model.Package, error) {
+	deploymentVersion := model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, getBundleName(createBundleReq.BundleName), *createBundleReq.Version)
+	if deploymentVersion == nil {
+		versionNum := utils.FormatVersionStr(*createBundleReq.Version)
+		bundleName := getBundleName(createBundleReq.BundleName)
+		deploymentVersion = &model.DeploymentVersion{
+			DeploymentId: deployment.Id,
+			BundleName:   &bundleName,
+			AppVersion:   createBundleReq.Version,
+			VersionNum:   &versionNum,
+			CreateTime:   utils.GetTimeNow(),
+		}
+		if err := tx.Create(deploymentVersion).Error; err != nil {
+			return nil, err
+		}
+
+		// deployment.VersionId只记录主bundle的最新版本
+		if bundleName == "" && (deployment.VersionId == nil || newerThanCurrent(*deployment.VersionId, *createBundleReq.Version)) {
+			deployment.VersionId = deploymentVersion.Id
+			deployment.UpdateTime = utils.GetTimeNow()
+			if err := tx.Updates(deployment).Error; err != nil {
+				return nil, err
 			}
 		}
-		// uuid, _ := uuid.NewUUID()
-		// hash := uuid.String()
-		newPackage := model.Package{
-			DeploymentId:        deployment.Id,
-			DeploymentVersionId: deploymentVersion.Id,
-			Size:                createBundleReq.Size,
-			Hash:                createBundleReq.Hash,
-			Download:            createBundleReq.DownloadUrl,
-			Description:         createBundleReq.Description,
-			Active:              utils.CreateInt(0),
-			Installed:           utils.CreateInt(0),
-			Failed:              utils.CreateInt(0),
-			CreateTime:          utils.GetTimeNow(),
-		}
-		model.Create[model.Package](&newPackage)
+	} else {
+		nowPack := model.GetOne[model.Package]("id=?", deploymentVersion.CurrentPackage)
+		if nowPack != nil && *nowPack.Hash == *createBundleReq.Hash {
+			panic(errConflict(constants.ERR_DUPLICATE_PACKAGE, "Upload package no modification"))
+		}
+	}
+	// uuid, _ := uuid.NewUUID()
+	// hash := uuid.String()
+	newPackage := model.Package{
+		DeploymentId:        deployment.Id,
+		DeploymentVersionId: deploymentVersion.Id,
+		Size:                createBundleReq.Size,
+		Hash:                createBundleReq.Hash,
+		Download:            createBundleReq.DownloadUrl,
+		Description:         createBundleReq.Description,
+		Active:              utils.CreateInt(0),
+		Installed:           utils.CreateInt(0),
+		Failed:              utils.CreateInt(0),
+		CreateTime:          utils.GetTimeNow(),
+		Uid:                 &uid,
+		Rollout:             createBundleReq.Rollout,
+		IsMandatory:         createBundleReq.IsMandatory,
+		Metadata:            encodeMetadata(createBundleReq.Metadata),
+		Descriptions:        encodeLocalized("descriptions", createBundleReq.Descriptions),
+		Provenance:          encodeProvenance(createBundleReq.Provenance),
+	}
+	private := createBundleReq.Private
+	pending := !private && deployment.RequireApproval != nil && *deployment.RequireApproval
+	if pending {
+		status := constants.PACKAGE_STATUS_PENDING
+		newPackage.Status = &status
+	} else if private {
+		status := constants.PACKAGE_STATUS_PRIVATE
+		newPackage.Status = &status
+	}
+	if storage.ReplicaEnabled() {
+		replicationStatus := constants.REPLICATION_PENDING
+		newPackage.ReplicationStatus = &replicationStatus
+	}
+	if idempotencyKey != "" {
+		newPackage.IdempotencyKey = &idempotencyKey
+	}
+	if err := tx.Create(&newPackage).Error; err != nil {
+		return nil, err
+	}
+	label, err := releaseLabel(tx, deployment, *createBundleReq.Version, *newPackage.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&model.Package{}).Where("id", *newPackage.Id).Update("label", label).Error; err != nil {
+		return nil, err
+	}
+	newPackage.Label = &label
+	if !pending && !private {
 		deploymentVersion.CurrentPackage = newPackage.Id
 		deploymentVersion.UpdateTime = utils.GetTimeNow()
-		model.Update[model.DeploymentVersion](deploymentVersion)
-		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
-	} else {
-		log.Panic(err.Error())
+		if err := tx.Updates(deploymentVersion).Error; err != nil {
+			return nil, err
+		}
+		if err := (model.ReleaseHistory{}).Add(tx, *deploymentVersion.Id, newPackage.Id, constants.RELEASE_ACTION_RELEASE, uid); err != nil {
+			return nil, err
+		}
+	}
+	// 新版本的记录也要更新new_version
+	if err := (model.DeploymentLookup{}).Rebuild(tx, *deployment.Id); err != nil {
+		return nil, err
+	}
+	err = events.Enqueue(tx, events.Event{
+		Type:          events.RELEASE_CREATED,
+		Uid:           uid,
+		AppId:         *deployment.AppId,
+		DeploymentId:  *deployment.Id,
+		DeploymentKey: *deployment.Key,
+		PackageId:     *newPackage.Id,
+		Label:         label,
+		Status:        utils.StringValue(newPackage.Status),
+		Rollout:       utils.IntValue(newPackage.Rollout),
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &newPackage, nil
+}
+
+// 待审批或私有的发布生效时的事件,与状态变更在同一事务中写入
+func enqueueReleaseEvent(tx *gorm.DB, eventType string, uid int, deployment *model.Deployment, pack *model.Package) error {
+	return events.Enqueue(tx, events.Event{
+		Type:          eventType,
+		Uid:           uid,
+		AppId:         *deployment.AppId,
+		DeploymentId:  *deployment.Id,
+		DeploymentKey: *deployment.Key,
+		PackageId:     *pack.Id,
+		Label:         utils.StringValue(pack.Label),
+		Rollout:       utils.IntValue(pack.Rollout),
+	})
+}
+
+// 事务提交后: 差量包;审批通知和缓存由事件处理
+func afterRelease(uid int, deployment *model.Deployment, newPackage *model.Package) {
+	if newPackage.Rollout != nil {
+		model.RolloutHistory{}.Add(*newPackage.Id, uid, constants.ROLLOUT_ACTION_SET, nil, *newPackage.Rollout)
+	}
+	diff.Enqueue(*newPackage.Id, *deployment.Name == "Production")
+	if deployment.EphemeralDays != nil {
+		touchEphemeral(*deployment.Id)
+	}
+}
+
+// 只做校验,不写入任何数据;有标签模板时返回下一个标签,
+// label_mode的标签在发布时才分配,只返回占位内容
+func dryRunBundle(ctx *gin.Context, deployment *model.Deployment, req *createBundleReq, warning string) {
+	utils.FormatVersionStr(*req.Version)
+	if *req.Size <= 0 {
+		panic(errInvalid("min", "size", "must be greater than 0"))
+	}
+	newVersion := true
+	deploymentVersion := model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, getBundleName(req.BundleName), *req.Version)
+	if deploymentVersion != nil {
+		newVersion = false
+		nowPack := model.GetOne[model.Package]("id=?", deploymentVersion.CurrentPackage)
+		if nowPack != nil && *nowPack.Hash == *req.Hash {
+			panic(errConflict(constants.ERR_DUPLICATE_PACKAGE, "Upload package no modification"))
+		}
+	}
+	label := "(" + config.GetConfig().LabelMode + " label allocated on release)"
+	labelExact := false
+	if deployment.LabelFormat != nil && *deployment.LabelFormat != "" {
+		// 同时有其他发布时实际的{n}可能更大
+		label = previewLabel(deployment, *deployment.LabelFormat, utils.IntValue(deployment.LabelSeq)+1, *req.Version)
+		labelExact = true
+	}
+	rep := gin.H{
+		"success":    true,
+		"dryRun":     true,
+		"label":      label,
+		"labelExact": labelExact,
+		"version":    req.Version,
+		"newVersion": newVersion,
+		"rollout":    req.Rollout,
+		"mandatory":  req.IsMandatory != nil && *req.IsMandatory,
+	}
+	if warning != "" {
+		rep["warning"] = warning
+	}
+	ctx.JSON(http.StatusOK, rep)
+}
+
+func getBundleName(bundleName *string) string {
+	if bundleName == nil {
+		return ""
+	}
+	return *bundleName
 }
 
 type createDeploymentInfo struct {
@@ -144,12 +378,13 @@ func (App) CreateDeployment(ctx *gin.Context) {
 		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
 		app := model.App{}.GetAppByUidAndAppName(uid, *createDeploymentInfo.AppName)
 		if app == nil {
-			log.Panic("App not found")
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
 		}
 		deployment := model.Deployment{}.GetByAppidAndName(*app.Id, *createDeploymentInfo.DeploymentName)
 		if deployment != nil {
-			log.Panic("Deployment name " + *createDeploymentInfo.DeploymentName + " exist")
+			panic(errConflict(constants.ERR_DEPLOYMENT_EXISTS, "Deployment name "+*createDeploymentInfo.DeploymentName+" exist"))
 		}
+		checkDeploymentQuota(uid)
 		uuid, _ := uuid.NewUUID()
 		key := uuid.String()
 		newDeployment := model.Deployment{
@@ -162,85 +397,115 @@ func (App) CreateDeployment(ctx *gin.Context) {
 		if err != nil {
 			log.Panic(err.Error())
 		}
+		redis.DelKeys(constants.REDIS_UNKNOWN_KEY + key)
 		ctx.JSON(http.StatusOK, gin.H{
 			"name": createDeploymentInfo.DeploymentName,
 			"key":  key,
 		})
 	} else {
-		log.Panic(err.Error())
+		panic(bindError(err))
 	}
 }
 func (App) UploadBundle(ctx *gin.Context) {
+	tracker := newProgressTracker(ctx, constants.UPLOAD_STAGE_RECEIVING)
+	defer tracker.finish()
+	ctx.Request.Body = tracker.wrapBody(ctx.Request.Body)
+
 	_, headers, err := ctx.Request.FormFile("file")
 	if err != nil {
 		log.Printf("Error when try to get file: %v", err)
 	}
-	file, err := headers.Open()
-	if err != nil {
-		log.Panic(err.Error())
-	}
-	defer file.Close()
-	config := config.GetConfig()
-
-	key := headers.Filename
-
-	switch config.CodePush.FileLocal {
-	case "local":
-		exist := utils.Exists(config.CodePush.Local.SavePath)
-		if !exist {
-			err := os.MkdirAll(config.CodePush.Local.SavePath, 0777)
-			if err != nil {
-				log.Panic(err.Error())
-			}
-		}
-		buf := new(bytes.Buffer)
-		_, err := buf.ReadFrom(file)
+	// tar.gz和单个bundle文件转成zip,返回新的key和packageHash供发布使用;
+	// 文件在存储完成前一直在内存中,占用计入unzip_memory_mb
+	key, data, release := readBundle("Bundle file: ", headers.Filename, headers.Size, func() (io.ReadCloser, error) {
+		return headers.Open()
+	})
+	jobStarted := false
+	defer func() {
+		if !jobStarted {
+			release()
+		}
+	}()
+	buf := bytes.NewBuffer(data)
+	var converted gin.H
+	if bundleFormat(headers.Filename) != BUNDLE_FORMAT_ZIP {
+		hash, err := packageManifestHash(data)
 		if err != nil {
 			log.Panic(err.Error())
 		}
-		os.WriteFile(path.Clean(config.CodePush.Local.SavePath+"/"+key), buf.Bytes(), 0777)
-	case "aws":
-		s3Config := &aws.Config{
-			Credentials:      credentials.NewStaticCredentials(config.CodePush.Aws.KeyId, config.CodePush.Aws.Secret, ""),
-			Endpoint:         aws.String(config.CodePush.Aws.Endpoint),
-			Region:           aws.String(config.CodePush.Aws.Region),
-			S3ForcePathStyle: aws.Bool(config.CodePush.Aws.S3ForcePathStyle),
-		}
-		newSession, _ := session.NewSession(s3Config)
+		converted = gin.H{"key": key, "packageHash": hash, "size": buf.Len(), "format": bundleFormat(headers.Filename)}
+	}
+	// 异步上传:文件接收完成后立即返回,存储和校验在后台执行
+	if ctx.Query("async") == "true" {
+		jobTracker := tracker.detach()
+		startReleaseJob(ctx, constants.JOB_KIND_UPLOAD, nil, func(*gin.Context, string) gin.H {
+			defer jobTracker.finish()
+			defer release()
+			jobTracker.setStage(constants.UPLOAD_STAGE_STORING)
+			if _, err := storage.Upload(key, buf.Bytes()); err != nil {
+				log.Panic(err.Error())
+			}
+			result := gin.H{
+				"key":    key,
+				"size":   buf.Len(),
+				"sha256": utils.Sha256Hex(buf.String()),
+			}
+			for k, v := range converted {
+				result[k] = v
+			}
+			return result
+		})
+		jobStarted = true
+		return
+	}
+	tracker.setStage(constants.UPLOAD_STAGE_STORING)
+	if _, err := storage.Upload(key, buf.Bytes()); err != nil {
+		log.Panic(err.Error())
+	}
 
-		s3Client := s3.New(newSession)
+	result := gin.H{
+		"success": true,
+	}
+	for k, v := range converted {
+		result[k] = v
+	}
+	ctx.JSON(http.StatusOK, result)
+}
 
-		_, err = s3Client.PutObject(&s3.PutObjectInput{
-			Body:   file,
-			Bucket: aws.String(config.CodePush.Aws.Bucket),
-			Key:    &key,
-		})
-		if err != nil {
-			log.Panic(err.Error())
-		}
-	case "ftp":
-		f, err := ftp.Dial(config.CodePush.Ftp.ServerUrl)
-		if err != nil {
-			log.Panic(err.Error())
-		}
-		err = f.Login(config.CodePush.Ftp.UserName, config.CodePush.Ftp.Password)
-		if err != nil {
-			log.Panic(err.Error())
-		}
+type setDeploymentSecretReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Secret     *string `json:"secret"`
+	// 保留旧secret直到客户端升级完成
+	KeepPrevious *bool `json:"keepPrevious"`
+}
 
-		err = f.Stor(key, file)
-		if err != nil {
-			log.Panic(err.Error())
-		}
-		if err := f.Quit(); err != nil {
-			log.Panic(err.Error())
+// 设置部署的二级secret,secret为空时关闭校验
+func (App) SetDeploymentSecret(ctx *gin.Context) {
+	req := setDeploymentSecretReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		var secretHash, previousSecretHash *string
+		if req.Secret != nil && *req.Secret != "" {
+			hash := utils.Sha256Hex(utils.Sha256Hex(*req.Secret))
+			secretHash = &hash
+			if req.KeepPrevious != nil && *req.KeepPrevious {
+				previousSecretHash = deployment.SecretHash
+			}
 		}
-
+		version := updateWithRowVersion(ctx, "deployment", *deployment.Id, func(tx *gorm.DB) error {
+			return model.Deployment{}.UpdateSecretHash(tx, *deployment.Id, secretHash, previousSecretHash)
+		})
+		addAuditLog(ctx, uid, "deployment.secret", *req.AppName+"/"+*req.Deployment, "")
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
 	}
-
-	ctx.JSON(http.StatusOK, gin.H{
-		"success": true,
-	})
 }
 
 type lsDeploymentReq struct {
@@ -260,6 +525,7 @@ type deploymentInfo struct {
 	Failed         *int    `json:"failed"`
 	Installed      *int    `json:"installed"`
 	DeploymentKey  *string `json:"deploymentKey"`
+	RowVersion     *int    `json:"rowVersion"`
 }
 
 func (App) LsDeployment(ctx *gin.Context) {
@@ -268,7 +534,7 @@ func (App) LsDeployment(ctx *gin.Context) {
 		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
 		app := model.App{}.GetAppByUidAndAppName(uid, *lsAppReq.AppName)
 		if app == nil {
-			log.Panic("App not found")
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
 		}
 		var deploymentInfos []deploymentInfo
 		deployment := model.Deployment{}.GetByAppids(*app.Id)
@@ -281,6 +547,7 @@ func (App) LsDeployment(ctx *gin.Context) {
 			deploymentInfo := deploymentInfo{
 				DeploymentName: v.Name,
 				DeploymentKey:  key,
+				RowVersion:     v.RowVersion,
 			}
 			if v.VersionId != nil {
 				deploymentVersion := model.GetOne[model.DeploymentVersion]("id=?", v.VersionId)
@@ -301,7 +568,7 @@ func (App) LsDeployment(ctx *gin.Context) {
 		}
 		ctx.JSON(http.StatusOK, lsAppInfo)
 	} else {
-		log.Panic(err.Error())
+		panic(bindError(err))
 	}
 }
 
@@ -311,6 +578,11 @@ func (App) LsApp(ctx *gin.Context) {
 	if len(*apps) <= 0 {
 		log.Panic("No app")
 	}
+	// 默认只返回应用名(兼容code-push-go),detail=true时返回展示信息
+	if ctx.Query("detail") == "true" {
+		lsAppDetail(ctx, apps)
+		return
+	}
 	var appsRep []string
 
 	for _, v := range *apps {
@@ -323,6 +595,7 @@ type checkBundleReq struct {
 	AppName    *string `json:"appName" binding:"required"`
 	Deployment *string `json:"deployment" binding:"required"`
 	Version    *string `json:"version" binding:"required"`
+	BundleName *string `json:"bundleName"`
 }
 
 func (App) CheckBundle(ctx *gin.Context) {
@@ -332,15 +605,15 @@ func (App) CheckBundle(ctx *gin.Context) {
 
 		app := model.App{}.GetAppByUidAndAppName(uid, *checkBundleReq.AppName)
 		if app == nil {
-			log.Panic("App not found")
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
 		}
 		deployment := model.Deployment{}.GetByAppidAndName(*app.Id, *checkBundleReq.Deployment)
 		if deployment == nil {
-			log.Panic("Deployment " + *checkBundleReq.Deployment + " not found")
+			panic(errNotFound(constants.ERR_DEPLOYMENT_NOT_FOUND, "Deployment "+*checkBundleReq.Deployment+" not found"))
 		}
 		var hash *string
 		if deployment.VersionId != nil {
-			deployment := model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, *checkBundleReq.Version)
+			deployment := model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, getBundleName(checkBundleReq.BundleName), *checkBundleReq.Version)
 			if deployment != nil && deployment.CurrentPackage != nil {
 				pack := model.GetOne[model.Package]("id", deployment.CurrentPackage)
 				hash = pack.Hash
@@ -353,7 +626,7 @@ func (App) CheckBundle(ctx *gin.Context) {
 			"hash":    hash,
 		})
 	} else {
-		log.Panic(err.Error())
+		panic(bindError(err))
 	}
 }
 
@@ -368,18 +641,18 @@ func (App) DelApp(ctx *gin.Context) {
 
 		app := model.App{}.GetAppByUidAndAppName(uid, *delAppInfo.AppName)
 		if app == nil {
-			log.Panic("App not found")
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
 		}
 		deployment := model.Deployment{}.GetByAppids(*app.Id)
 		if deployment != nil && len(*deployment) > 0 {
-			log.Panic("App exist deployment,Delete the deployment first and then delete the app ")
+			panic(errConflict(constants.ERR_APP_NOT_EMPTY, "App exist deployment,Delete the deployment first and then delete the app "))
 		}
 		model.Delete[model.App](model.App{Id: app.Id})
 		ctx.JSON(http.StatusOK, gin.H{
 			"success": true,
 		})
 	} else {
-		log.Panic(err.Error())
+		panic(bindError(err))
 	}
 }
 
@@ -395,41 +668,53 @@ func (App) DelDeployment(ctx *gin.Context) {
 
 		app := model.App{}.GetAppByUidAndAppName(uid, *delDeploymentInfo.AppName)
 		if app == nil {
-			log.Panic("App not found")
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
 		}
 		deployment := model.Deployment{}.GetByAppidAndName(*app.Id, *delDeploymentInfo.Deployment)
 		if deployment == nil {
-			log.Panic("Deployment " + *delDeploymentInfo.Deployment + " not found")
-		}
-		userDb, _ := db.GetUserDB()
-		err := userDb.Transaction(func(tx *gorm.DB) error {
-			if err := tx.Delete(model.Deployment{Id: deployment.Id}).Error; err != nil {
-				panic("DeleteError:" + err.Error())
-			}
-			if err := tx.Where("deployment_id", *deployment.Id).Delete(model.DeploymentVersion{}).Error; err != nil {
-				panic("DeleteError:" + err.Error())
-			}
-			if err := tx.Where("deployment_id", *deployment.Id).Delete(model.Package{}).Error; err != nil {
-				panic("DeleteError:" + err.Error())
-			}
-			return nil
-		})
-		if err != nil {
-			panic("DeleteError:" + err.Error())
+			panic(errNotFound(constants.ERR_DEPLOYMENT_NOT_FOUND, "Deployment "+*delDeploymentInfo.Deployment+" not found"))
 		}
+		deleteDeployment(deployment)
 
 		ctx.JSON(http.StatusOK, gin.H{
 			"success": true,
 		})
 	} else {
-		log.Panic(err.Error())
+		panic(bindError(err))
 	}
 }
 
+func deleteDeployment(deployment *model.Deployment) {
+	userDb, _ := db.GetUserDB()
+	err := userDb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(model.Deployment{Id: deployment.Id}).Error; err != nil {
+			panic("DeleteError:" + err.Error())
+		}
+		if err := tx.Where("deployment_id", *deployment.Id).Delete(model.DeploymentVersion{}).Error; err != nil {
+			panic("DeleteError:" + err.Error())
+		}
+		if err := tx.Where("deployment_id", *deployment.Id).Delete(model.Package{}).Error; err != nil {
+			panic("DeleteError:" + err.Error())
+		}
+		if err := (model.DeploymentLookup{}).DeleteByDeploymentId(tx, *deployment.Id); err != nil {
+			panic("DeleteError:" + err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		panic("DeleteError:" + err.Error())
+	}
+	// 之后的update_check返回Deployment key not found
+	redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+}
+
 type rollbackReq struct {
 	AppName    *string `json:"appName" binding:"required"`
 	Deployment *string `json:"deployment" binding:"required"`
 	Version    *string `json:"version"`
+	BundleName *string `json:"bundleName"`
+
+	FreezeOverrideReason *string `json:"freezeOverrideReason"`
 }
 
 func (App) Rollback(ctx *gin.Context) {
@@ -439,33 +724,55 @@ func (App) Rollback(ctx *gin.Context) {
 
 		app := model.App{}.GetAppByUidAndAppName(uid, *rollbackReq.AppName)
 		if app == nil {
-			log.Panic("App not found")
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
 		}
 		deployment := model.Deployment{}.GetByAppidAndName(*app.Id, *rollbackReq.Deployment)
 		if deployment == nil {
-			log.Panic("Deployment " + *rollbackReq.Deployment + " not found")
+			panic(errNotFound(constants.ERR_DEPLOYMENT_NOT_FOUND, "Deployment "+*rollbackReq.Deployment+" not found"))
 		}
+		checkFreeze(ctx, uid, deployment, rollbackReq.FreezeOverrideReason, false)
+		checkReleaseGate(ctx, uid, GATE_ACTION_ROLLBACK, deployment, rollbackReq)
 
 		var deploymentVersion *model.DeploymentVersion
 		if deployment.VersionId != nil {
-			deploymentVersion = model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, *rollbackReq.Version)
+			deploymentVersion = model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, getBundleName(rollbackReq.BundleName), *rollbackReq.Version)
 		}
 		if deploymentVersion == nil {
-			log.Panic("Version not found")
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Version not found"))
 		}
 		if deploymentVersion.CurrentPackage == nil {
 			log.Panic("There is no upload package for the current version")
 		}
 		newPackage := model.Package{}.GetRollbackPack(*deployment.Id, *deploymentVersion.CurrentPackage, *deploymentVersion.Id)
 
+		var version int
 		userDb, _ := db.GetUserDB()
 		err := userDb.Transaction(func(tx *gorm.DB) error {
-			if newPackage == nil {
-				model.DeploymentVersion{}.UpdateCurrentPackage(*deploymentVersion.Id, nil)
-			} else {
-				model.DeploymentVersion{}.UpdateCurrentPackage(*deploymentVersion.Id, newPackage.Id)
+			version = bumpRowVersion(ctx, tx, "deployment", *deployment.Id)
+			var pid *int
+			if newPackage != nil {
+				pid = newPackage.Id
+			}
+			if err := (model.DeploymentVersion{}).UpdateCurrentPackage(tx, *deploymentVersion.Id, pid, constants.RELEASE_ACTION_ROLLBACK, uid); err != nil {
+				return err
+			}
+			if err := (model.DeploymentLookup{}).Rebuild(tx, *deployment.Id); err != nil {
+				return err
+			}
+			// 回滚到安装包自带的bundle时没有packageId
+			event := events.Event{
+				Type:          events.RELEASE_ROLLED_BACK,
+				Uid:           uid,
+				AppId:         *deployment.AppId,
+				DeploymentId:  *deployment.Id,
+				DeploymentKey: *deployment.Key,
+				AppVersion:    *deploymentVersion.AppVersion,
 			}
-			return nil
+			if newPackage != nil {
+				event.PackageId = *newPackage.Id
+				event.Label = utils.StringValue(newPackage.Label)
+			}
+			return events.Enqueue(tx, event)
 		})
 		if err != nil {
 			panic("RollbackError:" + err.Error())
@@ -476,18 +783,22 @@ func (App) Rollback(ctx *gin.Context) {
 				"Success":    true,
 				"Version":    *deploymentVersion.AppVersion,
 				"PackId":     *newPackage.Id,
+				"Label":      newPackage.Label,
 				"Size":       *newPackage.Size,
 				"Hash":       *newPackage.Hash,
 				"CreateTime": *newPackage.CreateTime,
+				"rowVersion": version,
 			})
 		} else {
 			ctx.JSON(http.StatusOK, gin.H{
-				"Success": true,
-				"Version": *deploymentVersion.AppVersion,
+				"Success":    true,
+				"Version":    *deploymentVersion.AppVersion,
+				"rowVersion": version,
 			})
 		}
 		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		warmCache(*deployment.Key)
 	} else {
-		log.Panic(err.Error())
+		panic(bindError(err))
 	}
 }
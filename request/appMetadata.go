@@ -0,0 +1,117 @@
+package request
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+type setAppMetadataReq struct {
+	AppName      *string `json:"appName" binding:"required"`
+	DisplayName  *string `json:"displayName"`
+	Platform     *string `json:"platform"`
+	AppStoreUrl  *string `json:"appStoreUrl" binding:"omitempty,url"`
+	PlayStoreUrl *string `json:"playStoreUrl" binding:"omitempty,url"`
+}
+
+// 只更新请求中带上的字段
+func (App) SetAppMetadata(ctx *gin.Context) {
+	req := setAppMetadataReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		app := model.App{}.GetAppByUidAndAppName(uid, *req.AppName)
+		if app == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		version := updateWithRowVersion(ctx, "apps", *app.Id, func(tx *gorm.DB) error {
+			return tx.Updates(&model.App{
+				Id:           app.Id,
+				DisplayName:  req.DisplayName,
+				Platform:     req.Platform,
+				AppStoreUrl:  req.AppStoreUrl,
+				PlayStoreUrl: req.PlayStoreUrl,
+			}).Error
+		})
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+const maxIconSize = 1 << 20
+
+var iconTypes = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".webp": true}
+
+// multipart: appName + icon文件
+func (App) UploadAppIcon(ctx *gin.Context) {
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	app := model.App{}.GetAppByUidAndAppName(uid, ctx.PostForm("appName"))
+	if app == nil {
+		panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+	}
+	headers, err := ctx.FormFile("icon")
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	ext := strings.ToLower(path.Ext(headers.Filename))
+	if !iconTypes[ext] {
+		panic(errInvalid("oneof", "icon", "must be png, jpg or webp"))
+	}
+	if headers.Size > maxIconSize {
+		panic(errInvalid("max", "icon", "must be at most 1MB"))
+	}
+	file, err := headers.Open()
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	defer file.Close()
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(file); err != nil {
+		log.Panic(err.Error())
+	}
+	// 内容变化时key变化,避免客户端缓存旧图标
+	key := "icons/" + strconv.Itoa(*app.Id) + "-" + utils.Sha256Hex(buf.String())[:16] + ext
+	if _, err := storage.Upload(key, buf.Bytes()); err != nil {
+		log.Panic(err.Error())
+	}
+	version := updateWithRowVersion(ctx, "apps", *app.Id, func(tx *gorm.DB) error {
+		return tx.Updates(&model.App{Id: app.Id, Icon: &key}).Error
+	})
+	iconUrl, _ := storage.DownloadUrl(key, nil)
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"iconUrl":    iconUrl,
+		"rowVersion": version,
+	})
+}
+
+type appDetail struct {
+	model.App
+	IconUrl string `json:"iconUrl,omitempty"`
+}
+
+func lsAppDetail(ctx *gin.Context, apps *[]model.App) {
+	details := make([]appDetail, 0, len(*apps))
+	for _, v := range *apps {
+		detail := appDetail{App: v}
+		if v.Icon != nil {
+			detail.IconUrl, _ = storage.DownloadUrl(*v.Icon, nil)
+		}
+		details = append(details, detail)
+	}
+	ctx.JSON(http.StatusOK, details)
+}
@@ -0,0 +1,217 @@
+package request
+
+import (
+	"net/http"
+	"strings"
+
+	"com.lc.go.codepush/server/auth"
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/events"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"com.lc.go.codepush/server/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+type setDeploymentApprovalReq struct {
+	AppName         *string   `json:"appName" binding:"required"`
+	Deployment      *string   `json:"deployment" binding:"required"`
+	RequireApproval *bool     `json:"requireApproval" binding:"required"`
+	Approvers       *[]string `json:"approvers"`
+}
+
+// 设置部署是否需要审批以及审批人
+func (App) SetDeploymentApproval(ctx *gin.Context) {
+	req := setDeploymentApprovalReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		app := model.App{}.GetAppByUidAndAppName(uid, *req.AppName)
+		if app == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		deployment := model.Deployment{}.GetByAppidAndName(*app.Id, *req.Deployment)
+		if deployment == nil {
+			panic(errNotFound(constants.ERR_DEPLOYMENT_NOT_FOUND, "Deployment "+*req.Deployment+" not found"))
+		}
+		approvers := ""
+		if req.Approvers != nil {
+			approvers = strings.Join(*req.Approvers, ",")
+		}
+		if *req.RequireApproval && approvers == "" {
+			panic(errInvalid("required", "approvers", "is required when requireApproval is true"))
+		}
+		if requiresApproval(deployment) && (!*req.RequireApproval || approvers != utils.StringValue(deployment.Approvers)) {
+			checkApprovalAdmin(ctx, deployment)
+		}
+		deployment.RequireApproval = req.RequireApproval
+		deployment.Approvers = &approvers
+		deployment.UpdateTime = utils.GetTimeNow()
+		version := updateWithRowVersion(ctx, "deployment", *deployment.Id, func(tx *gorm.DB) error {
+			return tx.Updates(deployment).Error
+		})
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+type lsPendingBundleReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+}
+
+func (App) LsPendingBundle(ctx *gin.Context) {
+	req := lsPendingBundleReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		app := model.App{}.GetAppByUidAndAppName(uid, *req.AppName)
+		if app == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		deployment := model.Deployment{}.GetByAppidAndName(*app.Id, *req.Deployment)
+		if deployment == nil {
+			panic(errNotFound(constants.ERR_DEPLOYMENT_NOT_FOUND, "Deployment "+*req.Deployment+" not found"))
+		}
+		ctx.JSON(http.StatusOK, model.Package{}.GetByDeploymentIdAndStatus(*deployment.Id, constants.PACKAGE_STATUS_PENDING))
+	} else {
+		panic(bindError(err))
+	}
+}
+
+type reviewBundleReq struct {
+	// 标签只在部署内唯一
+	AppName              *string `json:"appName" binding:"required"`
+	Deployment           *string `json:"deployment" binding:"required"`
+	Label                *string `json:"label" binding:"required"`
+	FreezeOverrideReason *string `json:"freezeOverrideReason"`
+}
+
+func (App) ApproveBundle(ctx *gin.Context) {
+	reviewBundle(ctx, constants.PACKAGE_STATUS_APPROVED)
+}
+
+func (App) RejectBundle(ctx *gin.Context) {
+	reviewBundle(ctx, constants.PACKAGE_STATUS_REJECTED)
+}
+
+// 审批人必须在部署的审批人列表中,并且不能是上传者
+func reviewBundle(ctx *gin.Context, status string) {
+	req := reviewBundleReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		user := model.GetOne[model.User]("id=?", uid)
+		if user == nil {
+			panic(errForbidden("No permission to approve"))
+		}
+		deployment := getReviewDeployment(*user.UserName, *req.AppName, *req.Deployment)
+		pack := model.Package{}.GetByDeploymentIdAndLabel(*deployment.Id, *req.Label)
+		if pack == nil {
+			panic(errNotFound(constants.ERR_PACKAGE_NOT_FOUND, "Package not found"))
+		}
+		if pack.Status == nil || *pack.Status != constants.PACKAGE_STATUS_PENDING {
+			panic(errConflict(constants.ERR_PACKAGE_STATE, "Package is not pending approval"))
+		}
+		if pack.Uid != nil && *pack.Uid == uid {
+			panic(errForbidden("Package can't be approved by its uploader"))
+		}
+		if status == constants.PACKAGE_STATUS_APPROVED {
+			// 审批较早的包会让部署回退到比当前发布更旧的版本
+			deploymentVersion := model.GetOne[model.DeploymentVersion]("id=?", pack.DeploymentVersionId)
+			if deploymentVersion != nil && deploymentVersion.CurrentPackage != nil && *pack.Id < *deploymentVersion.CurrentPackage {
+				panic(errConflict(constants.ERR_PACKAGE_STATE, "Package is older than the current release"))
+			}
+			checkFreeze(ctx, uid, deployment, req.FreezeOverrideReason, false)
+			checkReleaseGate(ctx, uid, GATE_ACTION_PROMOTE, deployment, req)
+		}
+		// 状态、当前包、发布历史和查找表在同一个事务中修改
+		version := updateWithRowVersion(ctx, "package", *pack.Id, func(tx *gorm.DB) error {
+			if err := (model.Package{}).UpdateStatus(tx, *pack.Id, status, &uid); err != nil {
+				return err
+			}
+			if status != constants.PACKAGE_STATUS_APPROVED {
+				return nil
+			}
+			if err := (model.DeploymentVersion{}).UpdateCurrentPackage(tx, *pack.DeploymentVersionId, pack.Id, constants.RELEASE_ACTION_APPROVE, uid); err != nil {
+				return err
+			}
+			if err := (model.DeploymentLookup{}).Rebuild(tx, *deployment.Id); err != nil {
+				return err
+			}
+			return enqueueReleaseEvent(tx, events.RELEASE_APPROVED, uid, deployment, pack)
+		})
+		if status == constants.PACKAGE_STATUS_APPROVED {
+			redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+			warmCache(*deployment.Key)
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"label":      pack.Label,
+			"status":     status,
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 审批人通常不是应用的所有者,在所有同名应用的同名部署中找审批人列表包含userName的那个
+func getReviewDeployment(userName string, appName string, deploymentName string) *model.Deployment {
+	var found *model.Deployment
+	if deployments := (model.Deployment{}).GetByAppNameAndName(appName, deploymentName); deployments != nil {
+		for i := range *deployments {
+			deployment := &(*deployments)[i]
+			if !isApprover(deployment, userName) {
+				continue
+			}
+			if found != nil {
+				panic(errInvalid("unique", "appName", "matches more than one deployment you approve"))
+			}
+			found = deployment
+		}
+	}
+	if found == nil {
+		panic(errForbidden("No permission to approve"))
+	}
+	return found
+}
+
+func requiresApproval(deployment *model.Deployment) bool {
+	return deployment.RequireApproval != nil && *deployment.RequireApproval
+}
+
+// 已经开启审批的部署,关闭审批或修改审批人需要管理员(或管理员代入部署所有者)操作,
+// 否则所有者可以先去掉审批再直接发布
+func checkApprovalAdmin(ctx *gin.Context, deployment *model.Deployment) {
+	checkAdmin(ctx, "Only admins can change approval settings of deployment "+*deployment.Name)
+}
+
+// 管理员或管理员代入的用户
+func checkAdmin(ctx *gin.Context, message string) {
+	principal, ok := ctx.MustGet(constants.GIN_PRINCIPAL).(*auth.Principal)
+	if (ok && principal.Role == constants.ROLE_ADMIN) || ctx.GetInt(constants.GIN_IMPERSONATOR) != 0 {
+		return
+	}
+	panic(errForbidden(message))
+}
+
+func isApprover(deployment *model.Deployment, userName string) bool {
+	return deployment.Approvers != nil && containsName(*deployment.Approvers, userName)
+}
+
+func notifyApprovers(app *model.App, deployment *model.Deployment, pack *model.Package) {
+	webhook.Send(config.GetConfig().ApprovalWebhookUrl, gin.H{
+		"event":       "release.pending",
+		"appName":     app.AppName,
+		"deployment":  deployment.Name,
+		"label":       pack.Label,
+		"description": pack.Description,
+		"approvers":   deployment.Approvers,
+	})
+}
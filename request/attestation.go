@@ -0,0 +1,167 @@
+package request
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"com.lc.go.codepush/server/attest"
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 发布时CI提供的来源信息
+type provenanceReq struct {
+	// 源码仓库,例如 https://github.com/org/app
+	Repository *string `json:"repository" binding:"required,max=500"`
+	Commit     *string `json:"commit" binding:"required,hexadecimal,min=7,max=64"`
+	Ref        *string `json:"ref" binding:"omitempty,max=200"`
+	// CI的身份,例如 https://github.com/org/app/.github/workflows/release.yml@refs/heads/main
+	BuilderId *string `json:"builderId" binding:"omitempty,max=500"`
+	BuildId   *string `json:"buildId" binding:"omitempty,max=200"`
+	BuildUrl  *string `json:"buildUrl" binding:"omitempty,url,max=500"`
+}
+
+func encodeProvenance(provenance *provenanceReq) *string {
+	if provenance == nil {
+		return nil
+	}
+	data, err := json.Marshal(provenance)
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	s := string(data)
+	return &s
+}
+
+func decodeProvenance(data *string) *provenanceReq {
+	if data == nil {
+		return nil
+	}
+	provenance := provenanceReq{}
+	if err := json.Unmarshal([]byte(*data), &provenance); err != nil {
+		return nil
+	}
+	return &provenance
+}
+
+// 存储中的zip就是设备下载的内容,只计算一次
+func blobSha256(pack *model.Package) string {
+	if pack.BlobSha256 != nil {
+		return *pack.BlobSha256
+	}
+	// 签名前下载整个包,加密存储时解密还需要同样大小的内存
+	var size int64
+	if pack.Size != nil {
+		size = *pack.Size
+	}
+	release := reserveBundle("Package: ", 2*size)
+	defer release()
+	data, err := storage.Download(*pack.Download)
+	if err != nil {
+		log.Panic("Download package error:" + err.Error())
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	model.Package{}.UpdateBlobSha256(*pack.Id, hash)
+	return hash
+}
+
+// in-toto Statement v1 + SLSA Provenance v1
+func attestationStatement(appName string, deploymentName string, pack *model.Package) gin.H {
+	deploymentVersion := model.GetOne[model.DeploymentVersion]("id", *pack.DeploymentVersionId)
+	uploader := ""
+	if pack.Uid != nil {
+		if user := model.GetOne[model.User]("id", *pack.Uid); user != nil {
+			uploader = utils.StringValue(user.UserName)
+		}
+	}
+	external := gin.H{
+		"appName":     appName,
+		"deployment":  deploymentName,
+		"appVersion":  utils.StringValue(deploymentVersion.AppVersion),
+		"bundleName":  utils.StringValue(deploymentVersion.BundleName),
+		"label":       utils.StringValue(pack.Label),
+		"packageHash": utils.StringValue(pack.Hash),
+	}
+	builder := gin.H{"id": "urn:code-push-server-go:" + config.GetConfig().TenantName}
+	metadata := gin.H{"finishedOn": time.UnixMilli(*pack.CreateTime).UTC().Format(time.RFC3339)}
+	dependencies := []gin.H{}
+	if provenance := decodeProvenance(pack.Provenance); provenance != nil {
+		source := gin.H{"uri": *provenance.Repository, "digest": gin.H{"gitCommit": *provenance.Commit}}
+		if provenance.Ref != nil {
+			source["ref"] = *provenance.Ref
+		}
+		external["source"] = source
+		dependencies = append(dependencies, gin.H{
+			"uri":    "git+" + *provenance.Repository,
+			"digest": gin.H{"gitCommit": *provenance.Commit},
+		})
+		if provenance.BuilderId != nil {
+			builder["id"] = *provenance.BuilderId
+		}
+		if provenance.BuildId != nil {
+			metadata["invocationId"] = *provenance.BuildId
+		} else if provenance.BuildUrl != nil {
+			metadata["invocationId"] = *provenance.BuildUrl
+		}
+	}
+	return gin.H{
+		"_type": "https://in-toto.io/Statement/v1",
+		"subject": []gin.H{{
+			"name":   appName + "/" + deploymentName + "/" + utils.StringValue(pack.Label),
+			"digest": gin.H{"sha256": blobSha256(pack)},
+		}},
+		"predicateType": "https://slsa.dev/provenance/v1",
+		"predicate": gin.H{
+			"buildDefinition": gin.H{
+				"buildType":          config.GetConfig().Attestation.BuildType,
+				"externalParameters": external,
+				"internalParameters": gin.H{
+					"uploader":    uploader,
+					"status":      utils.StringValue(pack.Status),
+					"rollout":     pack.Rollout,
+					"isMandatory": pack.IsMandatory != nil && *pack.IsMandatory,
+				},
+				"resolvedDependencies": dependencies,
+			},
+			"runDetails": gin.H{
+				"builder":  builder,
+				"metadata": metadata,
+			},
+		},
+	}
+}
+
+// 返回DSSE信封,payload为base64的in-toto statement
+func (App) GetAttestation(ctx *gin.Context) {
+	req := packageReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	pack := getPackageByLabel(ctx, req.AppName, req.Deployment, req.Label)
+	payload, err := json.Marshal(attestationStatement(req.AppName, req.Deployment, pack))
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	ctx.JSON(http.StatusOK, attest.Sign(payload))
+}
+
+// 校验attestation用的公钥
+func (App) GetAttestationKey(ctx *gin.Context) {
+	if !attest.Enabled() {
+		panic(errNotFound(constants.ERR_NOT_FOUND, "Attestation signing is not configured"))
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"keyid":     attest.KeyId(),
+		"publicKey": attest.PublicKeyPEM(),
+	})
+}
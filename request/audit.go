@@ -0,0 +1,12 @@
+package request
+
+import (
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+)
+
+// 记录操作人的客户端IP,管理员代入用户时同时记录管理员
+func addAuditLog(ctx *gin.Context, uid int, action string, target string, detail string) {
+	model.AddImpersonatedAuditLog(ctx.GetString(constants.GIN_CLIENT_IP), uid, ctx.GetInt(constants.GIN_IMPERSONATOR), action, target, detail)
+}
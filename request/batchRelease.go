@@ -0,0 +1,294 @@
+package request
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"com.lc.go.codepush/server/db"
+	"com.lc.go.codepush/server/diff"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 批量发布清单,path为上传的zip中的bundle zip文件或目录
+type batchReleaseManifest struct {
+	Releases []batchReleaseEntry `json:"releases" binding:"required,min=1,max=50,dive"`
+
+	FreezeOverrideReason *string `json:"freezeOverrideReason"`
+	// 所有release共用
+	Provenance *provenanceReq `json:"provenance"`
+}
+
+type batchReleaseEntry struct {
+	AppName      *string           `json:"appName" binding:"required"`
+	Deployment   *string           `json:"deployment" binding:"required"`
+	Path         *string           `json:"path" binding:"required"`
+	Version      *string           `json:"version"`
+	Hash         *string           `json:"hash" binding:"required"`
+	Description  *string           `json:"description"`
+	Descriptions map[string]string `json:"descriptions"`
+	BundleName   *string           `json:"bundleName"`
+	Rollout      *int              `json:"rollout" binding:"omitempty,min=1,max=100"`
+	IsMandatory  *bool             `json:"isMandatory"`
+	Metadata     map[string]string `json:"metadata"`
+	Private      bool              `json:"private"`
+	// 同createBundle的binaryMetadata
+	BinaryMetadata *string `json:"binaryMetadata"`
+}
+
+type batchRelease struct {
+	app        *model.App
+	deployment *model.Deployment
+	req        createBundleReq
+	warning    string
+	pack       *model.Package
+}
+
+// 批量发布中已上传的bundle,发布成功之前出错时删除
+type batchUploads struct {
+	keys      []string
+	published bool
+}
+
+// 在handler中defer,包括panic时
+func (u *batchUploads) cleanup() {
+	if u.published {
+		return
+	}
+	for _, key := range u.keys {
+		storage.Delete(key)
+	}
+}
+
+// 一次上传多个应用的bundle,全部校验和上传成功后在一个事务中发布,任何一个失败都不会发布
+func (App) ReleaseBatch(ctx *gin.Context) {
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	_, headers, err := ctx.Request.FormFile("file")
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	// 大文件由multipart保存在临时文件中,直接按偏移读取,不整体读入内存
+	file, err := headers.Open()
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	defer file.Close()
+	archive, err := zip.NewReader(file, headers.Size)
+	if err != nil {
+		log.Panic("Batch file is not a zip: " + err.Error())
+	}
+	if err := diff.CheckArchive(archive); err != nil {
+		panicArchiveError("Batch file: ", err)
+	}
+	manifest := readBatchManifest(ctx, archive)
+
+	releases := make([]*batchRelease, len(manifest.Releases))
+	uploads := &batchUploads{}
+	defer uploads.cleanup()
+	seen := map[string]bool{}
+	for i, entry := range manifest.Releases {
+		releases[i] = prepareBatchRelease(ctx, uid, entry, manifest.FreezeOverrideReason, manifest.Provenance, seen, uploads, func() ([]byte, func()) {
+			return readBatchBundle(archive, *entry.Path)
+		})
+	}
+	results := publishBatchReleases(ctx, uid, releases, uploads, "package.release_batch", strconv.Itoa(len(releases)))
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"releases": results,
+	})
+}
+
+// 校验一个发布,读取bundle后立即上传并释放内存,不同时在内存中保留所有bundle;
+// seen用于检查同一个部署和bundleName是否重复
+func prepareBatchRelease(ctx *gin.Context, uid int, entry batchReleaseEntry, freezeOverrideReason *string, provenance *provenanceReq, seen map[string]bool, uploads *batchUploads, read func() ([]byte, func())) *batchRelease {
+	app := model.App{}.GetAppByUidAndAppName(uid, *entry.AppName)
+	if app == nil {
+		panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App "+*entry.AppName+" not found"))
+	}
+	deployment := model.Deployment{}.GetByAppidAndName(*app.Id, *entry.Deployment)
+	if deployment == nil {
+		panic(errNotFound(constants.ERR_DEPLOYMENT_NOT_FOUND, "Deployment "+*entry.AppName+"/"+*entry.Deployment+" not found"))
+	}
+	target := strconv.Itoa(*deployment.Id) + "/" + getBundleName(entry.BundleName)
+	if seen[target] {
+		log.Panic("Duplicate release for " + *entry.AppName + "/" + *entry.Deployment)
+	}
+	seen[target] = true
+	checkFreeze(ctx, uid, deployment, freezeOverrideReason, false)
+	// 上传前先校验版本号和metadata
+	versionWarning := inferBinaryVersion(&entry.Version, entry.BinaryMetadata)
+	utils.FormatVersionStr(*entry.Version)
+	encodeMetadata(entry.Metadata)
+	encodeLocalized("descriptions", entry.Descriptions)
+	data, releaseData := read()
+	defer releaseData()
+	size := int64(len(data))
+	release := &batchRelease{
+		app:        app,
+		deployment: deployment,
+		warning:    joinWarning(versionWarning, checkBinaryVersion(*app.Id, *entry.Version)),
+		req: createBundleReq{
+			AppName:      entry.AppName,
+			Deployment:   entry.Deployment,
+			Description:  entry.Description,
+			Descriptions: entry.Descriptions,
+			Version:      entry.Version,
+			Size:         &size,
+			Hash:         entry.Hash,
+			BundleName:   entry.BundleName,
+			Rollout:      entry.Rollout,
+			IsMandatory:  entry.IsMandatory,
+			Metadata:     entry.Metadata,
+			Private:      entry.Private,
+			Provenance:   provenance,
+		},
+	}
+	applyDeploymentPolicy(deployment, &release.req)
+	checkStorageQuota(*app.Uid, size)
+	checkReleaseGate(ctx, uid, GATE_ACTION_RELEASE, deployment, release.req)
+	key := uuid.NewString() + ".zip"
+	if _, err := storage.Upload(key, data); err != nil {
+		log.Panic(err.Error())
+	}
+	uploads.keys = append(uploads.keys, key)
+	release.req.DownloadUrl = &key
+	return release
+}
+
+// 所有bundle上传后在一个事务中发布,失败时由uploads.cleanup删除已上传的文件
+func publishBatchReleases(ctx *gin.Context, uid int, releases []*batchRelease, uploads *batchUploads, action string, detail string) []gin.H {
+	userDb, _ := db.GetUserDB()
+	err := userDb.Transaction(func(tx *gorm.DB) error {
+		for _, release := range releases {
+			pack, err := createRelease(tx, uid, release.deployment, &release.req, "")
+			if err != nil {
+				return err
+			}
+			release.pack = pack
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic("ReleaseError:" + err.Error())
+	}
+	// 已经发布,之后出错也不能删除文件
+	uploads.published = true
+
+	results := make([]gin.H, len(releases))
+	for i, release := range releases {
+		afterRelease(uid, release.deployment, release.pack)
+		result := gin.H{
+			"appName":    release.app.AppName,
+			"deployment": release.deployment.Name,
+			"label":      release.pack.Label,
+		}
+		if release.pack.Status != nil {
+			result["status"] = *release.pack.Status
+		}
+		if release.warning != "" {
+			result["warning"] = release.warning
+		}
+		results[i] = result
+		addAuditLog(ctx, uid, action, *release.app.AppName+"/"+*release.deployment.Name+"/"+*release.pack.Label, detail)
+	}
+	return results
+}
+
+// 清单在表单字段manifest中,或者是zip根目录的manifest.json
+func readBatchManifest(ctx *gin.Context, archive *zip.Reader) *batchReleaseManifest {
+	data := []byte(ctx.PostForm("manifest"))
+	if len(data) == 0 {
+		f, err := archive.Open("manifest.json")
+		if err != nil {
+			panic(errInvalid("required", "manifest", "is required as a form field or manifest.json"))
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			log.Panic(err.Error())
+		}
+	}
+	manifest := &batchReleaseManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		panic(bindError(err))
+	}
+	if err := binding.Validator.ValidateStruct(manifest); err != nil {
+		panic(bindError(err))
+	}
+	return manifest
+}
+
+// path是zip文件时原样使用,tar.gz或单个bundle文件转成zip,是目录时把目录下的文件重新打包;
+// 返回的函数释放bundle占用的内存
+func readBatchBundle(archive *zip.Reader, name string) ([]byte, func()) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if f, err := archive.Open(name); err == nil {
+		info, err := f.Stat()
+		f.Close()
+		if err == nil && !info.IsDir() {
+			_, data, release := readBundle(name+": ", name, info.Size(), func() (io.ReadCloser, error) {
+				return archive.Open(name)
+			})
+			return data, release
+		}
+	}
+	// 重新打包后的大小不超过解压后的大小
+	var size int64
+	for _, f := range archive.File {
+		if strings.HasPrefix(f.Name, name+"/") && !f.FileInfo().IsDir() {
+			size += int64(f.UncompressedSize64)
+		}
+	}
+	release := reserveBundle(name+": ", size)
+	defer func() {
+		if r := recover(); r != nil {
+			release()
+			panic(r)
+		}
+	}()
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	count := 0
+	for _, f := range archive.File {
+		if !strings.HasPrefix(f.Name, name+"/") || f.FileInfo().IsDir() {
+			continue
+		}
+		if err := copyZipEntry(w, f, strings.TrimPrefix(f.Name, name+"/")); err != nil {
+			log.Panic(err.Error())
+		}
+		count++
+	}
+	if err := w.Close(); err != nil {
+		log.Panic(err.Error())
+	}
+	if count == 0 {
+		panic(errInvalid("path", "path", name+" not found in batch file"))
+	}
+	return buf.Bytes(), release
+}
+
+func copyZipEntry(w *zip.Writer, f *zip.File, name string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	return err
+}
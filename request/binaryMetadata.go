@@ -0,0 +1,174 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"com.lc.go.codepush/server/utils"
+)
+
+// 从安装包信息中读取的版本
+type binaryInfo struct {
+	Version     string `json:"version"`
+	BuildNumber string `json:"buildNumber"`
+}
+
+// 支持XML格式的Info.plist、源码形式的AndroidManifest.xml,以及JSON
+// {"version","buildNumber"}(也接受CFBundleShortVersionString/versionName等原始字段名)
+func parseBinaryMetadata(content string) (binaryInfo, error) {
+	content = strings.TrimSpace(content)
+	switch {
+	case content == "":
+		return binaryInfo{}, errors.New("is empty")
+	case strings.HasPrefix(content, "bplist"):
+		return binaryInfo{}, errors.New("binary plist is not supported, convert it with plutil -convert xml1")
+	case strings.HasPrefix(content, "{"):
+		return parseMetadataJson(content)
+	case strings.Contains(content, "<plist"):
+		return parsePlist(content)
+	case strings.Contains(content, "<manifest"):
+		return parseManifest(content)
+	}
+	return binaryInfo{}, errors.New("must be an XML Info.plist, an AndroidManifest.xml or a JSON object")
+}
+
+func parseMetadataJson(content string) (binaryInfo, error) {
+	fields := map[string]any{}
+	if err := json.Unmarshal([]byte(content), &fields); err != nil {
+		return binaryInfo{}, err
+	}
+	get := func(names ...string) string {
+		for _, name := range names {
+			switch v := fields[name].(type) {
+			case string:
+				return v
+			case float64:
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		return ""
+	}
+	info := binaryInfo{
+		Version:     get("version", "CFBundleShortVersionString", "versionName"),
+		BuildNumber: get("buildNumber", "CFBundleVersion", "versionCode"),
+	}
+	return info, checkBinaryInfo(info)
+}
+
+// plist根节点dict中key后面紧跟的string
+func parsePlist(content string) (binaryInfo, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+	values := map[string]string{}
+	depth := 0
+	key := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return binaryInfo{}, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			// plist > dict > key/string
+			if depth != 3 {
+				continue
+			}
+			var text string
+			if err := decoder.DecodeElement(&text, &t); err != nil {
+				return binaryInfo{}, err
+			}
+			depth--
+			if t.Name.Local == "key" {
+				key = strings.TrimSpace(text)
+			} else {
+				if t.Name.Local == "string" && key != "" {
+					values[key] = strings.TrimSpace(text)
+				}
+				key = ""
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	info := binaryInfo{Version: values["CFBundleShortVersionString"], BuildNumber: values["CFBundleVersion"]}
+	return info, checkBinaryInfo(info)
+}
+
+func parseManifest(content string) (binaryInfo, error) {
+	manifest := struct {
+		Attrs []xml.Attr `xml:",any,attr"`
+	}{}
+	if err := xml.NewDecoder(bytes.NewReader([]byte(content))).Decode(&manifest); err != nil {
+		return binaryInfo{}, err
+	}
+	info := binaryInfo{}
+	for _, attr := range manifest.Attrs {
+		switch attr.Name.Local {
+		case "versionName":
+			info.Version = attr.Value
+		case "versionCode":
+			info.BuildNumber = attr.Value
+		}
+	}
+	// 源码中的manifest通常由gradle写入版本,应上传合并后的manifest
+	if strings.HasPrefix(info.Version, "@") || strings.HasPrefix(info.Version, "$") {
+		return binaryInfo{}, errors.New("versionName " + info.Version + " is not resolved, upload the merged manifest")
+	}
+	return info, checkBinaryInfo(info)
+}
+
+func checkBinaryInfo(info binaryInfo) error {
+	if info.Version == "" {
+		return errors.New("has no version")
+	}
+	if _, ok := versionNum(info.Version); !ok {
+		return errors.New("version " + info.Version + " is not a version like 1.2.0")
+	}
+	return nil
+}
+
+// version为空时使用安装包的版本;CI传入的version不包含安装包版本时返回警告,
+// 这种情况下该安装包的客户端收不到这次发布
+func inferBinaryVersion(version **string, binaryMetadata *string) string {
+	if binaryMetadata == nil {
+		if *version == nil {
+			panic(errInvalid("required", "version", "is required"))
+		}
+		return ""
+	}
+	info, err := parseBinaryMetadata(*binaryMetadata)
+	if err != nil {
+		panic(errInvalid("format", "binaryMetadata", err.Error()))
+	}
+	if *version == nil || **version == "" {
+		*version = &info.Version
+		return ""
+	}
+	if utils.MatchVersionRange(**version, info.Version) {
+		return ""
+	}
+	msg := "Version " + **version + " does not include binary version " + info.Version
+	if info.BuildNumber != "" {
+		msg += " (build " + info.BuildNumber + ")"
+	}
+	return msg
+}
+
+func joinWarning(warnings ...string) string {
+	var list []string
+	for _, w := range warnings {
+		if w != "" {
+			list = append(list, w)
+		}
+	}
+	return strings.Join(list, "; ")
+}
@@ -0,0 +1,129 @@
+package request
+
+import (
+	"log"
+	"net/http"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type setBinaryVersionReq struct {
+	AppName     *string `json:"appName" binding:"required"`
+	AppVersion  *string `json:"appVersion" binding:"required"`
+	TargetRange *string `json:"targetRange"`
+	Live        *bool   `json:"live" binding:"required"`
+}
+
+// 声明商店中的二进制版本及其允许的OTA目标范围
+func (App) SetBinaryVersion(ctx *gin.Context) {
+	req := setBinaryVersionReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		app := model.App{}.GetAppByUidAndAppName(uid, *req.AppName)
+		if app == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		utils.FormatVersionStr(*req.AppVersion)
+		binaryVersion := model.BinaryVersion{}.GetByAppIdAndVersion(*app.Id, *req.AppVersion)
+		if binaryVersion == nil {
+			binaryVersion = &model.BinaryVersion{
+				AppId:       app.Id,
+				AppVersion:  req.AppVersion,
+				TargetRange: req.TargetRange,
+				Live:        req.Live,
+				CreateTime:  utils.GetTimeNow(),
+			}
+			if err := model.Create[model.BinaryVersion](binaryVersion); err != nil {
+				log.Panic(err.Error())
+			}
+		} else {
+			binaryVersion.TargetRange = req.TargetRange
+			binaryVersion.Live = req.Live
+			binaryVersion.UpdateTime = utils.GetTimeNow()
+			model.Update[model.BinaryVersion](binaryVersion)
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+type lsBinaryVersionReq struct {
+	AppName *string `json:"appName" binding:"required"`
+}
+
+func (App) LsBinaryVersion(ctx *gin.Context) {
+	req := lsBinaryVersionReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		app := model.App{}.GetAppByUidAndAppName(uid, *req.AppName)
+		if app == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		ctx.JSON(http.StatusOK, model.BinaryVersion{}.GetByAppId(*app.Id))
+	} else {
+		panic(bindError(err))
+	}
+}
+
+type delBinaryVersionReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	AppVersion *string `json:"appVersion" binding:"required"`
+}
+
+func (App) DelBinaryVersion(ctx *gin.Context) {
+	req := delBinaryVersionReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		app := model.App{}.GetAppByUidAndAppName(uid, *req.AppName)
+		if app == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		binaryVersion := model.BinaryVersion{}.GetByAppIdAndVersion(*app.Id, *req.AppVersion)
+		if binaryVersion == nil {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Binary version "+*req.AppVersion+" not found"))
+		}
+		model.Delete[model.BinaryVersion](model.BinaryVersion{Id: binaryVersion.Id})
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 检查发布的目标版本是否还有在线的二进制版本,按配置返回警告或阻止发布
+func checkBinaryVersion(appId int, version string) string {
+	mode := config.GetConfig().BinaryVersionCheck
+	if mode == "off" {
+		return ""
+	}
+	binaryVersions := model.BinaryVersion{}.GetByAppId(appId)
+	if binaryVersions == nil || len(*binaryVersions) <= 0 {
+		return ""
+	}
+	for _, v := range *binaryVersions {
+		if v.Live == nil || !*v.Live {
+			continue
+		}
+		targetRange := *v.AppVersion
+		if v.TargetRange != nil && *v.TargetRange != "" {
+			targetRange = *v.TargetRange
+		}
+		if utils.MatchVersionRange(targetRange, version) {
+			return ""
+		}
+	}
+	msg := "Version " + version + " does not match any live binary version"
+	if mode == "block" {
+		log.Panic(msg)
+	}
+	return msg
+}
@@ -0,0 +1,89 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 开启存储加密或私有模式后的下载地址,校验签名后返回(加密的对象先解密)。
+// 支持HEAD和If-Modified-Since/If-None-Match,代理用HEAD预检时可以拿到Content-Length
+func (Client) DownloadBlob(ctx *gin.Context) {
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+	// 绑定clientUniqueId的地址需要SDK下载时带上X-CodePush-Client-Unique-Id请求头
+	ip := ctx.GetString(constants.GIN_CLIENT_IP)
+	clientUniqueId := ctx.GetHeader("X-CodePush-Client-Unique-Id")
+	if !storage.VerifyBlobUrl(key, ctx.Query("expires"), ctx.Query("signature"), ctx.Query("bind"), ip, clientUniqueId) {
+		panic(errForbidden("Invalid or expired download url"))
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Cache-Control", "private, no-cache")
+	// 对象写入后不再改变,key可以作为ETag
+	ctx.Header("ETag", `"`+utils.Sha256Hex(key)[:32]+`"`)
+	size, modTime, ok := storage.Stat(key)
+	conditional := ctx.GetHeader("If-None-Match") != "" || ctx.GetHeader("If-Modified-Since") != ""
+	if ok && (ctx.Request.Method == http.MethodHead || conditional) {
+		// HEAD和返回304时不下载、不解密
+		http.ServeContent(ctx.Writer, ctx.Request, path.Base(key), modTime, &lazyBlob{key: key, size: size})
+		return
+	}
+	data, err := storage.Download(key)
+	if err != nil {
+		log.Panic("Download blob error:" + err.Error())
+	}
+	http.ServeContent(ctx.Writer, ctx.Request, path.Base(key), modTime, bytes.NewReader(data))
+}
+
+// 已知大小的对象,第一次Read时才下载
+type lazyBlob struct {
+	key    string
+	size   int64
+	offset int64
+	data   *bytes.Reader
+}
+
+func (b *lazyBlob) Seek(offset int64, whence int) (int64, error) {
+	if b.data != nil {
+		return b.data.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekStart:
+		b.offset = offset
+	case io.SeekCurrent:
+		b.offset += offset
+	case io.SeekEnd:
+		b.offset = b.size + offset
+	}
+	if b.offset < 0 {
+		return 0, errors.New("lazyBlob: negative position")
+	}
+	return b.offset, nil
+}
+
+func (b *lazyBlob) Read(p []byte) (int, error) {
+	if b.data == nil {
+		data, err := storage.Download(b.key)
+		if err != nil {
+			return 0, err
+		}
+		b.data = bytes.NewReader(data)
+		if _, err := b.data.Seek(b.offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	return b.data.Read(p)
+}
@@ -0,0 +1,57 @@
+package request
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+
+	"com.lc.go.codepush/server/auth"
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type bootstrapReq struct {
+	UserName *string `json:"userName"`
+}
+
+// 一次性初始化接口: 用户表为空且Bootstrap-Token正确时创建管理员
+func (User) Bootstrap(ctx *gin.Context) {
+	bootstrapToken := config.GetConfig().BootstrapToken
+	if bootstrapToken == "" || subtle.ConstantTimeCompare([]byte(ctx.GetHeader("Bootstrap-Token")), []byte(bootstrapToken)) != 1 {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"code":  constants.ERR_BOOTSTRAP_DISABLED,
+			"error": constants.ErrName(constants.ERR_BOOTSTRAP_DISABLED),
+			"msg":   "Bootstrap disabled",
+		})
+		return
+	}
+	req := bootstrapReq{}
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+			panic(bindError(err))
+		}
+	}
+	userName := "admin"
+	if req.UserName != nil && *req.UserName != "" {
+		userName = *req.UserName
+	}
+	result, err := auth.Bootstrap(userName)
+	if errors.Is(err, auth.ErrAlreadyBootstrapped) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"code":  constants.ERR_BOOTSTRAP_DISABLED,
+			"error": constants.ErrName(constants.ERR_BOOTSTRAP_DISABLED),
+			"msg":   "Bootstrap disabled",
+		})
+		return
+	}
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
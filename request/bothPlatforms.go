@@ -0,0 +1,109 @@
+package request
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+)
+
+// 一个平台的应用和部署,其他字段和releaseBatch的条目相同
+type platformReleaseEntry struct {
+	AppName        *string `json:"appName" binding:"required"`
+	Deployment     *string `json:"deployment" binding:"required"`
+	Version        *string `json:"version"`
+	Hash           *string `json:"hash" binding:"required"`
+	BundleName     *string `json:"bundleName"`
+	BinaryMetadata *string `json:"binaryMetadata"`
+}
+
+type bothPlatformsManifest struct {
+	Ios     *platformReleaseEntry `json:"ios" binding:"required"`
+	Android *platformReleaseEntry `json:"android" binding:"required"`
+	// 两个平台共用
+	Description  *string           `json:"description"`
+	Descriptions map[string]string `json:"descriptions"`
+	Rollout      *int              `json:"rollout" binding:"omitempty,min=1,max=100"`
+	IsMandatory  *bool             `json:"isMandatory"`
+	Metadata     map[string]string `json:"metadata"`
+	Private      bool              `json:"private"`
+	// 写入两个包的metadata.releaseTrain,用于关联同一次发布,为空时自动生成
+	Train *string `json:"train" binding:"omitempty,max=64"`
+
+	FreezeOverrideReason *string        `json:"freezeOverrideReason"`
+	Provenance           *provenanceReq `json:"provenance"`
+}
+
+// 同一次发布的iOS和Android bundle,表单文件ios/android,清单在表单字段manifest中;
+// 两个发布在一个事务中创建,任何一个失败都不会发布
+func (App) ReleaseBothPlatforms(ctx *gin.Context) {
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	manifest := bothPlatformsManifest{}
+	if err := json.Unmarshal([]byte(ctx.PostForm("manifest")), &manifest); err != nil {
+		panic(bindError(err))
+	}
+	if err := binding.Validator.ValidateStruct(manifest); err != nil {
+		panic(bindError(err))
+	}
+	train := uuid.NewString()
+	if manifest.Train != nil && *manifest.Train != "" {
+		train = *manifest.Train
+	}
+	metadata := map[string]string{}
+	for k, v := range manifest.Metadata {
+		metadata[k] = v
+	}
+	metadata["releaseTrain"] = train
+
+	seen := map[string]bool{}
+	releases := make([]*batchRelease, 0, 2)
+	uploads := &batchUploads{}
+	defer uploads.cleanup()
+	for _, platform := range []string{"ios", "android"} {
+		p := manifest.Ios
+		if platform == "android" {
+			p = manifest.Android
+		}
+		entry := batchReleaseEntry{
+			AppName:        p.AppName,
+			Deployment:     p.Deployment,
+			Version:        p.Version,
+			Hash:           p.Hash,
+			BundleName:     p.BundleName,
+			BinaryMetadata: p.BinaryMetadata,
+			Description:    manifest.Description,
+			Descriptions:   manifest.Descriptions,
+			Rollout:        manifest.Rollout,
+			IsMandatory:    manifest.IsMandatory,
+			Metadata:       metadata,
+			Private:        manifest.Private,
+		}
+		releases = append(releases, prepareBatchRelease(ctx, uid, entry, manifest.FreezeOverrideReason, manifest.Provenance, seen, uploads, func() ([]byte, func()) {
+			return readPlatformBundle(ctx, platform)
+		}))
+	}
+	results := publishBatchReleases(ctx, uid, releases, uploads, "package.release_both", train)
+	results[0]["platform"] = "ios"
+	results[1]["platform"] = "android"
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"train":    train,
+		"releases": results,
+	})
+}
+
+// 和uploadBundle一样接受zip、tar.gz或单个bundle文件
+func readPlatformBundle(ctx *gin.Context, field string) ([]byte, func()) {
+	_, headers, err := ctx.Request.FormFile(field)
+	if err != nil {
+		panic(errInvalid("required", field, "bundle file is required"))
+	}
+	_, data, release := readBundle(field+": ", headers.Filename, headers.Size, func() (io.ReadCloser, error) {
+		return headers.Open()
+	})
+	return data, release
+}
@@ -0,0 +1,129 @@
+package request
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type setBuildPinReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	// 客户端update_check上报的build_number
+	BuildNumber *string `json:"buildNumber" binding:"required,max=64"`
+	Label       *string `json:"label" binding:"required"`
+	Note        *string `json:"note"`
+}
+
+// 两个商店构建共用一个appVersion但原生模块不同时,把其中一个构建号固定到兼容的包;
+// 同一个appVersion和构建号只保留一条,再次设置时替换
+func (App) SetBuildPin(ctx *gin.Context) {
+	req := setBuildPinReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		if *req.BuildNumber == "" {
+			panic(errInvalid("required", "buildNumber", "is required"))
+		}
+		pack := getPackageByLabel(ctx, *req.AppName, *req.Deployment, *req.Label)
+		deploymentVersion := model.GetOne[model.DeploymentVersion]("id", *pack.DeploymentVersionId)
+		if deploymentVersion == nil {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Deployment version not found"))
+		}
+		pin := model.BuildPin{}.GetByBuildNumber(*deploymentVersion.Id, *req.BuildNumber)
+		if pin == nil {
+			pin = &model.BuildPin{
+				DeploymentId:        deployment.Id,
+				DeploymentVersionId: deploymentVersion.Id,
+				AppVersion:          deploymentVersion.AppVersion,
+				BuildNumber:         req.BuildNumber,
+			}
+		}
+		pin.PackageId = pack.Id
+		pin.Label = pack.Label
+		pin.Note = req.Note
+		pin.Uid = &uid
+		pin.CreateTime = utils.GetTimeNow()
+		if pin.Id == nil {
+			if err := model.Create[model.BuildPin](pin); err != nil {
+				log.Panic(err.Error())
+			}
+		} else {
+			model.Update[model.BuildPin](pin)
+		}
+		addAuditLog(ctx, uid, "build_pin.set", *req.AppName+"/"+*req.Deployment+"/"+*deploymentVersion.AppVersion+"+"+*req.BuildNumber, *req.Label)
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"id":         pin.Id,
+			"appVersion": deploymentVersion.AppVersion,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (App) LsBuildPin(ctx *gin.Context) {
+	req := lsDeploymentFreezeReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		ctx.JSON(http.StatusOK, model.BuildPin{}.GetByDeploymentId(*deployment.Id))
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (App) DelBuildPin(ctx *gin.Context) {
+	req := delDeploymentFreezeReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		pin := model.GetOne[model.BuildPin]("id=?", *req.Id)
+		if pin == nil || *pin.DeploymentId != *deployment.Id {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Build pin not found"))
+		}
+		model.Delete[model.BuildPin](model.BuildPin{Id: pin.Id})
+		addAuditLog(ctx, uid, "build_pin.delete", *req.AppName+"/"+*req.Deployment, strconv.Itoa(*pin.Id))
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 只加载客户端当前appVersion的固定,构建号 -> 包
+func getBuildPins(deploymentId int, deploymentVersion *model.DeploymentVersion) map[string]*updateInfo {
+	if deploymentVersion == nil {
+		return nil
+	}
+	pins := model.BuildPin{}.GetByDeploymentId(deploymentId)
+	if pins == nil {
+		return nil
+	}
+	var infos map[string]*updateInfo
+	for _, pin := range *pins {
+		if pin.DeploymentVersionId == nil || *pin.DeploymentVersionId != *deploymentVersion.Id || pin.PackageId == nil {
+			continue
+		}
+		pack := model.GetOne[model.Package]("id", *pin.PackageId)
+		if pack == nil {
+			continue
+		}
+		info := packageUpdateInfo(pack, deploymentVersion)
+		if infos == nil {
+			infos = map[string]*updateInfo{}
+		}
+		infos[*pin.BuildNumber] = &info
+	}
+	return infos
+}
@@ -0,0 +1,263 @@
+package request
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/diff"
+)
+
+const (
+	BUNDLE_FORMAT_ZIP   = "zip"
+	BUNDLE_FORMAT_TARGZ = "tar.gz"
+	BUNDLE_FORMAT_RAW   = "raw"
+)
+
+// 单个bundle文件放在CodePush目录下,与CLI打包的结构一致
+const rawBundleFolder = "CodePush"
+
+func bundleFormat(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		return BUNDLE_FORMAT_TARGZ
+	case strings.HasSuffix(lower, ".jsbundle") || strings.HasSuffix(lower, ".bundle") || strings.HasSuffix(lower, ".js"):
+		return BUNDLE_FORMAT_RAW
+	}
+	return BUNDLE_FORMAT_ZIP
+}
+
+// tar.gz和单个bundle文件转成zip,返回新的存储key;zip原样返回
+func normalizeBundle(name string, data []byte) (string, []byte, error) {
+	format := bundleFormat(name)
+	if format == BUNDLE_FORMAT_ZIP {
+		return name, data, nil
+	}
+	var files map[string][]byte
+	var err error
+	if format == BUNDLE_FORMAT_TARGZ {
+		files, err = readTarGz(data)
+		if err != nil {
+			return "", nil, err
+		}
+	} else {
+		files = map[string][]byte{rawBundleFolder + "/" + path.Base(name): data}
+	}
+	zipData, err := writeZip(files)
+	if err != nil {
+		return "", nil, err
+	}
+	base := path.Base(name)
+	lower := strings.ToLower(base)
+	for _, ext := range []string{".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			base = base[:len(base)-len(ext)]
+		}
+	}
+	return path.Join(path.Dir(name), base+".zip"), zipData, nil
+}
+
+// 逐个读取tar.gz中的普通文件,按tar头中的大小计算unzip限制,读取前拒绝超限的文件
+func walkTarGz(r io.Reader, fn func(name string, r io.Reader) error) error {
+	c := config.GetConfig().Diff
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	limit := c.UnzipMaxMB * 1024 * 1024
+	tr := tar.NewReader(gz)
+	count := 0
+	var total int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		if name == "" || ignoredBundleFile(name) {
+			continue
+		}
+		if count >= c.UnzipMaxFiles {
+			return &diff.LimitError{Msg: fmt.Sprintf("tar has more than %d files", c.UnzipMaxFiles)}
+		}
+		count++
+		total += header.Size
+		if total > limit {
+			return &diff.LimitError{Msg: fmt.Sprintf("tar uncompressed size exceeds %dMB", c.UnzipMaxMB)}
+		}
+		if err := fn(name, tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readTarGz(data []byte) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := walkTarGz(bytes.NewReader(data), func(name string, r io.Reader) error {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		files[name] = content
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("tar has no files")
+	}
+	return files, nil
+}
+
+// 读取和转换bundle需要的内存: 文件本身,tar.gz和单个bundle文件再加上解压后的文件和生成的zip;
+// tar.gz只读一遍tar头,不解压到内存
+func bundleMemory(name string, size int64, r io.Reader) (int64, error) {
+	switch bundleFormat(name) {
+	case BUNDLE_FORMAT_ZIP:
+		return size, nil
+	case BUNDLE_FORMAT_RAW:
+		return 2 * size, nil
+	}
+	var total int64
+	err := walkTarGz(r, func(_ string, r io.Reader) error {
+		n, err := io.Copy(io.Discard, r)
+		total += n
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size + 2*total, nil
+}
+
+// 在unzip_memory_mb内排队,返回的函数释放占用,可以重复调用
+func reserveBundle(prefix string, n int64) func() {
+	release, err := diff.Reserve(n)
+	if err != nil {
+		panicArchiveError(prefix, err)
+	}
+	return sync.OnceFunc(release)
+}
+
+// 按需要的内存排队后读入并转换成zip,返回新的key、zip内容和释放内存占用的函数
+func readBundle(prefix string, name string, size int64, open func() (io.ReadCloser, error)) (string, []byte, func()) {
+	n := size
+	if bundleFormat(name) != BUNDLE_FORMAT_ZIP {
+		r, err := open()
+		if err != nil {
+			log.Panic(err.Error())
+		}
+		n, err = bundleMemory(name, size, r)
+		r.Close()
+		if err != nil {
+			panicArchiveError(prefix, err)
+		}
+	}
+	release := reserveBundle(prefix, n)
+	defer func() {
+		if r := recover(); r != nil {
+			release()
+			panic(r)
+		}
+	}()
+	r, err := open()
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	defer r.Close()
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := buf.ReadFrom(r); err != nil {
+		log.Panic(err.Error())
+	}
+	key, data, err := normalizeBundle(name, buf.Bytes())
+	if err != nil {
+		panicArchiveError(prefix, err)
+	}
+	return key, data, release
+}
+
+// 与code-push CLI生成packageHash时忽略的文件一致
+func ignoredBundleFile(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(name, "__MACOSX/") || base == ".DS_Store" || base == ".codepushrelease"
+}
+
+func writeZip(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range names {
+		out, err := w.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := out.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// code-push的packageHash:每个文件"路径:sha256"排序后的JSON数组再做sha256
+func packageManifestHash(zipData []byte) (string, error) {
+	r, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		return "", err
+	}
+	manifest := []string{}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || ignoredBundleFile(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		manifest = append(manifest, f.Name+":"+hex.EncodeToString(h.Sum(nil)))
+	}
+	sort.Strings(manifest)
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(manifest); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bytes.TrimRight(buf.Bytes(), "\n"))
+	return hex.EncodeToString(sum[:]), nil
+}
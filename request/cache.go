@@ -0,0 +1,100 @@
+package request
+
+import (
+	"net/http"
+	"strings"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type Admin struct{}
+
+type cacheReq struct {
+	DeploymentKey *string `json:"deploymentKey" binding:"required"`
+	// 为空时匹配所有版本/bundle
+	AppVersion *string `json:"appVersion"`
+	BundleName *string `json:"bundleName"`
+}
+
+type cacheEntry struct {
+	Key         string `json:"key"`
+	AppVersion  string `json:"appVersion"`
+	BundleName  string `json:"bundleName"`
+	Ttl         int64  `json:"ttl"`
+	Label       string `json:"label"`
+	PackageHash string `json:"packageHash"`
+	PackageSize int64  `json:"packageSize"`
+	NewVersion  string `json:"newVersion"`
+	Diffs       int    `json:"diffs"`
+}
+
+// update_check缓存key: UPDATE_INFO:{deploymentKey}:{appVersion}[:{bundleName}]
+func findCacheKeys(req *cacheReq) []string {
+	prefix := constants.REDIS_UPDATE_INFO + *req.DeploymentKey + ":"
+	var keys []string
+	for _, key := range redis.ScanKeys(prefix + "*") {
+		appVersion, bundleName, _ := strings.Cut(strings.TrimPrefix(key, prefix), ":")
+		if req.AppVersion != nil && *req.AppVersion != appVersion {
+			continue
+		}
+		if req.BundleName != nil && *req.BundleName != bundleName {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (Admin) LsCache(ctx *gin.Context) {
+	req := cacheReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		prefix := constants.REDIS_UPDATE_INFO + *req.DeploymentKey + ":"
+		entries := []cacheEntry{}
+		for _, key := range findCacheKeys(&req) {
+			info := redis.GetRedisObj[updateInfoRedisInfo](key)
+			if info == nil {
+				continue
+			}
+			appVersion, bundleName, _ := strings.Cut(strings.TrimPrefix(key, prefix), ":")
+			entries = append(entries, cacheEntry{
+				Key:         key,
+				AppVersion:  appVersion,
+				BundleName:  bundleName,
+				Ttl:         redis.GetTTL(key),
+				Label:       info.Label,
+				PackageHash: info.PackageHash,
+				PackageSize: info.PackageSize,
+				NewVersion:  info.NewVersion,
+				Diffs:       len(info.Diffs),
+			})
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    entries,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 只删除指定部署的缓存,避免在共享redis上FLUSHDB
+func (Admin) DelCache(ctx *gin.Context) {
+	req := cacheReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		keys := findCacheKeys(&req)
+		for _, key := range keys {
+			redis.DelRedisObj(key)
+		}
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		addAuditLog(ctx, uid, "cache.flush", *req.DeploymentKey, strings.Join(keys, ","))
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"deleted": len(keys),
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
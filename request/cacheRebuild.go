@@ -0,0 +1,164 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/flags"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+)
+
+type cacheRebuildReq struct {
+	// 为空时重建所有应用
+	AppId *int `json:"appId"`
+}
+
+type cacheRebuild struct {
+	RebuildId   string   `json:"rebuildId"`
+	AppId       *int     `json:"appId,omitempty"`
+	Status      string   `json:"status"`
+	Deployments int      `json:"deployments"`
+	Done        int      `json:"done"`
+	Versions    int      `json:"versions"`
+	Failed      int      `json:"failed"`
+	Errors      []string `json:"errors,omitempty"`
+	CreateTime  int64    `json:"createTime"`
+	UpdateTime  int64    `json:"updateTime"`
+}
+
+const cacheRebuildTTL = 24 * time.Hour
+
+// 最多保留的错误信息条数
+const cacheRebuildMaxErrors = 50
+
+func saveCacheRebuild(rebuild *cacheRebuild) {
+	rebuild.UpdateTime = time.Now().UnixMilli()
+	redis.SetRedisObj(constants.REDIS_CACHE_REBUILD+rebuild.RebuildId, rebuild, cacheRebuildTTL)
+}
+
+func (r *cacheRebuild) addError(msg string) {
+	r.Failed++
+	if len(r.Errors) < cacheRebuildMaxErrors {
+		r.Errors = append(r.Errors, msg)
+	}
+}
+
+// Redis数据丢失或迁移后,从MySQL重建deployment_lookup和update_check缓存
+// 在后台执行,通过GET /admin/cache/rebuild?rebuildId=查询进度
+func (Admin) RebuildCache(ctx *gin.Context) {
+	req := cacheRebuildReq{}
+	// 允许空请求体
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err != nil && err != io.EOF {
+		panic(bindError(err))
+	}
+	var deployments *[]model.Deployment
+	target := "*"
+	if req.AppId != nil {
+		if model.GetOne[model.App]("id", *req.AppId) == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		deployments = model.Deployment{}.GetByAppids(*req.AppId)
+		target = strconv.Itoa(*req.AppId)
+	} else {
+		deployments = model.GetList[model.Deployment]("id>?", 0)
+	}
+	if deployments == nil {
+		deployments = &[]model.Deployment{}
+	}
+	rebuild := &cacheRebuild{
+		RebuildId:   uuid.NewString(),
+		AppId:       req.AppId,
+		Status:      constants.JOB_STATUS_RUNNING,
+		Deployments: len(*deployments),
+		CreateTime:  time.Now().UnixMilli(),
+	}
+	saveCacheRebuild(rebuild)
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	addAuditLog(ctx, uid, "cache.rebuild", target, rebuild.RebuildId)
+	go runCacheRebuild(rebuild, *deployments)
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"success":   true,
+		"rebuildId": rebuild.RebuildId,
+		"status":    rebuild.Status,
+	})
+}
+
+func (Admin) RebuildCacheStatus(ctx *gin.Context) {
+	rebuildId := ctx.Query("rebuildId")
+	if rebuildId == "" {
+		panic(errInvalid("required", "rebuildId", "is required"))
+	}
+	rebuild := redis.GetRedisObj[cacheRebuild](constants.REDIS_CACHE_REBUILD + rebuildId)
+	if rebuild == nil {
+		panic(errNotFound(constants.ERR_NOT_FOUND, "Rebuild not found"))
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"rebuild": rebuild,
+	})
+}
+
+func runCacheRebuild(rebuild *cacheRebuild, deployments []model.Deployment) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("cache rebuild %s failed: %v", rebuild.RebuildId, r)
+			sentry.CapturePanic("cache_rebuild", r, map[string]string{"rebuildId": rebuild.RebuildId})
+			rebuild.Status = constants.JOB_STATUS_FAILED
+			rebuild.addError(fmt.Sprint(r))
+			saveCacheRebuild(rebuild)
+		}
+	}()
+	flags.Invalidate()
+	for _, deployment := range deployments {
+		rebuildDeploymentCache(rebuild, &deployment)
+		rebuild.Done++
+		saveCacheRebuild(rebuild)
+	}
+	rebuild.Status = constants.JOB_STATUS_SUCCEEDED
+	saveCacheRebuild(rebuild)
+	log.Printf("cache rebuild %s: %d deployments, %d versions, %d failed", rebuild.RebuildId, rebuild.Done, rebuild.Versions, rebuild.Failed)
+}
+
+func rebuildDeploymentCache(rebuild *cacheRebuild, deployment *model.Deployment) {
+	if err := (model.DeploymentLookup{}).Rebuild(nil, *deployment.Id); err != nil {
+		rebuild.addError(*deployment.Key + ": " + err.Error())
+		return
+	}
+	redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+	redis.DelKeys(constants.REDIS_UNKNOWN_KEY + *deployment.Key)
+	versions := model.GetList[model.DeploymentVersion]("deployment_id", *deployment.Id)
+	if versions == nil {
+		return
+	}
+	for _, version := range *versions {
+		req := &updateCheckReq{DeploymentKey: *deployment.Key, AppVersion: *version.AppVersion}
+		if version.BundleName != nil {
+			req.BundleName = *version.BundleName
+		}
+		if err := rebuildUpdateInfo(req); err != nil {
+			rebuild.addError(*deployment.Key + " " + req.AppVersion + ": " + err.Error())
+			continue
+		}
+		rebuild.Versions++
+	}
+}
+
+func rebuildUpdateInfo(req *updateCheckReq) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	loadUpdateInfo(req, updateInfoRedisKey(req))
+	return nil
+}
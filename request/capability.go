@@ -0,0 +1,105 @@
+package request
+
+import (
+	"encoding/json"
+	"strings"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 只统计已知的能力,避免客户端随意传值导致指标标签过多
+var knownCapabilities = []string{
+	constants.CAPABILITY_DIFF,
+	constants.CAPABILITY_ZSTD,
+	constants.CAPABILITY_CAMEL_CASE,
+}
+
+// 旧客户端都支持差量包;SDK版本达到sdk_capabilities配置的视为支持,capabilities中带-前缀的关闭,例如 "zstd,-diff"
+func capabilities(req *updateCheckReq) map[string]bool {
+	if req.caps != nil {
+		return req.caps
+	}
+	caps := map[string]bool{constants.CAPABILITY_DIFF: true}
+	if req.SdkVersion != "" {
+		for name, minVersion := range config.GetConfig().SdkCapabilities {
+			if versionAtLeast(req.SdkVersion, minVersion) {
+				caps[name] = true
+			}
+		}
+	}
+	for _, c := range strings.Split(req.Capabilities, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if name, ok := strings.CutPrefix(c, "-"); ok {
+			delete(caps, name)
+		} else if c != "" {
+			caps[c] = true
+		}
+	}
+	req.caps = caps
+	return caps
+}
+
+// 版本号格式不对时返回false
+func versionAtLeast(version string, minVersion string) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return utils.FormatVersionStr(version) >= utils.FormatVersionStr(minVersion)
+}
+
+// 请求参数优先,其次是SDK自带的X-CodePush-SDK-Version请求头
+func sdkVersion(ctx *gin.Context, req *updateCheckReq) {
+	if req.SdkVersion == "" {
+		req.SdkVersion = ctx.GetHeader("X-CodePush-SDK-Version")
+	}
+}
+
+func recordCapabilities(req *updateCheckReq) {
+	caps := capabilities(req)
+	counted := false
+	for _, name := range knownCapabilities {
+		if caps[name] {
+			metrics.Count("update_check_capability", 1, map[string]string{"capability": name})
+			counted = true
+		}
+	}
+	if !counted {
+		metrics.Count("update_check_capability", 1, map[string]string{"capability": "none"})
+	}
+}
+
+// camel_case的客户端使用 updateInfo/downloadUrl/isAvailable 等字段名,应用关闭new_api_version时仍使用旧字段名
+func shapeUpdateCheck(req *updateCheckReq, info *updateInfo) any {
+	if req.legacyApi || !capabilities(req)[constants.CAPABILITY_CAMEL_CASE] {
+		return gin.H{"update_info": info}
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		panic(err.Error())
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		panic(err.Error())
+	}
+	shaped := make(map[string]any, len(fields))
+	for k, v := range fields {
+		shaped[camelCase(k)] = v
+	}
+	return gin.H{"updateInfo": shaped}
+}
+
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
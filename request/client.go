@@ -1,114 +1,426 @@
 package request
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"com.lc.go.codepush/server/analytics"
+	"com.lc.go.codepush/server/anomaly"
 	"com.lc.go.codepush/server/config"
 	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/events"
+	"com.lc.go.codepush/server/flags"
+	"com.lc.go.codepush/server/kafka"
+	"com.lc.go.codepush/server/metrics"
 	"com.lc.go.codepush/server/model"
 	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/report"
+	"com.lc.go.codepush/server/rollup"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/storage"
 	"com.lc.go.codepush/server/utils"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gin-gonic/gin"
 )
 
 type Client struct{}
 type updateInfo struct {
-	DownloadUrl            string `json:"download_url"`
-	Description            string `json:"description"`
-	IsAvailable            bool   `json:"is_available"`
-	IsDisabled             bool   `json:"is_disabled"`
-	TargetBinaryRange      string `json:"target_binary_range"`
-	PackageHash            string `json:"package_hash"`
-	Label                  string `json:"label"`
-	PackageSize            int64  `json:"package_size"`
-	UpdateAppVersion       bool   `json:"update_app_version"`
-	ShouldRunBinaryVersion bool   `json:"should_run_binary_version"`
-	IsMandatory            bool   `json:"is_mandatory"`
+	DownloadUrl            string            `json:"download_url"`
+	Description            string            `json:"description"`
+	IsAvailable            bool              `json:"is_available"`
+	IsDisabled             bool              `json:"is_disabled"`
+	TargetBinaryRange      string            `json:"target_binary_range"`
+	PackageHash            string            `json:"package_hash"`
+	Label                  string            `json:"label"`
+	PackageSize            int64             `json:"package_size"`
+	UpdateAppVersion       bool              `json:"update_app_version"`
+	ShouldRunBinaryVersion bool              `json:"should_run_binary_version"`
+	IsMandatory            bool              `json:"is_mandatory"`
+	AppStoreUrl            string            `json:"app_store_url,omitempty"`
+	Metadata               map[string]string `json:"metadata,omitempty"`
+	// locale -> description,返回前由localize选出一个
+	Descriptions map[string]string `json:"descriptions,omitempty"`
+	// 为空表示zip
+	PackageFormat string `json:"package_format,omitempty"`
 }
 type updateInfoRedisInfo struct {
 	updateInfo
-	NewVersion string
+	NewVersion         string
+	SecretHash         string
+	PreviousSecretHash string
+	// 客户端当前包hash -> 差量包
+	Diffs map[string]diffInfo
+	// 灰度百分比,nil表示全量
+	Rollout       *int
+	RolloutPaused bool
+	Fallback      *updateInfo
+	ClientRules   []clientRuleInfo
+	ForceBinary   *updateInfo
+	Zstd          *diffInfo
+	// 指标的app标签
+	AppName string
+	// 邀请码hash -> 预发布包
+	Invites map[string]inviteInfo
+	// 安装包构建号 -> 固定的包
+	BuildPins map[string]*updateInfo
+	// 临时部署的id,update_check时记录活动时间
+	EphemeralId int
+	// 应用所属账号和套餐的每月活跃客户端限制,0表示不限制
+	OwnerUid           int
+	MonthlyActiveLimit int
+	// 调试日志开关,nil表示关闭
+	Debug *debugLogFlag
+	// 关闭new_api_version时为true,只返回旧的字段名
+	LegacyApi bool
+}
+type clientRuleInfo struct {
+	ClientUniqueId string
+	Block          bool
+	Pin            *updateInfo
+	// 毫秒,0表示一直有效;缓存中的规则过期后不再匹配
+	ExpireTime int64
+}
+type diffInfo struct {
+	DownloadUrl string
+	PackageSize int64
+}
+
+var errUnknownKey = constants.ErrObj{
+	Status: http.StatusNotFound,
+	Code:   constants.ERR_DEPLOYMENT_KEY_NOT_FOUND,
+	Msg:    "Deployment key not found",
+}
+
+type updateCheckReq struct {
+	DeploymentKey  string `json:"deployment_key" form:"deployment_key"`
+	AppVersion     string `json:"app_version" form:"app_version"`
+	PackageHash    string `json:"package_hash" form:"package_hash"`
+	Label          string `json:"label" form:"label"`
+	ClientUniqueId string `json:"client_unique_id" form:"client_unique_id"`
+	BundleName     string `json:"bundle_name" form:"bundle_name"`
+	// sha256(deployment secret)
+	DeploymentSecret string `json:"deployment_secret" form:"deployment_secret"`
+	// 客户端支持的能力,逗号分隔,例如 "diff,zstd"
+	Capabilities string `json:"capabilities" form:"capabilities"`
+	// 为空时使用X-CodePush-SDK-Version请求头
+	SdkVersion string `json:"sdk_version" form:"sdk_version"`
+	// 预发布包的邀请码
+	InviteToken string `json:"invite_token" form:"invite_token"`
+	// 优先于Accept-Language,例如 zh-TW
+	Locale string `json:"locale" form:"locale"`
+	// 安装包构建号,iOS CFBundleVersion / Android versionCode
+	BuildNumber string `json:"build_number" form:"build_number"`
+	// checkUpdate之后填入,用于指标标签
+	appName   string
+	legacyApi bool
+	caps      map[string]bool
+	// 开启调试日志时为缓存的更新信息
+	debug *updateInfoRedisInfo
 }
 
 func (Client) CheckUpdate(ctx *gin.Context) {
-	deploymentKey := ctx.Query("deployment_key")
-	appVersion := ctx.Query("app_version")
-	packageHash := ctx.Query("package_hash")
-	// label := ctx.Query("label")
-	// clientUniqueId := ctx.Query("client_unique_id")
-	redisKey := constants.REDIS_UPDATE_INFO + deploymentKey + ":" + appVersion
-	updateInfoRedis := redis.GetRedisObj[updateInfoRedisInfo](redisKey)
-	updateInfo := updateInfo{}
-	config := config.GetConfig()
+	req := updateCheckReq{}
+	ctx.ShouldBindQuery(&req)
+	ctx.Set(constants.GIN_DEPLOYMENT_KEY, req.DeploymentKey)
+	sdkVersion(ctx, &req)
+	recordTraffic(&req)
+	var updateInfo updateInfo
+	defer func() {
+		if err := recover(); err != nil {
+			writeDebugLog(ctx, &req, nil, err)
+			panic(err)
+		}
+		writeDebugLog(ctx, &req, &updateInfo, nil)
+	}()
+	updateInfo = checkUpdate(&req)
+	updateInfo.DownloadUrl = clientDownloadUrl(ctx, &req, updateInfo.DownloadUrl)
+	localize(ctx, &req, &updateInfo)
+	recordUpdateCheck(&req, &updateInfo)
+	exportUpdateCheck(ctx, &req, &updateInfo)
+	setMetadataHeaders(ctx, updateInfo.Metadata)
+	writeJSON(ctx, http.StatusOK, shapeUpdateCheck(&req, &updateInfo))
+}
 
-	if updateInfoRedis == nil {
-		updateInfoRedis = &updateInfoRedisInfo{}
-		deployment := model.GetOne[model.Deployment]("key", deploymentKey)
-		if deployment == nil {
-			log.Panic("Key error")
-		}
-		deploymentVersion := model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, appVersion)
-		if deploymentVersion != nil {
-			if deploymentVersion.CurrentPackage != nil {
-				packag := model.GetOne[model.Package]("id", deploymentVersion.CurrentPackage)
-				if packag != nil {
-					// && *packag.Hash != packageHash
-					updateInfoRedis.TargetBinaryRange = *deploymentVersion.AppVersion
-					updateInfoRedis.PackageHash = *packag.Hash
-					updateInfoRedis.PackageSize = *packag.Size
-					updateInfoRedis.IsAvailable = true
-					updateInfoRedis.IsMandatory = false
-					label := strconv.Itoa(*packag.Id)
-					updateInfoRedis.Label = label
-
-					s3Config := &aws.Config{
-						Credentials:      credentials.NewStaticCredentials(config.CodePush.Aws.KeyId, config.CodePush.Aws.Secret, ""),
-						Endpoint:         aws.String(config.CodePush.Aws.Endpoint),
-						Region:           aws.String(config.CodePush.Aws.Region),
-						S3ForcePathStyle: aws.Bool(config.CodePush.Aws.S3ForcePathStyle),
-					}
-					newSession, _ := session.NewSession(s3Config)
+type batchUpdateCheckReq struct {
+	Checks []updateCheckReq `json:"checks" binding:"required,max=50"`
+}
+
+type batchUpdateCheckResult struct {
+	DeploymentKey string      `json:"deployment_key"`
+	UpdateInfo    *updateInfo `json:"update_info,omitempty"`
+	Error         string      `json:"error,omitempty"`
+	Code          int         `json:"code,omitempty"`
+}
+
+// 一次请求检查多个(deploymentKey, appVersion, packageHash),单个失败不影响其他结果
+func (Client) BatchCheckUpdate(ctx *gin.Context) {
+	req := batchUpdateCheckReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Panic(err.Error())
+	}
+	results := make([]batchUpdateCheckResult, len(req.Checks))
+	for i := range req.Checks {
+		sdkVersion(ctx, &req.Checks[i])
+		recordTraffic(&req.Checks[i])
+		results[i] = batchCheckUpdate(&req.Checks[i])
+		if results[i].UpdateInfo != nil {
+			results[i].UpdateInfo.DownloadUrl = clientDownloadUrl(ctx, &req.Checks[i], results[i].UpdateInfo.DownloadUrl)
+			localize(ctx, &req.Checks[i], results[i].UpdateInfo)
+			exportUpdateCheck(ctx, &req.Checks[i], results[i].UpdateInfo)
+		}
+		if results[i].Error != "" {
+			writeDebugLog(ctx, &req.Checks[i], nil, results[i].Error)
+		} else {
+			writeDebugLog(ctx, &req.Checks[i], results[i].UpdateInfo, nil)
+		}
+	}
+	writeJSON(ctx, http.StatusOK, gin.H{
+		"results": results,
+	})
+}
+
+func batchCheckUpdate(req *updateCheckReq) (result batchUpdateCheckResult) {
+	result.DeploymentKey = req.DeploymentKey
+	defer func() {
+		if err := recover(); err != nil {
+			result.UpdateInfo = nil
+			if e, ok := err.(constants.ErrObj); ok {
+				result.Error = e.Msg
+				result.Code = e.Code
+				return
+			}
+			// 其他异常可能带有sql或内部信息,只记录日志,客户端收到统一的错误
+			log.Printf("batch update_check error:%v", err)
+			sentry.CapturePanic("batch_update_check", err, nil)
+			result.Error = "system error"
+			result.Code = constants.ERR_INTERNAL
+		}
+	}()
+	updateInfo := checkUpdate(req)
+	recordUpdateCheck(req, &updateInfo)
+	result.UpdateInfo = &updateInfo
+	return
+}
+
+// 返回给客户端的下载地址:开启blob_url_binding时绑定请求的ip/clientUniqueId,相对地址补全
+func clientDownloadUrl(ctx *gin.Context, req *updateCheckReq, u string) string {
+	u = storage.BindBlobUrl(u, ctx.GetString(constants.GIN_CLIENT_IP), req.ClientUniqueId)
+	return absoluteUrl(ctx, u)
+}
+
+// deploymentKey -> 应用名,report_status/download没有查询应用,使用update_check时记下的值
+var deploymentAppNames sync.Map
+
+func recordUpdateCheck(req *updateCheckReq, info *updateInfo) {
+	result := "none"
+	if info.IsAvailable {
+		result = "update"
+	} else if info.UpdateAppVersion {
+		result = "binary"
+	}
+	if req.appName != "" {
+		deploymentAppNames.Store(req.DeploymentKey, req.appName)
+	}
+	recordCapabilities(req)
+	recordClientState(req)
+	anomaly.RecordCheck(req.DeploymentKey)
+	rollup.RecordCheck(req.DeploymentKey)
+	metrics.Count("update_check", 1, map[string]string{"result": result, "app": metrics.AppLabel(req.appName)})
+}
 
-					s3Client := s3.New(newSession)
-					request, _ := s3Client.GetObjectRequest(&s3.GetObjectInput{
-						Bucket: aws.String(config.CodePush.Aws.Bucket),
-						Key:    aws.String(*packag.Download),
-					})
+func exportRecord(ctx *gin.Context, rec analytics.Record) {
+	if !analytics.Enabled() && !kafka.Enabled() {
+		return
+	}
+	rec.Country = ctx.GetHeader(config.GetConfig().AccessExport.CountryHeader)
+	analytics.Add(rec)
+	kafka.Acquisition(rec)
+}
+
+// label为下发的包,没有更新时为空
+func exportUpdateCheck(ctx *gin.Context, req *updateCheckReq, info *updateInfo) {
+	rec := analytics.Record{Event: analytics.EVENT_UPDATE_CHECK, DeploymentKey: req.DeploymentKey, AppVersion: req.AppVersion}
+	if info.IsAvailable {
+		rec.Label = info.Label
+		rec.PackageHash = info.PackageHash
+	}
+	exportRecord(ctx, rec)
+}
+
+func appLabelByKey(deploymentKey *string) string {
+	if deploymentKey != nil {
+		if appName, ok := deploymentAppNames.Load(*deploymentKey); ok {
+			return metrics.AppLabel(appName.(string))
+		}
+	}
+	return metrics.AppLabel("")
+}
 
-					resourceURL, err := request.Presign(24 * time.Hour) // 24 hours
+// 缓存未命中时从数据库加载并写入redis
+func loadUpdateInfo(req *updateCheckReq, redisKey string) *updateInfoRedisInfo {
+	updateInfoRedis := &updateInfoRedisInfo{}
+	deployment, deploymentVersion, newVersion := resolveDeployment(req.DeploymentKey, req.BundleName, req.AppVersion)
+	// 关闭signing_enforcement时不缓存secret,checkDeploymentSecret直接通过
+	if flags.Enabled(flags.SIGNING_ENFORCEMENT, *deployment.AppId) {
+		if deployment.SecretHash != nil {
+			updateInfoRedis.SecretHash = *deployment.SecretHash
+		}
+		if deployment.PreviousSecretHash != nil {
+			updateInfoRedis.PreviousSecretHash = *deployment.PreviousSecretHash
+		}
+	}
+	updateInfoRedis.LegacyApi = !flags.Enabled(flags.NEW_API_VERSION, *deployment.AppId)
+	if deployment.ForceBinaryUpdate != nil && *deployment.ForceBinaryUpdate {
+		updateInfoRedis.ForceBinary = forceBinaryInfo(deployment)
+	}
+	if deploymentVersion != nil {
+		if deploymentVersion.CurrentPackage != nil {
+			packag := model.GetOne[model.Package]("id", deploymentVersion.CurrentPackage)
+			if packag != nil {
+				// && *packag.Hash != packageHash
+				updateInfoRedis.updateInfo = packageUpdateInfo(packag, deploymentVersion)
+				if flags.Enabled(flags.DIFF_SERVING, *deployment.AppId) {
+					updateInfoRedis.Diffs = getDiffs(*packag.Id)
+				}
+				if packag.ZstdDownload != nil {
+					zstdURL, err := storage.DownloadUrl(*packag.ZstdDownload, packag.ReplicationStatus)
 					if err != nil {
 						log.Panic("Failed to sign request", err)
 					}
-					updateInfoRedis.DownloadUrl = resourceURL
-					if packag.Description != nil {
-						updateInfoRedis.Description = *packag.Description
+					updateInfoRedis.Zstd = &diffInfo{DownloadUrl: zstdURL, PackageSize: *packag.ZstdSize}
+				}
+				if packag.Rollout != nil && *packag.Rollout < 100 {
+					updateInfoRedis.Rollout = packag.Rollout
+				}
+				updateInfoRedis.RolloutPaused = packag.RolloutPaused != nil && *packag.RolloutPaused
+				// 不在灰度范围内的客户端使用上一个全量发布的包
+				if (updateInfoRedis.Rollout != nil || updateInfoRedis.RolloutPaused) && flags.Enabled(flags.AUTO_ROLLBACK, *deployment.AppId) {
+					fallback := model.Package{}.GetRollbackPack(*deployment.Id, *packag.Id, *deploymentVersion.Id)
+					if fallback != nil {
+						fallbackInfo := packageUpdateInfo(fallback, deploymentVersion)
+						updateInfoRedis.Fallback = &fallbackInfo
 					}
 				}
 			}
 		}
-		deploymentVersionNew := model.DeploymentVersion{}.GetNewVersionByKeyDeploymentId(*deployment.Id)
-		if deploymentVersionNew != nil {
-			updateInfoRedis.NewVersion = *deploymentVersionNew.AppVersion
+	}
+	updateInfoRedis.ClientRules = getClientRules(*deployment.Id, deploymentVersion)
+	updateInfoRedis.Invites = getInvites(*deployment.Id, deploymentVersion)
+	updateInfoRedis.BuildPins = getBuildPins(*deployment.Id, deploymentVersion)
+	if app := model.GetOne[model.App]("id", deployment.AppId); app != nil {
+		updateInfoRedis.AppName = *app.AppName
+		if plan := (model.TenantPlan{}).GetByUid(*app.Uid); plan != nil {
+			updateInfoRedis.OwnerUid = *app.Uid
+			updateInfoRedis.MonthlyActiveLimit = utils.IntValue(plan.MaxMonthlyActive)
+		}
+	}
+	if deployment.EphemeralDays != nil {
+		updateInfoRedis.EphemeralId = *deployment.Id
+	}
+	updateInfoRedis.Debug = redis.GetRedisObj[debugLogFlag](debugLogKey(req.DeploymentKey))
+	updateInfoRedis.NewVersion = newVersion
+	redis.SetRedisObj(redisKey, updateInfoRedis, time.Duration(config.GetConfig().UpdateCacheTTL)*time.Second)
+	return updateInfoRedis
+}
+
+func updateInfoRedisKey(req *updateCheckReq) string {
+	redisKey := constants.REDIS_UPDATE_INFO + req.DeploymentKey + ":" + req.AppVersion
+	if req.BundleName != "" {
+		redisKey += ":" + req.BundleName
+	}
+	return redisKey
+}
+
+func checkUpdate(req *updateCheckReq) updateInfo {
+	if isUnknownKey(req.DeploymentKey) {
+		panic(errUnknownKey)
+	}
+	redisKey := updateInfoRedisKey(req)
+	updateInfoRedis := redis.GetRedisObj[updateInfoRedisInfo](redisKey)
+	if updateInfoRedis == nil {
+		updateInfoRedis = loadUpdateInfoOnce(req, redisKey)
+	}
+	req.appName = updateInfoRedis.AppName
+	req.legacyApi = updateInfoRedis.LegacyApi
+	if debugLogEnabled(updateInfoRedis.Debug, req.ClientUniqueId) {
+		req.debug = updateInfoRedis
+	}
+	if updateInfoRedis.EphemeralId > 0 {
+		touchEphemeral(updateInfoRedis.EphemeralId)
+	}
+	checkDeploymentSecret(updateInfoRedis, req.DeploymentSecret)
+	if overMonthlyActive(req, updateInfoRedis) {
+		return updateInfo{}
+	}
+	updateInfo := resolveUpdate(req, updateInfoRedis)
+	shadowCheck(req, updateInfoRedis, &updateInfo)
+	return updateInfo
+}
+
+// 旧的解析流程
+func resolveUpdate(req *updateCheckReq, updateInfoRedis *updateInfoRedisInfo) updateInfo {
+	appVersion := req.AppVersion
+	packageHash := req.PackageHash
+	updateInfo := updateInfo{}
+	if updateInfoRedis.ForceBinary != nil {
+		updateInfo = *updateInfoRedis.ForceBinary
+		if updateInfo.TargetBinaryRange == "" {
+			updateInfo.TargetBinaryRange = appVersion
+		}
+		return updateInfo
+	}
+	// 带邀请码的客户端下发预发布包
+	if invite := matchInvite(updateInfoRedis.Invites, req.InviteToken); invite != nil {
+		if invite.PackageHash != packageHash {
+			updateInfo = *invite
 		}
-		redis.SetRedisObj(redisKey, updateInfoRedis, time.Duration(24*time.Hour-(10*time.Second)))
+		return updateInfo
+	}
+	// 固定和禁止规则优先于灰度分桶
+	if rule := matchClientRule(updateInfoRedis.ClientRules, req.ClientUniqueId); rule != nil {
+		if rule.Pin != nil && rule.Pin.PackageHash != packageHash {
+			updateInfo = *rule.Pin
+		}
+		return updateInfo
+	}
+	// 按构建号固定,同一个appVersion的不同商店构建可以使用不同的包
+	if pin, ok := updateInfoRedis.BuildPins[req.BuildNumber]; ok && req.BuildNumber != "" {
+		if pin.PackageHash != packageHash {
+			updateInfo = *pin
+		}
+		return updateInfo
 	}
 	if updateInfoRedis.PackageHash != "" {
-		if updateInfoRedis.PackageHash != packageHash && appVersion == updateInfoRedis.TargetBinaryRange {
+		if updateInfoRedis.PackageHash != packageHash && appVersion == updateInfoRedis.TargetBinaryRange && !inRollout(updateInfoRedis, req.ClientUniqueId) {
+			if fallback := updateInfoRedis.Fallback; fallback != nil && fallback.PackageHash != packageHash {
+				updateInfo = *fallback
+			}
+		} else if updateInfoRedis.PackageHash != packageHash && appVersion == updateInfoRedis.TargetBinaryRange {
 			updateInfo.TargetBinaryRange = updateInfoRedis.TargetBinaryRange
 			updateInfo.PackageHash = updateInfoRedis.PackageHash
 			updateInfo.PackageSize = updateInfoRedis.PackageSize
 			updateInfo.IsAvailable = true
-			updateInfo.IsMandatory = false
+			updateInfo.IsMandatory = updateInfoRedis.IsMandatory
 			updateInfo.Label = updateInfoRedis.Label
 			updateInfo.DownloadUrl = updateInfoRedis.DownloadUrl
 			updateInfo.Description = updateInfoRedis.Description
+			updateInfo.Descriptions = updateInfoRedis.Descriptions
+			updateInfo.Metadata = updateInfoRedis.Metadata
+			caps := capabilities(req)
+			if diff, ok := updateInfoRedis.Diffs[packageHash]; ok && caps[constants.CAPABILITY_DIFF] {
+				updateInfo.DownloadUrl = diff.DownloadUrl
+				updateInfo.PackageSize = diff.PackageSize
+			} else if updateInfoRedis.Zstd != nil && caps[constants.CAPABILITY_ZSTD] {
+				updateInfo.DownloadUrl = updateInfoRedis.Zstd.DownloadUrl
+				updateInfo.PackageSize = updateInfoRedis.Zstd.PackageSize
+				updateInfo.PackageFormat = constants.PACKAGE_FORMAT_TAR_ZSTD
+			}
 		} else if updateInfoRedis.NewVersion != "" && appVersion != updateInfoRedis.NewVersion && utils.FormatVersionStr(appVersion) < utils.FormatVersionStr(updateInfoRedis.NewVersion) {
 			updateInfo.TargetBinaryRange = updateInfoRedis.NewVersion
 			updateInfo.UpdateAppVersion = true
@@ -118,11 +430,196 @@ func (Client) CheckUpdate(ctx *gin.Context) {
 		updateInfo.TargetBinaryRange = updateInfoRedis.NewVersion
 		updateInfo.UpdateAppVersion = true
 	}
+	return updateInfo
+}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"update_info": updateInfo,
-	})
+func packageUpdateInfo(packag *model.Package, deploymentVersion *model.DeploymentVersion) updateInfo {
+	info := updateInfo{
+		TargetBinaryRange: *deploymentVersion.AppVersion,
+		PackageHash:       *packag.Hash,
+		PackageSize:       *packag.Size,
+		IsAvailable:       true,
+		IsMandatory:       packag.IsMandatory != nil && *packag.IsMandatory,
+	}
+	if packag.Label != nil {
+		info.Label = *packag.Label
+	} else {
+		info.Label = strconv.Itoa(*packag.Id)
+	}
+	resourceURL, err := storage.DownloadUrl(*packag.Download, packag.ReplicationStatus)
+	if err != nil {
+		log.Panic("Failed to sign request", err)
+	}
+	info.DownloadUrl = resourceURL
+	if packag.Description != nil {
+		info.Description = *packag.Description
+	}
+	info.Descriptions = decodeLocalized(packag.Descriptions)
+	info.Metadata = decodeMetadata(packag.Metadata)
+	return info
+}
+
+// 优先使用deployment_lookup(主键查询),没有记录时按原来的方式查询并重建该部署的记录
+func resolveDeployment(deploymentKey string, bundleName string, appVersion string) (*model.Deployment, *model.DeploymentVersion, string) {
+	if lookup := (model.DeploymentLookup{}).Resolve(deploymentKey, bundleName, appVersion); lookup != nil {
+		deployment := model.GetOne[model.Deployment]("id", *lookup.DeploymentId)
+		deploymentVersion := model.GetOne[model.DeploymentVersion]("id", *lookup.DeploymentVersionId)
+		if deployment != nil && deploymentVersion != nil {
+			newVersion := ""
+			if lookup.NewVersion != nil {
+				newVersion = *lookup.NewVersion
+			}
+			return deployment, deploymentVersion, newVersion
+		}
+	}
+	if redis.GetRedisObj[bool](constants.REDIS_UNKNOWN_KEY+deploymentKey) != nil {
+		addUnknownKey(deploymentKey)
+		panic(errUnknownKey)
+	}
+	deployment := model.Deployment{}.GetByKey(deploymentKey)
+	if deployment == nil {
+		addUnknownKey(deploymentKey)
+		if allowUnknownKeyWrite() {
+			redis.SetRedisObj(constants.REDIS_UNKNOWN_KEY+deploymentKey, true, time.Duration(config.GetConfig().UnknownKeyCacheTTL)*time.Second)
+		}
+		panic(errUnknownKey)
+	}
+	deploymentVersion := model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, bundleName, appVersion)
+	if deploymentVersion != nil {
+		if err := (model.DeploymentLookup{}).Rebuild(nil, *deployment.Id); err != nil {
+			log.Println("deployment lookup rebuild error:" + err.Error())
+		}
+	}
+	newVersion := ""
+	if deploymentVersionNew := (model.DeploymentVersion{}).GetNewVersionByKeyDeploymentId(*deployment.Id, bundleName); deploymentVersionNew != nil {
+		newVersion = *deploymentVersionNew.AppVersion
+	}
+	return deployment, deploymentVersion, newVersion
+}
+
+// 没有设置地址时使用应用的商店地址 (1=iOS 2=Android);安装包版本以主bundle为准
+func forceBinaryInfo(deployment *model.Deployment) *updateInfo {
+	info := &updateInfo{
+		UpdateAppVersion: true,
+		IsMandatory:      true,
+	}
+	if deployment.ForceBinaryMessage != nil {
+		info.Description = *deployment.ForceBinaryMessage
+	}
+	info.Descriptions = decodeLocalized(deployment.ForceBinaryMessages)
+	if deployment.ForceBinaryUrl != nil && *deployment.ForceBinaryUrl != "" {
+		info.AppStoreUrl = *deployment.ForceBinaryUrl
+	} else if app := model.GetOne[model.App]("id", deployment.AppId); app != nil {
+		if *app.OS == 1 && app.AppStoreUrl != nil {
+			info.AppStoreUrl = *app.AppStoreUrl
+		} else if *app.OS == 2 && app.PlayStoreUrl != nil {
+			info.AppStoreUrl = *app.PlayStoreUrl
+		}
+	}
+	if newVersion := (model.DeploymentVersion{}).GetNewVersionByKeyDeploymentId(*deployment.Id, ""); newVersion != nil {
+		info.TargetBinaryRange = *newVersion.AppVersion
+	}
+	return info
+}
 
+// 固定的包只对同一个版本的客户端生效
+func getClientRules(deploymentId int, deploymentVersion *model.DeploymentVersion) []clientRuleInfo {
+	rules := model.ClientRule{}.GetByDeploymentId(deploymentId)
+	if rules == nil {
+		return nil
+	}
+	var infos []clientRuleInfo
+	for _, rule := range *rules {
+		info := clientRuleInfo{ClientUniqueId: *rule.ClientUniqueId}
+		if rule.ExpireTime != nil {
+			info.ExpireTime = *rule.ExpireTime
+		}
+		if *rule.Action == constants.CLIENT_RULE_BLOCK {
+			info.Block = true
+		} else {
+			if deploymentVersion == nil || rule.PackageId == nil {
+				continue
+			}
+			pack := model.GetOne[model.Package]("id", *rule.PackageId)
+			if pack == nil || *pack.DeploymentVersionId != *deploymentVersion.Id {
+				continue
+			}
+			pin := packageUpdateInfo(pack, deploymentVersion)
+			info.Pin = &pin
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// 完全匹配优先,其次是最长的前缀
+func matchClientRule(rules []clientRuleInfo, clientUniqueId string) *clientRuleInfo {
+	if clientUniqueId == "" {
+		return nil
+	}
+	var matched *clientRuleInfo
+	prefixLen := -1
+	now := time.Now().UnixMilli()
+	for i := range rules {
+		if rules[i].ExpireTime > 0 && rules[i].ExpireTime <= now {
+			continue
+		}
+		id := rules[i].ClientUniqueId
+		if id == clientUniqueId {
+			return &rules[i]
+		}
+		if strings.HasSuffix(id, "*") && strings.HasPrefix(clientUniqueId, id[:len(id)-1]) && len(id) > prefixLen {
+			matched = &rules[i]
+			prefixLen = len(id)
+		}
+	}
+	return matched
+}
+
+// 按clientUniqueId和标签稳定分桶,暂停时不再放量
+func inRollout(info *updateInfoRedisInfo, clientUniqueId string) bool {
+	if info.RolloutPaused {
+		return false
+	}
+	if info.Rollout == nil {
+		return true
+	}
+	if clientUniqueId == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(clientUniqueId + ":" + info.Label))
+	return int(binary.BigEndian.Uint32(sum[:4])%100) < *info.Rollout
+}
+
+func getDiffs(packageId int) map[string]diffInfo {
+	diffs := model.PackageDiff{}.GetSucceededByPackageId(packageId)
+	if diffs == nil || len(*diffs) == 0 {
+		return nil
+	}
+	m := make(map[string]diffInfo)
+	for _, v := range *diffs {
+		url, err := storage.DownloadUrl(*v.Download, nil)
+		if err != nil {
+			log.Panic("Failed to sign request", err)
+		}
+		m[*v.BaseHash] = diffInfo{DownloadUrl: url, PackageSize: *v.Size}
+	}
+	return m
+}
+
+// 轮换期间新旧secret都可以通过
+func checkDeploymentSecret(updateInfoRedis *updateInfoRedisInfo, secret string) {
+	if updateInfoRedis.SecretHash == "" {
+		return
+	}
+	// 按固定时间比较,不能从响应时间猜出hash
+	secretHash := []byte(utils.Sha256Hex(secret))
+	current := subtle.ConstantTimeCompare(secretHash, []byte(updateInfoRedis.SecretHash))
+	previous := subtle.ConstantTimeCompare(secretHash, []byte(updateInfoRedis.PreviousSecretHash))
+	if secret != "" && current|previous == 1 {
+		return
+	}
+	log.Panic("Deployment secret error")
 }
 
 type reportStatuReq struct {
@@ -138,15 +635,24 @@ type reportStatuReq struct {
 func (Client) ReportStatus(ctx *gin.Context) {
 	json := reportStatuReq{}
 	ctx.BindJSON(&json)
+	if json.DeploymentKey != nil {
+		ctx.Set(constants.GIN_DEPLOYMENT_KEY, *json.DeploymentKey)
+	}
 	if json.Status != nil {
-		pack := model.GetOne[model.Package]("id=?", json.Label)
-		if pack != nil {
-			if *json.Status == "DeploymentSucceeded" {
-				model.Package{}.AddActive(*pack.Id)
-			} else if *json.Status == "DeploymentFailed" {
-				model.Package{}.AddFailed(*pack.Id)
-			}
-		}
+		events.Publish(events.Event{
+			Type:          events.STATUS_REPORTED,
+			DeploymentKey: utils.StringValue(json.DeploymentKey),
+			Label:         utils.StringValue(json.Label),
+			AppVersion:    utils.StringValue(json.AppVersion),
+			Status:        *json.Status,
+		})
+		exportRecord(ctx, analytics.Record{
+			Event:         analytics.EVENT_DEPLOY,
+			DeploymentKey: utils.StringValue(json.DeploymentKey),
+			AppVersion:    utils.StringValue(json.AppVersion),
+			Label:         utils.StringValue(json.Label),
+			Status:        *json.Status,
+		})
 
 	}
 
@@ -162,9 +668,15 @@ type downloadReq struct {
 func (Client) Download(ctx *gin.Context) {
 	json := downloadReq{}
 	ctx.BindJSON(&json)
-	pack := model.GetOne[model.Package]("id=?", json.Label)
-	if pack != nil {
-		model.Package{}.AddInstalled(*pack.Id)
+	if json.DeploymentKey != nil {
+		ctx.Set(constants.GIN_DEPLOYMENT_KEY, *json.DeploymentKey)
 	}
+	report.Add(utils.StringValue(json.DeploymentKey), utils.StringValue(json.Label), report.INSTALLED)
+	metrics.Count("download", 1, map[string]string{"app": appLabelByKey(json.DeploymentKey)})
+	exportRecord(ctx, analytics.Record{
+		Event:         analytics.EVENT_DOWNLOAD,
+		DeploymentKey: utils.StringValue(json.DeploymentKey),
+		Label:         utils.StringValue(json.Label),
+	})
 	ctx.String(http.StatusOK, "OK")
 }
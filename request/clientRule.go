@@ -0,0 +1,113 @@
+package request
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type addClientRuleReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	// 以*结尾时匹配一组设备,例如 "qa-*"
+	ClientUniqueId *string `json:"clientUniqueId" binding:"required"`
+	Action         *string `json:"action" binding:"required,oneof=pin block"`
+	Label          *string `json:"label"`
+	Note           *string `json:"note"`
+	// 规则有效的分钟数,为空时一直有效
+	ExpireMinutes *int64 `json:"expireMinutes" binding:"omitempty,min=1"`
+}
+
+// 把指定设备固定到某个标签(用于复现问题)或禁止其更新
+func (App) AddClientRule(ctx *gin.Context) {
+	req := addClientRuleReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		if *req.ClientUniqueId == "" || *req.ClientUniqueId == "*" {
+			panic(errInvalid("invalid", "clientUniqueId", "must be a single client id"))
+		}
+		rule := model.ClientRule{
+			DeploymentId:   deployment.Id,
+			ClientUniqueId: req.ClientUniqueId,
+			Action:         req.Action,
+			Note:           req.Note,
+			Uid:            &uid,
+			CreateTime:     utils.GetTimeNow(),
+		}
+		if req.ExpireMinutes != nil {
+			expireTime := time.Now().Add(time.Duration(*req.ExpireMinutes) * time.Minute).UnixMilli()
+			rule.ExpireTime = &expireTime
+		}
+		detail := *req.Action
+		if *req.Action == constants.CLIENT_RULE_PIN {
+			if req.Label == nil {
+				panic(errInvalid("required", "label", "is required"))
+			}
+			pack := getPackageByLabel(ctx, *req.AppName, *req.Deployment, *req.Label)
+			rule.PackageId = pack.Id
+			detail += " " + *req.Label
+		}
+		if err := model.Create[model.ClientRule](&rule); err != nil {
+			log.Panic(err.Error())
+		}
+		addAuditLog(ctx, uid, "client_rule.add", *req.AppName+"/"+*req.Deployment+"/"+*req.ClientUniqueId, detail)
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"id":      rule.Id,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+type lsClientRuleReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+}
+
+func (App) LsClientRule(ctx *gin.Context) {
+	req := lsClientRuleReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		ctx.JSON(http.StatusOK, model.ClientRule{}.GetByDeploymentId(*deployment.Id))
+	} else {
+		panic(bindError(err))
+	}
+}
+
+type delClientRuleReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Id         *int    `json:"id" binding:"required"`
+}
+
+func (App) DelClientRule(ctx *gin.Context) {
+	req := delClientRuleReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		rule := model.GetOne[model.ClientRule]("id=?", *req.Id)
+		if rule == nil || *rule.DeploymentId != *deployment.Id {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Client rule not found"))
+		}
+		model.Delete[model.ClientRule](model.ClientRule{Id: rule.Id})
+		addAuditLog(ctx, uid, "client_rule.delete", *req.AppName+"/"+*req.Deployment, strconv.Itoa(*rule.Id))
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
@@ -0,0 +1,102 @@
+package request
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/db/redis"
+	"golang.org/x/sync/singleflight"
+)
+
+// 同一个redisKey同时只有一个请求查询数据库,其余请求等待结果
+var updateInfoGroup singleflight.Group
+
+func loadUpdateInfoOnce(req *updateCheckReq, redisKey string) *updateInfoRedisInfo {
+	v, err, _ := updateInfoGroup.Do(redisKey, func() (result any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = flightPanic{value: r}
+			}
+		}()
+		// 等待期间可能已经被其他实例写入
+		if info := redis.GetRedisObj[updateInfoRedisInfo](redisKey); info != nil {
+			return info, nil
+		}
+		return loadUpdateInfo(req, redisKey), nil
+	})
+	if err != nil {
+		// 保留原始的panic值,例如带错误码的ErrObj
+		panic(err.(flightPanic).value)
+	}
+	return v.(*updateInfoRedisInfo)
+}
+
+type flightPanic struct {
+	value any
+}
+
+func (p flightPanic) Error() string {
+	return fmt.Sprint(p.value)
+}
+
+const unknownKeyTTL = 10 * time.Second
+
+// 不存在的deploymentKey短时间内直接拒绝,不再查询数据库
+var unknownKeys = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+func isUnknownKey(deploymentKey string) bool {
+	unknownKeys.Lock()
+	defer unknownKeys.Unlock()
+	expire, ok := unknownKeys.m[deploymentKey]
+	if ok && time.Now().After(expire) {
+		delete(unknownKeys.m, deploymentKey)
+		return false
+	}
+	return ok
+}
+
+func addUnknownKey(deploymentKey string) {
+	unknownKeys.Lock()
+	defer unknownKeys.Unlock()
+	// 防止大量随机key占满内存
+	if len(unknownKeys.m) >= 10000 {
+		now := time.Now()
+		for k, expire := range unknownKeys.m {
+			if now.After(expire) {
+				delete(unknownKeys.m, k)
+			}
+		}
+		if len(unknownKeys.m) >= 10000 {
+			return
+		}
+	}
+	unknownKeys.m[deploymentKey] = time.Now().Add(unknownKeyTTL)
+}
+
+// 每个实例每分钟最多写入redis的不存在key数,大量随机key时不会占满redis
+const unknownKeyWritesPerMinute = 1000
+
+var unknownKeyWrites = struct {
+	sync.Mutex
+	minute int64
+	count  int
+}{}
+
+func allowUnknownKeyWrite() bool {
+	unknownKeyWrites.Lock()
+	defer unknownKeyWrites.Unlock()
+	minute := time.Now().Unix() / 60
+	if minute != unknownKeyWrites.minute {
+		unknownKeyWrites.minute = minute
+		unknownKeyWrites.count = 0
+	}
+	if unknownKeyWrites.count >= unknownKeyWritesPerMinute {
+		return false
+	}
+	unknownKeyWrites.count++
+	return true
+}
@@ -0,0 +1,154 @@
+package request
+
+import (
+	"net/http"
+	"sort"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type compareDeploymentsReq struct {
+	From string `form:"from"`
+	To   string `form:"to"`
+	// 只返回未推广或有待审批发布的版本
+	OnlyDiff bool `form:"onlyDiff"`
+}
+
+type compareSide struct {
+	Label       string `json:"label"`
+	PackageHash string `json:"packageHash"`
+	CreateTime  int64  `json:"createTime"`
+	// nil表示全量
+	Rollout       *int `json:"rollout"`
+	RolloutPaused bool `json:"rolloutPaused"`
+}
+
+type compareRow struct {
+	AppName    string       `json:"appName"`
+	AppVersion string       `json:"appVersion"`
+	BundleName string       `json:"bundleName,omitempty"`
+	From       *compareSide `json:"from"`
+	To         *compareSide `json:"to"`
+	SameHash   bool         `json:"sameHash"`
+	// from的当前包还没有出现在to的当前包中
+	NotPromoted bool `json:"notPromoted"`
+	// to中等待审批或未公开的发布
+	Pending []string `json:"pending,omitempty"`
+}
+
+// 一次比较账号下所有应用的两个部署(默认Staging和Production)
+func (App) CompareDeployments(ctx *gin.Context) {
+	req := compareDeploymentsReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	if req.From == "" {
+		req.From = "Staging"
+	}
+	if req.To == "" {
+		req.To = "Production"
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	rows := []compareRow{}
+	var notPromoted int
+	if apps := model.GetList[model.App]("uid=?", uid); apps != nil {
+		for _, app := range *apps {
+			from := model.Deployment{}.GetByAppidAndName(*app.Id, req.From)
+			to := model.Deployment{}.GetByAppidAndName(*app.Id, req.To)
+			if from == nil && to == nil {
+				continue
+			}
+			for _, row := range compareApp(*app.AppName, from, to) {
+				if row.NotPromoted {
+					notPromoted++
+				}
+				if req.OnlyDiff && !row.NotPromoted && len(row.Pending) == 0 {
+					continue
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"from":        req.From,
+		"to":          req.To,
+		"notPromoted": notPromoted,
+		"versions":    rows,
+	})
+}
+
+// 按appVersion/bundleName对齐两个部署的当前包
+func compareApp(appName string, from *model.Deployment, to *model.Deployment) []compareRow {
+	rows := map[string]*compareRow{}
+	var keys []string
+	row := func(v *model.DeploymentVersion) *compareRow {
+		key := utils.StringValue(v.AppVersion) + "|" + utils.StringValue(v.BundleName)
+		if rows[key] == nil {
+			rows[key] = &compareRow{AppName: appName, AppVersion: utils.StringValue(v.AppVersion), BundleName: utils.StringValue(v.BundleName)}
+			keys = append(keys, key)
+		}
+		return rows[key]
+	}
+	if from != nil {
+		if versions := model.GetList[model.DeploymentVersion]("deployment_id", *from.Id); versions != nil {
+			for i := range *versions {
+				v := &(*versions)[i]
+				row(v).From = currentSide(v)
+			}
+		}
+	}
+	if to != nil {
+		if versions := model.GetList[model.DeploymentVersion]("deployment_id", *to.Id); versions != nil {
+			for i := range *versions {
+				v := &(*versions)[i]
+				row(v).To = currentSide(v)
+			}
+		}
+		for _, status := range []string{constants.PACKAGE_STATUS_PENDING, constants.PACKAGE_STATUS_PRIVATE} {
+			packs := model.Package{}.GetByDeploymentIdAndStatus(*to.Id, status)
+			if packs == nil {
+				continue
+			}
+			for _, pack := range *packs {
+				v := model.GetOne[model.DeploymentVersion]("id", *pack.DeploymentVersionId)
+				if v != nil {
+					r := row(v)
+					r.Pending = append(r.Pending, utils.StringValue(pack.Label)+" ("+status+")")
+				}
+			}
+		}
+	}
+	sort.Strings(keys)
+	list := make([]compareRow, 0, len(keys))
+	for _, key := range keys {
+		r := rows[key]
+		r.SameHash = r.From != nil && r.To != nil && r.From.PackageHash == r.To.PackageHash
+		r.NotPromoted = r.From != nil && !r.SameHash
+		list = append(list, *r)
+	}
+	return list
+}
+
+func currentSide(v *model.DeploymentVersion) *compareSide {
+	if v.CurrentPackage == nil {
+		return nil
+	}
+	pack := model.GetOne[model.Package]("id", *v.CurrentPackage)
+	if pack == nil {
+		return nil
+	}
+	side := &compareSide{
+		Label:         utils.StringValue(pack.Label),
+		PackageHash:   utils.StringValue(pack.Hash),
+		Rollout:       pack.Rollout,
+		RolloutPaused: pack.RolloutPaused != nil && *pack.RolloutPaused,
+	}
+	if pack.CreateTime != nil {
+		side.CreateTime = *pack.CreateTime
+	}
+	return side
+}
@@ -0,0 +1,76 @@
+package request
+
+import (
+	"net/http"
+	"strings"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/tlscert"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type customDomainReq struct {
+	// 例如 updates.brandx.com,需要先CNAME到本服务
+	Domain *string `json:"domain" binding:"required,fqdn,max=253"`
+}
+
+func (Admin) LsCustomDomain(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    model.CustomDomain{}.GetByTenant(config.GetConfig().TenantName),
+	})
+}
+
+// 证书在第一次HTTPS请求时申请
+func (Admin) AddCustomDomain(ctx *gin.Context) {
+	req := customDomainReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		domain := strings.ToLower(*req.Domain)
+		if (model.CustomDomain{}).GetByDomain(domain) != nil {
+			panic(errConflict(constants.ERR_DOMAIN_EXISTS, "Domain "+domain+" already registered"))
+		}
+		tenant := config.GetConfig().TenantName
+		customDomain := model.CustomDomain{
+			Tenant:     &tenant,
+			Domain:     &domain,
+			Uid:        &uid,
+			CreateTime: utils.GetTimeNow(),
+		}
+		if err := model.Create[model.CustomDomain](&customDomain); err != nil {
+			panic(errConflict(constants.ERR_DOMAIN_EXISTS, "Domain "+domain+" already registered"))
+		}
+		tlscert.Forget(domain)
+		addAuditLog(ctx, uid, "custom_domain.add", domain, "")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    customDomain,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (Admin) DelCustomDomain(ctx *gin.Context) {
+	req := customDomainReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		domain := strings.ToLower(*req.Domain)
+		customDomain := model.CustomDomain{}.GetByDomain(domain)
+		if customDomain == nil || *customDomain.Tenant != config.GetConfig().TenantName {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Domain "+domain+" not found"))
+		}
+		model.Delete[model.CustomDomain](model.CustomDomain{Id: customDomain.Id})
+		tlscert.Forget(domain)
+		addAuditLog(ctx, uid, "custom_domain.del", domain, "")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
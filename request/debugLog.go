@@ -0,0 +1,212 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+type setDebugLogReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	// 0表示关闭
+	Minutes *int `json:"minutes" binding:"required,min=0"`
+	// 只记录该客户端,为空时记录所有客户端
+	ClientUniqueId string `json:"clientUniqueId" binding:"max=200"`
+}
+
+type debugLogFlag struct {
+	ClientUniqueId string `json:"clientUniqueId,omitempty"`
+	// 毫秒
+	ExpireTime int64 `json:"expireTime"`
+}
+
+type debugLogEntry struct {
+	Time              string `json:"time"`
+	DeploymentKeyHash string `json:"deployment_key_hash"`
+	ClientIp          string `json:"client_ip,omitempty"`
+	UserAgent         string `json:"user_agent,omitempty"`
+	// 请求参数,密钥和邀请码已脱敏
+	Request updateCheckReq `json:"request"`
+	// 缓存中的当前发布,用于判断客户端为什么没有收到更新
+	Current  debugLogCurrent `json:"current"`
+	Response *updateInfo     `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Code     int             `json:"code,omitempty"`
+}
+
+type debugLogCurrent struct {
+	Label         string `json:"label"`
+	PackageHash   string `json:"package_hash"`
+	NewVersion    string `json:"new_version"`
+	Rollout       *int   `json:"rollout"`
+	RolloutPaused bool   `json:"rollout_paused"`
+	ForceBinary   bool   `json:"force_binary"`
+	ClientRules   int    `json:"client_rules"`
+}
+
+var (
+	debugLogOnce sync.Once
+	debugLogOut  io.Writer
+	debugLogMu   sync.Mutex
+)
+
+func debugLogKey(deploymentKey string) string {
+	return constants.REDIS_DEBUG_LOG + deploymentKey
+}
+
+// 开启后记录该部署完整的update_check请求和响应,到期自动关闭
+func (App) SetDebugLog(ctx *gin.Context) {
+	req := setDebugLogReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		c := config.GetConfig().DebugLog
+		if c.Output == "" {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Debug logging is not enabled"))
+		}
+		if *req.Minutes > int(c.MaxMinutes) {
+			panic(errInvalid("max", "minutes", "must be at most debug_log_max_minutes"))
+		}
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		// 开关保存在redis中,仍然递增部署的版本号,持有旧ETag的修改会冲突
+		version := bumpRowVersion(ctx, nil, "deployment", *deployment.Id)
+		key := debugLogKey(*deployment.Key)
+		target := *req.AppName + "/" + *req.Deployment
+		var flag *debugLogFlag
+		if *req.Minutes == 0 {
+			redis.DelRedisObj(key)
+			addAuditLog(ctx, uid, "debug_log.disable", target, "")
+		} else {
+			ttl := time.Duration(*req.Minutes) * time.Minute
+			flag = &debugLogFlag{ClientUniqueId: req.ClientUniqueId, ExpireTime: time.Now().Add(ttl).UnixMilli()}
+			redis.SetRedisObj(key, flag, ttl)
+			addAuditLog(ctx, uid, "debug_log.enable", target, strconv.Itoa(*req.Minutes)+"m "+req.ClientUniqueId)
+		}
+		// update_check缓存中带有开关
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"debugLog":   flag,
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 缓存的有效期可能比开关长,所以还要比较到期时间
+func debugLogEnabled(flag *debugLogFlag, clientUniqueId string) bool {
+	if flag == nil || flag.ExpireTime < time.Now().UnixMilli() {
+		return false
+	}
+	return flag.ClientUniqueId == "" || flag.ClientUniqueId == clientUniqueId
+}
+
+func debugLogWriter() io.Writer {
+	debugLogOnce.Do(func() {
+		c := config.GetConfig()
+		switch c.DebugLog.Output {
+		case "":
+		case "stdout":
+			debugLogOut = os.Stdout
+		default:
+			debugLogOut = &lumberjack.Logger{
+				Filename:   c.DebugLog.Output,
+				MaxSize:    c.AccessLog.MaxSizeMB,
+				MaxBackups: c.AccessLog.MaxBackups,
+				MaxAge:     c.AccessLog.MaxAgeDays,
+			}
+		}
+	})
+	return debugLogOut
+}
+
+// err为checkUpdate中的panic,写完日志后由调用方继续处理
+func writeDebugLog(ctx *gin.Context, req *updateCheckReq, info *updateInfo, err any) {
+	if req.debug == nil {
+		return
+	}
+	out := debugLogWriter()
+	if out == nil {
+		return
+	}
+	redact := map[string]bool{}
+	for _, field := range config.GetConfig().AccessLog.Redact {
+		redact[field] = true
+	}
+	entry := debugLogEntry{
+		Time:              time.Now().Format(time.RFC3339Nano),
+		DeploymentKeyHash: utils.Sha256Hex(req.DeploymentKey)[:16],
+		Request:           *req,
+		Current: debugLogCurrent{
+			Label:         req.debug.Label,
+			PackageHash:   req.debug.PackageHash,
+			NewVersion:    req.debug.NewVersion,
+			Rollout:       req.debug.Rollout,
+			RolloutPaused: req.debug.RolloutPaused,
+			ForceBinary:   req.debug.ForceBinary != nil,
+			ClientRules:   len(req.debug.ClientRules),
+		},
+	}
+	entry.Request.DeploymentKey = ""
+	entry.Request.DeploymentSecret = redactValue(req.DeploymentSecret)
+	entry.Request.InviteToken = redactValue(req.InviteToken)
+	if !redact["client_ip"] {
+		entry.ClientIp = ctx.GetString(constants.GIN_CLIENT_IP)
+	}
+	if !redact["user_agent"] {
+		entry.UserAgent = ctx.Request.UserAgent()
+	}
+	if info != nil {
+		rep := *info
+		rep.DownloadUrl = stripQuery(rep.DownloadUrl)
+		entry.Response = &rep
+	}
+	if err != nil {
+		entry.Error = fmt.Sprint(err)
+		if e, ok := err.(constants.ErrObj); ok {
+			entry.Error = e.Msg
+			entry.Code = e.Code
+		}
+	}
+	line, e := json.Marshal(entry)
+	if e != nil {
+		log.Printf("debug log error:%s", e.Error())
+		return
+	}
+	debugLogMu.Lock()
+	out.Write(append(line, '\n'))
+	debugLogMu.Unlock()
+}
+
+func redactValue(v string) string {
+	if v == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// 签名地址的参数是临时凭证,不写入日志
+func stripQuery(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	parsed.RawQuery = ""
+	return parsed.String()
+}
@@ -0,0 +1,113 @@
+package request
+
+import (
+	"net/http"
+	"strconv"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+type setDeploymentPolicyReq struct {
+	AppName          *string `json:"appName" binding:"required"`
+	Deployment       *string `json:"deployment" binding:"required"`
+	DefaultMandatory *bool   `json:"defaultMandatory"`
+	DefaultRollout   *int    `json:"defaultRollout" binding:"omitempty,min=1,max=100"`
+	// 字节
+	MaxPackageSize  *int64 `json:"maxPackageSize" binding:"omitempty,min=1"`
+	RequireApproval *bool  `json:"requireApproval"`
+	// 为true时发布不能取消强制更新或使用更大的灰度
+	Enforce bool `json:"enforce"`
+}
+
+type deploymentPolicyReq struct {
+	AppName    *string `form:"appName" binding:"required"`
+	Deployment *string `form:"deployment" binding:"required"`
+}
+
+// 整体替换部署的发布策略,没有传的字段清空
+func (App) SetDeploymentPolicy(ctx *gin.Context) {
+	req := setDeploymentPolicyReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		if req.RequireApproval != nil && *req.RequireApproval && utils.StringValue(deployment.Approvers) == "" {
+			panic(errInvalid("required", "requireApproval", "set approvers with setDeploymentApproval first"))
+		}
+		if req.RequireApproval != nil && !*req.RequireApproval && requiresApproval(deployment) {
+			checkApprovalAdmin(ctx, deployment)
+		}
+		deployment.DefaultMandatory = req.DefaultMandatory
+		deployment.DefaultRollout = req.DefaultRollout
+		deployment.MaxPackageSize = req.MaxPackageSize
+		deployment.EnforcePolicy = &req.Enforce
+		if req.RequireApproval != nil {
+			deployment.RequireApproval = req.RequireApproval
+		}
+		deployment.UpdateTime = utils.GetTimeNow()
+		version := updateWithRowVersion(ctx, "deployment", *deployment.Id, func(tx *gorm.DB) error {
+			return model.Deployment{}.UpdatePolicy(tx, deployment)
+		})
+		deployment.RowVersion = &version
+		addAuditLog(ctx, uid, "deployment.policy", *req.AppName+"/"+*req.Deployment, "enforce="+strconv.FormatBool(req.Enforce))
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"policy":     deploymentPolicy(deployment),
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (App) GetDeploymentPolicy(ctx *gin.Context) {
+	req := deploymentPolicyReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+	setRowEtag(ctx, deployment.RowVersion)
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"policy":     deploymentPolicy(deployment),
+		"rowVersion": deployment.RowVersion,
+	})
+}
+
+func deploymentPolicy(deployment *model.Deployment) gin.H {
+	return gin.H{
+		"defaultMandatory": deployment.DefaultMandatory,
+		"defaultRollout":   deployment.DefaultRollout,
+		"maxPackageSize":   deployment.MaxPackageSize,
+		"requireApproval":  deployment.RequireApproval != nil && *deployment.RequireApproval,
+		"enforce":          deployment.EnforcePolicy != nil && *deployment.EnforcePolicy,
+	}
+}
+
+// 发布前补上默认值并检查策略,createBundle和releaseBatch共用
+func applyDeploymentPolicy(deployment *model.Deployment, req *createBundleReq) {
+	name := utils.StringValue(deployment.Name)
+	if deployment.MaxPackageSize != nil && req.Size != nil && *req.Size > *deployment.MaxPackageSize {
+		panic(errPolicy("Package size " + strconv.FormatInt(*req.Size, 10) + " exceeds the limit of deployment " + name + " (" + strconv.FormatInt(*deployment.MaxPackageSize, 10) + ")"))
+	}
+	enforce := deployment.EnforcePolicy != nil && *deployment.EnforcePolicy
+	if deployment.DefaultRollout != nil {
+		if req.Rollout == nil {
+			req.Rollout = deployment.DefaultRollout
+		} else if enforce && *req.Rollout > *deployment.DefaultRollout {
+			panic(errPolicy("Rollout of deployment " + name + " must be at most " + strconv.Itoa(*deployment.DefaultRollout)))
+		}
+	}
+	if deployment.DefaultMandatory != nil {
+		if req.IsMandatory == nil {
+			req.IsMandatory = deployment.DefaultMandatory
+		} else if enforce && *deployment.DefaultMandatory && !*req.IsMandatory {
+			panic(errPolicy("Releases to deployment " + name + " must be mandatory"))
+		}
+	}
+}
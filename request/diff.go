@@ -0,0 +1,17 @@
+package request
+
+import (
+	"net/http"
+
+	"com.lc.go.codepush/server/diff"
+	"github.com/gin-gonic/gin"
+)
+
+// 差量包worker池的任务统计
+func (Admin) DiffStats(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"enabled": diff.Enabled(),
+		"stats":   diff.GetStats(),
+	})
+}
@@ -0,0 +1,161 @@
+package request
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+)
+
+type createEphemeralDeploymentReq struct {
+	AppName *string `json:"appName" binding:"required"`
+	// 分支名或PR号,例如 feature/login 或 pr-123
+	Branch *string `json:"branch" binding:"required,max=200"`
+	// 不活动天数,默认ephemeral_days
+	Days int `json:"days" binding:"omitempty,min=1,max=365"`
+}
+
+var branchNameReg = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// deploymentId -> 上次写入活动时间,每个实例每小时最多写一次
+var ephemeralTouched sync.Map
+
+func ephemeralName(branch string) string {
+	return "branch-" + branchNameReg.ReplaceAllString(branch, "-")
+}
+
+// CI每次构建都可以调用,已存在的临时部署返回原来的key并刷新活动时间
+func (App) CreateEphemeralDeployment(ctx *gin.Context) {
+	req := createEphemeralDeploymentReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		app := model.App{}.GetAppByUidAndAppName(uid, *req.AppName)
+		if app == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		if req.Days == 0 {
+			req.Days = int(config.GetConfig().EphemeralDays)
+		}
+		name := ephemeralName(*req.Branch)
+		now := utils.GetTimeNow()
+		deployment := model.Deployment{}.GetByAppidAndName(*app.Id, name)
+		if deployment != nil {
+			if deployment.EphemeralDays == nil {
+				panic(errConflict(constants.ERR_DEPLOYMENT_EXISTS, "Deployment name "+name+" exist and is not ephemeral"))
+			}
+			model.Update[model.Deployment](&model.Deployment{Id: deployment.Id, EphemeralDays: &req.Days, LastActiveTime: now})
+		} else {
+			key := uuid.NewString()
+			deployment = &model.Deployment{
+				AppId:          app.Id,
+				Name:           &name,
+				Key:            &key,
+				CreateTime:     now,
+				EphemeralDays:  &req.Days,
+				LastActiveTime: now,
+			}
+			if err := model.Create[model.Deployment](deployment); err != nil {
+				log.Panic(err.Error())
+			}
+			addAuditLog(ctx, uid, "deployment.create_ephemeral", *req.AppName+"/"+name, strconv.Itoa(req.Days)+"d")
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"name":          name,
+			"key":           deployment.Key,
+			"ephemeralDays": req.Days,
+			"expireTime":    *now + int64(req.Days)*24*60*60*1000,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 发布和update_check都算活动
+func touchEphemeral(deploymentId int) {
+	now := time.Now()
+	if last, ok := ephemeralTouched.Load(deploymentId); ok && now.Sub(last.(time.Time)) < time.Hour {
+		return
+	}
+	ephemeralTouched.Store(deploymentId, now)
+	model.Deployment{}.UpdateLastActiveTime(deploymentId, now.UnixMilli())
+}
+
+// 每小时由一个实例删除过期的临时部署
+func StartEphemeralCleanup() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			hour := time.Now().UTC().Truncate(time.Hour)
+			if redis.SetNX(constants.REDIS_EPHEMERAL+"lock:"+strconv.FormatInt(hour.Unix(), 10), 2*time.Hour) {
+				cleanupEphemeral()
+			}
+		}
+	}()
+}
+
+func cleanupEphemeral() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ephemeral: cleanup error:%v", r)
+			sentry.CapturePanic("ephemeral", r, nil)
+		}
+	}()
+	deployments := model.Deployment{}.GetExpiredEphemeral(time.Now().UnixMilli())
+	if deployments == nil {
+		return
+	}
+	for i := range *deployments {
+		purgeDeployment(&(*deployments)[i])
+	}
+}
+
+// 删除部署、发布历史和包文件,其他部署仍在使用的文件保留
+func purgeDeployment(deployment *model.Deployment) {
+	var keys []string
+	if packs := model.GetList[model.Package]("deployment_id", *deployment.Id); packs != nil {
+		for _, pack := range *packs {
+			keys = appendKey(keys, pack.Download)
+			keys = appendKey(keys, pack.ZstdDownload)
+			if diffs := model.GetList[model.PackageDiff]("package_id", *pack.Id); diffs != nil {
+				for _, d := range *diffs {
+					keys = appendKey(keys, d.Download)
+				}
+			}
+			model.PackageDiff{}.DeleteByPackageId(*pack.Id)
+		}
+	}
+	deleteDeployment(deployment)
+	for _, key := range keys {
+		if (model.Package{}).CountOtherByDownload(*deployment.Id, key) > 0 {
+			continue
+		}
+		if err := storage.Delete(key); err != nil {
+			sentry.CaptureError("ephemeral", err, map[string]string{"key": key})
+		}
+	}
+	target := *deployment.Name
+	if app := model.GetOne[model.App]("id", deployment.AppId); app != nil {
+		target = *app.AppName + "/" + target
+	}
+	model.AddAuditLog(0, "deployment.expire", target, strconv.Itoa(*deployment.EphemeralDays)+"d inactive")
+	log.Printf("ephemeral: deleted %s, %d files", target, len(keys))
+}
+
+func appendKey(keys []string, key *string) []string {
+	if key == nil || *key == "" {
+		return keys
+	}
+	return append(keys, *key)
+}
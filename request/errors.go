@@ -0,0 +1,44 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+
+	"com.lc.go.codepush/server/diff"
+	"com.lc.go.codepush/server/model/constants"
+)
+
+func errNotFound(code int, msg string) constants.ErrObj {
+	return constants.ErrObj{Status: http.StatusNotFound, Code: code, Msg: msg}
+}
+
+func errConflict(code int, msg string) constants.ErrObj {
+	return constants.ErrObj{Status: http.StatusConflict, Code: code, Msg: msg}
+}
+
+func errForbidden(msg string) constants.ErrObj {
+	return constants.ErrObj{Status: http.StatusForbidden, Code: constants.ERR_PERMISSION_DENIED, Msg: msg}
+}
+
+func errPolicy(msg string) constants.ErrObj {
+	return constants.ErrObj{Status: http.StatusForbidden, Code: constants.ERR_POLICY_VIOLATION, Msg: msg}
+}
+
+// 单个字段校验失败,格式和bindError一致
+func errInvalid(code string, field string, message string) constants.ErrObj {
+	return constants.ErrObj{
+		Status: http.StatusBadRequest,
+		Code:   constants.ERR_VALIDATION,
+		Msg:    "Invalid request",
+		Errors: []constants.FieldError{{Code: code, Field: field, Message: message}},
+	}
+}
+
+// 超过解压限制时返回413,其他错误原样panic
+func panicArchiveError(prefix string, err error) {
+	var limitErr *diff.LimitError
+	if errors.As(err, &limitErr) {
+		panic(constants.ErrObj{Status: http.StatusRequestEntityTooLarge, Code: constants.ERR_ARCHIVE_TOO_LARGE, Msg: prefix + limitErr.Msg})
+	}
+	panic(prefix + err.Error())
+}
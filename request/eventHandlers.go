@@ -0,0 +1,67 @@
+package request
+
+import (
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/events"
+	"com.lc.go.codepush/server/kafka"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/report"
+	"com.lc.go.codepush/server/webhook"
+)
+
+// 在events.Start之前调用
+func SubscribeEvents() {
+	events.Subscribe(events.CONSUMER_CACHE, invalidateOnEvent)
+	events.Subscribe(events.CONSUMER_WEBHOOK, notifyOnEvent)
+	events.Subscribe(events.CONSUMER_METRICS, countOnEvent)
+	events.Subscribe(events.CONSUMER_KAFKA, kafka.Event)
+}
+
+// 新发布生效、回滚或灰度变化后刷新update_check缓存
+func invalidateOnEvent(e events.Event) {
+	switch e.Type {
+	case events.RELEASE_CREATED:
+		if e.Status != "" {
+			return
+		}
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + e.DeploymentKey + "*")
+		warmCache(e.DeploymentKey)
+	case events.RELEASE_APPROVED, events.RELEASE_ROLLED_BACK:
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + e.DeploymentKey + "*")
+		warmCache(e.DeploymentKey)
+	case events.ROLLOUT_CHANGED:
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + e.DeploymentKey + "*")
+	}
+}
+
+func notifyOnEvent(e events.Event) {
+	// outbox中的事件由outbox发送并重试
+	if e.Id == 0 {
+		webhook.Send(config.GetConfig().EventBus.WebhookUrl, e)
+	}
+	if e.Type != events.RELEASE_CREATED || e.Status != constants.PACKAGE_STATUS_PENDING {
+		return
+	}
+	app := model.GetOne[model.App]("id", e.AppId)
+	deployment := model.GetOne[model.Deployment]("id", e.DeploymentId)
+	pack := model.GetOne[model.Package]("id", e.PackageId)
+	if app != nil && deployment != nil && pack != nil {
+		notifyApprovers(app, deployment, pack)
+	}
+}
+
+func countOnEvent(e events.Event) {
+	if e.Type != events.STATUS_REPORTED {
+		return
+	}
+	switch e.Status {
+	case "DeploymentSucceeded":
+		report.Add(e.DeploymentKey, e.Label, report.ACTIVE)
+	case "DeploymentFailed":
+		report.Add(e.DeploymentKey, e.Label, report.FAILED)
+	}
+	metrics.Count("report_status", 1, map[string]string{"status": e.Status, "app": appLabelByKey(&e.DeploymentKey)})
+}
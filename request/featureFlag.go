@@ -0,0 +1,106 @@
+package request
+
+import (
+	"net/http"
+	"strconv"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/flags"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type featureFlagReq struct {
+	Name *string `json:"name" binding:"required"`
+	// 为空时对整个租户生效
+	AppId   *int  `json:"appId"`
+	Enabled *bool `json:"enabled"`
+}
+
+func (Admin) LsFeatureFlag(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"data":     model.FeatureFlag{}.GetByTenant(config.GetConfig().TenantName),
+		"defaults": flags.Defaults(),
+	})
+}
+
+func (Admin) SetFeatureFlag(ctx *gin.Context) {
+	req := featureFlagReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		if req.Enabled == nil {
+			panic(errInvalid("required", "enabled", "is required"))
+		}
+		if req.AppId != nil && model.GetOne[model.App]("id", *req.AppId) == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		tenant := config.GetConfig().TenantName
+		flag := model.FeatureFlag{}.GetByName(tenant, *req.Name, req.AppId)
+		if flag == nil {
+			flag = &model.FeatureFlag{
+				Tenant:     &tenant,
+				Name:       req.Name,
+				AppId:      req.AppId,
+				Enabled:    req.Enabled,
+				CreateTime: utils.GetTimeNow(),
+				UpdateTime: utils.GetTimeNow(),
+			}
+			model.Create[model.FeatureFlag](flag)
+		} else {
+			flag.Enabled = req.Enabled
+			flag.UpdateTime = utils.GetTimeNow()
+			model.Update[model.FeatureFlag](flag)
+		}
+		flagChanged(ctx, &req)
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (Admin) DelFeatureFlag(ctx *gin.Context) {
+	req := featureFlagReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		flag := model.FeatureFlag{}.GetByName(config.GetConfig().TenantName, *req.Name, req.AppId)
+		if flag == nil {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Feature flag not found"))
+		}
+		model.Delete[model.FeatureFlag](model.FeatureFlag{Id: flag.Id})
+		req.Enabled = nil
+		flagChanged(ctx, &req)
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 开关影响update_check的返回,同时清理对应的缓存
+func flagChanged(ctx *gin.Context, req *featureFlagReq) {
+	flags.Invalidate()
+	target := "tenant"
+	if req.AppId != nil {
+		target = "app:" + strconv.Itoa(*req.AppId)
+		deployments := model.Deployment{}.GetByAppids(*req.AppId)
+		if deployments != nil {
+			for _, v := range *deployments {
+				redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *v.Key + "*")
+			}
+		}
+	} else {
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + "*")
+	}
+	detail := "deleted"
+	if req.Enabled != nil {
+		detail = strconv.FormatBool(*req.Enabled)
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	addAuditLog(ctx, uid, "feature_flag."+*req.Name, target, detail)
+}
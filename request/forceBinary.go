@@ -0,0 +1,50 @@
+package request
+
+import (
+	"net/http"
+	"strconv"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+type setForceBinaryUpdateReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Enabled    *bool   `json:"enabled" binding:"required"`
+	Message    *string `json:"message"`
+	// 按客户端语言返回的提示,例如 {"zh-TW":"..."}
+	Messages map[string]string `json:"messages"`
+	Url      *string           `json:"url" binding:"omitempty,url"`
+}
+
+// 开启后update_check对所有客户端返回update_app_version=true,用于热更新无法修复的问题
+func (App) SetForceBinaryUpdate(ctx *gin.Context) {
+	req := setForceBinaryUpdateReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		deployment.ForceBinaryUpdate = req.Enabled
+		if *req.Enabled {
+			deployment.ForceBinaryMessage = req.Message
+			deployment.ForceBinaryMessages = encodeLocalized("messages", req.Messages)
+			deployment.ForceBinaryUrl = req.Url
+		}
+		deployment.UpdateTime = utils.GetTimeNow()
+		version := updateWithRowVersion(ctx, "deployment", *deployment.Id, func(tx *gorm.DB) error {
+			return tx.Updates(deployment).Error
+		})
+		addAuditLog(ctx, uid, "deployment.force_binary_update", *req.AppName+"/"+*req.Deployment, strconv.FormatBool(*req.Enabled))
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
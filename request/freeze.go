@@ -0,0 +1,181 @@
+package request
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type addDeploymentFreezeReq struct {
+	AppName    *string   `json:"appName" binding:"required"`
+	Deployment *string   `json:"deployment" binding:"required"`
+	StartDay   *int      `json:"startDay" binding:"required,min=0,max=6"`
+	StartTime  *string   `json:"startTime" binding:"required,datetime=15:04"`
+	EndDay     *int      `json:"endDay" binding:"required,min=0,max=6"`
+	EndTime    *string   `json:"endTime" binding:"required,datetime=15:04"`
+	Timezone   *string   `json:"timezone"`
+	Overriders *[]string `json:"overriders"`
+}
+
+// 添加每周重复的冻结窗口,例如周五18:00到周一08:00 (0=Sunday)
+func (App) AddDeploymentFreeze(ctx *gin.Context) {
+	req := addDeploymentFreezeReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		timezone := "UTC"
+		if req.Timezone != nil && *req.Timezone != "" {
+			timezone = *req.Timezone
+		}
+		if _, err := time.LoadLocation(timezone); err != nil {
+			panic(errInvalid("timezone", "timezone", "unknown timezone"))
+		}
+		overriders := ""
+		if req.Overriders != nil {
+			overriders = strings.Join(*req.Overriders, ",")
+		}
+		freeze := model.DeploymentFreeze{
+			DeploymentId: deployment.Id,
+			StartDay:     req.StartDay,
+			StartTime:    req.StartTime,
+			EndDay:       req.EndDay,
+			EndTime:      req.EndTime,
+			Timezone:     &timezone,
+			Overriders:   &overriders,
+			CreateTime:   utils.GetTimeNow(),
+		}
+		if err := model.Create[model.DeploymentFreeze](&freeze); err != nil {
+			log.Panic(err.Error())
+		}
+		addAuditLog(ctx, uid, "freeze.add", *req.AppName+"/"+*req.Deployment, strconv.Itoa(*freeze.Id))
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"id":      freeze.Id,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+type lsDeploymentFreezeReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+}
+
+func (App) LsDeploymentFreeze(ctx *gin.Context) {
+	req := lsDeploymentFreezeReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		ctx.JSON(http.StatusOK, model.DeploymentFreeze{}.GetByDeploymentId(*deployment.Id))
+	} else {
+		panic(bindError(err))
+	}
+}
+
+type delDeploymentFreezeReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Id         *int    `json:"id" binding:"required"`
+}
+
+func (App) DelDeploymentFreeze(ctx *gin.Context) {
+	req := delDeploymentFreezeReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		freeze := model.GetOne[model.DeploymentFreeze]("id=?", *req.Id)
+		if freeze == nil || *freeze.DeploymentId != *deployment.Id {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Freeze not found"))
+		}
+		// 生效中的窗口被删除后就可以直接发布,需要管理员操作
+		if inFreeze(freeze, time.Now()) {
+			checkAdmin(ctx, "Only admins can delete an active freeze of deployment "+*deployment.Name)
+		}
+		model.Delete[model.DeploymentFreeze](model.DeploymentFreeze{Id: freeze.Id})
+		addAuditLog(ctx, uid, "freeze.delete", *req.AppName+"/"+*req.Deployment, strconv.Itoa(*freeze.Id))
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func getDeploymentByName(uid int, appName string, deploymentName string) *model.Deployment {
+	app := model.App{}.GetAppByUidAndAppName(uid, appName)
+	if app == nil {
+		panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+	}
+	deployment := model.Deployment{}.GetByAppidAndName(*app.Id, deploymentName)
+	if deployment == nil {
+		panic(errNotFound(constants.ERR_DEPLOYMENT_NOT_FOUND, "Deployment "+deploymentName+" not found"))
+	}
+	return deployment
+}
+
+// 冻结期间拒绝发布,除非当前用户在冻结窗口的override列表中并填写了原因
+// dryRun时只检查权限,不记录覆盖
+func checkFreeze(ctx *gin.Context, uid int, deployment *model.Deployment, overrideReason *string, dryRun bool) {
+	freezes := model.DeploymentFreeze{}.GetByDeploymentId(*deployment.Id)
+	if freezes == nil {
+		return
+	}
+	for _, v := range *freezes {
+		if !inFreeze(&v, time.Now()) {
+			continue
+		}
+		if overrideReason == nil || *overrideReason == "" {
+			panic(errConflict(constants.ERR_DEPLOYMENT_FROZEN, "Deployment "+*deployment.Name+" is frozen"))
+		}
+		user := model.GetOne[model.User]("id=?", uid)
+		if user == nil || v.Overriders == nil || !containsName(*v.Overriders, *user.UserName) {
+			panic(errForbidden("No permission to override freeze of deployment " + *deployment.Name))
+		}
+		if !dryRun {
+			addAuditLog(ctx, uid, "freeze.override", *deployment.Name, *overrideReason)
+		}
+	}
+}
+
+func inFreeze(freeze *model.DeploymentFreeze, now time.Time) bool {
+	loc, err := time.LoadLocation(*freeze.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+	nowMin := int(now.Weekday())*24*60 + now.Hour()*60 + now.Minute()
+	startMin := *freeze.StartDay*24*60 + parseClock(*freeze.StartTime)
+	endMin := *freeze.EndDay*24*60 + parseClock(*freeze.EndTime)
+	if startMin <= endMin {
+		return nowMin >= startMin && nowMin < endMin
+	}
+	// 跨周末的窗口
+	return nowMin >= startMin || nowMin < endMin
+}
+
+// "18:00" -> minutes of day
+func parseClock(clock string) int {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		log.Panic("Time format error, use HH:mm")
+	}
+	return t.Hour()*60 + t.Minute()
+}
+
+func containsName(names string, name string) bool {
+	for _, v := range strings.Split(names, ",") {
+		if strings.TrimSpace(v) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package request
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/auth"
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type impersonateReq struct {
+	UserName *string `json:"userName" binding:"required"`
+	// 必须说明原因,写入审计日志
+	Reason *string `json:"reason" binding:"required,min=10,max=500"`
+	// 默认impersonation_max_minutes
+	Minutes int64 `json:"minutes" binding:"omitempty,min=1"`
+}
+
+type endImpersonationReq struct {
+	SessionId *string `json:"sessionId" binding:"required"`
+}
+
+// 管理员以其他用户身份操作,用于复现权限问题;之后的请求带上X-Impersonation-Session请求头,
+// 审计日志记录为该用户并带上管理员uid
+func (Admin) Impersonate(ctx *gin.Context) {
+	req := impersonateReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		principal := ctx.MustGet(constants.GIN_PRINCIPAL).(*auth.Principal)
+		maxMinutes := config.GetConfig().ImpersonationMaxMinutes
+		if req.Minutes == 0 {
+			req.Minutes = maxMinutes
+		}
+		if req.Minutes > maxMinutes {
+			panic(errInvalid("max", "minutes", "must be at most impersonation_max_minutes"))
+		}
+		user := model.GetOne[model.User]("user_name", *req.UserName)
+		if user == nil {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "User "+*req.UserName+" not found"))
+		}
+		if user.GetRole() == constants.ROLE_ADMIN {
+			panic(errForbidden("Admins cannot be impersonated"))
+		}
+		session := auth.StartImpersonation(principal, user, *req.Reason, time.Duration(req.Minutes)*time.Minute)
+		model.AddImpersonatedAuditLog(ctx.GetString(constants.GIN_CLIENT_IP), *user.Id, principal.Uid,
+			"impersonation.start", *req.UserName, strconv.FormatInt(req.Minutes, 10)+"m: "+*req.Reason)
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"session": session,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (Admin) EndImpersonation(ctx *gin.Context) {
+	req := endImpersonationReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		principal := ctx.MustGet(constants.GIN_PRINCIPAL).(*auth.Principal)
+		session := auth.GetImpersonation(*req.SessionId)
+		if session == nil {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Impersonation session not found"))
+		}
+		// 只有发起会话的管理员可以提前结束
+		if session.AdminUid != principal.Uid {
+			panic(errForbidden("Only the admin who started the session can end it"))
+		}
+		auth.EndImpersonation(session.Id)
+		model.AddImpersonatedAuditLog(ctx.GetString(constants.GIN_CLIENT_IP), session.Uid, principal.Uid,
+			"impersonation.end", session.UserName, "")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 所有管理员进行中的代入会话
+func (Admin) LsImpersonation(ctx *gin.Context) {
+	sessions := []auth.ImpersonationSession{}
+	for _, key := range redis.ScanKeys(constants.REDIS_IMPERSONATION + "*") {
+		if session := redis.GetRedisObj[auth.ImpersonationSession](key); session != nil {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreateTime < sessions[j].CreateTime })
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"sessions": sessions,
+	})
+}
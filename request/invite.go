@@ -0,0 +1,222 @@
+package request
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/events"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type publishPrivateBundleReq struct {
+	AppName              *string `json:"appName" binding:"required"`
+	Deployment           *string `json:"deployment" binding:"required"`
+	Label                *string `json:"label" binding:"required"`
+	FreezeOverrideReason *string `json:"freezeOverrideReason"`
+}
+
+type createInviteTokenReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Label      *string `json:"label" binding:"required"`
+	Note       *string `json:"note"`
+	// 有效天数,0表示一直有效
+	ExpireDays int64 `json:"expireDays" binding:"omitempty,min=1,max=365"`
+}
+
+type lsInviteTokenReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+}
+
+type delInviteTokenReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Id         *int    `json:"id" binding:"required"`
+}
+
+type inviteInfo struct {
+	// 毫秒,0表示一直有效
+	ExpireTime int64
+	Info       updateInfo
+}
+
+func getPrivatePackage(ctx *gin.Context, appName string, deploymentName string, label string) *model.Package {
+	pack := getPackageByLabel(ctx, appName, deploymentName, label)
+	if pack.Status == nil || *pack.Status != constants.PACKAGE_STATUS_PRIVATE {
+		panic(errConflict(constants.ERR_PACKAGE_STATE, "Package "+label+" is not private"))
+	}
+	return pack
+}
+
+// 公开预发布包,需要审批的部署进入待审批
+func (App) PublishPrivateBundle(ctx *gin.Context) {
+	req := publishPrivateBundleReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		pack := getPrivatePackage(ctx, *req.AppName, *req.Deployment, *req.Label)
+		checkFreeze(ctx, uid, deployment, req.FreezeOverrideReason, false)
+		checkReleaseGate(ctx, uid, GATE_ACTION_PROMOTE, deployment, req)
+		status := constants.PACKAGE_STATUS_APPROVED
+		var approvedBy *int
+		if deployment.RequireApproval != nil && *deployment.RequireApproval {
+			status = constants.PACKAGE_STATUS_PENDING
+		} else {
+			approvedBy = &uid
+		}
+		version := updateWithRowVersion(ctx, "package", *pack.Id, func(tx *gorm.DB) error {
+			if err := (model.Package{}).UpdateStatus(tx, *pack.Id, status, approvedBy); err != nil {
+				return err
+			}
+			if status == constants.PACKAGE_STATUS_PENDING {
+				return nil
+			}
+			if err := (model.DeploymentVersion{}).UpdateCurrentPackage(tx, *pack.DeploymentVersionId, pack.Id, constants.RELEASE_ACTION_PUBLISH, uid); err != nil {
+				return err
+			}
+			if err := (model.DeploymentLookup{}).Rebuild(tx, *deployment.Id); err != nil {
+				return err
+			}
+			return enqueueReleaseEvent(tx, events.RELEASE_APPROVED, uid, deployment, pack)
+		})
+		if status == constants.PACKAGE_STATUS_PENDING {
+			notifyApprovers(model.GetOne[model.App]("id", deployment.AppId), deployment, pack)
+		}
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		if status == constants.PACKAGE_STATUS_APPROVED {
+			warmCache(*deployment.Key)
+		}
+		addAuditLog(ctx, uid, "package.publish", *req.AppName+"/"+*req.Deployment+"/"+*req.Label, status)
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"label":      pack.Label,
+			"status":     status,
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 邀请码只在创建时返回,数据库中保存sha256
+func (App) CreateInviteToken(ctx *gin.Context) {
+	req := createInviteTokenReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		pack := getPrivatePackage(ctx, *req.AppName, *req.Deployment, *req.Label)
+		token := strings.ReplaceAll(uuid.NewString(), "-", "")
+		tokenHash := utils.Sha256Hex(token)
+		invite := model.InviteToken{
+			DeploymentId: deployment.Id,
+			PackageId:    pack.Id,
+			TokenHash:    &tokenHash,
+			Note:         req.Note,
+			Uid:          &uid,
+			CreateTime:   utils.GetTimeNow(),
+		}
+		if req.ExpireDays > 0 {
+			expireTime := time.Now().AddDate(0, 0, int(req.ExpireDays)).UnixMilli()
+			invite.ExpireTime = &expireTime
+		}
+		if err := model.Create[model.InviteToken](&invite); err != nil {
+			log.Panic(err.Error())
+		}
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		addAuditLog(ctx, uid, "invite_token.create", *req.AppName+"/"+*req.Deployment+"/"+*req.Label, strconv.Itoa(*invite.Id))
+		deepLink := appLink(ctx, "invite", *deployment.Key, token)
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"id":         invite.Id,
+			"token":      token,
+			"deepLink":   deepLink,
+			"qrCode":     qrDataUri(deepLink),
+			"expireTime": invite.ExpireTime,
+			"instructions": "1. Install a build of " + *req.AppName + " that handles " + config.GetConfig().PinLinkScheme + "://codepush/invite\n" +
+				"2. Scan the QR code or open " + deepLink + " on the device\n" +
+				"3. The app sends the token as invite_token in update_check and installs " + *req.Label,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (App) LsInviteToken(ctx *gin.Context) {
+	req := lsInviteTokenReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		ctx.JSON(http.StatusOK, model.InviteToken{}.GetByDeploymentId(*deployment.Id))
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (App) DelInviteToken(ctx *gin.Context) {
+	req := delInviteTokenReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		invite := model.GetOne[model.InviteToken]("id", *req.Id)
+		if invite == nil || *invite.DeploymentId != *deployment.Id {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "Invite token not found"))
+		}
+		if err := model.Delete[model.InviteToken](model.InviteToken{Id: invite.Id}); err != nil {
+			log.Panic(err.Error())
+		}
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		addAuditLog(ctx, uid, "invite_token.delete", *req.AppName+"/"+*req.Deployment, strconv.Itoa(*req.Id))
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 只下发同一个版本中仍是预发布状态的包
+func getInvites(deploymentId int, deploymentVersion *model.DeploymentVersion) map[string]inviteInfo {
+	if deploymentVersion == nil {
+		return nil
+	}
+	tokens := model.InviteToken{}.GetValidByDeploymentId(deploymentId)
+	if tokens == nil || len(*tokens) == 0 {
+		return nil
+	}
+	invites := map[string]inviteInfo{}
+	for _, token := range *tokens {
+		pack := model.GetOne[model.Package]("id", *token.PackageId)
+		if pack == nil || *pack.DeploymentVersionId != *deploymentVersion.Id || pack.Status == nil || *pack.Status != constants.PACKAGE_STATUS_PRIVATE {
+			continue
+		}
+		invite := inviteInfo{Info: packageUpdateInfo(pack, deploymentVersion)}
+		if token.ExpireTime != nil {
+			invite.ExpireTime = *token.ExpireTime
+		}
+		invites[*token.TokenHash] = invite
+	}
+	return invites
+}
+
+func matchInvite(invites map[string]inviteInfo, token string) *updateInfo {
+	if token == "" || len(invites) == 0 {
+		return nil
+	}
+	invite, ok := invites[utils.Sha256Hex(token)]
+	if !ok || (invite.ExpireTime > 0 && invite.ExpireTime <= time.Now().UnixMilli()) {
+		return nil
+	}
+	return &invite.Info
+}
@@ -0,0 +1,104 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+type setLabelFormatReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	// 例如 v{n}、{version}-{n}、{date}.{n},为空时恢复label_mode
+	Format *string `json:"format" binding:"required,max=64"`
+	// 下一个发布的{n},从其他CodePush服务器迁移时设为原来最后的标签号+1
+	Start *int `json:"start" binding:"omitempty,min=1"`
+}
+
+// 模板中除了占位符只允许字母数字和.-_
+var labelFormatRegexp = regexp.MustCompile(`^([A-Za-z0-9._-]|\{n\}|\{version\}|\{date\})*$`)
+
+func formatLabel(format string, seq int, version string, now time.Time) string {
+	return strings.NewReplacer(
+		"{n}", strconv.Itoa(seq),
+		"{version}", version,
+		"{date}", now.UTC().Format("20060102"),
+	).Replace(format)
+}
+
+// 跳过已使用标签的最大次数
+const maxLabelSkip = 1000
+
+// 部署设置了标签模板时按模板生成,否则按label_mode;
+// start设得比已有标签小时跳过已使用的标签
+func releaseLabel(tx *gorm.DB, deployment *model.Deployment, version string, pid int) (string, error) {
+	if deployment.LabelFormat == nil || *deployment.LabelFormat == "" {
+		return model.Package{}.NewLabel(pid), nil
+	}
+	for i := 0; i < maxLabelSkip; i++ {
+		seq, err := model.Deployment{}.NextLabelSeq(tx, *deployment.Id)
+		if err != nil {
+			return "", err
+		}
+		label := formatLabel(*deployment.LabelFormat, seq, version, time.Now())
+		if !(model.Package{}).LabelTaken(tx, *deployment.Id, label) {
+			return label, nil
+		}
+	}
+	return "", errors.New("no free label in the next " + strconv.Itoa(maxLabelSkip) + ", set a larger start with setLabelFormat")
+}
+
+// dry run和setLabelFormat返回的下一个标签,不修改序号
+func previewLabel(deployment *model.Deployment, format string, seq int, version string) string {
+	if format == "" {
+		return ""
+	}
+	label := formatLabel(format, seq, version, time.Now())
+	for i := 1; i < maxLabelSkip && (model.Package{}).LabelTaken(nil, *deployment.Id, label); i++ {
+		label = formatLabel(format, seq+i, version, time.Now())
+	}
+	return label
+}
+
+// 标签在部署内唯一,模板必须包含{n}
+func (App) SetLabelFormat(ctx *gin.Context) {
+	req := setLabelFormatReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		format := *req.Format
+		if format != "" && (!strings.Contains(format, "{n}") || !labelFormatRegexp.MatchString(format)) {
+			panic(errInvalid("format", "format", "must contain {n} and only letters, digits, .-_ and {n} {version} {date}"))
+		}
+		seq := utils.IntValue(deployment.LabelSeq)
+		if req.Start != nil {
+			seq = *req.Start - 1
+		}
+		var labelFormat *string
+		if format != "" {
+			labelFormat = &format
+		}
+		version := updateWithRowVersion(ctx, "deployment", *deployment.Id, func(tx *gorm.DB) error {
+			return model.Deployment{}.UpdateLabelFormat(tx, *deployment.Id, labelFormat, seq)
+		})
+		addAuditLog(ctx, uid, "deployment.label_format", *req.AppName+"/"+*req.Deployment, format+" next="+strconv.Itoa(seq+1))
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"format":     format,
+			"nextLabel":  previewLabel(deployment, format, seq+1, "{version}"),
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
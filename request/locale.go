@@ -0,0 +1,126 @@
+package request
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxLocales       = 50
+	maxLocalizedText = 4096
+)
+
+// BCP 47 语言标签,例如 en, zh-TW, pt-BR
+var localeRegexp = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// locale -> 文本,key统一转成小写保存
+func encodeLocalized(field string, texts map[string]string) *string {
+	if len(texts) == 0 {
+		return nil
+	}
+	if len(texts) > maxLocales {
+		panic(errInvalid("max", field, "too many locales"))
+	}
+	m := make(map[string]string, len(texts))
+	for k, v := range texts {
+		if !localeRegexp.MatchString(k) {
+			panic(errInvalid("locale", field+"."+k, "must be a language tag such as en or zh-TW"))
+		}
+		if len(v) > maxLocalizedText {
+			panic(errInvalid("max", field+"."+k, "length must be at most "+strconv.Itoa(maxLocalizedText)))
+		}
+		m[strings.ToLower(k)] = v
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	str := string(data)
+	return &str
+}
+
+func decodeLocalized(texts *string) map[string]string {
+	if texts == nil || *texts == "" {
+		return nil
+	}
+	m := map[string]string{}
+	if err := json.Unmarshal([]byte(*texts), &m); err != nil {
+		log.Println("localized text decode error:" + err.Error())
+		return nil
+	}
+	return m
+}
+
+// "zh-TW,zh;q=0.9,en;q=0.8" -> [zh-tw zh en]
+func acceptLanguages(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
+
+// 先完全匹配,再依次去掉后缀匹配(zh-hant-tw -> zh-hant -> zh),都没有时返回空
+func pickLocalized(texts map[string]string, tags []string) string {
+	for _, tag := range tags {
+		for tag != "" {
+			if text, ok := texts[tag]; ok {
+				return text
+			}
+			i := strings.LastIndex(tag, "-")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return ""
+}
+
+// 查询参数locale优先于Accept-Language,没有匹配的语言时使用默认description
+func localize(ctx *gin.Context, req *updateCheckReq, info *updateInfo) {
+	if len(info.Descriptions) == 0 {
+		return
+	}
+	var tags []string
+	if req.Locale != "" {
+		tags = append(tags, strings.ToLower(req.Locale))
+	}
+	tags = append(tags, acceptLanguages(ctx.GetHeader("Accept-Language"))...)
+	if text := pickLocalized(info.Descriptions, tags); text != "" {
+		info.Description = text
+	}
+	info.Descriptions = nil
+}
@@ -0,0 +1,58 @@
+package request
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxMetadataKeys     = 20
+	maxMetadataValueLen = 1024
+)
+
+// key同时用作响应头名称,只允许字母数字和-_
+var metadataKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func encodeMetadata(metadata map[string]string) *string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	if len(metadata) > maxMetadataKeys {
+		panic(errInvalid("max", "metadata", "too many keys"))
+	}
+	for k, v := range metadata {
+		if !metadataKeyRegexp.MatchString(k) {
+			panic(errInvalid("key", "metadata."+k, "key must match "+metadataKeyRegexp.String()))
+		}
+		if len(v) > maxMetadataValueLen {
+			panic(errInvalid("max", "metadata."+k, "value too long"))
+		}
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	str := string(data)
+	return &str
+}
+
+func decodeMetadata(metadata *string) map[string]string {
+	if metadata == nil || *metadata == "" {
+		return nil
+	}
+	m := map[string]string{}
+	if err := json.Unmarshal([]byte(*metadata), &m); err != nil {
+		log.Println("metadata decode error:" + err.Error())
+		return nil
+	}
+	return m
+}
+
+func setMetadataHeaders(ctx *gin.Context, metadata map[string]string) {
+	for k, v := range metadata {
+		ctx.Header("X-CodePush-Meta-"+k, v)
+	}
+}
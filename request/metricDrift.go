@@ -0,0 +1,60 @@
+package request
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/rollup"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type reconcileMetricsReq struct {
+	// 默认rollup_reconcile_hours
+	Hours  *uint `json:"hours" binding:"omitempty,min=1"`
+	DryRun bool  `json:"dryRun"`
+}
+
+func checkReconcileEnabled() {
+	if !model.MetricEventsEnabled() {
+		panic(errNotFound(constants.ERR_NOT_FOUND, "Metric reconciliation is not enabled"))
+	}
+}
+
+// 最近一次核对的差异报告,每小时由汇总任务生成
+func (Admin) GetMetricDrift(ctx *gin.Context) {
+	checkReconcileEnabled()
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"report":  rollup.LastDrift(),
+	})
+}
+
+// 立即核对,不等下一次汇总任务
+func (Admin) ReconcileMetrics(ctx *gin.Context) {
+	checkReconcileEnabled()
+	req := reconcileMetricsReq{}
+	// 允许空请求体
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err != nil && err != io.EOF {
+		panic(bindError(err))
+	}
+	c := config.GetConfig().Rollup
+	hours := c.ReconcileHours
+	if req.Hours != nil {
+		hours = *req.Hours
+	}
+	if hours > c.HourlyRetentionDays*24 {
+		panic(errInvalid("max", "hours", "must be within rollup_hourly_retention_days"))
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	report := rollup.Reconcile(hours, req.DryRun || c.ReconcileDryRun)
+	addAuditLog(ctx, uid, "metrics.reconcile", "*", "drift="+strconv.Itoa(report.Drifted))
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"report":  report,
+	})
+}
@@ -0,0 +1,59 @@
+package request
+
+import (
+	"net/http"
+	"time"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/rollup"
+	"github.com/gin-gonic/gin"
+)
+
+type metricRollupReq struct {
+	AppName     string `form:"appName" binding:"required"`
+	Deployment  string `form:"deployment" binding:"required"`
+	Granularity string `form:"granularity" binding:"required,oneof=hour day month"`
+	// 毫秒时间戳,默认最近7天
+	From int64 `form:"from"`
+	To   int64 `form:"to"`
+}
+
+type metricRollupRow struct {
+	model.MetricRollup
+	// 报表时区的本地时间,例如 2024-05-01 / 2024-05 / 2024-05-01T09:00:00+09:00
+	Bucket string `json:"bucket"`
+}
+
+var bucketFormats = map[string]string{
+	constants.ROLLUP_HOUR:  time.RFC3339,
+	constants.ROLLUP_DAY:   "2006-01-02",
+	constants.ROLLUP_MONTH: "2006-01",
+}
+
+func (App) LsMetricRollup(ctx *gin.Context) {
+	req := metricRollupReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	deployment := getDeploymentByName(uid, req.AppName, req.Deployment)
+	if req.To == 0 {
+		req.To = time.Now().UnixMilli()
+	}
+	if req.From == 0 {
+		req.From = req.To - (7 * 24 * time.Hour).Milliseconds()
+	}
+	rows := []metricRollupRow{}
+	if list := (model.MetricRollup{}).GetByDeploymentId(*deployment.Id, req.Granularity, req.From, req.To); list != nil {
+		loc := rollup.Location()
+		for _, v := range *list {
+			rows = append(rows, metricRollupRow{v, time.UnixMilli(*v.BucketTime).In(loc).Format(bucketFormats[req.Granularity])})
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"timezone": rollup.Location().String(),
+		"metrics":  rows,
+	})
+}
@@ -0,0 +1,98 @@
+package request
+
+import (
+	"log"
+	"net/http"
+	"path"
+
+	"com.lc.go.codepush/server/diff"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type packageReq struct {
+	AppName    string `form:"appName" binding:"required"`
+	Deployment string `form:"deployment" binding:"required"`
+	Label      string `form:"label" binding:"required"`
+}
+
+func getPackageByLabel(ctx *gin.Context, appName string, deploymentName string, label string) *model.Package {
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	deployment := getDeploymentByName(uid, appName, deploymentName)
+	pack := model.Package{}.GetByDeploymentIdAndLabel(*deployment.Id, label)
+	if pack == nil {
+		panic(errNotFound(constants.ERR_PACKAGE_NOT_FOUND, "Package "+label+" not found"))
+	}
+	return pack
+}
+
+// 下载历史发布的原始zip,manifest=true时返回包信息和文件清单
+func (App) DownloadPackage(ctx *gin.Context) {
+	req := packageReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	pack := getPackageByLabel(ctx, req.AppName, req.Deployment, req.Label)
+	data, err := storage.Download(*pack.Download)
+	if err != nil {
+		log.Panic("Download package error:" + err.Error())
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	addAuditLog(ctx, uid, "package.download", req.AppName+"/"+req.Deployment+"/"+req.Label, ctx.Query("manifest"))
+	if ctx.Query("manifest") == "true" {
+		files, err := diff.Manifest(data)
+		if err != nil {
+			panicArchiveError("Read package error:", err)
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"package": pack,
+			"files":   files,
+		})
+		return
+	}
+	fileName := req.AppName + "-" + req.Deployment + "-" + req.Label + path.Ext(*pack.Download)
+	ctx.Header("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+	ctx.Data(http.StatusOK, "application/zip", data)
+}
+
+type comparePackageReq struct {
+	AppName    string `form:"appName" binding:"required"`
+	Deployment string `form:"deployment" binding:"required"`
+	From       string `form:"from" binding:"required"`
+	To         string `form:"to" binding:"required"`
+}
+
+// 比较同一部署下两个标签的文件清单
+func (App) ComparePackage(ctx *gin.Context) {
+	req := comparePackageReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	fromPack := getPackageByLabel(ctx, req.AppName, req.Deployment, req.From)
+	toPack := getPackageByLabel(ctx, req.AppName, req.Deployment, req.To)
+	fromFiles := packageManifest(fromPack)
+	toFiles := packageManifest(toPack)
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"from":            req.From,
+		"to":              req.To,
+		"packageSizeFrom": fromPack.Size,
+		"packageSizeTo":   toPack.Size,
+		"diff":            diff.CompareManifests(fromFiles, toFiles),
+	})
+}
+
+func packageManifest(pack *model.Package) []diff.ManifestFile {
+	data, err := storage.Download(*pack.Download)
+	if err != nil {
+		log.Panic("Download package error:" + err.Error())
+	}
+	files, err := diff.Manifest(data)
+	if err != nil {
+		panicArchiveError("Read package error:", err)
+	}
+	return files
+}
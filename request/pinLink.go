@@ -0,0 +1,167 @@
+package request
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+type createPinLinkReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Label      *string `json:"label" binding:"required"`
+	// 链接和设备固定的有效分钟数
+	ExpireMinutes int64 `json:"expireMinutes" binding:"omitempty,min=1,max=10080"`
+}
+
+type pinLink struct {
+	AppName       string `json:"appName"`
+	Deployment    string `json:"deployment"`
+	DeploymentId  int    `json:"deploymentId"`
+	DeploymentKey string `json:"deploymentKey"`
+	PackageId     int    `json:"packageId"`
+	Label         string `json:"label"`
+	Uid           int    `json:"uid"`
+	ExpireTime    int64  `json:"expireTime"`
+}
+
+type pinReq struct {
+	Token          string `json:"token" binding:"required"`
+	ClientUniqueId string `json:"client_unique_id" binding:"required"`
+}
+
+// 服务器对外地址,部署在代理后面时使用X-Forwarded-Proto/Host/Prefix,
+// 没有X-Forwarded-Prefix时使用url_prefix
+func serverUrl(ctx *gin.Context) string {
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := ctx.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := ctx.Request.Host
+	if forwardedHost := ctx.GetHeader("X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+	prefix := strings.TrimRight(config.GetConfig().UrlPrefix, "/")
+	if forwardedPrefix := ctx.GetHeader("X-Forwarded-Prefix"); forwardedPrefix != "" {
+		prefix = strings.TrimRight(forwardedPrefix, "/")
+	}
+	return scheme + "://" + host + prefix
+}
+
+// 相对地址(resource_url以/开头)补全为服务器对外地址
+func absoluteUrl(ctx *gin.Context, u string) string {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") {
+		return u
+	}
+	return serverUrl(ctx) + u
+}
+
+// {pin_link_scheme}://codepush/{action}?serverUrl=..&deploymentKey=..&token=..
+func appLink(ctx *gin.Context, action string, deploymentKey string, token string) string {
+	query := url.Values{}
+	query.Set("serverUrl", serverUrl(ctx))
+	query.Set("deploymentKey", deploymentKey)
+	query.Set("token", token)
+	return config.GetConfig().PinLinkScheme + "://codepush/" + action + "?" + query.Encode()
+}
+
+// PNG格式的二维码data URI
+func qrDataUri(text string) string {
+	png, err := qrcode.Encode(text, qrcode.Medium, 256)
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+}
+
+// 生成指向某个标签的深链接和二维码,QA用debug包扫码后该设备在有效期内固定到这个标签
+func (App) CreatePinLink(ctx *gin.Context) {
+	req := createPinLinkReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		pack := getPackageByLabel(ctx, *req.AppName, *req.Deployment, *req.Label)
+		if req.ExpireMinutes == 0 {
+			req.ExpireMinutes = 60
+		}
+		ttl := time.Duration(req.ExpireMinutes) * time.Minute
+		token := uuid.NewString()
+		link := pinLink{
+			AppName:       *req.AppName,
+			Deployment:    *req.Deployment,
+			DeploymentId:  *deployment.Id,
+			DeploymentKey: *deployment.Key,
+			PackageId:     *pack.Id,
+			Label:         *req.Label,
+			Uid:           uid,
+			ExpireTime:    time.Now().Add(ttl).UnixMilli(),
+		}
+		redis.SetRedisObj(constants.REDIS_PIN_LINK+token, link, ttl)
+		deepLink := appLink(ctx, "pin", *deployment.Key, token)
+		addAuditLog(ctx, uid, "pin_link.create", *req.AppName+"/"+*req.Deployment+"/"+*req.Label, strconv.FormatInt(req.ExpireMinutes, 10)+"m")
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"token":      token,
+			"deepLink":   deepLink,
+			"qrCode":     qrDataUri(deepLink),
+			"expireTime": link.ExpireTime,
+			"instructions": "1. Install a debug build of " + *req.AppName + " that handles " + config.GetConfig().PinLinkScheme + "://codepush/pin\n" +
+				"2. Scan the QR code or open " + deepLink + " on the device\n" +
+				"3. Restart the app, it will install " + *req.Label + " from " + *req.Deployment,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// debug包打开深链接后调用,为该设备创建带过期时间的固定规则
+func (Client) Pin(ctx *gin.Context) {
+	req := pinReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		log.Panic(err.Error())
+	}
+	link := redis.GetRedisObj[pinLink](constants.REDIS_PIN_LINK + req.Token)
+	if link == nil || link.ExpireTime <= time.Now().UnixMilli() {
+		panic(constants.ErrObj{Status: http.StatusNotFound, Code: constants.ERR_PIN_LINK_EXPIRED, Msg: "Pin link expired"})
+	}
+	ctx.Set(constants.GIN_DEPLOYMENT_KEY, link.DeploymentKey)
+	action := constants.CLIENT_RULE_PIN
+	note := "pin link"
+	rule := model.ClientRule{
+		DeploymentId:   &link.DeploymentId,
+		ClientUniqueId: &req.ClientUniqueId,
+		Action:         &action,
+		PackageId:      &link.PackageId,
+		Note:           &note,
+		Uid:            &link.Uid,
+		CreateTime:     utils.GetTimeNow(),
+		ExpireTime:     &link.ExpireTime,
+	}
+	if err := model.Create[model.ClientRule](&rule); err != nil {
+		log.Panic(err.Error())
+	}
+	addAuditLog(ctx, link.Uid, "client_rule.add", link.AppName+"/"+link.Deployment+"/"+req.ClientUniqueId, "pin "+link.Label+" via link")
+	redis.DelRedisObj(constants.REDIS_UPDATE_INFO + link.DeploymentKey + "*")
+	ctx.JSON(http.StatusOK, gin.H{
+		"deployment_key": link.DeploymentKey,
+		"label":          link.Label,
+		"expire_time":    link.ExpireTime,
+	})
+}
@@ -0,0 +1,137 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+)
+
+type uploadProgress struct {
+	UploadId   string `json:"uploadId"`
+	Stage      string `json:"stage"`
+	Received   int64  `json:"received"`
+	Total      int64  `json:"total"`
+	Error      string `json:"error,omitempty"`
+	UpdateTime int64  `json:"updateTime"`
+}
+
+// 客户端通过Upload-Id请求头为上传/发布指定一个id,再轮询uploadProgress获取进度
+type progressTracker struct {
+	key      string
+	progress uploadProgress
+	lastSave time.Time
+	detached bool
+}
+
+const progressTTL = time.Hour
+
+func progressKey(uid int, uploadId string) string {
+	return constants.REDIS_UPLOAD + strconv.Itoa(uid) + ":" + uploadId
+}
+
+// 没有Upload-Id请求头时返回nil,所有方法对nil安全
+func newProgressTracker(ctx *gin.Context, stage string) *progressTracker {
+	uploadId := ctx.GetHeader("Upload-Id")
+	if uploadId == "" {
+		return nil
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	t := &progressTracker{
+		key:      progressKey(uid, uploadId),
+		progress: uploadProgress{UploadId: uploadId},
+	}
+	// createBundle沿用uploadBundle的进度,只更新阶段
+	if old := redis.GetRedisObj[uploadProgress](t.key); old != nil {
+		t.progress = *old
+		t.progress.Error = ""
+	}
+	if stage == constants.UPLOAD_STAGE_RECEIVING {
+		t.progress.Received = 0
+		t.progress.Total = ctx.Request.ContentLength
+	}
+	t.setStage(stage)
+	return t
+}
+
+func (t *progressTracker) save() {
+	t.progress.UpdateTime = time.Now().UnixMilli()
+	t.lastSave = time.Now()
+	redis.SetRedisObj(t.key, t.progress, progressTTL)
+}
+
+func (t *progressTracker) setStage(stage string) {
+	if t == nil {
+		return
+	}
+	t.progress.Stage = stage
+	t.save()
+}
+
+// 请求结束时记录done,出错时记录failed后继续抛出
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		t.progress.Error = fmt.Sprint(r)
+		t.setStage(constants.UPLOAD_STAGE_FAILED)
+		panic(r)
+	}
+	if t.detached {
+		return
+	}
+	t.setStage(constants.UPLOAD_STAGE_DONE)
+}
+
+// 交给后台任务继续更新进度,请求本身正常返回时不再记录done
+func (t *progressTracker) detach() *progressTracker {
+	if t == nil {
+		return nil
+	}
+	t.detached = true
+	return &progressTracker{key: t.key, progress: t.progress, lastSave: t.lastSave}
+}
+
+// 统计已接收的请求体字节数,每秒最多写一次redis
+func (t *progressTracker) wrapBody(body io.ReadCloser) io.ReadCloser {
+	if t == nil {
+		return body
+	}
+	return &countingBody{ReadCloser: body, tracker: t}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	tracker *progressTracker
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.tracker.progress.Received += int64(n)
+	if err == io.EOF || time.Since(b.tracker.lastSave) >= time.Second {
+		b.tracker.save()
+	}
+	return n, err
+}
+
+func (App) UploadProgress(ctx *gin.Context) {
+	uploadId := ctx.Query("uploadId")
+	if uploadId == "" {
+		panic(errInvalid("required", "uploadId", "is required"))
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	progress := redis.GetRedisObj[uploadProgress](progressKey(uid, uploadId))
+	if progress == nil {
+		panic(errNotFound(constants.ERR_NOT_FOUND, "Upload not found"))
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"progress": progress,
+	})
+}
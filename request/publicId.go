@@ -0,0 +1,38 @@
+package request
+
+import (
+	"log"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/sentry"
+)
+
+// 开启uuidv7后在后台给已有的app、deployment和package补上public_id,补完之前这些行在接口中的id为null
+func StartPublicIdBackfill() {
+	if !model.PublicIds() {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("public id backfill error:%v", r)
+				sentry.CapturePanic("public_id", r, nil)
+			}
+		}()
+		total := 0
+		for {
+			n, err := model.BackfillPublicIds(500)
+			total += n
+			if err != nil {
+				log.Printf("public id backfill error:%s", err.Error())
+				return
+			}
+			if n == 0 {
+				break
+			}
+		}
+		if total > 0 {
+			log.Printf("public id backfill: %d rows", total)
+		}
+	}()
+}
@@ -0,0 +1,214 @@
+package request
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+type delBundleReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Label      *string `json:"label" binding:"required"`
+	Reason     *string `json:"reason" binding:"omitempty,max=500"`
+}
+
+type lsDeletedBundleReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+}
+
+type purgeDeletedBundleReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	// 为空时清空该部署的回收站
+	Label *string `json:"label"`
+}
+
+// 删除单个发布,当前包需要先回滚
+func (App) DelBundle(ctx *gin.Context) {
+	req := delBundleReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		pack := getPackageByLabel(ctx, *req.AppName, *req.Deployment, *req.Label)
+		deploymentVersion := model.GetOne[model.DeploymentVersion]("id", *pack.DeploymentVersionId)
+		if deploymentVersion != nil && deploymentVersion.CurrentPackage != nil && *deploymentVersion.CurrentPackage == *pack.Id {
+			panic(errConflict(constants.ERR_PACKAGE_STATE, "Package "+*req.Label+" is the current release, roll back first"))
+		}
+		reason := "manual"
+		if req.Reason != nil && *req.Reason != "" {
+			reason = *req.Reason
+		}
+		removePackage(pack, reason, uid)
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+		addAuditLog(ctx, uid, "package.delete", *req.AppName+"/"+*req.Deployment+"/"+*req.Label, reason)
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (App) LsDeletedBundle(ctx *gin.Context) {
+	req := lsDeletedBundleReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		ctx.JSON(http.StatusOK, model.PackageTombstone{}.GetByDeploymentId(*deployment.Id))
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 删除回收站中的文件和记录,之后无法恢复
+func (App) PurgeDeletedBundle(ctx *gin.Context) {
+	req := purgeDeletedBundleReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		tombstones := model.PackageTombstone{}.GetByDeploymentId(*deployment.Id)
+		purged := 0
+		if tombstones != nil {
+			for _, tombstone := range *tombstones {
+				if req.Label != nil && utils.StringValue(tombstone.Label) != *req.Label {
+					continue
+				}
+				purgeTombstone(&tombstone)
+				addAuditLog(ctx, uid, "package.purge", *req.AppName+"/"+*req.Deployment+"/"+utils.StringValue(tombstone.Label), utils.StringValue(tombstone.Hash))
+				purged++
+			}
+		}
+		if req.Label != nil && purged == 0 {
+			panic(errNotFound(constants.ERR_PACKAGE_NOT_FOUND, "Deleted package "+*req.Label+" not found"))
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"purged":  purged,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 包移入回收站,相关的差量包、固定规则和邀请码一起删除
+func removePackage(pack *model.Package, reason string, uid int) {
+	diffs := model.GetList[model.PackageDiff]("package_id", *pack.Id)
+	userDb, _ := db.GetUserDB()
+	err := userDb.Transaction(func(tx *gorm.DB) error {
+		tombstone := model.PackageTombstone{
+			PackageId:           pack.Id,
+			DeploymentId:        pack.DeploymentId,
+			DeploymentVersionId: pack.DeploymentVersionId,
+			Label:               pack.Label,
+			Hash:                pack.Hash,
+			Size:                pack.Size,
+			Download:            pack.Download,
+			ZstdDownload:        pack.ZstdDownload,
+			Description:         pack.Description,
+			Reason:              &reason,
+			Uid:                 &uid,
+			ReleaseTime:         pack.CreateTime,
+			DeleteTime:          utils.GetTimeNow(),
+		}
+		if err := tx.Create(&tombstone).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(model.Package{Id: pack.Id}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("package_id", *pack.Id).Delete(model.PackageDiff{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("package_id", *pack.Id).Delete(model.ClientRule{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("package_id", *pack.Id).Delete(model.BuildPin{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("package_id", *pack.Id).Delete(model.InviteToken{}).Error
+	})
+	if err != nil {
+		log.Panic("DeleteError:" + err.Error())
+	}
+	// 差量包可以重新生成,不进回收站
+	if diffs != nil {
+		for _, d := range *diffs {
+			if d.Download != nil && *d.Download != "" {
+				storage.Delete(*d.Download)
+			}
+		}
+	}
+}
+
+// 没有其他包或删除记录引用的文件才删除
+func purgeTombstone(tombstone *model.PackageTombstone) {
+	for _, key := range appendKey(appendKey(nil, tombstone.Download), tombstone.ZstdDownload) {
+		if (model.Package{}).CountByDownload(key) > 0 || (model.PackageTombstone{}).CountOtherByDownload(*tombstone.Id, key) > 0 {
+			continue
+		}
+		if err := storage.Delete(key); err != nil {
+			log.Panic(err.Error())
+		}
+	}
+	if err := model.Delete[model.PackageTombstone](model.PackageTombstone{Id: tombstone.Id}); err != nil {
+		log.Panic(err.Error())
+	}
+}
+
+// 每小时由一个实例按release_retention_count清理旧的发布
+func StartReleaseRetention() {
+	if config.GetConfig().ReleaseRetentionCount <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			hour := time.Now().UTC().Truncate(time.Hour)
+			if redis.SetNX(constants.REDIS_RETENTION+"lock:"+strconv.FormatInt(hour.Unix(), 10), 2*time.Hour) {
+				applyRetention()
+			}
+		}
+	}()
+}
+
+func applyRetention() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("retention: error:%v", r)
+			sentry.CapturePanic("retention", r, nil)
+		}
+	}()
+	keep := int(config.GetConfig().ReleaseRetentionCount)
+	packs := model.Package{}.GetBeyondRetention(keep, 500)
+	if packs == nil {
+		return
+	}
+	deploymentKeys := map[int]string{}
+	for i := range *packs {
+		pack := &(*packs)[i]
+		removePackage(pack, "retention: keep "+strconv.Itoa(keep), 0)
+		if _, ok := deploymentKeys[*pack.DeploymentId]; !ok {
+			if deployment := model.GetOne[model.Deployment]("id", *pack.DeploymentId); deployment != nil {
+				deploymentKeys[*pack.DeploymentId] = *deployment.Key
+			}
+		}
+	}
+	for _, key := range deploymentKeys {
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + key + "*")
+	}
+	log.Printf("retention: removed %d packages", len(*packs))
+}
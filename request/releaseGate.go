@@ -0,0 +1,81 @@
+package request
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/opa"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	GATE_ACTION_RELEASE  = "release"
+	GATE_ACTION_PROMOTE  = "promote"
+	GATE_ACTION_ROLLBACK = "rollback"
+)
+
+// 在冻结检查之后调用,OPA拒绝时返回POLICY_VIOLATION;未配置opa_url时不检查
+func checkReleaseGate(ctx *gin.Context, uid int, action string, deployment *model.Deployment, req any) {
+	if !opa.Enabled() {
+		return
+	}
+	app := model.GetOne[model.App]("id", deployment.AppId)
+	target := utils.StringValue(deployment.Name)
+	if app != nil {
+		target = utils.StringValue(app.AppName) + "/" + target
+	}
+	decision, err := opa.Evaluate(releaseGateInput(ctx, uid, action, app, deployment, req))
+	if err != nil {
+		log.Printf("opa: evaluate %s %s error:%s", action, target, err.Error())
+		sentry.CaptureError("opa", err, nil)
+		if config.GetConfig().Opa.FailOpen {
+			return
+		}
+		panic(errPolicy("Policy engine unavailable"))
+	}
+	if decision.Allow {
+		return
+	}
+	reason := strings.Join(decision.Reasons, "; ")
+	addAuditLog(ctx, uid, "policy.deny", target, action+": "+reason)
+	msg := "Denied by policy"
+	if reason != "" {
+		msg += ": " + reason
+	}
+	panic(errPolicy(msg))
+}
+
+// OPA的input,包含请求体和发布人、应用、部署的信息
+func releaseGateInput(ctx *gin.Context, uid int, action string, app *model.App, deployment *model.Deployment, req any) gin.H {
+	now := time.Now().UTC()
+	input := gin.H{
+		"action":  action,
+		"tenant":  config.GetConfig().TenantName,
+		"region":  config.GetConfig().Region,
+		"time":    now.Format(time.RFC3339),
+		"weekday": now.Weekday().String(),
+		"request": req,
+		"client": gin.H{
+			"ip":        ctx.GetString(constants.GIN_CLIENT_IP),
+			"userAgent": ctx.Request.UserAgent(),
+		},
+		"deployment": gin.H{
+			"id":              deployment.Id,
+			"name":            deployment.Name,
+			"requireApproval": deployment.RequireApproval != nil && *deployment.RequireApproval,
+		},
+	}
+	if user := model.GetOne[model.User]("id", uid); user != nil {
+		input["user"] = gin.H{"id": uid, "userName": user.UserName, "role": user.GetRole()}
+	}
+	if app != nil {
+		input["app"] = gin.H{"id": app.Id, "name": app.AppName, "os": app.OS, "platform": app.Platform}
+	}
+	return input
+}
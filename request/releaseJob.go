@@ -0,0 +1,254 @@
+package request
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type releaseJob struct {
+	ProcessingId string `json:"processingId"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	Result       gin.H  `json:"result,omitempty"`
+	CreateTime   int64  `json:"createTime"`
+	UpdateTime   int64  `json:"updateTime"`
+}
+
+// 重新执行异步发布需要的内容,请求中的默认值在执行时按当时的部署策略补上
+type releaseJobPayload struct {
+	Request        createBundleReq `json:"request"`
+	IdempotencyKey string          `json:"idempotencyKey"`
+	Warning        string          `json:"warning"`
+	ClientIp       string          `json:"clientIp"`
+	UserAgent      string          `json:"userAgent"`
+	Impersonator   int             `json:"impersonator"`
+}
+
+const (
+	// 完成的任务保留这么久
+	releaseJobTTL = 24 * time.Hour
+	// 执行中的任务定期刷新update_time,超过releaseJobStale没有刷新时视为中断
+	releaseJobHeartbeat = 30 * time.Second
+	releaseJobStale     = 2 * time.Minute
+)
+
+// 在后台执行耗时的发布步骤,立即返回202和processingId,通过releaseStatus查询结果
+// work在goroutine中运行,只能使用传入的jobCtx;payload不为nil时保存下来,重启后可以重新执行
+func startReleaseJob(ctx *gin.Context, kind string, payload any, work func(jobCtx *gin.Context, processingId string) gin.H) {
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	processingId := uuid.NewString()
+	status := constants.JOB_STATUS_RUNNING
+	job := model.ReleaseJob{
+		ProcessingId: &processingId,
+		Uid:          &uid,
+		Kind:         &kind,
+		Status:       &status,
+		CreateTime:   utils.GetTimeNow(),
+		UpdateTime:   utils.GetTimeNow(),
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			log.Panic(err.Error())
+		}
+		payloadStr := string(data)
+		job.Payload = &payloadStr
+	}
+	if err := model.Create[model.ReleaseJob](&job); err != nil {
+		log.Panic(err.Error())
+	}
+	jobCtx := jobContext(uid, ctx.GetString(constants.GIN_CLIENT_IP), ctx.Request.UserAgent(), ctx.GetInt(constants.GIN_IMPERSONATOR))
+	go runReleaseJob(processingId, jobCtx, work)
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"success":      true,
+		"processingId": processingId,
+		"status":       constants.JOB_STATUS_PENDING,
+	})
+}
+
+// 后台任务中代替请求的context,审计日志和发布门禁需要其中的用户、IP和代入信息
+func jobContext(uid int, clientIp string, userAgent string, impersonator int) *gin.Context {
+	ctx := &gin.Context{Request: &http.Request{Header: http.Header{}}}
+	ctx.Request.Header.Set("User-Agent", userAgent)
+	ctx.Set(constants.GIN_USER_ID, uid)
+	ctx.Set(constants.GIN_CLIENT_IP, clientIp)
+	if impersonator != 0 {
+		ctx.Set(constants.GIN_IMPERSONATOR, impersonator)
+	}
+	return ctx
+}
+
+func runReleaseJob(processingId string, jobCtx *gin.Context, work func(jobCtx *gin.Context, processingId string) gin.H) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(releaseJobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				model.ReleaseJob{}.Heartbeat(processingId)
+			}
+		}
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("release job %s failed: %v", processingId, r)
+			sentry.CapturePanic("release_job", r, map[string]string{"processingId": processingId})
+			msg := fmt.Sprint(r)
+			if err := (model.ReleaseJob{}).Finish(processingId, constants.JOB_STATUS_FAILED, nil, &msg); err != nil {
+				log.Printf("release job %s save error:%s", processingId, err.Error())
+			}
+		}
+	}()
+	data, err := json.Marshal(work(jobCtx, processingId))
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	result := string(data)
+	if err := (model.ReleaseJob{}).Finish(processingId, constants.JOB_STATUS_SUCCEEDED, &result, nil); err != nil {
+		log.Printf("release job %s save error:%s", processingId, err.Error())
+	}
+}
+
+// 执行时重新读取应用和部署,并重新检查冻结、部署策略、配额和发布门禁,排队期间的变更同样生效;
+// 没有Idempotency-Key时用processingId,中断后重新执行不会重复发布
+func executeReleaseJob(ctx *gin.Context, processingId string, payload *releaseJobPayload) gin.H {
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	req := payload.Request
+	if req.UploadProcessingId != nil {
+		if err := waitReleaseJob(uid, *req.UploadProcessingId, 30*time.Minute); err != nil {
+			log.Panic(err.Error())
+		}
+	}
+	app := model.App{}.GetAppByUidAndAppName(uid, *req.AppName)
+	if app == nil {
+		panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+	}
+	deployment := model.Deployment{}.GetByAppidAndName(*app.Id, *req.Deployment)
+	if deployment == nil {
+		panic(errNotFound(constants.ERR_DEPLOYMENT_NOT_FOUND, "Deployment "+*req.Deployment+" not found"))
+	}
+	idempotencyKey := payload.IdempotencyKey
+	if idempotencyKey == "" {
+		idempotencyKey = processingId
+	}
+	if oldPack := (model.Package{}).GetByIdempotencyKey(*deployment.Id, idempotencyKey); oldPack != nil {
+		return gin.H{
+			"success": true,
+			"label":   oldPack.Label,
+		}
+	}
+	checkFreeze(ctx, uid, deployment, req.FreezeOverrideReason, false)
+	applyDeploymentPolicy(deployment, &req)
+	checkStorageQuota(*app.Uid, *req.Size)
+	checkReleaseGate(ctx, uid, GATE_ACTION_RELEASE, deployment, req)
+	return releaseBundle(uid, app, deployment, &req, idempotencyKey, payload.Warning)
+}
+
+// 等待另一个任务(例如异步上传)完成
+func waitReleaseJob(uid int, processingId string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		job := model.ReleaseJob{}.Get(uid, processingId)
+		if job == nil {
+			return errors.New("Processing " + processingId + " not found")
+		}
+		switch *job.Status {
+		case constants.JOB_STATUS_SUCCEEDED:
+			return nil
+		case constants.JOB_STATUS_FAILED:
+			return errors.New("Processing " + processingId + " failed: " + utils.StringValue(job.Error))
+		}
+		time.Sleep(time.Second)
+	}
+	return errors.New("Processing " + processingId + " timeout")
+}
+
+// 启动时和之后每分钟接手中断的任务: 发布任务重新执行;
+// 上传任务的文件只在原来实例的内存中,标记为失败
+func StartReleaseJobs() {
+	go func() {
+		for {
+			resumeReleaseJobs()
+			time.Sleep(time.Minute)
+		}
+	}()
+}
+
+func resumeReleaseJobs() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("release job: resume error:%v", r)
+			sentry.CapturePanic("release_job", r, nil)
+		}
+	}()
+	now := time.Now()
+	if err := (model.ReleaseJob{}).DeleteFinishedBefore(now.Add(-releaseJobTTL).UnixMilli()); err != nil {
+		log.Printf("release job: cleanup error:%s", err.Error())
+	}
+	jobs := model.ReleaseJob{}.GetStale(now.Add(-releaseJobStale).UnixMilli())
+	if jobs == nil {
+		return
+	}
+	for _, job := range *jobs {
+		processingId := *job.ProcessingId
+		if !(model.ReleaseJob{}).Claim(processingId, *job.UpdateTime) {
+			continue
+		}
+		payload := &releaseJobPayload{}
+		if *job.Kind != constants.JOB_KIND_RELEASE || job.Payload == nil || json.Unmarshal([]byte(*job.Payload), payload) != nil {
+			msg := "Interrupted by a server restart, upload again"
+			model.ReleaseJob{}.Finish(processingId, constants.JOB_STATUS_FAILED, nil, &msg)
+			continue
+		}
+		log.Printf("release job %s resumed", processingId)
+		jobCtx := jobContext(*job.Uid, payload.ClientIp, payload.UserAgent, payload.Impersonator)
+		go runReleaseJob(processingId, jobCtx, func(jobCtx *gin.Context, processingId string) gin.H {
+			return executeReleaseJob(jobCtx, processingId, payload)
+		})
+	}
+}
+
+func (App) ReleaseStatus(ctx *gin.Context) {
+	processingId := ctx.Query("processingId")
+	if processingId == "" {
+		panic(errInvalid("required", "processingId", "is required"))
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	job := model.ReleaseJob{}.Get(uid, processingId)
+	if job == nil {
+		panic(errNotFound(constants.ERR_NOT_FOUND, "Processing not found"))
+	}
+	view := releaseJob{
+		ProcessingId: processingId,
+		Status:       *job.Status,
+		Error:        utils.StringValue(job.Error),
+		CreateTime:   *job.CreateTime,
+		UpdateTime:   *job.UpdateTime,
+	}
+	// 对外仍然是pending/succeeded/failed
+	if view.Status == constants.JOB_STATUS_RUNNING {
+		view.Status = constants.JOB_STATUS_PENDING
+	}
+	if job.Result != nil {
+		json.Unmarshal([]byte(*job.Result), &view.Result)
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"job":     view,
+	})
+}
@@ -0,0 +1,76 @@
+package request
+
+import (
+	"net/http"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type renameAppReq struct {
+	AppName *string `json:"appName" binding:"required"`
+	NewName *string `json:"newName" binding:"required,max=256"`
+}
+
+type renameDeploymentReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	NewName    *string `json:"newName" binding:"required,max=256"`
+}
+
+// 只修改名称,id、deploymentKey、包文件和发布历史都不变
+func (App) RenameApp(ctx *gin.Context) {
+	req := renameAppReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		app := model.App{}.GetAppByUidAndAppName(uid, *req.AppName)
+		if app == nil {
+			panic(errNotFound(constants.ERR_APP_NOT_FOUND, "App not found"))
+		}
+		if (model.App{}).GetAppByUidAndAppName(uid, *req.NewName) != nil {
+			panic(errConflict(constants.ERR_APP_EXISTS, "AppName "+*req.NewName+" exist"))
+		}
+		version := bumpRowVersion(ctx, nil, "apps", *app.Id)
+		model.App{}.UpdateName(*app.Id, *req.NewName)
+		// 缓存中的应用名用于指标标签
+		if deployments := (model.Deployment{}).GetByAppids(*app.Id); deployments != nil {
+			for _, deployment := range *deployments {
+				deploymentAppNames.Store(*deployment.Key, *req.NewName)
+				redis.DelRedisObj(constants.REDIS_UPDATE_INFO + *deployment.Key + "*")
+			}
+		}
+		addAuditLog(ctx, uid, "app.rename", *req.AppName, *req.NewName)
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"appName":    req.NewName,
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (App) RenameDeployment(ctx *gin.Context) {
+	req := renameDeploymentReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		if (model.Deployment{}).GetByAppidAndName(*deployment.AppId, *req.NewName) != nil {
+			panic(errConflict(constants.ERR_DEPLOYMENT_EXISTS, "Deployment name "+*req.NewName+" exist"))
+		}
+		version := bumpRowVersion(ctx, nil, "deployment", *deployment.Id)
+		model.Deployment{}.UpdateName(*deployment.Id, *req.NewName)
+		addAuditLog(ctx, uid, "deployment.rename", *req.AppName+"/"+*req.Deployment, *req.NewName)
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"deployment": req.NewName,
+			"key":        deployment.Key,
+			"rowVersion": version,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
@@ -0,0 +1,26 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// 高频接口使用池化的buffer序列化json,减少内存分配
+func writeJSON(ctx *gin.Context, code int, obj any) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	if err := json.NewEncoder(buf).Encode(obj); err != nil {
+		panic(err.Error())
+	}
+	ctx.Data(code, "application/json; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,169 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"com.lc.go.codepush/server/db"
+	"com.lc.go.codepush/server/events"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+type rolloutReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Label      *string `json:"label" binding:"required"`
+	Rollout    *int    `json:"rollout" binding:"omitempty,min=1,max=100"`
+}
+
+func (App) SetRollout(ctx *gin.Context) {
+	req := rolloutReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		if req.Rollout == nil {
+			panic(errInvalid("required", "rollout", "is required"))
+		}
+		changeRollout(ctx, &req, constants.ROLLOUT_ACTION_SET)
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 暂停后不再有新客户端进入灰度,已经更新的客户端不受影响
+func (App) PauseRollout(ctx *gin.Context) {
+	req := rolloutReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		changeRollout(ctx, &req, constants.ROLLOUT_ACTION_PAUSE)
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (App) ResumeRollout(ctx *gin.Context) {
+	req := rolloutReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		changeRollout(ctx, &req, constants.ROLLOUT_ACTION_RESUME)
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func changeRollout(ctx *gin.Context, req *rolloutReq, action string) {
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+	pack := getPackageByLabel(ctx, *req.AppName, *req.Deployment, *req.Label)
+	checkIfMatch(ctx, pack.RowVersion)
+	from := 100
+	if pack.Rollout != nil {
+		from = *pack.Rollout
+	}
+	paused := pack.RolloutPaused != nil && *pack.RolloutPaused
+	to := from
+	switch action {
+	case constants.ROLLOUT_ACTION_SET:
+		to = *req.Rollout
+	case constants.ROLLOUT_ACTION_PAUSE:
+		if from >= 100 {
+			panic(errConflict(constants.ERR_ROLLOUT_COMPLETE, "Rollout is complete"))
+		}
+		if paused {
+			panic(errConflict(constants.ERR_ROLLOUT_STATE, "Rollout is already paused"))
+		}
+		paused = true
+	case constants.ROLLOUT_ACTION_RESUME:
+		if !paused {
+			panic(errConflict(constants.ERR_ROLLOUT_STATE, "Rollout is not paused"))
+		}
+		paused = false
+	}
+	var changeRamp func(tx *gorm.DB) error
+	if pack.RolloutRampStart != nil {
+		switch action {
+		case constants.ROLLOUT_ACTION_SET:
+			// 手动设置后不再自动调整
+			changeRamp = func(tx *gorm.DB) error {
+				return model.Package{}.UpdateRolloutRamp(tx, *pack.Id, nil, 0, 0, 0)
+			}
+		case constants.ROLLOUT_ACTION_RESUME:
+			changeRamp = func(tx *gorm.DB) error {
+				return resumeRamp(tx, pack, from)
+			}
+		}
+	}
+	version := updateRollout(uid, deployment, pack, to, paused, ifMatch(ctx), changeRamp)
+	setRowEtag(ctx, &version)
+	model.RolloutHistory{}.Add(*pack.Id, uid, action, &from, to)
+	addAuditLog(ctx, uid, "rollout."+action, *req.AppName+"/"+*req.Deployment+"/"+*req.Label, strconv.Itoa(from)+"->"+strconv.Itoa(to))
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"rollout":    to,
+		"paused":     paused,
+		"rowVersion": version,
+	})
+}
+
+func (App) LsRolloutHistory(ctx *gin.Context) {
+	req := packageReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	pack := getPackageByLabel(ctx, req.AppName, req.Deployment, req.Label)
+	setRowEtag(ctx, pack.RowVersion)
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"rowVersion":    pack.RowVersion,
+		"rollout":       pack.Rollout,
+		"rolloutPaused": pack.RolloutPaused,
+		"ramp":          rampInfo(pack),
+		"history":       model.RolloutHistory{}.GetByPackageId(*pack.Id),
+	})
+}
+
+// 与rollout.changed事件在同一事务中写入;expected为If-Match中的版本,返回包的新版本
+func updateRollout(uid int, deployment *model.Deployment, pack *model.Package, rollout int, paused bool, expected *int, changeRamp func(tx *gorm.DB) error) int {
+	version, err := saveRollout(uid, deployment, pack, rollout, paused, expected, changeRamp)
+	if errors.Is(err, model.ErrRowVersion) {
+		panic(errVersionConflict())
+	}
+	if err != nil {
+		panic("RolloutError:" + err.Error())
+	}
+	return version
+}
+
+// changeRamp不为nil时在同一事务中修改自动灰度,版本冲突时一起回滚
+func saveRollout(uid int, deployment *model.Deployment, pack *model.Package, rollout int, paused bool, expected *int, changeRamp func(tx *gorm.DB) error) (int, error) {
+	userDb, _ := db.GetUserDB()
+	var version int
+	err := userDb.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if version, err = model.BumpRowVersion(tx, "package", *pack.Id, expected); err != nil {
+			return err
+		}
+		if err := (model.Package{}).UpdateRollout(tx, *pack.Id, rollout, paused); err != nil {
+			return err
+		}
+		if changeRamp != nil {
+			if err := changeRamp(tx); err != nil {
+				return err
+			}
+		}
+		return events.Enqueue(tx, events.Event{
+			Type:          events.ROLLOUT_CHANGED,
+			Uid:           uid,
+			AppId:         *deployment.AppId,
+			DeploymentId:  *deployment.Id,
+			DeploymentKey: *deployment.Key,
+			PackageId:     *pack.Id,
+			Label:         utils.StringValue(pack.Label),
+			Rollout:       rollout,
+			Paused:        paused,
+		})
+	})
+	return version, err
+}
@@ -0,0 +1,185 @@
+package request
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+type rolloutRampReq struct {
+	AppName    *string `json:"appName" binding:"required"`
+	Deployment *string `json:"deployment" binding:"required"`
+	Label      *string `json:"label" binding:"required"`
+	// 多少分钟内升到100%,最长30天
+	DurationMinutes *int `json:"durationMinutes" binding:"required,min=1,max=43200"`
+	// 分几次调整,为空时线性
+	Steps *int `json:"steps" binding:"omitempty,min=2,max=100"`
+	// 起始百分比,为空时从当前灰度开始
+	From *int `json:"from" binding:"omitempty,min=1,max=99"`
+}
+
+// 设置后由后台任务每分钟调整rollout,到100%后结束
+func (App) SetRolloutRamp(ctx *gin.Context) {
+	req := rolloutRampReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		deployment := getDeploymentByName(uid, *req.AppName, *req.Deployment)
+		pack := getPackageByLabel(ctx, *req.AppName, *req.Deployment, *req.Label)
+		checkIfMatch(ctx, pack.RowVersion)
+		current := 100
+		if pack.Rollout != nil {
+			current = *pack.Rollout
+		}
+		from := current
+		if req.From != nil {
+			from = *req.From
+		}
+		if from >= 100 {
+			panic(errConflict(constants.ERR_ROLLOUT_COMPLETE, "Rollout is complete, pass from to start a new ramp"))
+		}
+		steps := 0
+		if req.Steps != nil {
+			steps = *req.Steps
+		}
+		paused := pack.RolloutPaused != nil && *pack.RolloutPaused
+		version := updateRollout(uid, deployment, pack, from, paused, ifMatch(ctx), func(tx *gorm.DB) error {
+			return model.Package{}.UpdateRolloutRamp(tx, *pack.Id, utils.GetTimeNow(), *req.DurationMinutes, steps, from)
+		})
+		setRowEtag(ctx, &version)
+		model.RolloutHistory{}.Add(*pack.Id, uid, constants.ROLLOUT_ACTION_RAMP, &current, from)
+		addAuditLog(ctx, uid, "rollout."+constants.ROLLOUT_ACTION_RAMP, *req.AppName+"/"+*req.Deployment+"/"+*req.Label,
+			strconv.Itoa(from)+"->100 in "+strconv.Itoa(*req.DurationMinutes)+"m steps="+strconv.Itoa(steps))
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"rollout":    from,
+			"paused":     paused,
+			"rowVersion": version,
+			"ramp":       rampInfo(model.GetOne[model.Package]("id", *pack.Id)),
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 从from开始经过elapsed后的百分比,steps为0时线性
+func rampRollout(from int, minutes int, steps int, elapsed int64) int {
+	total := int64(minutes) * time.Minute.Milliseconds()
+	if elapsed >= total {
+		return 100
+	}
+	if elapsed <= 0 {
+		return from
+	}
+	if steps > 0 {
+		k := elapsed * int64(steps) / total
+		return from + int(int64(100-from)*k/int64(steps))
+	}
+	return from + int(int64(100-from)*elapsed/total)
+}
+
+// 达到rollout需要的最短时间,用于恢复暂停后从当前百分比继续
+func rampElapsed(from int, minutes int, steps int, rollout int) int64 {
+	total := int64(minutes) * time.Minute.Milliseconds()
+	if rollout <= from {
+		return 0
+	}
+	if steps > 0 {
+		k := 0
+		for k < steps && from+(100-from)*(k+1)/steps <= rollout {
+			k++
+		}
+		return (int64(k)*total + int64(steps) - 1) / int64(steps)
+	}
+	return (int64(rollout-from)*total + int64(100-from) - 1) / int64(100-from)
+}
+
+// 暂停期间不计时
+func resumeRamp(tx *gorm.DB, pack *model.Package, rollout int) error {
+	minutes, steps, from := *pack.RolloutRampMinutes, utils.IntValue(pack.RolloutRampSteps), *pack.RolloutRampFrom
+	start := *utils.GetTimeNow() - rampElapsed(from, minutes, steps, rollout)
+	return model.Package{}.UpdateRolloutRamp(tx, *pack.Id, &start, minutes, steps, from)
+}
+
+func rampInfo(pack *model.Package) gin.H {
+	if pack == nil || pack.RolloutRampStart == nil {
+		return nil
+	}
+	return gin.H{
+		"startTime":       *pack.RolloutRampStart,
+		"endTime":         *pack.RolloutRampStart + int64(*pack.RolloutRampMinutes)*time.Minute.Milliseconds(),
+		"durationMinutes": *pack.RolloutRampMinutes,
+		"steps":           utils.IntValue(pack.RolloutRampSteps),
+		"from":            *pack.RolloutRampFrom,
+	}
+}
+
+// 每分钟由一个实例调整自动灰度的百分比
+func StartRolloutRamp() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			minute := time.Now().UTC().Truncate(time.Minute)
+			if redis.SetNX(constants.REDIS_ROLLOUT_RAMP+"lock:"+strconv.FormatInt(minute.Unix(), 10), 2*time.Minute) {
+				advanceRamps()
+			}
+		}
+	}()
+}
+
+func advanceRamps() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("rollout ramp: error:%v", r)
+			sentry.CapturePanic("rollout_ramp", r, nil)
+		}
+	}()
+	packs := model.Package{}.GetRolloutRamping(1000)
+	if packs == nil {
+		return
+	}
+	now := *utils.GetTimeNow()
+	for i := range *packs {
+		pack := &(*packs)[i]
+		from := 100
+		if pack.Rollout != nil {
+			from = *pack.Rollout
+		}
+		to := rampRollout(*pack.RolloutRampFrom, *pack.RolloutRampMinutes, utils.IntValue(pack.RolloutRampSteps), now-*pack.RolloutRampStart)
+		if to <= from {
+			if to >= 100 {
+				model.Package{}.UpdateRolloutRamp(nil, *pack.Id, nil, 0, 0, 0)
+			}
+			continue
+		}
+		deployment := model.GetOne[model.Deployment]("id", *pack.DeploymentId)
+		if deployment == nil {
+			continue
+		}
+		var changeRamp func(tx *gorm.DB) error
+		if to >= 100 {
+			changeRamp = func(tx *gorm.DB) error {
+				return model.Package{}.UpdateRolloutRamp(tx, *pack.Id, nil, 0, 0, 0)
+			}
+		}
+		// 按读取时的版本写入,期间被暂停或手动修改时跳过,下一分钟重新读取
+		_, err := saveRollout(0, deployment, pack, to, false, pack.RowVersion, changeRamp)
+		if errors.Is(err, model.ErrRowVersion) {
+			continue
+		}
+		if err != nil {
+			log.Printf("rollout ramp: package %d error:%s", *pack.Id, err.Error())
+			continue
+		}
+		model.RolloutHistory{}.Add(*pack.Id, 0, constants.ROLLOUT_ACTION_AUTO, &from, to)
+	}
+}
@@ -0,0 +1,77 @@
+package request
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"com.lc.go.codepush/server/db"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// If-Match: "3"、W/"3"或3;没有该请求头或为*时返回nil,不检查
+func ifMatch(ctx *gin.Context) *int {
+	value := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if value == "" || value == "*" {
+		return nil
+	}
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		// 无法匹配任何版本
+		version = -1
+	}
+	return &version
+}
+
+func rowEtag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+func setRowEtag(ctx *gin.Context, version *int) {
+	if version != nil {
+		ctx.Header("ETag", rowEtag(*version))
+	}
+}
+
+func errVersionConflict() constants.ErrObj {
+	return errConflict(constants.ERR_VERSION_CONFLICT, "Modified by someone else, reload and retry")
+}
+
+// 在修改前快速失败,真正的检查在bumpRowVersion中
+func checkIfMatch(ctx *gin.Context, current *int) {
+	if expected := ifMatch(ctx); expected != nil && (current == nil || *current != *expected) {
+		panic(errVersionConflict())
+	}
+}
+
+// 修改app、deployment或release前调用,If-Match和当前版本不一致时返回409;新版本写入ETag响应头
+func bumpRowVersion(ctx *gin.Context, tx *gorm.DB, table string, id int) int {
+	version, err := model.BumpRowVersion(tx, table, id, ifMatch(ctx))
+	if errors.Is(err, model.ErrRowVersion) {
+		panic(errVersionConflict())
+	}
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	ctx.Header("ETag", rowEtag(version))
+	return version
+}
+
+// 版本号和修改在同一个事务中,修改失败时版本号不变;返回新版本
+func updateWithRowVersion(ctx *gin.Context, table string, id int, update func(tx *gorm.DB) error) int {
+	var version int
+	userDb, _ := db.GetUserDB()
+	err := userDb.Transaction(func(tx *gorm.DB) error {
+		version = bumpRowVersion(ctx, tx, table, id)
+		return update(tx)
+	})
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	return version
+}
@@ -0,0 +1,53 @@
+package request
+
+import (
+	"net/http"
+	"strings"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin"
+)
+
+type searchReq struct {
+	Q string `form:"q" binding:"required,min=2,max=100"`
+	// 逗号分隔的app,deployment,release,默认全部
+	Types string `form:"types"`
+	// 每种结果的数量,默认20
+	Limit int `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// 按名称搜索应用和部署,按标签和更新说明搜索发布,只返回当前用户的应用
+func (App) Search(ctx *gin.Context) {
+	req := searchReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	if req.Limit == 0 {
+		req.Limit = 20
+	}
+	types := map[string]bool{}
+	for _, t := range strings.Split(req.Types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			if t != "app" && t != "deployment" && t != "release" {
+				panic(errInvalid("oneof", "types", "must be app, deployment or release"))
+			}
+			types[t] = true
+		}
+	}
+	all := len(types) == 0
+	q := strings.TrimSpace(req.Q)
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	res := gin.H{"success": true}
+	if all || types["app"] {
+		res["apps"] = model.SearchApp{}.Find(uid, q, req.Limit)
+	}
+	if all || types["deployment"] {
+		res["deployments"] = model.SearchDeployment{}.Find(uid, q, req.Limit)
+	}
+	if all || types["release"] {
+		res["releases"] = model.SearchRelease{}.Find(uid, q, req.Limit, config.GetConfig().SearchFulltext)
+	}
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,189 @@
+package request
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type servedReleaseReq struct {
+	AppName    string `form:"appName" binding:"required"`
+	Deployment string `form:"deployment" binding:"required"`
+	AppVersion string `form:"appVersion" binding:"required"`
+	BundleName string `form:"bundleName"`
+	// 毫秒时间戳或RFC 3339时间
+	Time string `form:"time" binding:"required"`
+	// 带上时按灰度分桶判断该设备拿到的包
+	ClientUniqueId string `form:"clientUniqueId"`
+}
+
+type servedPackage struct {
+	// 开启uuidv7时为字符串id,已删除的包为null
+	PackageId   any    `json:"packageId"`
+	Label       string `json:"label"`
+	PackageHash string `json:"packageHash"`
+	ReleaseTime int64  `json:"releaseTime"`
+	IsMandatory bool   `json:"isMandatory"`
+	// 之后被删除,信息来自回收站
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// 某个时间点下发给appVersion的包,用于对照旧的崩溃报告;
+// 当前包来自release_history,灰度来自rollout_history,固定规则和邀请码没有历史,不在结果中
+func (App) GetServedRelease(ctx *gin.Context) {
+	req := servedReleaseReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	at, ok := parseTimeParam(req.Time)
+	if !ok {
+		panic(errInvalid("datetime", "time", "must be a ms timestamp or an RFC 3339 time"))
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	deployment := getDeploymentByName(uid, req.AppName, req.Deployment)
+	res := gin.H{
+		"success":    true,
+		"time":       at,
+		"appVersion": req.AppVersion,
+		"bundleName": req.BundleName,
+		"release":    nil,
+	}
+	version := model.DeploymentVersion{}.GetByKeyDeploymentIdAndVersion(*deployment.Id, req.BundleName, req.AppVersion)
+	if version == nil || (version.CreateTime != nil && *version.CreateTime > at) {
+		res["source"] = "none"
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+	var packId *int
+	if history := (model.ReleaseHistory{}).GetAt(*version.Id, at); history != nil {
+		packId = history.PackageId
+		res["source"] = "history"
+		res["since"] = history.CreateTime
+		res["action"] = history.Action
+	} else {
+		// 开始记录之前,取当时已发布的最后一个包;审批和回滚的时间无法还原
+		if pack := (model.Package{}).GetReleasedBefore(*version.Id, at); pack != nil {
+			packId = pack.Id
+			res["since"] = pack.CreateTime
+		}
+		res["source"] = "inferred"
+	}
+	if packId == nil {
+		// 安装包自带的bundle
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+	pack := model.GetOne[model.Package]("id", *packId)
+	release := servedPackageById(*packId, pack)
+	res["release"] = release
+	var rollout *int
+	paused := false
+	if pack != nil {
+		rollout, paused = rolloutAt(pack, at)
+	}
+	res["rollout"] = rollout
+	res["rolloutPaused"] = paused
+	var fallback *servedPackage
+	if rollout != nil || paused {
+		if previous := (model.Package{}).GetRollbackPack(*deployment.Id, *packId, *version.Id); previous != nil {
+			fallback = servedPackageById(*previous.Id, previous)
+			res["fallback"] = fallback
+		}
+	}
+	if req.ClientUniqueId != "" {
+		bucket := &updateInfoRedisInfo{Rollout: rollout, RolloutPaused: paused}
+		bucket.Label = release.Label
+		in := inRollout(bucket, req.ClientUniqueId)
+		res["inRollout"] = in
+		if in {
+			res["served"] = release
+		} else {
+			res["served"] = fallback
+		}
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+func parseTimeParam(value string) (int64, bool) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ms, true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, false
+	}
+	return t.UnixMilli(), true
+}
+
+// pack为nil时从回收站查找
+func servedPackageById(id int, pack *model.Package) *servedPackage {
+	served := &servedPackage{PackageId: id, Label: strconv.Itoa(id)}
+	if model.PublicIds() {
+		served.PackageId = nil
+	}
+	if pack != nil {
+		served.PackageId = pack.ExternalId()
+		served.PackageHash = utils.StringValue(pack.Hash)
+		served.IsMandatory = pack.IsMandatory != nil && *pack.IsMandatory
+		if pack.Label != nil {
+			served.Label = *pack.Label
+		}
+		if pack.CreateTime != nil {
+			served.ReleaseTime = *pack.CreateTime
+		}
+		return served
+	}
+	served.Deleted = true
+	if tombstone := model.GetOne[model.PackageTombstone]("package_id", id); tombstone != nil {
+		served.PackageHash = utils.StringValue(tombstone.Hash)
+		if tombstone.Label != nil {
+			served.Label = *tombstone.Label
+		}
+		if tombstone.ReleaseTime != nil {
+			served.ReleaseTime = *tombstone.ReleaseTime
+		}
+	}
+	return served
+}
+
+// 按rollout_history还原time时的灰度百分比(nil表示全量)和暂停状态
+func rolloutAt(pack *model.Package, at int64) (*int, bool) {
+	current := pack.Rollout
+	paused := false
+	history := model.RolloutHistory{}.GetByPackageId(*pack.Id)
+	if history != nil && len(*history) > 0 {
+		var after *model.RolloutHistory
+		var last *model.RolloutHistory
+		for i := range *history {
+			h := &(*history)[i]
+			if *h.CreateTime > at {
+				after = h
+				break
+			}
+			last = h
+			switch *h.Action {
+			case constants.ROLLOUT_ACTION_PAUSE:
+				paused = true
+			case constants.ROLLOUT_ACTION_RESUME:
+				paused = false
+			}
+		}
+		if last != nil {
+			current = last.ToRollout
+		} else if after != nil {
+			// 第一次变更之前为发布时的灰度
+			current = after.FromRollout
+		}
+	} else {
+		paused = pack.RolloutPaused != nil && *pack.RolloutPaused
+	}
+	if current != nil && *current >= 100 {
+		current = nil
+	}
+	return current, paused
+}
@@ -0,0 +1,152 @@
+package request
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"reflect"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/utils"
+)
+
+// 新的解析流程: 按顺序执行,第一个返回true的步骤决定结果
+type resolveStep func(req *updateCheckReq, info *updateInfoRedisInfo) (updateInfo, bool)
+
+var resolveSteps = []resolveStep{
+	resolveForceBinary,
+	resolveInvite,
+	resolveClientRule,
+	resolveBuildPin,
+	resolveRelease,
+	resolveBinaryUpdate,
+}
+
+func resolveUpdateV2(req *updateCheckReq, info *updateInfoRedisInfo) updateInfo {
+	for _, step := range resolveSteps {
+		if result, ok := step(req, info); ok {
+			return result
+		}
+	}
+	return updateInfo{}
+}
+
+func resolveForceBinary(req *updateCheckReq, info *updateInfoRedisInfo) (updateInfo, bool) {
+	if info.ForceBinary == nil {
+		return updateInfo{}, false
+	}
+	result := *info.ForceBinary
+	if result.TargetBinaryRange == "" {
+		result.TargetBinaryRange = req.AppVersion
+	}
+	return result, true
+}
+
+func resolveInvite(req *updateCheckReq, info *updateInfoRedisInfo) (updateInfo, bool) {
+	invite := matchInvite(info.Invites, req.InviteToken)
+	if invite == nil {
+		return updateInfo{}, false
+	}
+	return offer(invite, req.PackageHash), true
+}
+
+func resolveClientRule(req *updateCheckReq, info *updateInfoRedisInfo) (updateInfo, bool) {
+	rule := matchClientRule(info.ClientRules, req.ClientUniqueId)
+	if rule == nil {
+		return updateInfo{}, false
+	}
+	return offer(rule.Pin, req.PackageHash), true
+}
+
+func resolveBuildPin(req *updateCheckReq, info *updateInfoRedisInfo) (updateInfo, bool) {
+	pin, ok := info.BuildPins[req.BuildNumber]
+	if !ok || req.BuildNumber == "" {
+		return updateInfo{}, false
+	}
+	return offer(pin, req.PackageHash), true
+}
+
+// 客户端在当前版本且还没有当前包时,灰度内下发当前包,灰度外下发上一个全量包
+func resolveRelease(req *updateCheckReq, info *updateInfoRedisInfo) (updateInfo, bool) {
+	if info.PackageHash == "" || info.PackageHash == req.PackageHash || req.AppVersion != info.TargetBinaryRange {
+		return updateInfo{}, false
+	}
+	if !inRollout(info, req.ClientUniqueId) {
+		return offer(info.Fallback, req.PackageHash), true
+	}
+	result := info.updateInfo
+	result.UpdateAppVersion = false
+	result.ShouldRunBinaryVersion = false
+	result.IsDisabled = false
+	result.AppStoreUrl = ""
+	result.PackageFormat = ""
+	caps := capabilities(req)
+	if diff, ok := info.Diffs[req.PackageHash]; ok && caps[constants.CAPABILITY_DIFF] {
+		result.DownloadUrl, result.PackageSize = diff.DownloadUrl, diff.PackageSize
+	} else if info.Zstd != nil && caps[constants.CAPABILITY_ZSTD] {
+		result.DownloadUrl, result.PackageSize = info.Zstd.DownloadUrl, info.Zstd.PackageSize
+		result.PackageFormat = constants.PACKAGE_FORMAT_TAR_ZSTD
+	}
+	return result, true
+}
+
+func resolveBinaryUpdate(req *updateCheckReq, info *updateInfoRedisInfo) (updateInfo, bool) {
+	if info.NewVersion == "" || req.AppVersion == info.NewVersion || utils.FormatVersionStr(req.AppVersion) >= utils.FormatVersionStr(info.NewVersion) {
+		return updateInfo{}, false
+	}
+	return updateInfo{TargetBinaryRange: info.NewVersion, UpdateAppVersion: true}, true
+}
+
+// 客户端已经是该包时不下发
+func offer(pack *updateInfo, packageHash string) updateInfo {
+	if pack == nil || pack.PackageHash == packageHash {
+		return updateInfo{}
+	}
+	return *pack
+}
+
+// 按shadow_check_sample_rate抽样比较新旧流程,不一致时记录完整上下文,始终返回旧结果
+func shadowCheck(req *updateCheckReq, info *updateInfoRedisInfo, legacy *updateInfo) {
+	rate := config.GetConfig().ShadowCheckSampleRate
+	if rate <= 0 || rand.Float64() >= rate {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			metrics.Count("update_check_shadow", 1, map[string]string{"result": "error"})
+			log.Printf("shadow: error:%v request:%s", r, shadowContext(req, info, legacy, nil))
+			sentry.CapturePanic("shadow_check", r, nil)
+		}
+	}()
+	shadow := resolveUpdateV2(req, info)
+	if reflect.DeepEqual(shadow, *legacy) {
+		metrics.Count("update_check_shadow", 1, map[string]string{"result": "match"})
+		return
+	}
+	metrics.Count("update_check_shadow", 1, map[string]string{"result": "mismatch"})
+	log.Printf("shadow: mismatch %s", shadowContext(req, info, legacy, &shadow))
+}
+
+func shadowContext(req *updateCheckReq, info *updateInfoRedisInfo, legacy *updateInfo, shadow *updateInfo) string {
+	data, err := json.Marshal(map[string]any{
+		"deploymentKeyHash": utils.Sha256Hex(req.DeploymentKey)[:16],
+		"appVersion":        req.AppVersion,
+		"packageHash":       req.PackageHash,
+		"clientUniqueId":    req.ClientUniqueId,
+		"bundleName":        req.BundleName,
+		"capabilities":      capabilities(req),
+		"currentLabel":      info.Label,
+		"rollout":           info.Rollout,
+		"rolloutPaused":     info.RolloutPaused,
+		"newVersion":        info.NewVersion,
+		"legacy":            legacy,
+		"shadow":            shadow,
+	})
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
@@ -0,0 +1,193 @@
+package request
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func clientStateKey(deploymentKey string, day time.Time) string {
+	return constants.REDIS_CLIENT_STATE + deploymentKey + ":" + day.UTC().Format("20060102")
+}
+
+// 按天记录客户端当前运行的appVersion/bundleName/label,每个组合一个HyperLogLog
+func recordClientState(req *updateCheckReq) {
+	c := config.GetConfig().Stale
+	if !c.Enabled || req.DeploymentKey == "" || req.ClientUniqueId == "" {
+		return
+	}
+	key := clientStateKey(req.DeploymentKey, time.Now())
+	combo := req.AppVersion + "|" + req.BundleName + "|" + req.Label
+	ttl := time.Duration(c.Days+1) * 24 * time.Hour
+	redis.IncrHash(key, combo, ttl)
+	redis.AddUnique(key+":"+combo, req.ClientUniqueId, ttl)
+}
+
+type staleClientsReq struct {
+	AppName    string `form:"appName" binding:"required"`
+	Deployment string `form:"deployment" binding:"required"`
+	// 落后当前发布至少几个版本算过期,默认3
+	OlderThan int `form:"olderThan" binding:"omitempty,min=1,max=1000"`
+	// 低于该原生版本算过期
+	MinAppVersion string `form:"minAppVersion"`
+	// 统计最近几天出现过的客户端,默认1
+	Days int `form:"days" binding:"omitempty,min=1,max=30"`
+}
+
+type staleClientRow struct {
+	AppVersion string `json:"appVersion"`
+	BundleName string `json:"bundleName,omitempty"`
+	// 为空表示运行安装包自带的bundle
+	Label   string `json:"label"`
+	Clients int64  `json:"clients"`
+	// 同一appVersion在该label之后已经发布的版本数
+	ReleasesBehind int  `json:"releasesBehind"`
+	StaleLabel     bool `json:"staleLabel"`
+	StaleBinary    bool `json:"staleBinary"`
+}
+
+// 按appVersion和label统计仍在运行旧版本的客户端数,数量为HyperLogLog估算值
+func (App) GetStaleClients(ctx *gin.Context) {
+	req := staleClientsReq{}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		panic(bindError(err))
+	}
+	c := config.GetConfig().Stale
+	if !c.Enabled {
+		panic(errNotFound(constants.ERR_NOT_FOUND, "Stale client tracking is not enabled"))
+	}
+	if req.OlderThan == 0 {
+		req.OlderThan = 3
+	}
+	if req.Days == 0 {
+		req.Days = 1
+	}
+	if req.Days > int(c.Days) {
+		panic(errInvalid("max", "days", "must be at most stale_tracking_days"))
+	}
+	var minVersion int64
+	if req.MinAppVersion != "" {
+		v, ok := versionNum(req.MinAppVersion)
+		if !ok {
+			panic(errInvalid("semver", "minAppVersion", "must be a version like 1.2.0"))
+		}
+		minVersion = v
+	}
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	deployment := getDeploymentByName(uid, req.AppName, req.Deployment)
+	behind := releasesBehind(*deployment.Id)
+
+	// 每个组合在这几天的HyperLogLog
+	combos := map[string][]string{}
+	now := time.Now()
+	for i := 0; i < req.Days; i++ {
+		key := clientStateKey(*deployment.Key, now.AddDate(0, 0, -i))
+		for combo := range redis.GetHashCounts(key) {
+			combos[combo] = append(combos[combo], key+":"+combo)
+		}
+	}
+	rows := []staleClientRow{}
+	var total, staleLabel, staleBinary, stale int64
+	for combo, keys := range combos {
+		parts := strings.SplitN(combo, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		row := staleClientRow{AppVersion: parts[0], BundleName: parts[1], Label: parts[2]}
+		row.Clients = redis.GetUniqueCount(keys...)
+		row.ReleasesBehind = behind(row.AppVersion, row.BundleName, row.Label)
+		row.StaleLabel = row.ReleasesBehind >= req.OlderThan
+		if v, ok := versionNum(row.AppVersion); ok && minVersion > 0 {
+			row.StaleBinary = v < minVersion
+		}
+		total += row.Clients
+		if row.StaleLabel {
+			staleLabel += row.Clients
+		}
+		if row.StaleBinary {
+			staleBinary += row.Clients
+		}
+		if row.StaleLabel || row.StaleBinary {
+			stale += row.Clients
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Clients != rows[j].Clients {
+			return rows[i].Clients > rows[j].Clients
+		}
+		return rows[i].AppVersion+"|"+rows[i].Label < rows[j].AppVersion+"|"+rows[j].Label
+	})
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"days":    req.Days,
+		"summary": gin.H{
+			"clients":     total,
+			"staleLabel":  staleLabel,
+			"staleBinary": staleBinary,
+			"stale":       stale,
+		},
+		"versions": rows,
+	})
+}
+
+// 客户端上报的appVersion可能不是数字版本,这时不比较
+func versionNum(v string) (int64, bool) {
+	for _, part := range strings.Split(v, ".") {
+		if _, err := strconv.ParseInt(part, 10, 64); err != nil {
+			return 0, false
+		}
+	}
+	return utils.FormatVersionStr(v), true
+}
+
+// 返回(appVersion, bundleName, label)落后当前发布的版本数;回滚后当前包之后的版本不算
+func releasesBehind(deploymentId int) func(appVersion string, bundleName string, label string) int {
+	versions := map[string]*model.DeploymentVersion{}
+	if list := model.GetList[model.DeploymentVersion]("deployment_id", deploymentId); list != nil {
+		for i := range *list {
+			v := &(*list)[i]
+			versions[utils.StringValue(v.AppVersion)+"|"+utils.StringValue(v.BundleName)] = v
+		}
+	}
+	// deploymentVersionId -> 已发布的包,按id排序
+	released := map[int][]model.Package{}
+	if packs := model.GetList[model.Package]("deployment_id", deploymentId); packs != nil {
+		for _, pack := range *packs {
+			if pack.Status != nil && *pack.Status != constants.PACKAGE_STATUS_APPROVED {
+				continue
+			}
+			released[*pack.DeploymentVersionId] = append(released[*pack.DeploymentVersionId], pack)
+		}
+	}
+	for _, packs := range released {
+		sort.Slice(packs, func(i, j int) bool { return *packs[i].Id < *packs[j].Id })
+	}
+	return func(appVersion string, bundleName string, label string) int {
+		v := versions[appVersion+"|"+bundleName]
+		if v == nil || v.CurrentPackage == nil {
+			return 0
+		}
+		count := 0
+		for i := len(released[*v.Id]) - 1; i >= 0; i-- {
+			pack := released[*v.Id][i]
+			if *pack.Id > *v.CurrentPackage {
+				continue
+			}
+			if utils.StringValue(pack.Label) == label {
+				break
+			}
+			count++
+		}
+		return count
+	}
+}
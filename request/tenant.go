@@ -0,0 +1,259 @@
+package request
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 套餐限制,为空或0表示不限制
+type tenantLimitsReq struct {
+	Plan             *string `json:"plan" binding:"omitempty,max=100"`
+	ExternalId       *string `json:"externalId" binding:"omitempty,max=200"`
+	MaxApps          *int    `json:"maxApps" binding:"omitempty,min=0"`
+	MaxDeployments   *int    `json:"maxDeployments" binding:"omitempty,min=0"`
+	MaxStorageGB     *int    `json:"maxStorageGB" binding:"omitempty,min=0"`
+	MaxMonthlyActive *int    `json:"maxMonthlyActive" binding:"omitempty,min=0"`
+	// 账号必须开启两步验证,不设置时按全局的totp_required
+	TotpRequired *bool `json:"totpRequired"`
+}
+
+type provisionTenantReq struct {
+	UserName *string `json:"userName" binding:"required,max=200"`
+	Role     *string `json:"role" binding:"omitempty,oneof=admin developer viewer"`
+	// access key有效天数
+	TokenDays *int `json:"tokenDays" binding:"omitempty,min=1,max=3650"`
+	tenantLimitsReq
+}
+
+type setTenantPlanReq struct {
+	UserName *string `json:"userName" binding:"required"`
+	tenantLimitsReq
+}
+
+// 门户开通租户:创建账号、access key和套餐,密码和access key只在这里返回一次
+func (Admin) ProvisionTenant(ctx *gin.Context) {
+	req := provisionTenantReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		if model.GetOne[model.User]("user_name", *req.UserName) != nil {
+			panic(errConflict(constants.ERR_USER_EXISTS, "User "+*req.UserName+" exist"))
+		}
+		role := constants.ROLE_DEVELOPER
+		if req.Role != nil {
+			role = *req.Role
+		}
+		tokenDays := 365
+		if req.TokenDays != nil {
+			tokenDays = *req.TokenDays
+		}
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			log.Panic(err.Error())
+		}
+		password := hex.EncodeToString(b)
+		// 客户端登录时提交md5(password)
+		sum := md5.Sum([]byte(password))
+		passwordHash := hex.EncodeToString(sum[:])
+		accessKey := uuid.NewString()
+		expireTime := *utils.GetTimeNow() + int64(tokenDays)*24*time.Hour.Milliseconds()
+		tenant := config.GetConfig().TenantName
+		user := model.User{UserName: req.UserName, Password: &passwordHash, Role: &role}
+		plan := req.tenantLimitsReq.toPlan()
+		plan.Tenant = &tenant
+		plan.CreateTime = utils.GetTimeNow()
+		userDb, _ := db.GetUserDB()
+		err := userDb.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			del := false
+			if err := tx.Create(&model.Token{Uid: user.Id, Token: &accessKey, ExpireTime: &expireTime, Del: &del}).Error; err != nil {
+				return err
+			}
+			plan.Uid = user.Id
+			return tx.Create(plan).Error
+		})
+		if err != nil {
+			log.Panic("ProvisionError:" + err.Error())
+		}
+		addAuditLog(ctx, uid, "tenant.provision", *req.UserName, utils.StringValue(plan.Plan))
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data": gin.H{
+				"userName":   *req.UserName,
+				"role":       role,
+				"password":   password,
+				"accessKey":  accessKey,
+				"expireTime": expireTime,
+				"plan":       plan,
+			},
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+// 套餐和当前用量
+func (Admin) LsTenant(ctx *gin.Context) {
+	plans := model.TenantPlan{}.GetByTenant(config.GetConfig().TenantName)
+	data := []gin.H{}
+	if plans != nil {
+		for i := range *plans {
+			plan := &(*plans)[i]
+			userName := ""
+			if user := model.GetOne[model.User]("id", *plan.Uid); user != nil {
+				userName = utils.StringValue(user.UserName)
+			}
+			data = append(data, gin.H{
+				"userName": userName,
+				"plan":     plan,
+				"usage":    tenantUsage(*plan.Uid),
+			})
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// 只修改请求中带的限制
+func (Admin) SetTenantPlan(ctx *gin.Context) {
+	req := setTenantPlanReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		user := model.GetOne[model.User]("user_name", *req.UserName)
+		if user == nil {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "User "+*req.UserName+" not found"))
+		}
+		plan := model.TenantPlan{}.GetByUid(*user.Id)
+		if plan == nil || *plan.Tenant != config.GetConfig().TenantName {
+			panic(errNotFound(constants.ERR_NOT_FOUND, "User "+*req.UserName+" has no plan"))
+		}
+		req.tenantLimitsReq.applyTo(plan)
+		plan.UpdateTime = utils.GetTimeNow()
+		if err := (model.TenantPlan{}).UpdateLimits(plan); err != nil {
+			log.Panic(err.Error())
+		}
+		// update_check缓存中带有每月活跃客户端的限制
+		for _, key := range (model.TenantPlan{}).DeploymentKeys(*user.Id) {
+			redis.DelRedisObj(constants.REDIS_UPDATE_INFO + key + "*")
+		}
+		addAuditLog(ctx, uid, "tenant.plan", *req.UserName, utils.StringValue(plan.Plan))
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"plan":    plan,
+			"usage":   tenantUsage(*user.Id),
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (req tenantLimitsReq) toPlan() *model.TenantPlan {
+	plan := &model.TenantPlan{}
+	req.applyTo(plan)
+	return plan
+}
+
+func (req tenantLimitsReq) applyTo(plan *model.TenantPlan) {
+	if req.Plan != nil {
+		plan.Plan = req.Plan
+	}
+	if req.ExternalId != nil {
+		plan.ExternalId = req.ExternalId
+	}
+	if req.MaxApps != nil {
+		plan.MaxApps = req.MaxApps
+	}
+	if req.MaxDeployments != nil {
+		plan.MaxDeployments = req.MaxDeployments
+	}
+	if req.MaxStorageGB != nil {
+		plan.MaxStorageGB = req.MaxStorageGB
+	}
+	if req.MaxMonthlyActive != nil {
+		plan.MaxMonthlyActive = req.MaxMonthlyActive
+	}
+	if req.TotpRequired != nil {
+		plan.TotpRequired = req.TotpRequired
+	}
+}
+
+func tenantUsage(uid int) gin.H {
+	return gin.H{
+		"apps":          model.TenantPlan{}.CountApps(uid),
+		"deployments":   model.TenantPlan{}.CountDeployments(uid),
+		"storageBytes":  model.TenantPlan{}.StorageBytes(uid),
+		"monthlyActive": redis.GetUniqueCount(monthlyActiveKey(uid, time.Now())),
+	}
+}
+
+func errQuota(msg string) constants.ErrObj {
+	return constants.ErrObj{Status: http.StatusForbidden, Code: constants.ERR_QUOTA_EXCEEDED, Msg: msg}
+}
+
+func checkAppQuota(uid int) {
+	plan := model.TenantPlan{}.GetByUid(uid)
+	if plan == nil || utils.IntValue(plan.MaxApps) == 0 {
+		return
+	}
+	if (model.TenantPlan{}).CountApps(uid) >= int64(*plan.MaxApps) {
+		panic(errQuota("Plan allows at most " + strconv.Itoa(*plan.MaxApps) + " apps"))
+	}
+}
+
+func checkDeploymentQuota(uid int) {
+	plan := model.TenantPlan{}.GetByUid(uid)
+	if plan == nil || utils.IntValue(plan.MaxDeployments) == 0 {
+		return
+	}
+	if (model.TenantPlan{}).CountDeployments(uid) >= int64(*plan.MaxDeployments) {
+		panic(errQuota("Plan allows at most " + strconv.Itoa(*plan.MaxDeployments) + " deployments"))
+	}
+}
+
+// size为本次发布的包大小
+func checkStorageQuota(uid int, size int64) {
+	plan := model.TenantPlan{}.GetByUid(uid)
+	if plan == nil || utils.IntValue(plan.MaxStorageGB) == 0 {
+		return
+	}
+	if (model.TenantPlan{}).StorageBytes(uid)+size > int64(*plan.MaxStorageGB)<<30 {
+		panic(errQuota("Plan allows at most " + strconv.Itoa(*plan.MaxStorageGB) + "GB of packages"))
+	}
+}
+
+func monthlyActiveKey(uid int, t time.Time) string {
+	return constants.REDIS_QUOTA + "active:" + strconv.Itoa(uid) + ":" + t.UTC().Format("200601")
+}
+
+// 本月不同客户端数超过套餐时不再下发更新,客户端继续使用当前版本
+func overMonthlyActive(req *updateCheckReq, info *updateInfoRedisInfo) bool {
+	if info.MonthlyActiveLimit == 0 || req.ClientUniqueId == "" {
+		return false
+	}
+	count := redis.CountUnique(monthlyActiveKey(info.OwnerUid, time.Now()), req.ClientUniqueId, 35*24*time.Hour)
+	if count <= int64(info.MonthlyActiveLimit) {
+		return false
+	}
+	metrics.Count("quota.monthly_active_exceeded", 1, map[string]string{"app": metrics.AppLabel(info.AppName)})
+	return true
+}
@@ -1,9 +1,10 @@
 package request
 
 import (
-	"log"
 	"net/http"
+	"time"
 
+	"com.lc.go.codepush/server/auth"
 	"com.lc.go.codepush/server/config"
 	"com.lc.go.codepush/server/model"
 	"com.lc.go.codepush/server/model/constants"
@@ -18,27 +19,31 @@ type User struct{}
 type loginUser struct {
 	UserName *string `json:"userName" binding:"required"`
 	Password *string `json:"password" binding:"required"`
+	Otp      *string `json:"otp"`
 }
 
 func (User) Login(ctx *gin.Context) {
 	loginUser := loginUser{}
 	if err := ctx.ShouldBindBodyWith(&loginUser, binding.JSON); err == nil {
-		user := model.GetOne[model.User]("user_name", &loginUser.UserName)
-		if user == nil || *user.Password != *loginUser.Password {
+		principal, err := auth.Login(ctx.Request.Context(), *loginUser.UserName, *loginUser.Password)
+		if err != nil {
 			panic("UserName or Psssword error")
 		}
+		if principal.Provider == "db" {
+			checkLoginTotp(principal.Uid, loginUser.Otp)
+		}
 		uuid, _ := uuid.NewUUID()
 		timeNow := utils.GetTimeNow()
 		expireTime := *timeNow + (config.GetConfig().TokenExpireTime * 24 * 60 * 60 * 1000)
 		token := uuid.String()
 		del := false
 		tokenInfo := model.Token{
-			Uid:        user.Id,
+			Uid:        &principal.Uid,
 			Token:      &token,
 			ExpireTime: &expireTime,
 			Del:        &del,
 		}
-		err := model.Create[model.Token](&tokenInfo)
+		err = model.Create[model.Token](&tokenInfo)
 		if err != nil {
 			panic("create token error")
 		}
@@ -46,7 +51,7 @@ func (User) Login(ctx *gin.Context) {
 			"token": token,
 		})
 	} else {
-		log.Panic(err.Error())
+		panic(bindError(err))
 	}
 }
 
@@ -66,6 +71,150 @@ func (User) ChangePassword(ctx *gin.Context) {
 			"success": true,
 		})
 	} else {
-		log.Panic(err.Error())
+		panic(bindError(err))
+	}
+}
+
+// 开启了两步验证的本地账号登录时需要提供动态码或恢复码
+func checkLoginTotp(uid int, otp *string) {
+	user := model.GetOne[model.User]("id=?", uid)
+	if user == nil || user.TotpEnabled == nil || !*user.TotpEnabled {
+		return
+	}
+	if otp == nil || *otp == "" {
+		panic("Two-factor code required")
+	}
+	if validateTotp(user, *otp) {
+		return
+	}
+	if user.TotpRecoveryCodes != nil {
+		left, ok := auth.UseRecoveryCode(*user.TotpRecoveryCodes, *otp)
+		if ok {
+			model.User{}.UpdateTotp(uid, user.TotpSecret, true, &left)
+			return
+		}
+	}
+	panic("Two-factor code error")
+}
+
+// 校验动态码并记录时间步,已经用过的动态码不能再次使用
+func validateTotp(user *model.User, code string) bool {
+	step, ok := auth.ValidateTotp(*user.TotpSecret, code, time.Now())
+	return ok && model.User{}.UseTotpStep(*user.Id, step)
+}
+
+func (User) EnrollTotp(ctx *gin.Context) {
+	uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+	user := model.GetOne[model.User]("id=?", uid)
+	if user == nil || user.Password == nil {
+		panic("Two-factor is only available for local accounts")
+	}
+	if user.TotpEnabled != nil && *user.TotpEnabled {
+		panic("Two-factor already enabled")
+	}
+	secret := auth.GenerateTotpSecret()
+	if err := (model.User{}.UpdateTotp(uid, &secret, false, nil)); err != nil {
+		panic(err.Error())
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"url":    auth.TotpUrl("code-push-server-go "+config.GetConfig().TenantName, *user.UserName, secret),
+	})
+}
+
+type totpCodeReq struct {
+	Code *string `json:"code" binding:"required"`
+}
+
+func (User) ActivateTotp(ctx *gin.Context) {
+	req := totpCodeReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		user := model.GetOne[model.User]("id=?", uid)
+		if user == nil || user.TotpSecret == nil {
+			panic("Two-factor not enrolled")
+		}
+		if !validateTotp(user, *req.Code) {
+			panic("Two-factor code error")
+		}
+		codes, hashes := auth.GenerateRecoveryCodes(10)
+		if err := (model.User{}.UpdateTotp(uid, user.TotpSecret, true, &hashes)); err != nil {
+			panic(err.Error())
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":       true,
+			"recoveryCodes": codes,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+func (User) DisableTotp(ctx *gin.Context) {
+	req := totpCodeReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		uid := ctx.MustGet(constants.GIN_USER_ID).(int)
+		if (model.TenantPlan{}).RequiresTotp(uid, config.GetConfig().TotpRequired) {
+			panic("Two-factor is required")
+		}
+		checkLoginTotp(uid, req.Code)
+		if err := (model.User{}.UpdateTotp(uid, nil, false, nil)); err != nil {
+			panic(err.Error())
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	} else {
+		panic(bindError(err))
+	}
+}
+
+type introspectReq struct {
+	Token *string `json:"token" binding:"required"`
+}
+
+type introspectApp struct {
+	AppName    *string `json:"appName"`
+	OS         *int    `json:"os"`
+	Permission string  `json:"permission"`
+}
+
+// 供内部服务校验委托token,返回token对应的用户、权限范围、过期时间和应用权限;
+// 内部服务需要使用管理员token调用
+func (User) Introspect(ctx *gin.Context) {
+	req := introspectReq{}
+	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		caller := ctx.MustGet(constants.GIN_PRINCIPAL).(*auth.Principal)
+		principal, err := auth.ValidateToken(ctx.Request.Context(), *req.Token)
+		// 非管理员只能查询自己的token,其他token一律按无效返回
+		if err != nil || (caller.Role != constants.ROLE_ADMIN && principal.Uid != caller.Uid) {
+			ctx.JSON(http.StatusOK, gin.H{
+				"active": false,
+			})
+			return
+		}
+		apps := []introspectApp{}
+		appList := model.GetList[model.App]("uid=?", principal.Uid)
+		if appList != nil {
+			for _, v := range *appList {
+				apps = append(apps, introspectApp{
+					AppName:    v.AppName,
+					OS:         v.OS,
+					Permission: "owner",
+				})
+			}
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"active":     true,
+			"uid":        principal.Uid,
+			"userName":   principal.UserName,
+			"role":       principal.Role,
+			"provider":   principal.Provider,
+			"scopes":     principal.Scopes,
+			"expireTime": principal.ExpireTime,
+			"apps":       apps,
+		})
+	} else {
+		panic(bindError(err))
 	}
 }
@@ -0,0 +1,104 @@
+package request
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// 校验错误中的字段名使用json/form标签,和CLI发送的字段一致
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			for _, tag := range []string{"json", "form"} {
+				name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+				if name == "-" {
+					return ""
+				}
+				if name != "" {
+					return name
+				}
+			}
+			return field.Name
+		})
+	}
+}
+
+// 请求参数绑定失败时返回400和字段错误列表
+func bindError(err error) constants.ErrObj {
+	e := constants.ErrObj{Status: http.StatusBadRequest, Code: constants.ERR_VALIDATION, Msg: "Invalid request"}
+	var validationErrors validator.ValidationErrors
+	var typeError *json.UnmarshalTypeError
+	var syntaxError *json.SyntaxError
+	var numError *strconv.NumError
+	switch {
+	case errors.As(err, &validationErrors):
+		for _, fe := range validationErrors {
+			e.Errors = append(e.Errors, constants.FieldError{
+				Code:    fe.Tag(),
+				Field:   fieldPath(fe.Namespace()),
+				Message: fieldMessage(fe),
+			})
+		}
+	case errors.As(err, &typeError):
+		e.Errors = append(e.Errors, constants.FieldError{
+			Code:    "type",
+			Field:   typeError.Field,
+			Message: "must be " + typeError.Type.String() + ", got " + typeError.Value,
+		})
+	case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+		e.Errors = append(e.Errors, constants.FieldError{Code: "json", Message: "malformed JSON: " + err.Error()})
+	case errors.Is(err, io.EOF):
+		e.Errors = append(e.Errors, constants.FieldError{Code: "required", Message: "request body is empty"})
+	case errors.As(err, &numError):
+		e.Errors = append(e.Errors, constants.FieldError{Code: "type", Message: "must be a number, got " + strconv.Quote(numError.Num)})
+	default:
+		e.Errors = append(e.Errors, constants.FieldError{Code: "invalid", Message: err.Error()})
+	}
+	return e
+}
+
+// createBundleReq.releases[0].appName -> releases[0].appName
+func fieldPath(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		if fe.Kind() == reflect.String || fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map {
+			return "length must be at least " + fe.Param()
+		}
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String || fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map {
+			return "length must be at most " + fe.Param()
+		}
+		return "must be at most " + fe.Param()
+	case "oneof":
+		return "must be one of " + fe.Param()
+	case "datetime":
+		return "must match time format " + fe.Param()
+	case "email":
+		return "must be a valid email"
+	case "url":
+		return "must be a valid url"
+	}
+	if fe.Param() != "" {
+		return "failed " + fe.Tag() + "=" + fe.Param()
+	}
+	return "failed " + fe.Tag()
+}
@@ -0,0 +1,100 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/buildinfo"
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type VersionInfo struct {
+	buildinfo.Info
+	// 存储链,第一个为主存储
+	Storage  []string `json:"storage"`
+	Database string   `json:"database"`
+	Auth     []string `json:"auth"`
+	Metrics  []string `json:"metrics"`
+	// 按配置开启的可选功能
+	Features []string `json:"features"`
+}
+
+func GetVersionInfo() VersionInfo {
+	c := config.GetConfig()
+	info := VersionInfo{
+		Info:     buildinfo.Get(),
+		Storage:  storage.Chain(),
+		Database: "mysql",
+		Auth:     c.Auth.Providers,
+		Metrics:  c.Metrics.Sinks,
+		Features: []string{},
+	}
+	if info.Metrics == nil {
+		info.Metrics = []string{}
+	}
+	enabled := []struct {
+		name string
+		on   bool
+	}{
+		{"tls", c.Tls.Addr != ""},
+		{"management_listener", c.ManagementAddr != ""},
+		{"private_mode", c.Private.Enabled},
+		{"replication", storage.ReplicaEnabled()},
+		{"blob_encryption", c.BlobEncryption.KmsKeyId != ""},
+		{"deployment_key_encryption", model.KeyEncryptionEnabled()},
+		{"diff", c.Diff.PackageCount > 0},
+		{"cache_warm", c.Warm.Top > 0},
+		{"report_batching", c.Report.Workers > 0},
+		{"event_bus_stream", c.EventBus.Stream != ""},
+		{"kafka", c.Kafka.RestUrl != ""},
+		{"opa", c.Opa.Url != ""},
+		{"rollup", c.Rollup.Enabled},
+		{"rollup_reconcile", model.MetricEventsEnabled()},
+		{"stale_tracking", c.Stale.Enabled},
+		{"anomaly_alerts", c.Anomaly.WebhookUrl != "" || c.Anomaly.SlackWebhookUrl != ""},
+		{"access_log", c.AccessLog.Output != ""},
+		{"access_export", c.AccessExport.Prefix != ""},
+		{"shadow_check", c.ShadowCheckSampleRate > 0},
+		{"attestation_signing", c.Attestation.SigningKey != ""},
+		{"egress_proxy", c.Egress.Proxy != ""},
+		{"sentry", c.Sentry.Dsn != ""},
+		{"snowflake_ids", c.IdGenerator == "snowflake" || c.IdGenerator == "uuidv7"},
+		{"uuidv7_ids", c.IdGenerator == "uuidv7"},
+	}
+	for _, f := range enabled {
+		if f.on {
+			info.Features = append(info.Features, f.name)
+		}
+	}
+	return info
+}
+
+// 启动时打印的一行,key=value格式便于日志系统解析
+func (v VersionInfo) Banner() string {
+	return fmt.Sprintf("code-push-server-go version=%s git_sha=%s build_date=%s dirty=%t go=%s storage=%s db=%s auth=%s metrics=%s features=%s",
+		v.Version, v.ShortSha(), v.BuildDate, v.Dirty, v.GoVersion, strings.Join(v.Storage, ","), v.Database,
+		strings.Join(v.Auth, ","), strings.Join(v.Metrics, ","), strings.Join(v.Features, ","))
+}
+
+// 不需要登录,不包含密钥和地址
+func (Client) Version(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, GetVersionInfo())
+}
+
+// build_info指标固定为1,版本在标签中;推送型的监控每分钟重新发送
+func StartBuildInfoMetric() {
+	info := buildinfo.Get()
+	tags := map[string]string{"version": info.Version, "git_sha": info.ShortSha(), "go_version": info.GoVersion}
+	go func() {
+		for {
+			metrics.Gauge("build_info", 1, tags)
+			time.Sleep(time.Minute)
+		}
+	}()
+}
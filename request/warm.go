@@ -0,0 +1,79 @@
+package request
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/egress"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/storage"
+)
+
+const trafficTTL = 7 * 24 * time.Hour
+
+// 抽样记录每个部署最常见的appVersion/bundle组合,用于发布后预热
+func recordTraffic(req *updateCheckReq) {
+	c := config.GetConfig().Warm
+	if c.Top == 0 || req.DeploymentKey == "" || rand.Float64() >= c.SampleRate {
+		return
+	}
+	member := req.AppVersion
+	if req.BundleName != "" {
+		member += "|" + req.BundleName
+	}
+	redis.IncrScore(constants.REDIS_TRAFFIC+req.DeploymentKey, member, trafficTTL)
+}
+
+// 发布、回滚或审批通过后在后台重新填充update_check缓存,避免第一批客户端同时回源
+func warmCache(deploymentKey string) {
+	c := config.GetConfig().Warm
+	if c.Top == 0 {
+		return
+	}
+	go func() {
+		members := redis.TopMembers(constants.REDIS_TRAFFIC+deploymentKey, int64(c.Top))
+		warmed := map[string]bool{}
+		for _, member := range members {
+			appVersion, bundleName, _ := strings.Cut(member, "|")
+			url := warmOne(&updateCheckReq{DeploymentKey: deploymentKey, AppVersion: appVersion, BundleName: bundleName})
+			// 私有模式下地址指向本服务,不经过CDN
+			if c.Cdn && !storage.PrivateMode() && url != "" && !warmed[url] {
+				warmed[url] = true
+				warmCdn(url)
+			}
+		}
+		if len(members) > 0 {
+			log.Printf("cache warm: %s %d versions", deploymentKey, len(members))
+		}
+	}()
+}
+
+func warmOne(req *updateCheckReq) (downloadUrl string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("cache warm: %s %s error:%v", req.DeploymentKey, req.AppVersion, r)
+		}
+	}()
+	checkUpdate(req)
+	if info := redis.GetRedisObj[updateInfoRedisInfo](updateInfoRedisKey(req)); info != nil {
+		downloadUrl = info.DownloadUrl
+	}
+	return
+}
+
+var warmClient = egress.Client(0)
+
+func warmCdn(url string) {
+	resp, err := warmClient.Get(url)
+	if err != nil {
+		log.Printf("cache warm: cdn error:%s", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+}
@@ -0,0 +1,191 @@
+package rollup
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/metrics"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+)
+
+// 报告中最多保留的差异条数
+const driftMaxRows = 100
+
+type DriftRow struct {
+	BucketTime   int64  `json:"bucketTime"`
+	DeploymentId int    `json:"deploymentId"`
+	PackageId    int    `json:"packageId"`
+	Metric       string `json:"metric"`
+	// 汇总表中的值和按metric_event重新计算的值
+	Recorded int64 `json:"recorded"`
+	Expected int64 `json:"expected"`
+}
+
+type DriftReport struct {
+	Time int64 `json:"time"`
+	// 核对的小时范围[from,to),开启核对之前的小时不核对
+	From     int64      `json:"from"`
+	To       int64      `json:"to"`
+	Checked  int        `json:"checked"`
+	Drifted  int        `json:"drifted"`
+	Repaired bool       `json:"repaired"`
+	Rows     []DriftRow `json:"rows"`
+}
+
+type driftKey struct {
+	bucketTime int64
+	rowKey
+}
+
+var packageMetrics = []string{constants.METRIC_ACTIVE, constants.METRIC_FAILED, constants.METRIC_INSTALLED}
+
+// 第一条metric_event之后的整点开始,按小时的包指标由metric_event计算
+func eventsStart() (time.Time, bool) {
+	if !model.MetricEventsEnabled() {
+		return time.Time{}, false
+	}
+	first := model.MetricEvent{}.FirstTime()
+	if first == 0 {
+		return time.Time{}, false
+	}
+	start := time.UnixMilli(first).UTC().Truncate(time.Hour)
+	if start.UnixMilli() != first {
+		start = start.Add(time.Hour)
+	}
+	return start, true
+}
+
+func eventsCover(hour time.Time) bool {
+	start, ok := eventsStart()
+	return ok && !hour.Before(start)
+}
+
+func eventRows(from time.Time, to time.Time) []model.MetricRollup {
+	var rows []model.MetricRollup
+	if list := (model.MetricEvent{}).SumByHour(from.UnixMilli(), to.UnixMilli()); list != nil {
+		for _, v := range *list {
+			bucket := time.UnixMilli(v.BucketTime).UTC()
+			for i, value := range []int64{v.Active, v.Failed, v.Installed} {
+				if value > 0 {
+					rows = append(rows, newRow(constants.ROLLUP_HOUR, bucket, v.DeploymentId, v.PackageId, packageMetrics[i], value))
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// 立即核对到当前整点之前的hours个小时,结果同时保存为最近一次报告
+func Reconcile(hours uint, dryRun bool) *DriftReport {
+	return reconcile(time.Now().UTC().Truncate(time.Hour), hours, dryRun)
+}
+
+// 最近一次核对的报告
+func LastDrift() *DriftReport {
+	return redis.GetRedisObj[DriftReport](constants.REDIS_ROLLUP + "drift")
+}
+
+// 用metric_event重新计算[to-hours,to)的小时数据,与汇总表比较;不是dryRun时覆盖有差异的行并重新汇总受影响的天和月
+func reconcile(to time.Time, hours uint, dryRun bool) *DriftReport {
+	from := to.Add(-time.Duration(hours) * time.Hour)
+	report := &DriftReport{Time: time.Now().UnixMilli(), To: to.UnixMilli(), Rows: []DriftRow{}}
+	if start, ok := eventsStart(); !ok {
+		from = to
+	} else if from.Before(start) {
+		from = start
+	}
+	report.From = from.UnixMilli()
+	if from.Before(to) {
+		compare(report, from, to, dryRun)
+	}
+	redis.SetRedisObj(constants.REDIS_ROLLUP+"drift", report, snapshotTTL)
+	metrics.Count("rollup.drift", int64(report.Drifted), nil)
+	log.Printf("rollup: reconcile %s..%s checked %d, drift %d, repaired %t",
+		from.Format(time.RFC3339), to.Format(time.RFC3339), report.Checked, report.Drifted, report.Repaired)
+	return report
+}
+
+func compare(report *DriftReport, from time.Time, to time.Time, dryRun bool) {
+	expected := map[driftKey]int64{}
+	for _, v := range eventRows(from, to) {
+		expected[driftKey{*v.BucketTime, rowKey{*v.DeploymentId, *v.PackageId, *v.Metric}}] = *v.Value
+	}
+	recorded := map[driftKey]int64{}
+	if list := (model.MetricRollup{}).GetRange(constants.ROLLUP_HOUR, from.UnixMilli(), to.UnixMilli()); list != nil {
+		for _, v := range *list {
+			// update_check只在redis中计数,无法核对
+			if *v.PackageId == 0 {
+				continue
+			}
+			recorded[driftKey{*v.BucketTime, rowKey{*v.DeploymentId, *v.PackageId, *v.Metric}}] = *v.Value
+		}
+	}
+	keys := map[driftKey]bool{}
+	for k := range expected {
+		keys[k] = true
+	}
+	for k := range recorded {
+		keys[k] = true
+	}
+	var drifted []DriftRow
+	for k := range keys {
+		report.Checked++
+		if expected[k] == recorded[k] {
+			continue
+		}
+		drifted = append(drifted, DriftRow{k.bucketTime, k.deploymentId, k.packageId, k.metric, recorded[k], expected[k]})
+	}
+	sort.Slice(drifted, func(i, j int) bool {
+		a, b := drifted[i], drifted[j]
+		if a.BucketTime != b.BucketTime {
+			return a.BucketTime < b.BucketTime
+		}
+		if a.PackageId != b.PackageId {
+			return a.PackageId < b.PackageId
+		}
+		return a.Metric < b.Metric
+	})
+	report.Drifted = len(drifted)
+	report.Rows = append(report.Rows, drifted[:min(len(drifted), driftMaxRows)]...)
+	if dryRun || len(drifted) == 0 {
+		return
+	}
+	rows := make([]model.MetricRollup, 0, len(drifted))
+	for _, v := range drifted {
+		rows = append(rows, newRow(constants.ROLLUP_HOUR, time.UnixMilli(v.BucketTime), v.DeploymentId, v.PackageId, v.Metric, v.Expected))
+	}
+	if err := (model.MetricRollup{}).Upsert(rows); err != nil {
+		log.Panic(err.Error())
+	}
+	rerollup(drifted)
+	report.Repaired = true
+}
+
+// 已经结束的天和月重新汇总,当天和当月由每小时的任务汇总
+func rerollup(drifted []DriftRow) {
+	now := time.Now().In(Location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	days := map[time.Time]bool{}
+	months := map[time.Time]bool{}
+	for _, v := range drifted {
+		local := time.UnixMilli(v.BucketTime).In(Location())
+		day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+		month := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, local.Location())
+		if day.Before(today) {
+			days[day] = true
+		}
+		if month.Before(thisMonth) {
+			months[month] = true
+		}
+	}
+	for day := range days {
+		rollupInto(constants.ROLLUP_HOUR, constants.ROLLUP_DAY, day, day.AddDate(0, 0, 1))
+	}
+	for month := range months {
+		rollupInto(constants.ROLLUP_DAY, constants.ROLLUP_MONTH, month, month.AddDate(0, 1, 0))
+	}
+}
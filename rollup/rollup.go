@@ -0,0 +1,222 @@
+package rollup
+
+import (
+	"bytes"
+	"encoding/csv"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/storage"
+	"com.lc.go.codepush/server/utils"
+)
+
+// 包的累计计数,与上一小时的差值即为该小时的指标
+type counters map[int][3]int
+
+const snapshotTTL = 7 * 24 * time.Hour
+
+func Enabled() bool {
+	return config.GetConfig().Rollup.Enabled
+}
+
+var location = sync.OnceValue(func() *time.Location {
+	loc, err := time.LoadLocation(config.GetConfig().Rollup.Timezone)
+	if err != nil {
+		log.Printf("rollup: timezone error:%s, use UTC", err.Error())
+		return time.UTC
+	}
+	return loc
+})
+
+// 报表时区,小时数据按UTC整点,天和月从该时区的零点开始
+func Location() *time.Location {
+	return location()
+}
+
+func checkKey(hour time.Time) string {
+	return constants.REDIS_CHECK_HOURLY + strconv.FormatInt(hour.Unix(), 10)
+}
+
+// 按小时统计每个部署的update_check次数
+func RecordCheck(deploymentKey string) {
+	if !Enabled() || deploymentKey == "" {
+		return
+	}
+	redis.IncrHash(checkKey(time.Now().UTC().Truncate(time.Hour)), deploymentKey, 3*time.Hour)
+}
+
+// 每小时由一个实例执行: 汇总上一小时并核对最近的小时数据,再汇总昨天和上个月,最后清理过期数据
+func Start() {
+	if !Enabled() {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			hour := time.Now().UTC().Truncate(time.Hour)
+			if redis.SetNX(constants.REDIS_ROLLUP+"lock:"+strconv.FormatInt(hour.Unix(), 10), 2*time.Hour) {
+				run(hour)
+			}
+		}
+	}()
+}
+
+func run(hour time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("rollup: %s error:%v", hour.Format(time.RFC3339), r)
+			sentry.CapturePanic("rollup", r, nil)
+		}
+	}()
+	c := config.GetConfig().Rollup
+	rollupHour(hour.Add(-time.Hour))
+	if model.MetricEventsEnabled() {
+		reconcile(hour, c.ReconcileHours, c.ReconcileDryRun)
+	}
+	local := hour.In(Location())
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	month := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, local.Location())
+	rollupInto(constants.ROLLUP_HOUR, constants.ROLLUP_DAY, day.AddDate(0, 0, -1), day)
+	rollupInto(constants.ROLLUP_DAY, constants.ROLLUP_MONTH, month.AddDate(0, -1, 0), month)
+	expire(constants.ROLLUP_HOUR, day.AddDate(0, 0, -int(c.HourlyRetentionDays)))
+	expire(constants.ROLLUP_DAY, day.AddDate(0, 0, -int(c.DailyRetentionDays)))
+	expire(constants.ROLLUP_MONTH, month.AddDate(0, -int(c.MonthlyRetentionMonths), 0))
+	if model.MetricEventsEnabled() {
+		if err := (model.MetricEvent{}).DeleteBefore(day.AddDate(0, 0, -int(c.HourlyRetentionDays)).UnixMilli()); err != nil {
+			log.Panic(err.Error())
+		}
+	}
+}
+
+func newRow(granularity string, bucket time.Time, deploymentId int, packageId int, metric string, value int64) model.MetricRollup {
+	bucketTime := bucket.UnixMilli()
+	return model.MetricRollup{
+		Granularity:  &granularity,
+		BucketTime:   &bucketTime,
+		DeploymentId: &deploymentId,
+		PackageId:    &packageId,
+		Metric:       &metric,
+		Value:        &value,
+	}
+}
+
+func rollupHour(hour time.Time) {
+	var rows []model.MetricRollup
+	for key, count := range redis.GetHashCounts(checkKey(hour)) {
+		deployment := model.Deployment{}.GetByKey(key)
+		if deployment == nil {
+			continue
+		}
+		rows = append(rows, newRow(constants.ROLLUP_HOUR, hour, *deployment.Id, 0, constants.METRIC_UPDATE_CHECK, count))
+	}
+	if eventsCover(hour) {
+		rows = append(rows, eventRows(hour, hour.Add(time.Hour))...)
+		// 快照仍然更新,关闭核对后可以继续按差值计算
+		snapshotRows(hour)
+	} else {
+		rows = append(rows, snapshotRows(hour)...)
+	}
+	if err := (model.MetricRollup{}).Upsert(rows); err != nil {
+		log.Panic(err.Error())
+	}
+}
+
+// 包的计数与上一次快照的差值
+func snapshotRows(hour time.Time) []model.MetricRollup {
+	var rows []model.MetricRollup
+	packs := model.Package{}.GetCounters()
+	if packs != nil {
+		current := counters{}
+		for _, pack := range *packs {
+			current[*pack.Id] = [3]int{utils.IntValue(pack.Active), utils.IntValue(pack.Failed), utils.IntValue(pack.Installed)}
+		}
+		// 没有快照时(第一次运行或redis数据丢失)只记录快照
+		if previous := redis.GetRedisObj[counters](constants.REDIS_ROLLUP + "snapshot"); previous != nil {
+			metrics := []string{constants.METRIC_ACTIVE, constants.METRIC_FAILED, constants.METRIC_INSTALLED}
+			for _, pack := range *packs {
+				// 新发布的包没有快照,差值即为当前计数
+				before := (*previous)[*pack.Id]
+				now := current[*pack.Id]
+				for i, metric := range metrics {
+					if delta := now[i] - before[i]; delta > 0 {
+						rows = append(rows, newRow(constants.ROLLUP_HOUR, hour, *pack.DeploymentId, *pack.Id, metric, int64(delta)))
+					}
+				}
+			}
+		}
+		redis.SetRedisObj(constants.REDIS_ROLLUP+"snapshot", current, snapshotTTL)
+	}
+	return rows
+}
+
+type rowKey struct {
+	deploymentId int
+	packageId    int
+	metric       string
+}
+
+// 把[from,to)之间的细粒度数据合并为一行,重复执行结果相同
+func rollupInto(src string, dst string, from time.Time, to time.Time) {
+	list := model.MetricRollup{}.GetRange(src, from.UnixMilli(), to.UnixMilli())
+	if list == nil || len(*list) == 0 {
+		return
+	}
+	sums := map[rowKey]int64{}
+	for _, v := range *list {
+		sums[rowKey{*v.DeploymentId, *v.PackageId, *v.Metric}] += *v.Value
+	}
+	rows := make([]model.MetricRollup, 0, len(sums))
+	for k, sum := range sums {
+		rows = append(rows, newRow(dst, from, k.deploymentId, k.packageId, k.metric, sum))
+	}
+	if err := (model.MetricRollup{}).Upsert(rows); err != nil {
+		log.Panic(err.Error())
+	}
+}
+
+// 超过保留时间的数据先导出CSV再删除,导出失败时保留数据等下一次执行
+func expire(granularity string, before time.Time) {
+	list := model.MetricRollup{}.GetRange(granularity, 0, before.UnixMilli())
+	if list == nil || len(*list) == 0 {
+		return
+	}
+	if prefix := config.GetConfig().Rollup.ArchivePrefix; prefix != "" {
+		data, err := toCsv(*list)
+		if err != nil {
+			log.Panic(err.Error())
+		}
+		key := prefix + granularity + "/" + before.Format("2006-01-02") + ".csv"
+		if _, err := storage.Upload(key, data); err != nil {
+			log.Printf("rollup: archive %s error:%s", key, err.Error())
+			return
+		}
+	}
+	if err := (model.MetricRollup{}).DeleteBefore(granularity, before.UnixMilli()); err != nil {
+		log.Panic(err.Error())
+	}
+	log.Printf("rollup: expired %d %s rows before %s", len(*list), granularity, before.Format("2006-01-02"))
+}
+
+func toCsv(list []model.MetricRollup) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	w.Write([]string{"granularity", "bucket_time", "deployment_id", "package_id", "metric", "value"})
+	for _, v := range list {
+		w.Write([]string{
+			*v.Granularity,
+			time.UnixMilli(*v.BucketTime).In(Location()).Format(time.RFC3339),
+			strconv.Itoa(*v.DeploymentId),
+			strconv.Itoa(*v.PackageId),
+			*v.Metric,
+			strconv.FormatInt(*v.Value, 10),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
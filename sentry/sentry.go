@@ -0,0 +1,69 @@
+package sentry
+
+import (
+	"fmt"
+	"log"
+
+	"com.lc.go.codepush/server/buildinfo"
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/egress"
+	sentrygo "github.com/getsentry/sentry-go"
+)
+
+var enabled bool
+
+// 配置了sentry_dsn时初始化,错误同时上报Sentry并带上租户和区域标签
+func Init() {
+	c := config.GetConfig()
+	if c.Sentry.Dsn == "" {
+		return
+	}
+	environment := c.Sentry.Environment
+	if environment == "" {
+		environment = c.Environment
+	}
+	err := sentrygo.Init(sentrygo.ClientOptions{
+		Dsn:           c.Sentry.Dsn,
+		Environment:   environment,
+		Release:       buildinfo.Get().Release(),
+		SampleRate:    c.Sentry.SampleRate,
+		HTTPTransport: egress.Transport(),
+	})
+	if err != nil {
+		log.Printf("sentry: init error:%s", err.Error())
+		return
+	}
+	sentrygo.ConfigureScope(func(scope *sentrygo.Scope) {
+		scope.SetTag("tenant", c.TenantName)
+		if c.Region != "" {
+			scope.SetTag("region", c.Region)
+		}
+	})
+	enabled = true
+}
+
+// source区分来源,例如 http, release_job, diff, storage
+func CaptureError(source string, err error, tags map[string]string) {
+	if !enabled || err == nil {
+		return
+	}
+	sentrygo.WithScope(func(scope *sentrygo.Scope) {
+		scope.SetTag("source", source)
+		for k, v := range tags {
+			scope.SetTag(k, v)
+		}
+		sentrygo.CaptureException(err)
+	})
+}
+
+// recover()得到的值
+func CapturePanic(source string, r any, tags map[string]string) {
+	if !enabled || r == nil {
+		return
+	}
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	CaptureError(source, err, tags)
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"container/list"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/utils"
+)
+
+// 按大小淘汰的本地磁盘LRU缓存,减少从S3重复下载同一个包
+type diskCache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	size    int64
+	items   map[string]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+var (
+	cache     *diskCache
+	cacheOnce sync.Once
+)
+
+func getCache() *diskCache {
+	cacheOnce.Do(func() {
+		c := config.GetConfig().CodePush.Cache
+		if c.Path == "" {
+			return
+		}
+		if err := os.MkdirAll(c.Path, 0777); err != nil {
+			log.Printf("storage: cache disabled:%s", err.Error())
+			return
+		}
+		cache = &diskCache{
+			dir:     c.Path,
+			maxSize: int64(c.SizeMB) * 1024 * 1024,
+			items:   make(map[string]*list.Element),
+			lru:     list.New(),
+		}
+		cache.load()
+	})
+	return cache
+}
+
+// 启动时按修改时间恢复已有的缓存文件
+func (c *diskCache) load() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !info.IsDir() {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		c.items[info.Name()] = c.lru.PushBack(&cacheEntry{name: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+	c.evict()
+}
+
+func (c *diskCache) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	name := utils.Sha256Hex(key)
+	c.mu.Lock()
+	elem, ok := c.items[name]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		c.remove(name)
+		return nil, false
+	}
+	return data, true
+}
+
+// 边读边写入缓存文件,超过缓存大小的对象不缓存
+func (c *diskCache) Put(key string, r io.Reader) {
+	if c == nil {
+		return
+	}
+	name := utils.Sha256Hex(key)
+	tmp := filepath.Join(c.dir, name+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Printf("storage: cache put %s error:%s", key, err.Error())
+		return
+	}
+	size, err := io.Copy(f, io.LimitReader(r, c.maxSize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || size > c.maxSize {
+		if err != nil {
+			log.Printf("storage: cache put %s error:%s", key, err.Error())
+		}
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(c.dir, name)); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[name]; ok {
+		c.size -= elem.Value.(*cacheEntry).size
+		c.lru.Remove(elem)
+	}
+	c.items[name] = c.lru.PushFront(&cacheEntry{name: name, size: size})
+	c.size += size
+	c.evict()
+}
+
+func (c *diskCache) Delete(key string) {
+	if c == nil {
+		return
+	}
+	name := utils.Sha256Hex(key)
+	c.remove(name)
+	os.Remove(filepath.Join(c.dir, name))
+}
+
+func (c *diskCache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[name]; ok {
+		c.size -= elem.Value.(*cacheEntry).size
+		c.lru.Remove(elem)
+		delete(c.items, name)
+	}
+}
+
+// 调用方持有锁
+func (c *diskCache) evict() {
+	for c.size > c.maxSize {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		entry := elem.Value.(*cacheEntry)
+		c.lru.Remove(elem)
+		delete(c.items, entry.name)
+		c.size -= entry.size
+		os.Remove(filepath.Join(c.dir, entry.name))
+	}
+}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/egress"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// 加密对象的格式: magic + 加密后数据密钥的长度(2字节) + 加密后的数据密钥 + nonce + AES-GCM密文
+var encryptedMagic = []byte("CPENC1")
+
+// magic和数据密钥长度,读到这里就能算出密文以外的长度
+var encryptedHeaderSize = len(encryptedMagic) + 2
+
+// AES-GCM的nonce和tag长度
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+// 加密对象中明文以外的字节数,没有加密头时为0
+func encryptedOverhead(head []byte) int64 {
+	if len(head) < encryptedHeaderSize || !bytes.HasPrefix(head, encryptedMagic) {
+		return 0
+	}
+	n := int64(binary.BigEndian.Uint16(head[len(encryptedMagic):]))
+	return int64(encryptedHeaderSize) + n + gcmNonceSize + gcmTagSize
+}
+
+// 同一个数据密钥使用一天后重新生成
+const dataKeyTTL = 24 * time.Hour
+
+type dataKey struct {
+	plain     []byte
+	encrypted []byte
+	created   time.Time
+}
+
+var (
+	dataKeyMu  sync.Mutex
+	currentKey *dataKey
+	// 加密后的数据密钥 -> 明文,下载时不必每次调用KMS
+	plainKeys sync.Map
+)
+
+func EncryptionEnabled() bool {
+	return config.GetConfig().BlobEncryption.KmsKeyId != ""
+}
+
+func kmsClient() *kms.KMS {
+	c := config.GetConfig()
+	region := c.BlobEncryption.KmsRegion
+	if region == "" {
+		region = c.CodePush.Aws.Region
+	}
+	kmsConfig := aws.Config{
+		Credentials: credentials.NewStaticCredentials(c.CodePush.Aws.KeyId, c.CodePush.Aws.Secret, ""),
+		Region:      aws.String(region),
+		HTTPClient:  egress.Client(0),
+	}
+	if c.BlobEncryption.KmsEndpoint != "" {
+		kmsConfig.Endpoint = aws.String(c.BlobEncryption.KmsEndpoint)
+	}
+	newSession, err := session.NewSession(&kmsConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	return kms.New(newSession)
+}
+
+// 加密上下文绑定租户,其他租户的密钥无法解密
+func encryptionContext() map[string]*string {
+	return map[string]*string{"tenant": aws.String(config.GetConfig().TenantName)}
+}
+
+func getDataKey() (*dataKey, error) {
+	dataKeyMu.Lock()
+	defer dataKeyMu.Unlock()
+	if currentKey != nil && time.Since(currentKey.created) < dataKeyTTL {
+		return currentKey, nil
+	}
+	out, err := kmsClient().GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:             aws.String(config.GetConfig().BlobEncryption.KmsKeyId),
+		KeySpec:           aws.String(kms.DataKeySpecAes256),
+		EncryptionContext: encryptionContext(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	currentKey = &dataKey{plain: out.Plaintext, encrypted: out.CiphertextBlob, created: time.Now()}
+	plainKeys.Store(string(out.CiphertextBlob), out.Plaintext)
+	return currentKey, nil
+}
+
+func plainKey(encrypted []byte) ([]byte, error) {
+	if v, ok := plainKeys.Load(string(encrypted)); ok {
+		return v.([]byte), nil
+	}
+	out, err := kmsClient().Decrypt(&kms.DecryptInput{
+		CiphertextBlob:    encrypted,
+		EncryptionContext: encryptionContext(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	plainKeys.Store(string(encrypted), out.Plaintext)
+	return out.Plaintext, nil
+}
+
+// 对象key作为附加数据,密文不能换到其他key下使用
+func encryptBlob(key string, data []byte) ([]byte, error) {
+	k, err := getDataKey()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(k.plain)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(encryptedMagic)+2+len(k.encrypted)+len(nonce)+len(data)+gcm.Overhead())
+	out = append(out, encryptedMagic...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(k.encrypted)))
+	out = append(out, k.encrypted...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, []byte(key)), nil
+}
+
+// 没有加密头的对象(开启加密前上传的)原样返回
+func decryptBlob(key string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+	rest := data[len(encryptedMagic):]
+	if len(rest) < 2 {
+		return nil, errors.New("encrypted blob is truncated")
+	}
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < n {
+		return nil, errors.New("encrypted blob is truncated")
+	}
+	plain, err := plainKey(rest[:n])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(plain)
+	if err != nil {
+		return nil, err
+	}
+	rest = rest[n:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("encrypted blob is truncated")
+	}
+	return gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], []byte(key))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+const blobPath = "/v0.1/public/codepush/blob/"
+
+func blobSignature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(config.GetConfig().BlobEncryption.UrlSecret))
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 私有模式下不访问公网,下载都经过本服务
+func PrivateMode() bool {
+	return config.GetConfig().Private.Enabled
+}
+
+// 私有模式使用internal_url,没有时返回带url_prefix的相对地址
+func proxyBase() string {
+	configs := config.GetConfig()
+	if !configs.Private.Enabled {
+		return configs.BlobEncryption.ProxyUrl
+	}
+	if configs.Private.InternalUrl != "" {
+		return configs.Private.InternalUrl
+	}
+	return strings.TrimRight(configs.UrlPrefix, "/")
+}
+
+// 加密后或私有模式下对象不能直接从存储下载,返回本服务的签名下载地址
+func blobProxyUrl(key string) string {
+	c := config.GetConfig().BlobEncryption
+	expires := time.Now().Add(time.Duration(c.UrlTTL) * time.Second).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	query.Set("signature", blobSignature(key, expires))
+	u := url.URL{Path: blobPath + key}
+	return proxyBase() + u.EscapedPath() + "?" + query.Encode()
+}
+
+// bind不为空时是BindBlobUrl生成的地址,ip和clientUniqueId要和生成时相同
+func VerifyBlobUrl(key string, expires string, signature string, bind string, ip string, clientUniqueId string) bool {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	if bind != "" {
+		key += "\n" + bindValues(bind, ip, clientUniqueId)
+	}
+	return hmac.Equal([]byte(blobSignature(key, unix)), []byte(signature))
+}
+
+// 把update_check返回的代理下载地址绑定到请求的ip和/或clientUniqueId,并缩短有效期;
+// 绑定的值只参与签名,不出现在地址中
+func BindBlobUrl(u string, ip string, clientUniqueId string) string {
+	c := config.GetConfig().BlobEncryption
+	if len(c.UrlBinding) == 0 {
+		return u
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	i := strings.Index(parsed.Path, blobPath)
+	if i < 0 {
+		return u
+	}
+	key := parsed.Path[i+len(blobPath):]
+	bind := strings.Join(c.UrlBinding, ",")
+	expires := time.Now().Add(time.Duration(c.UrlBindTTL) * time.Second).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("bind", bind)
+	query.Set("signature", blobSignature(key+"\n"+bindValues(bind, ip, clientUniqueId), expires))
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// bind也参与签名,去掉bind参数后签名不再有效
+func bindValues(bind string, ip string, clientUniqueId string) string {
+	values := bind
+	for _, field := range strings.Split(bind, ",") {
+		switch field {
+		case "ip":
+			values += "\n" + ip
+		case "client":
+			values += "\n" + clientUniqueId
+		}
+	}
+	return values
+}
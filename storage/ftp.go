@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/egress"
+	"github.com/jlaffaye/ftp"
+)
+
+type ftpProvider struct{}
+
+func dialFtp() (*ftp.ServerConn, error) {
+	ftpConfig := config.GetConfig().CodePush.Ftp
+	// 控制和数据连接都经过egress代理
+	f, err := ftp.Dial(ftpConfig.ServerUrl, ftp.DialWithDialFunc(egress.Dial))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Login(ftpConfig.UserName, ftpConfig.Password); err != nil {
+		f.Quit()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (ftpProvider) Put(key string, r io.Reader) error {
+	f, err := dialFtp()
+	if err != nil {
+		return err
+	}
+	defer f.Quit()
+	return f.Stor(key, r)
+}
+
+func (ftpProvider) Get(key string) ([]byte, error) {
+	f, err := dialFtp()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Quit()
+	rep, err := f.Retr(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rep.Close()
+	return io.ReadAll(rep)
+}
+
+func (ftpProvider) DownloadUrl(key string) (string, error) {
+	return config.GetConfig().ResourceUrl + path.Clean("/"+key), nil
+}
+
+// 需要服务器支持SIZE和MDTM
+func (ftpProvider) Stat(key string) (int64, time.Time, error) {
+	f, err := dialFtp()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer f.Quit()
+	size, err := f.FileSize(key)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	modTime, err := f.GetTime(key)
+	return size, modTime, err
+}
+
+func (ftpProvider) ReadHead(key string, n int) ([]byte, error) {
+	f, err := dialFtp()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Quit()
+	rep, err := f.Retr(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rep.Close()
+	head := make([]byte, n)
+	_, err = io.ReadFull(rep, head)
+	return head, err
+}
+
+func (ftpProvider) Delete(key string) error {
+	f, err := dialFtp()
+	if err != nil {
+		return err
+	}
+	defer f.Quit()
+	if err := f.Delete(key); err != nil && !strings.HasPrefix(err.Error(), "550") {
+		return err
+	}
+	return nil
+}
+
+func (ftpProvider) Check() error {
+	f, err := dialFtp()
+	if err != nil {
+		return err
+	}
+	defer f.Quit()
+	return f.NoOp()
+}
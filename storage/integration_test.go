@@ -0,0 +1,87 @@
+//go:build integration
+
+// 需要mysql和minio: go run -tags integration ./testenv go test -tags integration -p 1 ./...
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testObjectKey(name string) string {
+	return "it-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + name
+}
+
+func mustProvider(t *testing.T, name string) Provider {
+	provider, err := GetProvider(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestS3Check(t *testing.T) {
+	if err := mustProvider(t, "aws").Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUploadDownloadDelete(t *testing.T) {
+	key := testObjectKey("bundle.zip")
+	data := []byte("console.log('integration');")
+	name, err := Upload(key, data)
+	if err != nil || name != "aws" {
+		t.Fatalf("upload: got %s %v", name, err)
+	}
+	got, err := Download(key)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("download: got %q %v", got, err)
+	}
+
+	url, err := DownloadUrl(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("presigned url: got %s %q", resp.Status, body)
+	}
+
+	if err := Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mustProvider(t, "aws").Get(key); err == nil {
+		t.Fatal("get after delete should fail")
+	}
+	// 删除不存在的对象不算错误
+	if err := Delete(key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 大于aws_s3_part_size_mb时分片上传和下载
+func TestMultipart(t *testing.T) {
+	key := testObjectKey("large.zip")
+	data := make([]byte, 9*1024*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := mustProvider(t, "aws").Put(key, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	defer Delete(key)
+	got, err := mustProvider(t, "aws").Get(key)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("multipart get: %d bytes %v", len(got), err)
+	}
+}
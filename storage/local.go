@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/utils"
+)
+
+type localProvider struct{}
+
+func localPath(key string) string {
+	return filepath.Join(config.GetConfig().CodePush.Local.SavePath, path.Clean("/"+key))
+}
+
+// 先写临时文件再改名,写到一半失败时不会留下不完整的对象
+func (localProvider) Put(key string, r io.Reader) error {
+	filePath := localPath(key)
+	dir := filepath.Dir(filePath)
+	if !utils.Exists(dir) {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+	}
+	tmp := filePath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0777)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filePath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (localProvider) Get(key string) ([]byte, error) {
+	return os.ReadFile(localPath(key))
+}
+
+// 本地存储由nginx或本服务通过resource_url提供下载
+func (localProvider) DownloadUrl(key string) (string, error) {
+	return config.GetConfig().ResourceUrl + path.Clean("/"+key), nil
+}
+
+func (localProvider) Stat(key string) (int64, time.Time, error) {
+	info, err := os.Stat(localPath(key))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return info.Size(), info.ModTime(), nil
+}
+
+func (localProvider) ReadHead(key string, n int) ([]byte, error) {
+	f, err := os.Open(localPath(key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, n)
+	_, err = io.ReadFull(f, head)
+	return head, err
+}
+
+func (localProvider) Delete(key string) error {
+	if err := os.Remove(localPath(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (localProvider) Check() error {
+	_, err := os.Stat(config.GetConfig().CodePush.Local.SavePath)
+	return err
+}
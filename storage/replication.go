@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"bytes"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var primaryHealthy atomic.Bool
+
+func init() {
+	primaryHealthy.Store(true)
+}
+
+func PrimaryHealthy() bool {
+	return primaryHealthy.Load()
+}
+
+// 启动主存储健康检查、副本复制和备用存储回迁
+func Start() {
+	if !ReplicaEnabled() && len(Chain()) <= 1 {
+		return
+	}
+	go healthCheckLoop()
+	if ReplicaEnabled() {
+		go replicationLoop()
+	}
+	if len(Chain()) > 1 {
+		go reconcileLoop()
+	}
+}
+
+// 副本和存储链都开启时按较短的间隔检查
+func healthCheckInterval() time.Duration {
+	c := config.GetConfig().CodePush
+	interval := uint(0)
+	if ReplicaEnabled() {
+		interval = c.Replica.HealthCheckInterval
+	}
+	if len(Chain()) > 1 && (interval == 0 || c.ChainHealthCheckInterval < interval) {
+		interval = c.ChainHealthCheckInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+func healthCheckLoop() {
+	interval := healthCheckInterval()
+	for {
+		checkPrimary()
+		time.Sleep(interval)
+	}
+}
+
+func checkPrimary() {
+	defer recoverLoop("health_check")
+	primary, err := GetProvider(Chain()[0])
+	if err == nil {
+		err = primary.Check()
+	}
+	healthy := err == nil
+	if primaryHealthy.Swap(healthy) != healthy {
+		log.Printf("storage: primary storage healthy=%v", healthy)
+		// cached download urls point at the old origin
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + "*")
+	}
+}
+
+// 后台循环中的异常(例如redis暂时不可用)只记录,不能让进程退出
+func recoverLoop(op string) {
+	if r := recover(); r != nil {
+		log.Printf("storage: %s error:%v", op, r)
+		sentry.CapturePanic("storage", r, map[string]string{"op": op})
+	}
+}
+
+const (
+	// 复制锁的有效期,每复制一个包续期一次
+	replicationLease = 5 * time.Minute
+	// 失败后按间隔指数退避重试,超过次数标记为failed
+	replicationMaxAttempts = 8
+	replicationMaxBackoff  = time.Hour
+)
+
+func replicationLoop() {
+	interval := time.Duration(config.GetConfig().CodePush.Replica.Interval) * time.Second
+	for {
+		replicatePending(interval)
+		time.Sleep(interval)
+	}
+}
+
+// 多个实例中只有拿到锁的一个复制
+func replicatePending(interval time.Duration) {
+	defer recoverLoop("replicate")
+	lock := constants.REDIS_REPLICATION + "lock"
+	token, ok := redis.Lock(lock, replicationLease)
+	if !ok {
+		return
+	}
+	defer redis.Unlock(lock, token)
+	packs := model.Package{}.GetReplicationDue(time.Now().UnixMilli(), 50)
+	if packs == nil {
+		return
+	}
+	for _, pack := range *packs {
+		if !redis.ExtendLock(lock, token, replicationLease) {
+			return
+		}
+		err := replicate(*pack.Download)
+		if err == nil {
+			model.Package{}.UpdateReplicationStatus(*pack.Id, constants.REPLICATION_SUCCEEDED)
+			continue
+		}
+		log.Printf("storage: replicate package %d error:%s", *pack.Id, err.Error())
+		sentry.CaptureError("storage", err, map[string]string{"op": "replicate"})
+		attempts := 1
+		if pack.ReplicationAttempts != nil {
+			attempts += *pack.ReplicationAttempts
+		}
+		if attempts >= replicationMaxAttempts {
+			model.Package{}.UpdateReplicationStatus(*pack.Id, constants.REPLICATION_FAILED)
+			continue
+		}
+		backoff := interval << attempts
+		if backoff <= 0 || backoff > replicationMaxBackoff {
+			backoff = replicationMaxBackoff
+		}
+		model.Package{}.UpdateReplicationRetry(*pack.Id, attempts, time.Now().Add(backoff).UnixMilli())
+	}
+}
+
+func replicate(key string) error {
+	data, err := Download(key)
+	if err != nil {
+		return err
+	}
+	_, err = ReplicaS3().PutObject(&s3.PutObjectInput{
+		Body:   bytes.NewReader(data),
+		Bucket: aws.String(config.GetConfig().CodePush.Replica.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// 主存储恢复后把只写入了备用存储的对象同步回主存储
+func reconcileLoop() {
+	interval := time.Duration(config.GetConfig().CodePush.ChainHealthCheckInterval) * time.Second
+	for {
+		if PrimaryHealthy() {
+			reconcilePending()
+		}
+		time.Sleep(interval)
+	}
+}
+
+func reconcilePending() {
+	defer recoverLoop("reconcile")
+	primary, err := GetProvider(Chain()[0])
+	if err != nil {
+		log.Printf("storage: reconcile error:%s", err.Error())
+		return
+	}
+	lock := constants.REDIS_REPLICATION + "reconcile"
+	token, ok := redis.Lock(lock, replicationLease)
+	if !ok {
+		return
+	}
+	defer redis.Unlock(lock, token)
+	reconciled := 0
+	pendings := model.StoragePending{}.GetList(50)
+	if pendings != nil {
+		for _, v := range *pendings {
+			if !redis.ExtendLock(lock, token, replicationLease) {
+				break
+			}
+			provider, err := GetProvider(*v.Provider)
+			var data []byte
+			if err == nil {
+				data, err = provider.Get(*v.ObjectKey)
+			}
+			if err == nil {
+				err = primary.Put(*v.ObjectKey, bytes.NewReader(data))
+			}
+			if err != nil {
+				log.Printf("storage: reconcile %s error:%s", *v.ObjectKey, err.Error())
+				sentry.CaptureError("storage", err, map[string]string{"op": "reconcile", "provider": *v.Provider})
+				continue
+			}
+			model.Delete[model.StoragePending](model.StoragePending{Id: v.Id})
+			setPendingProvider(*v.ObjectKey, "")
+			reconciled++
+		}
+	}
+	if reconciled > 0 {
+		// cached download urls point at the fallback storage
+		redis.DelRedisObj(constants.REDIS_UPDATE_INFO + "*")
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/egress"
+	"com.lc.go.codepush/server/model/constants"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+func newS3Client(endpoint string, region string, keyId string, secret string, forcePathStyle bool, accelerate bool) *s3.S3 {
+	c := config.GetConfig().CodePush.Aws
+	s3Config := aws.Config{
+		Credentials:      credentials.NewStaticCredentials(keyId, secret, ""),
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(forcePathStyle),
+		S3UseAccelerate:  aws.Bool(accelerate),
+		HTTPClient:       egress.Client(0),
+	}
+	request.WithRetryer(&s3Config, client.DefaultRetryer{
+		NumMaxRetries:    c.MaxRetries,
+		MinRetryDelay:    time.Duration(c.RetryMinDelayMs) * time.Millisecond,
+		MinThrottleDelay: time.Duration(c.RetryMinDelayMs) * time.Millisecond,
+		MaxRetryDelay:    time.Duration(c.RetryMaxDelayMs) * time.Millisecond,
+		MaxThrottleDelay: time.Duration(c.RetryMaxDelayMs) * time.Millisecond,
+	})
+	options := session.Options{Config: s3Config}
+	if caBundle := config.GetConfig().CodePush.Aws.CABundle; caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			log.Panic("Read aws_ca_bundle error:" + err.Error())
+		}
+		options.CustomCABundle = bytes.NewReader(pem)
+	}
+	newSession, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	return s3.New(newSession)
+}
+
+// aws_s3_addressing_style优先于aws_s3_force_path_style
+func forcePathStyle(addressingStyle string, forcePathStyle bool) bool {
+	switch addressingStyle {
+	case "path":
+		return true
+	case "virtual":
+		return false
+	}
+	return forcePathStyle
+}
+
+// 主存储桶
+func PrimaryS3() *s3.S3 {
+	c := config.GetConfig().CodePush.Aws
+	return newS3Client(c.Endpoint, c.Region, c.KeyId, c.Secret, forcePathStyle(c.AddressingStyle, c.S3ForcePathStyle), c.Accelerate)
+}
+
+// 副本存储桶(跨区域复制)
+func ReplicaS3() *s3.S3 {
+	c := config.GetConfig().CodePush.Replica
+	return newS3Client(c.Endpoint, c.Region, c.KeyId, c.Secret, c.S3ForcePathStyle, false)
+}
+
+func ReplicaEnabled() bool {
+	return Chain()[0] == "aws" && config.GetConfig().CodePush.Replica.Bucket != ""
+}
+
+// Presign a download url for the package key. When the primary bucket is
+// unhealthy and the package has been replicated, the replica origin is used.
+func PresignDownload(key string, replicationStatus *string) (string, error) {
+	configs := config.GetConfig()
+	client := PrimaryS3()
+	bucket := configs.CodePush.Aws.Bucket
+	if !PrimaryHealthy() && replicationStatus != nil && *replicationStatus == constants.REPLICATION_SUCCEEDED {
+		client = ReplicaS3()
+		bucket = configs.CodePush.Replica.Bucket
+	}
+	request, _ := client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return request.Presign(24 * time.Hour) // 24 hours
+}
+
+type s3Provider struct{}
+
+// 大于一个分片的包按aws_s3_part_size_mb分片并发上传
+func (s3Provider) Put(key string, r io.Reader) error {
+	c := config.GetConfig().CodePush.Aws
+	uploader := s3manager.NewUploaderWithClient(PrimaryS3(), func(u *s3manager.Uploader) {
+		u.PartSize = int64(c.PartSizeMB) * 1024 * 1024
+		u.Concurrency = int(c.Concurrency)
+	})
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Body:   r,
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s3Provider) Get(key string) ([]byte, error) {
+	c := config.GetConfig().CodePush.Aws
+	downloader := s3manager.NewDownloaderWithClient(PrimaryS3(), func(d *s3manager.Downloader) {
+		d.PartSize = int64(c.PartSizeMB) * 1024 * 1024
+		d.Concurrency = int(c.Concurrency)
+	})
+	buf := aws.NewWriteAtBuffer(nil)
+	_, err := downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s3Provider) Stat(key string) (int64, time.Time, error) {
+	out, err := PrimaryS3().HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(config.GetConfig().CodePush.Aws.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return aws.Int64Value(out.ContentLength), aws.TimeValue(out.LastModified), nil
+}
+
+func (s3Provider) ReadHead(key string, n int) ([]byte, error) {
+	out, err := PrimaryS3().GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(config.GetConfig().CodePush.Aws.Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String("bytes=0-" + strconv.Itoa(n-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	head := make([]byte, n)
+	_, err = io.ReadFull(out.Body, head)
+	return head, err
+}
+
+func (s3Provider) DownloadUrl(key string) (string, error) {
+	return PresignDownload(key, nil)
+}
+
+// S3删除不存在的对象也返回成功
+func (s3Provider) Delete(key string) error {
+	_, err := PrimaryS3().DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(config.GetConfig().CodePush.Aws.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func deleteReplica(key string) error {
+	_, err := ReplicaS3().DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(config.GetConfig().CodePush.Replica.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s3Provider) Check() error {
+	_, err := PrimaryS3().HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(config.GetConfig().CodePush.Aws.Bucket),
+	})
+	return err
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"com.lc.go.codepush/server/sentry"
+	"com.lc.go.codepush/server/utils"
+)
+
+// 存储提供者: aws, local, ftp
+type Provider interface {
+	Put(key string, r io.Reader) error
+	Get(key string) ([]byte, error)
+	DownloadUrl(key string) (string, error)
+	Delete(key string) error
+	Check() error
+}
+
+// 不下载整个对象就能返回大小、修改时间和开头部分的提供者
+type statProvider interface {
+	Stat(key string) (int64, time.Time, error)
+	ReadHead(key string, n int) ([]byte, error)
+}
+
+type blobInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+// key -> 解密后的大小和修改时间,对象写入后不再改变
+var blobInfos sync.Map
+
+func GetProvider(name string) (Provider, error) {
+	switch name {
+	case "aws":
+		return s3Provider{}, nil
+	case "local":
+		return localProvider{}, nil
+	case "ftp":
+		return ftpProvider{}, nil
+	}
+	return nil, errors.New("Storage provider " + name + " not supported")
+}
+
+// 按顺序的存储链,第一个为主存储
+func Chain() []string {
+	configs := config.GetConfig()
+	if len(configs.CodePush.Chain) > 0 {
+		return configs.CodePush.Chain
+	}
+	return []string{configs.CodePush.FileLocal}
+}
+
+// 只写入了备用存储的对象记录的缓存时间
+const pendingCacheTTL = 10 * time.Minute
+
+type pendingInfo struct {
+	// 为空表示对象在主存储
+	Provider string
+}
+
+// 对象所在的备用存储,不在备用存储时返回空;结果缓存在redis中,避免每次生成下载地址都查询storage_pending
+func pendingProvider(key string) string {
+	if len(Chain()) <= 1 {
+		return ""
+	}
+	cacheKey := constants.REDIS_STORE_PENDING + key
+	if info := redis.GetRedisObj[pendingInfo](cacheKey); info != nil {
+		return info.Provider
+	}
+	info := pendingInfo{}
+	if pending := (model.StoragePending{}).GetByObjectKey(key); pending != nil {
+		info.Provider = *pending.Provider
+	}
+	redis.SetRedisObj(cacheKey, info, pendingCacheTTL)
+	return info.Provider
+}
+
+func setPendingProvider(key string, provider string) {
+	redis.SetRedisObj(constants.REDIS_STORE_PENDING+key, pendingInfo{Provider: provider}, pendingCacheTTL)
+}
+
+// 存放对象的提供者: 只写入了备用存储的对象使用备用存储,其他使用主存储
+func objectProvider(key string) (Provider, error) {
+	name := pendingProvider(key)
+	if name == "" {
+		name = Chain()[0]
+	}
+	return GetProvider(name)
+}
+
+func Upload(key string, data []byte) (string, error) {
+	return UploadReader(key, bytes.NewReader(data))
+}
+
+// 依次尝试存储链上的提供者,每次从头读取r,写入备用存储的对象在主存储恢复后同步回去。
+// 开启加密时需要整个对象才能加密,先读入内存
+func UploadReader(key string, r io.ReadSeeker) (string, error) {
+	if EncryptionEnabled() {
+		data, err := io.ReadAll(r)
+		if err == nil {
+			data, err = encryptBlob(key, data)
+		}
+		if err != nil {
+			sentry.CaptureError("storage", err, map[string]string{"op": "encrypt"})
+			return "", err
+		}
+		r = bytes.NewReader(data)
+	}
+	var errs []error
+	for i, name := range Chain() {
+		provider, err := GetProvider(name)
+		if err == nil {
+			_, err = r.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			err = provider.Put(key, r)
+		}
+		if err == nil {
+			if i > 0 {
+				pending := model.StoragePending{
+					ObjectKey:  &key,
+					Provider:   &name,
+					CreateTime: utils.GetTimeNow(),
+				}
+				model.Create[model.StoragePending](&pending)
+				setPendingProvider(key, name)
+			}
+			if _, err := r.Seek(0, io.SeekStart); err == nil {
+				getCache().Put(key, r)
+			}
+			return name, nil
+		}
+		log.Printf("storage: put %s to %s error:%s", key, name, err.Error())
+		sentry.CaptureError("storage", err, map[string]string{"provider": name, "op": "put"})
+		errs = append(errs, err)
+	}
+	return "", errors.Join(errs...)
+}
+
+// 加密的对象在这里解密,本地缓存中仍是密文
+func Download(key string) ([]byte, error) {
+	if data, ok := getCache().Get(key); ok {
+		return decryptBlob(key, data)
+	}
+	provider, err := objectProvider(key)
+	var data []byte
+	if err == nil {
+		data, err = provider.Get(key)
+	}
+	if err != nil {
+		sentry.CaptureError("storage", err, map[string]string{"op": "get"})
+		return nil, err
+	}
+	getCache().Put(key, bytes.NewReader(data))
+	return decryptBlob(key, data)
+}
+
+// 对象解密后的大小和修改时间,用于下载代理的HEAD和条件请求,不下载整个对象;
+// 加密的对象只读取开头的加密头计算密文外的长度。提供者不支持时ok为false
+func Stat(key string) (size int64, modTime time.Time, ok bool) {
+	if v, ok := blobInfos.Load(key); ok {
+		info := v.(blobInfo)
+		return info.size, info.modTime, true
+	}
+	provider, err := objectProvider(key)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	stat, ok := provider.(statProvider)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	size, modTime, err = stat.Stat(key)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	if size >= int64(encryptedHeaderSize) {
+		head, err := stat.ReadHead(key, encryptedHeaderSize)
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+		size -= encryptedOverhead(head)
+	}
+	blobInfos.Store(key, blobInfo{size: size, modTime: modTime})
+	return size, modTime, true
+}
+
+// 生成下载地址: 只存在于备用存储的对象使用备用存储,主存储不可用时使用副本
+func DownloadUrl(key string, replicationStatus *string) (string, error) {
+	if EncryptionEnabled() || PrivateMode() {
+		return blobProxyUrl(key), nil
+	}
+	if name := pendingProvider(key); name != "" {
+		provider, err := GetProvider(name)
+		if err != nil {
+			return "", err
+		}
+		return provider.DownloadUrl(key)
+	}
+	if Chain()[0] == "aws" {
+		return PresignDownload(key, replicationStatus)
+	}
+	provider, err := GetProvider(Chain()[0])
+	if err != nil {
+		return "", err
+	}
+	return provider.DownloadUrl(key)
+}
+
+// 从存储链、副本和本地缓存中删除,对象不存在不算错误
+func Delete(key string) error {
+	var errs []error
+	for _, name := range Chain() {
+		provider, err := GetProvider(name)
+		if err == nil {
+			err = provider.Delete(key)
+		}
+		if err != nil {
+			log.Printf("storage: delete %s from %s error:%s", key, name, err.Error())
+			errs = append(errs, err)
+		}
+	}
+	if ReplicaEnabled() {
+		if err := deleteReplica(key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	getCache().Delete(key)
+	blobInfos.Delete(key)
+	if len(errs) == 0 {
+		model.StoragePending{}.DeleteByObjectKey(key)
+		if len(Chain()) > 1 {
+			setPendingProvider(key, "")
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,251 @@
+//go:build integration
+
+// 集成测试环境: 用dockertest启动mysql、redis和minio,把地址写入global_secrets后运行后面的命令,结束时删除容器。
+// 配置在包初始化时读取,所以不能在TestMain中启动容器:
+//
+//	go run -tags integration ./testenv go test -tags integration -p 1 ./...
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	mysqlPassword  = "codepush"
+	minioUser      = "minioadmin"
+	minioPassword  = "minioadmin"
+	bucket         = "codepush"
+	bootstrapToken = "integration-bootstrap"
+)
+
+type env struct {
+	pool      *dockertest.Pool
+	resources []*dockertest.Resource
+}
+
+func main() {
+	schema := flag.String("schema", "code-push.sql", "schema loaded into mysql")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal("usage: go run -tags integration ./testenv [-schema code-push.sql] command [args...]")
+	}
+	e := &env{}
+	code, err := e.run(*schema, flag.Args())
+	e.purge()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	os.Exit(code)
+}
+
+func (e *env) run(schema string, command []string) (int, error) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return 0, err
+	}
+	if err := pool.Client.Ping(); err != nil {
+		return 0, errors.New("docker not available: " + err.Error())
+	}
+	pool.MaxWait = 3 * time.Minute
+	e.pool = pool
+
+	mysqlAddr, err := e.startMysql(schema)
+	if err != nil {
+		return 0, err
+	}
+	redisAddr, err := e.startRedis()
+	if err != nil {
+		return 0, err
+	}
+	minioAddr, err := e.startMinio()
+	if err != nil {
+		return 0, err
+	}
+	listenAddr, err := freeAddr()
+	if err != nil {
+		return 0, err
+	}
+
+	mysqlHost, mysqlPort, _ := net.SplitHostPort(mysqlAddr)
+	redisHost, redisPort, _ := net.SplitHostPort(redisAddr)
+	secrets, _ := json.Marshal(map[string]string{
+		"db_username":             "root",
+		"db_password":             mysqlPassword,
+		"db_host":                 mysqlHost,
+		"db_port":                 mysqlPort,
+		"db_name":                 "code-push",
+		"redis_host":              redisHost,
+		"redis_port":              redisPort,
+		"resource_url":            "http://" + minioAddr + "/",
+		"environment":             "test",
+		"tenant_name":             "integration",
+		"build_save_location":     "aws",
+		"aws_s3_endpoint":         "http://" + minioAddr,
+		"aws_region":              "us-east-1",
+		"aws_s3_addressing_style": "path",
+		"aws_access_key_id":       minioUser,
+		"aws_secret_access_key":   minioPassword,
+		"aws_s3_bucket_name":      bucket,
+		"listen_addr":             listenAddr,
+		"bootstrap_token":         bootstrapToken,
+	})
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "global_secrets="+string(secrets))
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (e *env) start(options *dockertest.RunOptions) (*dockertest.Resource, error) {
+	resource, err := e.pool.RunWithOptions(options, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		return nil, errors.New("start " + options.Repository + ": " + err.Error())
+	}
+	e.resources = append(e.resources, resource)
+	// 进程被杀死时容器也会在10分钟后停止
+	resource.Expire(600)
+	return resource, nil
+}
+
+func (e *env) purge() {
+	for _, resource := range e.resources {
+		if err := e.pool.Purge(resource); err != nil {
+			log.Printf("testenv: purge %s error:%s", resource.Container.Name, err.Error())
+		}
+	}
+}
+
+func (e *env) startMysql(schema string) (string, error) {
+	data, err := os.ReadFile(schema)
+	if err != nil {
+		return "", err
+	}
+	resource, err := e.start(&dockertest.RunOptions{
+		Repository: "mysql",
+		Tag:        "8.0",
+		Env:        []string{"MYSQL_ROOT_PASSWORD=" + mysqlPassword},
+	})
+	if err != nil {
+		return "", err
+	}
+	addr := resource.GetHostPort("3306/tcp")
+	var db *sql.DB
+	err = e.pool.Retry(func() error {
+		var err error
+		db, err = sql.Open("mysql", "root:"+mysqlPassword+"@tcp("+addr+")/?multiStatements=true")
+		if err != nil {
+			return err
+		}
+		return db.Ping()
+	})
+	if err != nil {
+		return "", errors.New("mysql not ready: " + err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(data)); err != nil {
+		return "", errors.New("load " + schema + ": " + err.Error())
+	}
+	fmt.Println("testenv: mysql " + addr)
+	return addr, nil
+}
+
+func (e *env) startRedis() (string, error) {
+	resource, err := e.start(&dockertest.RunOptions{Repository: "redis", Tag: "7"})
+	if err != nil {
+		return "", err
+	}
+	addr := resource.GetHostPort("6379/tcp")
+	err = e.pool.Retry(func() error {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		defer client.Close()
+		return client.Ping(context.Background()).Err()
+	})
+	if err != nil {
+		return "", errors.New("redis not ready: " + err.Error())
+	}
+	fmt.Println("testenv: redis " + addr)
+	return addr, nil
+}
+
+func (e *env) startMinio() (string, error) {
+	resource, err := e.start(&dockertest.RunOptions{
+		Repository: "minio/minio",
+		Tag:        "latest",
+		Cmd:        []string{"server", "/data"},
+		Env:        []string{"MINIO_ROOT_USER=" + minioUser, "MINIO_ROOT_PASSWORD=" + minioPassword},
+	})
+	if err != nil {
+		return "", err
+	}
+	addr := resource.GetHostPort("9000/tcp")
+	err = e.pool.Retry(func() error {
+		resp, err := http.Get("http://" + addr + "/minio/health/live")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.New("minio health " + strconv.Itoa(resp.StatusCode))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.New("minio not ready: " + err.Error())
+	}
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(minioUser, minioPassword, ""),
+		Endpoint:         aws.String("http://" + addr),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := s3.New(sess).CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		return "", errors.New("create bucket: " + err.Error())
+	}
+	fmt.Println("testenv: minio " + addr)
+	return addr, nil
+}
+
+// 服务端在测试进程中启动时监听的地址
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
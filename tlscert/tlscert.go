@@ -0,0 +1,149 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"com.lc.go.codepush/server/config"
+	"com.lc.go.codepush/server/db/redis"
+	"com.lc.go.codepush/server/egress"
+	"com.lc.go.codepush/server/model"
+	"com.lc.go.codepush/server/model/constants"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// 证书和ACME账号保存在redis中,多个实例共用;其中有私钥,用deployment_key_secret加密
+type redisCache struct{}
+
+// 证书有效期90天,到期前30天续期,过期的条目不再保留
+const cacheTTL = 100 * 24 * time.Hour
+
+func (redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data := redis.GetRedisObj[[]byte](constants.REDIS_ACME + key)
+	if data == nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	// 无法解密的条目(例如加密之前保存的)重新申请
+	plain, err := model.DecryptSecret("acme:"+key, *data)
+	if err != nil {
+		log.Printf("acme: cache %s error:%s", key, err.Error())
+		return nil, autocert.ErrCacheMiss
+	}
+	return plain, nil
+}
+
+func (redisCache) Put(ctx context.Context, key string, data []byte) error {
+	encrypted, err := model.EncryptSecret("acme:"+key, data)
+	if err != nil {
+		return err
+	}
+	redis.SetRedisObj(constants.REDIS_ACME+key, encrypted, cacheTTL)
+	return nil
+}
+
+func (redisCache) Delete(ctx context.Context, key string) error {
+	redis.DelRedisObj(constants.REDIS_ACME + key)
+	return nil
+}
+
+// 域名是否属于本租户,缓存一分钟,删除域名后最多一分钟停止提供证书
+type allowEntry struct {
+	allowed bool
+	expire  time.Time
+}
+
+var allowCache sync.Map
+
+func allowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if slices.Contains(config.GetConfig().Tls.Hosts, host) {
+		return true
+	}
+	if v, ok := allowCache.Load(host); ok && time.Now().Before(v.(allowEntry).expire) {
+		return v.(allowEntry).allowed
+	}
+	domain := model.CustomDomain{}.GetByDomain(host)
+	ok := domain != nil && *domain.Tenant == config.GetConfig().TenantName
+	allowCache.Store(host, allowEntry{allowed: ok, expire: time.Now().Add(time.Minute)})
+	return ok
+}
+
+// 删除域名后调用,本实例立即停止提供证书
+func Forget(host string) {
+	allowCache.Delete(host)
+}
+
+var manager = sync.OnceValue(func() *autocert.Manager {
+	c := config.GetConfig().Tls
+	m := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  redisCache{},
+		Email:  c.AcmeEmail,
+		HostPolicy: func(ctx context.Context, host string) error {
+			if !allowed(host) {
+				return errors.New("host " + host + " is not a custom domain of this tenant")
+			}
+			return nil
+		},
+	}
+	m.Client = &acme.Client{DirectoryURL: c.AcmeDirectoryUrl, HTTPClient: egress.Client(0)}
+	return m
+})
+
+func Enabled() bool {
+	return config.GetConfig().Tls.Addr != ""
+}
+
+// 按SNI选择证书,没有证书时在握手中通过TLS-ALPN-01申请
+func TLSConfig() *tls.Config {
+	tlsConfig := manager().TLSConfig()
+	getCertificate := tlsConfig.GetCertificate
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName != "" && !allowed(hello.ServerName) {
+			return nil, errors.New("unknown server name " + hello.ServerName)
+		}
+		return getCertificate(hello)
+	}
+	return tlsConfig
+}
+
+// 在http端口上响应HTTP-01验证,其他请求交给handler
+func HTTPHandler(handler http.Handler) http.Handler {
+	return manager().HTTPHandler(handler)
+}
+
+// 启动https监听,server的配置和http端口一致;监听出错时记录日志并关闭server,
+// 返回的channel在server处理完已有请求后关闭
+func ListenAndServe(server *http.Server) <-chan struct{} {
+	tlsServer := &http.Server{
+		Addr:              config.GetConfig().Tls.Addr,
+		Handler:           server.Handler,
+		TLSConfig:         TLSConfig(),
+		IdleTimeout:       server.IdleTimeout,
+		ReadTimeout:       server.ReadTimeout,
+		ReadHeaderTimeout: server.ReadHeaderTimeout,
+		WriteTimeout:      server.WriteTimeout,
+		MaxHeaderBytes:    server.MaxHeaderBytes,
+	}
+	tlsServer.SetKeepAlivesEnabled(config.GetConfig().Http.KeepAlive)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		err := tlsServer.ListenAndServeTLS("", "")
+		log.Printf("TLS server error:%s", err.Error())
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("http server shutdown error:%s", err.Error())
+		}
+	}()
+	return stopped
+}
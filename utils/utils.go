@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"os"
 	"sort"
@@ -18,6 +20,21 @@ func CreateInt(num int) *int {
 	return &num
 }
 
+// nil时返回零值
+func IntValue(v *int) int {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func StringValue(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
 func Exists(path string) bool {
 	_, err := os.Stat(path) //os.Stat获取文件信息
 	if err != nil {
@@ -50,3 +67,27 @@ func ReverseArr(s interface{}) {
 		return true
 	})
 }
+
+// 版本范围匹配,支持 *、1.2.x 通配符和精确版本
+func MatchVersionRange(versionRange string, version string) bool {
+	versionRange = strings.TrimSpace(versionRange)
+	if versionRange == "" || versionRange == "*" {
+		return true
+	}
+	rs := strings.Split(versionRange, ".")
+	vs := strings.Split(version, ".")
+	for i, r := range rs {
+		if r == "x" || r == "X" || r == "*" {
+			return true
+		}
+		if i >= len(vs) || r != vs[i] {
+			return false
+		}
+	}
+	return len(rs) == len(vs)
+}
+
+func Sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
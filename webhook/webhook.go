@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"com.lc.go.codepush/server/egress"
+)
+
+var client = egress.Client(10 * time.Second)
+
+// 异步发送webhook,失败只记录日志
+func Send(url string, payload any) {
+	if url == "" {
+		return
+	}
+	go func() {
+		if err := Post(url, payload); err != nil {
+			log.Printf("webhook: post %s error:%s", url, err.Error())
+		}
+	}()
+}
+
+func Post(url string, payload any) error {
+	jData, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	rep, err := client.Post(url, "application/json", bytes.NewReader(jData))
+	if err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+	if rep.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", rep.StatusCode)
+	}
+	return nil
+}